@@ -1,21 +1,7 @@
 package com.nanocloud.auth.noauthlogged.connection;
 
-import java.io.BufferedReader;
-import java.io.DataOutputStream;
-import java.io.IOException;
-import java.io.InputStream;
-import java.io.InputStreamReader;
-import java.net.HttpURLConnection;
-import java.net.URL;
-import java.util.Date;
 import java.util.concurrent.atomic.AtomicBoolean;
-import javax.json.Json;
-import javax.json.JsonObject;
 
-import org.json.JSONException;
-import org.json.JSONObject;
-
-import com.nanocloud.auth.noauthlogged.NoAuthLoggedGuacamoleProperties;
 import com.nanocloud.auth.noauthlogged.tunnel.ManagedInetGuacamoleSocket;
 import com.nanocloud.auth.noauthlogged.tunnel.ManagedSSLGuacamoleSocket;
 
@@ -55,7 +41,9 @@ public class LoggedConnection extends SimpleConnection {
 
   /**
    * Task which handles cleanup of a connection associated with some given
-   * ActiveConnectionRecord.
+   * ActiveConnectionRecord. Session history is recorded by the API from the
+   * sessions opened on the machines, so the end of the connection is only
+   * logged.
    */
   private class ConnectionCleanupTask implements Runnable {
 
@@ -63,25 +51,10 @@ public class LoggedConnection extends SimpleConnection {
      * Whether this task has run.
      */
     private final AtomicBoolean hasRun = new AtomicBoolean(false);
-    private final String hostname;
-    private final Integer port;
-    private final String endpoint;
     private ActiveConnectionRecord connection;
-    private String token;
-    private String id;
-    private String email;
-    private String firstname;
-    private String lastname;
 
-    public ConnectionCleanupTask(ActiveConnectionRecord connection, String token) throws GuacamoleException {
+    public ConnectionCleanupTask(ActiveConnectionRecord connection) {
       this.connection = connection;
-
-      Environment env = new LocalEnvironment();
-
-      hostname = env.getProperty(NoAuthLoggedGuacamoleProperties.NOAUTHLOGGED_SERVERURL, "localhost");
-      port = env.getProperty(NoAuthLoggedGuacamoleProperties.NOAUTHLOGGED_SERVERPORT, 80);
-      endpoint = env.getProperty(NoAuthLoggedGuacamoleProperties.NOAUTHLOGGED_SERVERENDPOINT, "rpc");
-      this.token = token;
     }
 
     @Override
@@ -91,102 +64,7 @@ public class LoggedConnection extends SimpleConnection {
       if (!hasRun.compareAndSet(false, true))
         return;
 
-      try { 
-
-        URL myUrl = new URL("http://" + hostname + ":" + port + "/api/users?me=true");
-        HttpURLConnection urlConn = (HttpURLConnection)myUrl.openConnection();
-
-        urlConn.setInstanceFollowRedirects(false);
-        urlConn.setRequestProperty("Authorization", "Bearer " + token);
-        urlConn.setUseCaches(false);
-
-        urlConn.connect();
-
-        // Get Response
-        InputStream input = urlConn.getInputStream();
-
-        BufferedReader reader = new BufferedReader(new InputStreamReader(input));
-        StringBuilder response = new StringBuilder();
-
-        String line;
-        while ((line = reader.readLine()) != null) {
-          response.append(line);
-        }
-        reader.close();
-
-        JSONObject jsonResponse =  new JSONObject(response.toString());
-        JSONObject data = jsonResponse.getJSONObject("data");
-        String responseId = data.getString("id");
-
-        JSONObject dataAttribute = data.getJSONObject("attributes");
-        String responseUserEmail = dataAttribute.getString("email");
-        String responseUserFirstname = dataAttribute.getString("first-name");
-        String responseUserLastname = dataAttribute.getString("last-name");
-
-        this.id = responseId;
-        this.email = responseUserEmail;
-        this.firstname = responseUserFirstname;
-        this.lastname = responseUserLastname;
-
-      } catch (IOException e) {
-        // TODO Auto-generated catch block
-        e.printStackTrace();
-      } catch (JSONException e) {
-        e.printStackTrace();
-      }
-      logger.info("Trying to log history to token : " + this.token + " : " + hostname + ":" + port + "/" + endpoint);
-
-      try {
-        String token = this.token;
-
-        URL myUrl = new URL("http://" + hostname + ":" + port + "/" + endpoint);
-        HttpURLConnection urlConn = (HttpURLConnection)myUrl.openConnection();
-        urlConn.setInstanceFollowRedirects(false);
-        urlConn.setRequestProperty("Authorization", "Bearer " + token);
-
-        urlConn.setRequestProperty("Content-Type", "application/json");
-        JsonObject params = Json.createObjectBuilder()
-          .add("data", Json.createObjectBuilder()
-              .add("type", "histories")
-              .add("attributes", Json.createObjectBuilder()
-                .add("user-id", this.id)
-                .add("user-mail", this.email)
-                .add("user-firstname", this.firstname)
-                .add("user-lastname", this.lastname)
-                .add("connection-id", this.connection.getConnectionName())
-                .add("start-date", this.connection.getStartDate().toString())
-                .add("end-date", new Date().toString())))
-          .build();
-
-        urlConn.setUseCaches(false);
-        urlConn.setDoOutput(true);
-        // Send request (for some reason we actually need to wait for response)
-        DataOutputStream writer = new DataOutputStream(urlConn.getOutputStream());
-        writer.writeBytes(params.toString());
-        writer.close();
-
-        urlConn.connect();
-        urlConn.getOutputStream().close(); 
-
-        // Get Response
-        InputStream input = urlConn.getInputStream();
-        BufferedReader reader = new BufferedReader(new InputStreamReader(input));
-        StringBuilder response = new StringBuilder();
-
-        String line;
-        while ((line = reader.readLine()) != null) {
-          response.append(line);
-          response.append('\r');
-        }
-        reader.close();
-
-        logger.info("History transmitted to API");
-
-      } catch (IOException e) {
-        // TODO Auto-generated catch block
-        e.printStackTrace();
-      }
-
+      logger.info("Connection " + this.connection.getConnectionName() + " started at " + this.connection.getStartDate() + " closed");
     }
 
   }
@@ -205,7 +83,7 @@ public class LoggedConnection extends SimpleConnection {
   GuacamoleSocket socket;
 
   // Record new active connection
-  Runnable cleanupTask = new ConnectionCleanupTask(connection, token);
+  Runnable cleanupTask = new ConnectionCleanupTask(connection);
 
   // If guacd requires SSL, use it
   if (env.getProperty(Environment.GUACD_SSL, false))
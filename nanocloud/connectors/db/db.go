@@ -3,7 +3,9 @@ package db
 import (
 	"database/sql"
 	"os"
+	"time"
 
+	"github.com/Nanocloud/community/nanocloud/metrics"
 	_ "github.com/lib/pq"
 )
 
@@ -24,12 +26,50 @@ func getInstance() (*sql.DB, error) {
 	return _db, nil
 }
 
+var (
+	queryDuration = metrics.NewHistogram(
+		"nanocloud_db_query_duration_seconds",
+		"Duration of the database queries.",
+		metrics.DefaultBuckets,
+		"operation",
+	)
+
+	queryErrors = metrics.NewCounter(
+		"nanocloud_db_query_errors_total",
+		"Database queries that failed.",
+		"operation",
+	)
+
+	_ = metrics.NewGaugeFunc(
+		"nanocloud_db_open_connections",
+		"Connections opened to the database, in use or idle.",
+		nil,
+		func() ([]metrics.Sample, error) {
+			if _db == nil {
+				return []metrics.Sample{{Value: 0}}, nil
+			}
+			return []metrics.Sample{{Value: float64(_db.Stats().OpenConnections)}}, nil
+		},
+	)
+)
+
+func observe(operation string, start time.Time, err error) {
+	queryDuration.Observe(time.Since(start).Seconds(), operation)
+	if err != nil {
+		queryErrors.Inc(operation)
+	}
+}
+
 func Query(query string, args ...interface{}) (*sql.Rows, error) {
 	db, err := getInstance()
 	if err != nil {
 		return nil, err
 	}
-	return db.Query(query, args...)
+
+	start := time.Now()
+	rows, err := db.Query(query, args...)
+	observe("query", start, err)
+	return rows, err
 }
 
 func Exec(query string, args ...interface{}) (sql.Result, error) {
@@ -37,7 +77,11 @@ func Exec(query string, args ...interface{}) (sql.Result, error) {
 	if err != nil {
 		return nil, err
 	}
-	return db.Exec(query, args...)
+
+	start := time.Now()
+	res, err := db.Exec(query, args...)
+	observe("exec", start, err)
+	return res, err
 }
 
 func Begin() (*sql.Tx, error) {
@@ -45,5 +89,27 @@ func Begin() (*sql.Tx, error) {
 	if err != nil {
 		return nil, err
 	}
-	return db.Begin()
+
+	start := time.Now()
+	tx, err := db.Begin()
+	observe("begin", start, err)
+	return tx, err
+}
+
+// Count runs a query returning a single number, like `SELECT count(*) ...`.
+func Count(query string, args ...interface{}) (int, error) {
+	rows, err := Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var n int
+	if rows.Next() {
+		err = rows.Scan(&n)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return n, rows.Err()
 }
@@ -23,8 +23,12 @@
 package vms
 
 import (
+	"sync"
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 
+	"github.com/Nanocloud/community/nanocloud/metrics"
 	"github.com/Nanocloud/community/nanocloud/vms"
 )
 
@@ -35,15 +39,107 @@ const (
 	StatusTerminated = vms.StatusTerminated
 	StatusBooting    = vms.StatusBooting
 	StatusCreating   = vms.StatusCreating
+	StatusDegraded   = vms.StatusDegraded
 )
 
 var vm *vms.VM
 
+// machinesTTL is how long the number of machines by status is reused by the
+// metrics. Counting them queries the status of every machine, which must not
+// be done on each scrape.
+const machinesTTL = 30 * time.Second
+
+var (
+	machinesLock       sync.Mutex
+	machinesSamples    = []metrics.Sample{}
+	machinesErr        error
+	machinesCountedAt  time.Time
+	machinesRefreshing bool
+)
+
+// Return the number of machines in each status, as last counted. The count is
+// refreshed in the background once it is older than machinesTTL.
+func machinesByStatus() ([]metrics.Sample, error) {
+	machinesLock.Lock()
+	defer machinesLock.Unlock()
+
+	if !machinesRefreshing && time.Since(machinesCountedAt) > machinesTTL {
+		machinesRefreshing = true
+		go refreshMachinesByStatus()
+	}
+	return machinesSamples, machinesErr
+}
+
+func refreshMachinesByStatus() {
+	samples, err := countMachinesByStatus()
+
+	machinesLock.Lock()
+	if err == nil {
+		machinesSamples = samples
+	}
+	machinesErr = err
+	machinesCountedAt = time.Now()
+	machinesRefreshing = false
+	machinesLock.Unlock()
+}
+
+// Count the machines in each status. The statuses are retrieved in parallel
+// as some drivers query each machine.
+func countMachinesByStatus() ([]metrics.Sample, error) {
+	if vm == nil {
+		return []metrics.Sample{}, nil
+	}
+
+	machines, err := Machines()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(chan vms.MachineStatus)
+	for _, m := range machines {
+		go func(m vms.Machine) {
+			status, err := m.Status()
+			if err != nil {
+				status = vms.StatusUnknown
+			}
+			statuses <- status
+		}(m)
+	}
+
+	counts := make(map[vms.MachineStatus]int)
+	for range machines {
+		counts[<-statuses]++
+	}
+
+	samples := make([]metrics.Sample, 0, len(counts))
+	for status, count := range counts {
+		samples = append(samples, metrics.Sample{
+			Labels: []string{vms.StatusToString(status)},
+			Value:  float64(count),
+		})
+	}
+	return samples, nil
+}
+
+func init() {
+	metrics.NewGaugeFunc(
+		"nanocloud_machines",
+		"Machines by status.",
+		[]string{"status"},
+		machinesByStatus,
+	)
+}
+
 func SetVM(v *vms.VM) {
 	if (*v) == nil {
 		log.Fatal("Driver error: Please fix your configuration")
 	}
 	vm = v
+
+	machinesLock.Lock()
+	machinesRefreshing = true
+	machinesLock.Unlock()
+	go refreshMachinesByStatus()
 }
 
 func Machines() ([]vms.Machine, error) {
@@ -108,4 +108,208 @@ var (
 		http.StatusInternalServerError,
 		"The specified machine type does not exists",
 	}
+
+	UploadNotFound = &apiError{
+		0x000014,
+		http.StatusNotFound,
+		"The specified upload does not exist.",
+	}
+
+	UploadTooLarge = &apiError{
+		0x000015,
+		http.StatusRequestEntityTooLarge,
+		"The file exceeds the maximum upload size.",
+	}
+
+	QuotaExceeded = &apiError{
+		0x000016,
+		http.StatusRequestEntityTooLarge,
+		"The upload would exceed your storage quota.",
+	}
+
+	InvalidUploadOffset = &apiError{
+		0x000017,
+		http.StatusConflict,
+		"The upload offset doesn't match the number of bytes received.",
+	}
+
+	UploadChecksumMismatch = &apiError{
+		0x000018,
+		460,
+		"The chunk doesn't match its checksum.",
+	}
+
+	PlazaReleaseNotFound = &apiError{
+		0x000019,
+		http.StatusNotFound,
+		"No plaza release is available.",
+	}
+
+	SessionNotFound = &apiError{
+		0x00001a,
+		http.StatusNotFound,
+		"The specified session does not exist.",
+	}
+
+	AppNotFound = &apiError{
+		0x00001b,
+		http.StatusNotFound,
+		"The specified application does not exist.",
+	}
+
+	GroupNotFound = &apiError{
+		0x00001c,
+		http.StatusNotFound,
+		"The specified group does not exist.",
+	}
+
+	GroupDuplicated = &apiError{
+		0x00001d,
+		http.StatusConflict,
+		"A group with this name already exists.",
+	}
+
+	AssignmentNotFound = &apiError{
+		0x00001e,
+		http.StatusNotFound,
+		"The specified assignment does not exist.",
+	}
+
+	AssignmentDuplicated = &apiError{
+		0x00001f,
+		http.StatusConflict,
+		"The application is already assigned.",
+	}
+
+	RoleNotFound = &apiError{
+		0x000020,
+		http.StatusNotFound,
+		"The specified role does not exist.",
+	}
+
+	RoleDuplicated = &apiError{
+		0x000021,
+		http.StatusConflict,
+		"A role with this name already exists.",
+	}
+
+	RoleAssignmentDuplicated = &apiError{
+		0x000022,
+		http.StatusConflict,
+		"The role is already granted.",
+	}
+
+	OAuthClientNotFound = &apiError{
+		0x000023,
+		http.StatusNotFound,
+		"The specified OAuth client does not exist.",
+	}
+
+	OAuthClientDuplicated = &apiError{
+		0x000024,
+		http.StatusConflict,
+		"An OAuth client with this name already exists.",
+	}
+
+	DirectorySyncNotConfigured = &apiError{
+		0x000025,
+		http.StatusConflict,
+		"The directory synchronization is not configured.",
+	}
+
+	DirectoryUnreachable = &apiError{
+		0x000026,
+		http.StatusBadGateway,
+		"The directory cannot be reached.",
+	}
+
+	SSOProviderNotFound = &apiError{
+		0x000027,
+		http.StatusNotFound,
+		"The specified SSO provider does not exist.",
+	}
+
+	MFANotEnrolled = &apiError{
+		0x000028,
+		http.StatusConflict,
+		"Two-factor authentication is not set up for this user.",
+	}
+
+	MFAAlreadyEnabled = &apiError{
+		0x000029,
+		http.StatusConflict,
+		"Two-factor authentication is already enabled for this user.",
+	}
+
+	InvalidMFACode = &apiError{
+		0x00002a,
+		http.StatusForbidden,
+		"The two-factor authentication code is invalid.",
+	}
+
+	WeakPassword = &apiError{
+		0x00002b,
+		http.StatusBadRequest,
+		"The password does not follow the password policy.",
+	}
+
+	InvalidResetToken = &apiError{
+		0x00002c,
+		http.StatusBadRequest,
+		"The password reset link is invalid or has expired.",
+	}
+
+	MailNotConfigured = &apiError{
+		0x00002d,
+		http.StatusServiceUnavailable,
+		"Emails cannot be sent.",
+	}
+
+	SignupDisabled = &apiError{
+		0x00002e,
+		http.StatusForbidden,
+		"Signing up is disabled.",
+	}
+
+	EmailDomainNotAllowed = &apiError{
+		0x00002f,
+		http.StatusForbidden,
+		"This email domain is not allowed to sign up.",
+	}
+
+	InvalidInvitation = &apiError{
+		0x000030,
+		http.StatusBadRequest,
+		"The invitation is invalid or has expired.",
+	}
+
+	InvalidActivationToken = &apiError{
+		0x000031,
+		http.StatusBadRequest,
+		"The activation link is invalid or has expired.",
+	}
+
+	InvitationNotFound = &apiError{
+		0x000032,
+		http.StatusNotFound,
+		"The specified invitation does not exist.",
+	}
+
+	UserExists = &apiError{
+		0x000033,
+		http.StatusConflict,
+		"A user with this email already exists.",
+	}
+
+	InvalidCredentials = &apiError{
+		0x000034,
+		http.StatusUnauthorized,
+		"The email or the password is incorrect.",
+	}
+
+	TooManyAttempts = &apiError{
+		0x000035,
+		http.StatusTooManyRequests,
+		"Too many failed attempts, please try again later.",
+	}
 )
@@ -91,6 +91,11 @@ func (e *apiError) Error() string {
 	return e.title
 }
 
+// StatusCode returns the HTTP status sent with the error.
+func (e *apiError) StatusCode() int {
+	return e.status
+}
+
 type detailedError struct {
 	err    *apiError
 	detail string
@@ -100,6 +105,10 @@ func (e *detailedError) Error() string {
 	return e.err.Error() + " " + e.detail
 }
 
+func (e *detailedError) StatusCode() int {
+	return e.err.status
+}
+
 func (e *detailedError) Send(w http.ResponseWriter) {
 	b := hash{
 		"errors": [1]hash{
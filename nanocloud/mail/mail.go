@@ -0,0 +1,162 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package mail sends the emails of Nanocloud through the sender selected
+// with MAIL_SENDER: "smtp" or "fake", which keeps the messages in memory
+// and is meant for tests and development.
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+var NotConfigured = errors.New("no mail sender configured")
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers the messages.
+type Sender interface {
+	Send(from string, msg *Message) error
+}
+
+var (
+	lock   sync.Mutex
+	sender Sender
+	from   string
+)
+
+func init() {
+	from = utils.Env("MAIL_FROM", "Nanocloud <no-reply@nanocloud.com>")
+
+	switch utils.Env("MAIL_SENDER", "smtp") {
+	case "smtp":
+		if s := SMTPFromEnv(); s.Host != "" {
+			sender = s
+		}
+	case "fake":
+		sender = &Fake{}
+	default:
+		log.Error("[Mail] Unknown MAIL_SENDER, emails are disabled")
+	}
+}
+
+// SetSender replaces the sender, for instance with a Fake in the tests.
+func SetSender(s Sender) {
+	lock.Lock()
+	sender = s
+	lock.Unlock()
+}
+
+// Configured tells whether emails can be sent.
+func Configured() bool {
+	lock.Lock()
+	defer lock.Unlock()
+	return sender != nil
+}
+
+// Send delivers the message with the configured sender.
+func Send(msg *Message) error {
+	lock.Lock()
+	s := sender
+	lock.Unlock()
+
+	if s == nil {
+		return NotConfigured
+	}
+	return s.Send(from, msg)
+}
+
+// Link returns the link to a page of the web application, for the emails.
+// The application is served at PUBLIC_URL.
+func Link(route string, params url.Values) string {
+	link := strings.TrimRight(utils.Env("PUBLIC_URL", "http://localhost"), "/") + "/#/" + route
+	if len(params) > 0 {
+		link += "?" + params.Encode()
+	}
+	return link
+}
+
+// sanitize removes the line breaks that would let a value add headers.
+func sanitize(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+// Format returns the message as an RFC 5322 text/plain email.
+func Format(from string, msg *Message, date time.Time) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", sanitize(from))
+	fmt.Fprintf(&b, "To: %s\r\n", sanitize(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", sanitize(msg.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+
+	body := strings.Replace(msg.Body, "\r\n", "\n", -1)
+	b.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return b.Bytes()
+}
+
+// Fake keeps the messages instead of sending them.
+type Fake struct {
+	lock     sync.Mutex
+	Messages []Message
+}
+
+func (f *Fake) Send(from string, msg *Message) error {
+	f.lock.Lock()
+	f.Messages = append(f.Messages, *msg)
+	f.lock.Unlock()
+
+	log.Infof("[Mail] Fake email to %s: %s", msg.To, msg.Subject)
+	return nil
+}
+
+// Last returns the last message sent to the address, nil if there is none.
+func (f *Fake) Last(to string) *Message {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for i := len(f.Messages) - 1; i >= 0; i-- {
+		if f.Messages[i].To == to {
+			msg := f.Messages[i]
+			return &msg
+		}
+	}
+	return nil
+}
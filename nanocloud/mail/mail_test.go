@@ -0,0 +1,80 @@
+package mail
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	msg := &Message{
+		To:      "user@nanocloud.com\r\nBcc: attacker@example.com",
+		Subject: "Réinitialisation",
+		Body:    "Hello,\nThe link:\r\nhttp://localhost\n",
+	}
+
+	date := time.Date(2016, 5, 3, 10, 0, 0, 0, time.UTC)
+	out := string(Format("Nanocloud <no-reply@nanocloud.com>", msg, date))
+
+	headers := strings.SplitN(out, "\r\n\r\n", 2)
+	if len(headers) != 2 {
+		t.Fatalf("The headers are not separated from the body: %q", out)
+	}
+
+	if strings.Contains(headers[0], "\r\nBcc:") {
+		t.Errorf("A header was injected: %q", headers[0])
+	}
+
+	if !strings.Contains(headers[0], "Subject: =?utf-8?q?R=C3=A9initialisation?=\r\n") {
+		t.Errorf("The subject is not encoded: %q", headers[0])
+	}
+
+	if !strings.Contains(headers[0], "Date: Tue, 03 May 2016 10:00:00 +0000\r\n") {
+		t.Errorf("Invalid date: %q", headers[0])
+	}
+
+	if headers[1] != "Hello,\r\nThe link:\r\nhttp://localhost\r\n" {
+		t.Errorf("Invalid body: %q", headers[1])
+	}
+}
+
+func TestFake(t *testing.T) {
+	fake := &Fake{}
+	SetSender(fake)
+	defer SetSender(nil)
+
+	err := Send(&Message{To: "a@nanocloud.com", Subject: "First"})
+	if err != nil {
+		t.Fatalf("Cannot send: %s", err.Error())
+	}
+	Send(&Message{To: "b@nanocloud.com", Subject: "Other"})
+	Send(&Message{To: "a@nanocloud.com", Subject: "Second"})
+
+	msg := fake.Last("a@nanocloud.com")
+	if msg == nil || msg.Subject != "Second" {
+		t.Errorf("Expected the second message, got %v", msg)
+	}
+
+	if fake.Last("c@nanocloud.com") != nil {
+		t.Errorf("No message was sent to c@nanocloud.com")
+	}
+
+	SetSender(nil)
+	err = Send(&Message{To: "a@nanocloud.com"})
+	if err != NotConfigured {
+		t.Errorf("Expected NotConfigured, got %v", err)
+	}
+}
+
+func TestLink(t *testing.T) {
+	link := Link("activate", url.Values{"token": {"a b&c"}})
+	if !strings.HasSuffix(link, "/#/activate?token=a+b%26c") {
+		t.Errorf("Unexpected link %s", link)
+	}
+
+	link = Link("login", nil)
+	if !strings.HasSuffix(link, "/#/login") {
+		t.Errorf("Unexpected link %s", link)
+	}
+}
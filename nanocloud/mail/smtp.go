@@ -0,0 +1,129 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mail
+
+import (
+	"crypto/tls"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/utils"
+)
+
+// SMTP sends the messages through a mail server. With TLS, the connection
+// is encrypted from the start (port 465), otherwise STARTTLS is used when
+// the server supports it.
+type SMTP struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	TLS      bool
+}
+
+func SMTPFromEnv() *SMTP {
+	return &SMTP{
+		Host:     utils.Env("SMTP_HOST", ""),
+		Port:     utils.Env("SMTP_PORT", "25"),
+		Username: utils.Env("SMTP_USERNAME", ""),
+		Password: utils.Env("SMTP_PASSWORD", ""),
+		TLS:      utils.Env("SMTP_TLS", "false") == "true",
+	}
+}
+
+func (s *SMTP) Send(from string, msg *Message) error {
+	sender, err := netmail.ParseAddress(from)
+	if err != nil {
+		return err
+	}
+
+	recipient, err := netmail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	address := net.JoinHostPort(s.Host, s.Port)
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+
+	var conn net.Conn
+	if s.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: s.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if !s.TLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			err = client.StartTLS(&tls.Config{ServerName: s.Host})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(sender.Address)
+	if err != nil {
+		return err
+	}
+
+	err = client.Rcpt(recipient.Address)
+	if err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(Format(from, msg, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -24,21 +24,39 @@ package main
 
 import (
 	"errors"
+	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	vmsConn "github.com/Nanocloud/community/nanocloud/connectors/vms"
 	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/metrics"
 	m "github.com/Nanocloud/community/nanocloud/middlewares"
 	"github.com/Nanocloud/community/nanocloud/migration"
-	_ "github.com/Nanocloud/community/nanocloud/models/oauth"
+	directoryModel "github.com/Nanocloud/community/nanocloud/models/directory"
+	historiesModel "github.com/Nanocloud/community/nanocloud/models/histories"
+	oauthModel "github.com/Nanocloud/community/nanocloud/models/oauth"
+	rolesModel "github.com/Nanocloud/community/nanocloud/models/roles"
+	sessionsModel "github.com/Nanocloud/community/nanocloud/models/sessions"
+	"github.com/Nanocloud/community/nanocloud/plaza"
 	"github.com/Nanocloud/community/nanocloud/routes/apps"
+	"github.com/Nanocloud/community/nanocloud/routes/audit"
+	"github.com/Nanocloud/community/nanocloud/routes/directory-sync"
 	"github.com/Nanocloud/community/nanocloud/routes/files"
 	"github.com/Nanocloud/community/nanocloud/routes/front"
+	"github.com/Nanocloud/community/nanocloud/routes/groups"
 	"github.com/Nanocloud/community/nanocloud/routes/histories"
+	"github.com/Nanocloud/community/nanocloud/routes/invitations"
 	"github.com/Nanocloud/community/nanocloud/routes/machine-drivers"
 	"github.com/Nanocloud/community/nanocloud/routes/machines"
 	"github.com/Nanocloud/community/nanocloud/routes/oauth"
+	"github.com/Nanocloud/community/nanocloud/routes/oauth-clients"
+	"github.com/Nanocloud/community/nanocloud/routes/password-resets"
+	"github.com/Nanocloud/community/nanocloud/routes/roles"
 	"github.com/Nanocloud/community/nanocloud/routes/sessions"
+	"github.com/Nanocloud/community/nanocloud/routes/signup"
+	"github.com/Nanocloud/community/nanocloud/routes/sso"
 	"github.com/Nanocloud/community/nanocloud/routes/tokens"
 	"github.com/Nanocloud/community/nanocloud/routes/upload"
 	"github.com/Nanocloud/community/nanocloud/routes/users"
@@ -83,6 +101,95 @@ func initVms() error {
 	return nil
 }
 
+// updatePlaza periodically pushes the plaza release to the machines running
+// an other version. PLAZA_UPDATE_INTERVAL is the interval in minutes; 0
+// disables automatic updates.
+func updatePlaza() {
+	interval, err := strconv.Atoi(utils.Env("PLAZA_UPDATE_INTERVAL", "0"))
+	if err != nil || interval <= 0 {
+		return
+	}
+
+	port, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	for {
+		time.Sleep(time.Duration(interval) * time.Minute)
+
+		release, err := plaza.LoadRelease()
+		if err != nil {
+			log.Errorf("Unable to load the plaza release: %s", err)
+			continue
+		}
+
+		machines, err := vmsConn.Machines()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		plaza.UpdateMachines(machines, port, release)
+	}
+}
+
+// recordHistories polls the sessions opened on the machines to record when
+// users start and end them. HISTORY_POLL_INTERVAL is the interval in seconds;
+// 0 disables the recording.
+func recordHistories() {
+	interval, err := strconv.Atoi(utils.Env("HISTORY_POLL_INTERVAL", "30"))
+	if err != nil || interval <= 0 {
+		return
+	}
+
+	port, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	for {
+		time.Sleep(time.Duration(interval) * time.Second)
+
+		machines, err := vmsConn.Machines()
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+
+		list, reached, err := sessionsModel.ListReachableSessions(machines, port)
+		if err != nil {
+			log.Errorf("Unable to list the sessions: %s", err)
+			continue
+		}
+
+		err = historiesModel.Record(list, reached, time.Now())
+		if err != nil {
+			log.Errorf("Unable to record the histories: %s", err)
+		}
+	}
+}
+
+// syncDirectory synchronizes the users and groups with the LDAP directory
+// when enabled in the config table. The settings are read again before
+// each run.
+func syncDirectory() {
+	for {
+		time.Sleep(directoryModel.LoadConfig().Interval)
+
+		cfg := directoryModel.LoadConfig()
+		if !cfg.Enabled {
+			continue
+		}
+
+		_, err := directoryModel.Sync(cfg)
+		if err != nil {
+			log.Errorf("Unable to synchronize the directory: %s", err)
+		}
+	}
+}
+
 func main() {
 	err := migration.Migrate()
 	if err != nil {
@@ -92,71 +199,147 @@ func main() {
 	p := echo.New()
 	go p.Run(":8181")
 
+	// Count the requests sent to plaza and to the iaas API.
+	targets := map[string]string{"8080": "iaas"}
+	targets[utils.Env("PLAZA_PORT", "9090")] = "plaza"
+	http.DefaultTransport = &metrics.Transport{
+		Base:    http.DefaultTransport,
+		Targets: targets,
+	}
+
 	err = initVms()
 	if err != nil {
 		log.Error(err)
 		return
 	}
 
+	go updatePlaza()
+	go recordHistories()
+	go syncDirectory()
+
 	e := echo.New()
 	e.SetLogLevel(logger.DEBUG)
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
+	e.Use(metrics.Middleware)
 
 	e.SetHTTPErrorHandler(apiErrors.Handler)
 
+	/**
+	 * METRICS
+	 */
+	e.Get("/metrics", metrics.Handler)
+
 	/**
 	 * APPS
 	 */
-	e.Get("/api/apps", m.OAuth2(apps.ListApplications))
-	e.Delete("/api/apps/:app_id", m.OAuth2(m.Admin(apps.UnpublishApplication)))
-	e.Post("/api/apps", m.OAuth2(m.Admin(apps.PublishApplication)))
-	e.Get("/api/apps/connections", m.OAuth2(apps.GetConnections))
-	e.Patch("/api/apps/:app_id", m.OAuth2(m.Admin(apps.ChangeAppName)))
+	e.Get("/api/apps", m.OAuth2(m.Scope(oauthModel.AppsScope, apps.ListApplications)))
+	e.Delete("/api/apps/:app_id", m.OAuth2(m.Audit("apps.unpublish", "apps", m.Require(rolesModel.AppsPublish, apps.UnpublishApplication))))
+	e.Post("/api/apps", m.OAuth2(m.Audit("apps.publish", "apps", m.Require(rolesModel.AppsPublish, apps.PublishApplication))))
+	e.Get("/api/apps/connections", m.OAuth2(m.Scope(oauthModel.AppsScope, apps.GetConnections)))
+	e.Patch("/api/apps/:app_id", m.OAuth2(m.Audit("apps.rename", "apps", m.Require(rolesModel.AppsPublish, apps.ChangeAppName))))
+	e.Get("/api/apps/:app_id/assignments", m.OAuth2(m.Require(rolesModel.AppsRead, apps.ListAssignments)))
+	e.Post("/api/apps/:app_id/assignments", m.OAuth2(m.Audit("apps.assign", "apps", m.Require(rolesModel.AppsAssign, apps.CreateAssignment))))
+	e.Delete("/api/apps/:app_id/assignments/:id", m.OAuth2(m.Audit("apps.unassign", "apps", m.Require(rolesModel.AppsAssign, apps.DeleteAssignment))))
+
+	/**
+	 * GROUPS
+	 */
+	e.Get("/api/groups", m.OAuth2(m.Require(rolesModel.GroupsRead, groups.List)))
+	e.Post("/api/groups", m.OAuth2(m.Audit("groups.create", "groups", m.Require(rolesModel.GroupsManage, groups.Create))))
+	e.Get("/api/groups/:id", m.OAuth2(m.Require(rolesModel.GroupsRead, groups.Get)))
+	e.Patch("/api/groups/:id", m.OAuth2(m.Audit("groups.rename", "groups", m.Require(rolesModel.GroupsManage, groups.Update))))
+	e.Delete("/api/groups/:id", m.OAuth2(m.Audit("groups.delete", "groups", m.Require(rolesModel.GroupsManage, groups.Delete))))
+	e.Get("/api/groups/:id/users", m.OAuth2(m.Require(rolesModel.GroupsRead, groups.ListMembers)))
+	e.Post("/api/groups/:id/users", m.OAuth2(m.Audit("groups.add-member", "groups", m.Require(rolesModel.GroupsManage, groups.AddMember))))
+	e.Delete("/api/groups/:id/users/:user_id", m.OAuth2(m.Audit("groups.remove-member", "groups", m.Require(rolesModel.GroupsManage, groups.RemoveMember))))
+
+	/**
+	 * ROLES
+	 */
+	e.Get("/api/permissions", m.OAuth2(m.Require(rolesModel.RolesManage, roles.Permissions)))
+	e.Get("/api/roles", m.OAuth2(m.Require(rolesModel.RolesManage, roles.List)))
+	e.Post("/api/roles", m.OAuth2(m.Audit("roles.create", "roles", m.Require(rolesModel.RolesManage, roles.Create))))
+	e.Get("/api/roles/:id", m.OAuth2(m.Require(rolesModel.RolesManage, roles.Get)))
+	e.Patch("/api/roles/:id", m.OAuth2(m.Audit("roles.update", "roles", m.Require(rolesModel.RolesManage, roles.Update))))
+	e.Delete("/api/roles/:id", m.OAuth2(m.Audit("roles.delete", "roles", m.Require(rolesModel.RolesManage, roles.Delete))))
+	e.Get("/api/roles/:id/assignments", m.OAuth2(m.Require(rolesModel.RolesManage, roles.ListAssignments)))
+	e.Post("/api/roles/:id/assignments", m.OAuth2(m.Audit("roles.assign", "roles", m.Require(rolesModel.RolesManage, roles.CreateAssignment))))
+	e.Delete("/api/roles/:id/assignments/:assignment_id", m.OAuth2(m.Audit("roles.unassign", "roles", m.Require(rolesModel.RolesManage, roles.DeleteAssignment))))
 
 	/**
 	 * SESSIONS
 	 */
 
-	e.Get("/api/sessions", m.OAuth2(sessions.List))
-	e.Delete("/api/sessions", m.OAuth2(sessions.Logoff))
+	e.Get("/api/sessions", m.OAuth2(m.Scope(oauthModel.AppsScope, sessions.List)))
+	e.Delete("/api/sessions", m.OAuth2(m.Audit("sessions.logoff", "sessions", m.Scope(oauthModel.AppsScope, sessions.Logoff))))
+
+	e.Get("/api/machine-sessions", m.OAuth2(m.Require(rolesModel.SessionsRead, sessions.ListAll)))
+	e.Delete("/api/machine-sessions/:id", m.OAuth2(m.Audit("machine-sessions.logoff", "machine-sessions", m.Require(rolesModel.SessionsManage, sessions.LogoffSession))))
+	e.Post("/api/machine-sessions/:id/disconnect", m.OAuth2(m.Audit("machine-sessions.disconnect", "machine-sessions", m.Require(rolesModel.SessionsManage, sessions.Disconnect))))
+	e.Post("/api/machine-sessions/:id/messages", m.OAuth2(m.Audit("machine-sessions.message", "machine-sessions", m.Require(rolesModel.SessionsManage, sessions.SendMessage))))
 
 	/**
 	 * HISTORY
 	 */
-	e.Get("/api/histories", m.OAuth2(histories.List))
-	e.Post("/api/histories", m.OAuth2(histories.Add))
+	e.Get("/api/histories", m.OAuth2(m.Require(rolesModel.HistoriesRead, histories.List)))
+	e.Get("/api/reports/usage", m.OAuth2(m.Require(rolesModel.HistoriesRead, histories.Usage)))
+	e.Get("/api/reports/concurrency", m.OAuth2(m.Require(rolesModel.HistoriesRead, histories.Concurrency)))
 	//m.OAuth2(
 
 	/**
 	 * USERS
 	 */
-	e.Patch("/api/users/:id", m.OAuth2(users.Update))
-	e.Get("/api/users", m.OAuth2(users.Get))
-	e.Post("/api/users", m.OAuth2(m.Admin(users.Post)))
-	e.Delete("/api/users/:id", m.OAuth2(m.Admin(users.Delete)))
-	e.Put("/api/users/:id", m.OAuth2(m.Admin(users.UpdatePassword)))
-	e.Get("/api/users/:id", m.OAuth2(users.GetUser))
+	e.Patch("/api/users/:id", m.OAuth2(m.Audit("users.update", "users", m.Scope(oauthModel.ProfileScope, users.Update))))
+	e.Get("/api/users", m.OAuth2(m.Scope(oauthModel.ProfileScope, users.Get)))
+	e.Post("/api/users/import", m.OAuth2(m.Audit("users.import", "users", m.Require(rolesModel.UsersManage, users.Import))))
+	e.Get("/api/users/export", m.OAuth2(m.Require(rolesModel.UsersManage, users.Export)))
+	e.Post("/api/users", m.OAuth2(m.Audit("users.create", "users", m.Require(rolesModel.UsersManage, users.Post))))
+	e.Delete("/api/users/:id", m.OAuth2(m.Audit("users.delete", "users", m.Require(rolesModel.UsersManage, users.Delete))))
+	e.Put("/api/users/:id", m.OAuth2(m.Audit("users.password", "users", m.Require(rolesModel.UsersPassword, users.UpdatePassword))))
+	e.Get("/api/users/:id", m.OAuth2(m.Scope(oauthModel.ProfileScope, users.GetUser)))
+	e.Post("/api/password-resets", m.Audit("password-resets.create", "users", passwordresets.Create))
+	e.Put("/api/password-resets", m.Audit("password-resets.reset", "users", passwordresets.Reset))
+	e.Get("/api/users/:id/mfa", m.OAuth2(m.Scope(oauthModel.ProfileScope, users.GetMFA)))
+	e.Patch("/api/users/:id/mfa", m.OAuth2(m.Audit("users.mfa.update", "users", m.Require(rolesModel.UsersManage, users.UpdateMFA))))
+	e.Post("/api/users/:id/mfa", m.OAuth2(m.Audit("users.mfa.enroll", "users", m.Scope(oauthModel.ProfileScope, users.EnrollMFA))))
+	e.Post("/api/users/:id/mfa/verify", m.OAuth2(m.Audit("users.mfa.confirm", "users", m.Scope(oauthModel.ProfileScope, users.ConfirmMFA))))
+	e.Post("/api/users/:id/mfa/recovery-codes", m.OAuth2(m.Audit("users.mfa.recovery-codes", "users", m.Scope(oauthModel.ProfileScope, users.RegenerateRecoveryCodes))))
+	e.Delete("/api/users/:id/mfa", m.OAuth2(m.Audit("users.mfa.reset", "users", m.Scope(oauthModel.ProfileScope, users.ResetMFA))))
+
+	/**
+	 * SIGNUP
+	 */
+	e.Get("/api/signup", signup.Settings)
+	e.Post("/api/signup", m.Audit("signup.create", "users", signup.Create))
+	e.Post("/api/signup/activate", m.Audit("signup.activate", "users", signup.Activate))
+	e.Get("/api/signup/invitation", signup.GetInvitation)
+	e.Post("/api/signup/invitation", m.Audit("signup.accept", "users", signup.AcceptInvitation))
+	e.Get("/api/invitations", m.OAuth2(m.Require(rolesModel.UsersManage, invitations.List)))
+	e.Post("/api/invitations", m.OAuth2(m.Audit("invitations.create", "invitations", m.Require(rolesModel.UsersManage, invitations.Create))))
+	e.Delete("/api/invitations/:id", m.OAuth2(m.Audit("invitations.delete", "invitations", m.Require(rolesModel.UsersManage, invitations.Delete))))
 
 	/**
 	 * MACHINES
 	 */
-	e.Get("/api/machines", m.OAuth2(m.Admin(machines.Machines)))
-	e.Get("/api/machines/:id", m.OAuth2(m.Admin(machines.GetMachine)))
-	e.Patch("/api/machines/:id", m.OAuth2(m.Admin(machines.PatchMachine)))
-	e.Post("/api/machines", m.OAuth2(m.Admin(machines.CreateMachine)))
-	e.Delete("/api/machines/:id", m.OAuth2(m.Admin(machines.DeleteMachine)))
+	e.Get("/api/machines", m.OAuth2(m.Require(rolesModel.MachinesRead, machines.Machines)))
+	e.Get("/api/machines/:id", m.OAuth2(m.Require(rolesModel.MachinesRead, machines.GetMachine)))
+	e.Patch("/api/machines/:id", m.OAuth2(m.Audit("machines.update", "machines", m.Require(rolesModel.MachinesWrite, machines.PatchMachine))))
+	e.Post("/api/machines", m.OAuth2(m.Audit("machines.create", "machines", m.Require(rolesModel.MachinesWrite, machines.CreateMachine))))
+	e.Post("/api/machines/update-plaza", m.OAuth2(m.Audit("machines.update-plaza", "machines", m.Require(rolesModel.MachinesWrite, machines.UpdatePlaza))))
+	e.Delete("/api/machines/:id", m.OAuth2(m.Audit("machines.delete", "machines", m.Require(rolesModel.MachinesWrite, machines.DeleteMachine))))
 
 	/**
 	 * MACHINES DRIVERS
 	 */
-	e.Get("/api/machine-drivers", m.OAuth2(m.Admin(machinedrivers.FindAll)))
+	e.Get("/api/machine-drivers", m.OAuth2(m.Require(rolesModel.MachinesRead, machinedrivers.FindAll)))
 
 	/**
 	 * Files
 	 */
 	e.Get("/api/files", files.Get)
-	e.Get("/api/files/token", m.OAuth2(files.GetDownloadToken))
+	e.Get("/api/files/archive", files.GetArchive)
+	e.Get("/api/files/token", m.OAuth2(m.Scope(oauthModel.FilesScope, files.GetDownloadToken)))
 
 	/**
 	 * FRONT
@@ -168,16 +351,55 @@ func main() {
 	 */
 	e.Any("/oauth/*", oauth.Handler)
 
+	/**
+	 * SSO
+	 */
+	e.Get("/sso/providers", sso.Providers)
+	e.Get("/sso/:provider/login", sso.Login)
+	e.Get("/sso/oidc/callback", sso.OIDCCallback)
+	e.Post("/sso/saml/acs", sso.SAMLACS)
+	e.Get("/sso/saml/metadata", sso.SAMLMetadata)
+
+	/**
+	 * OAUTH CLIENTS
+	 */
+	e.Get("/api/oauth-clients", m.OAuth2(m.Require(rolesModel.OAuthClientsManage, oauthclients.List)))
+	e.Post("/api/oauth-clients", m.OAuth2(m.Audit("oauth-clients.create", "oauth-clients", m.Require(rolesModel.OAuthClientsManage, oauthclients.Create))))
+	e.Get("/api/oauth-clients/:id", m.OAuth2(m.Require(rolesModel.OAuthClientsManage, oauthclients.Get)))
+	e.Patch("/api/oauth-clients/:id", m.OAuth2(m.Audit("oauth-clients.update", "oauth-clients", m.Require(rolesModel.OAuthClientsManage, oauthclients.Update))))
+	e.Delete("/api/oauth-clients/:id", m.OAuth2(m.Audit("oauth-clients.delete", "oauth-clients", m.Require(rolesModel.OAuthClientsManage, oauthclients.Delete))))
+
+	/**
+	 * DIRECTORY SYNC
+	 */
+	e.Get("/api/directory-sync/preview", m.OAuth2(m.Require(rolesModel.UsersManage, directorysync.Preview)))
+	e.Post("/api/directory-sync", m.OAuth2(m.Audit("directory-sync.apply", "directory-sync", m.Require(rolesModel.UsersManage, directorysync.Apply))))
+
 	/**
 	 * TOKENS
 	 */
-	e.Get("/api/tokens", m.OAuth2(tokens.Get))
-	e.Delete("/api/tokens/:id", m.OAuth2(tokens.Delete))
+	e.Get("/api/tokens", m.OAuth2(m.Scope(oauthModel.ProfileScope, tokens.Get)))
+	e.Post("/api/tokens", m.OAuth2(m.Audit("tokens.create", "tokens", m.Scope(oauthModel.ProfileScope, tokens.Create))))
+	e.Delete("/api/tokens/:id", m.OAuth2(m.Audit("tokens.revoke", "tokens", m.Scope(oauthModel.ProfileScope, tokens.Delete))))
+	e.Delete("/api/tokens", m.OAuth2(m.Audit("tokens.revoke-others", "tokens", m.Scope(oauthModel.ProfileScope, tokens.DeleteOthers))))
+	e.Get("/api/users/:id/tokens", m.OAuth2(m.Require(rolesModel.UsersManage, tokens.GetUserTokens)))
+	e.Delete("/api/users/:id/tokens", m.OAuth2(m.Audit("users.tokens.revoke-all", "users", m.Require(rolesModel.UsersManage, tokens.DeleteUserTokens))))
+	e.Delete("/api/users/:id/tokens/:token_id", m.OAuth2(m.Audit("users.tokens.revoke", "users", m.Require(rolesModel.UsersManage, tokens.DeleteUserToken))))
+
+	/**
+	 * AUDIT
+	 */
+	e.Get("/api/audit", m.OAuth2(m.Require(rolesModel.AuditRead, audit.List)))
 
 	/**
 	 * UPLOAD
+	 * File transfers are not administrative actions and are not audited.
 	 */
 	e.Post("/upload", upload.Post)
+	e.Post("/api/uploads", m.OAuth2(m.Scope(oauthModel.FilesScope, upload.CreateUpload)))
+	e.Head("/api/uploads/:id", m.OAuth2(m.Scope(oauthModel.FilesScope, upload.GetUploadOffset)))
+	e.Patch("/api/uploads/:id", m.OAuth2(m.Scope(oauthModel.FilesScope, upload.PatchUpload)))
+	e.Delete("/api/uploads/:id", m.OAuth2(m.Scope(oauthModel.FilesScope, upload.DeleteUpload)))
 
 	addr := ":" + utils.Env("BACKEND_PORT", "8080")
 	log.Info("Server running at ", addr)
@@ -0,0 +1,135 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+var (
+	httpRequestDuration = NewHistogram(
+		"nanocloud_http_request_duration_seconds",
+		"Duration of the HTTP requests handled by nanocloud.",
+		DefaultBuckets,
+		"method", "route", "status",
+	)
+
+	outgoingRequests = NewCounter(
+		"nanocloud_outgoing_requests_total",
+		"HTTP requests sent by nanocloud to plaza and the iaas.",
+		"target", "method",
+	)
+
+	outgoingErrors = NewCounter(
+		"nanocloud_outgoing_request_errors_total",
+		"HTTP requests sent by nanocloud that failed or got a 5xx response.",
+		"target", "method",
+	)
+)
+
+// errorStatus returns the status sent for an error returned by a handler.
+func errorStatus(err error) int {
+	switch e := err.(type) {
+	case interface {
+		StatusCode() int
+	}:
+		return e.StatusCode()
+	case interface {
+		Code() int
+	}:
+		return e.Code()
+	}
+	return http.StatusInternalServerError
+}
+
+// Middleware records the duration of the requests per route.
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		status := c.Response().Status()
+		if err != nil {
+			status = errorStatus(err)
+		} else if status == 0 {
+			status = http.StatusOK
+		}
+
+		// The registered path is used rather than the URL to keep the number
+		// of series bounded.
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		httpRequestDuration.Observe(
+			time.Since(start).Seconds(),
+			c.Request().Method, route, strconv.Itoa(status),
+		)
+		return err
+	}
+}
+
+// Transport counts the requests sent through Base. The requests are labeled
+// with the target associated to the port of their URL in Targets, or `other`.
+type Transport struct {
+	Base    http.RoundTripper
+	Targets map[string]string
+}
+
+func (t *Transport) target(req *http.Request) string {
+	_, port, err := net.SplitHostPort(req.URL.Host)
+	if err != nil {
+		port = "80"
+	}
+
+	if target, ok := t.Targets[port]; ok {
+		return target
+	}
+	return "other"
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := t.target(req)
+	outgoingRequests.Inc(target, req.Method)
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil || resp.StatusCode >= 500 {
+		outgoingErrors.Inc(target, req.Method)
+	}
+	return resp, err
+}
+
+// CancelRequest is required by http.Client to support timeouts.
+func (t *Transport) CancelRequest(req *http.Request) {
+	if c, ok := t.Base.(interface {
+		CancelRequest(*http.Request)
+	}); ok {
+		c.CancelRequest(req)
+	}
+}
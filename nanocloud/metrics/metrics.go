@@ -0,0 +1,362 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package metrics collects the metrics of nanocloud and exposes them in the
+// Prometheus text format.
+package metrics
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Nanocloud/community/nanocloud/utils"
+	"github.com/labstack/echo"
+)
+
+// A sample of a metric computed when the metrics are collected.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+type metric interface {
+	write(b *bytes.Buffer)
+}
+
+var (
+	registry     = make(map[string]metric)
+	registryLock sync.Mutex
+)
+
+func register(name string, m metric) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, exists := registry[name]; exists {
+		panic("metric " + name + " registered twice")
+	}
+	registry[name] = m
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels returns the labels of a sample as `{name="value",...}`.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + labelEscaper.Replace(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+func writeHeader(b *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, kind)
+}
+
+// vector holds the values of a metric for each combination of labels.
+type vector struct {
+	name   string
+	help   string
+	labels []string
+
+	lock   sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newVector(name, help string, labels []string) *vector {
+	v := &vector{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		keys:   make(map[string][]string),
+	}
+
+	// A metric without labels is reported even before its first update.
+	if len(labels) == 0 {
+		v.values[""] = 0
+	}
+	return v
+}
+
+func (v *vector) add(delta float64, labels []string) {
+	key := strings.Join(labels, "\xff")
+
+	v.lock.Lock()
+	v.values[key] += delta
+	v.keys[key] = labels
+	v.lock.Unlock()
+}
+
+func (v *vector) writeValues(b *bytes.Buffer) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s%s %s\n", v.name, formatLabels(v.labels, v.keys[key]), formatValue(v.values[key]))
+	}
+}
+
+// A Counter is a value that only goes up, like a number of requests.
+type Counter struct {
+	*vector
+}
+
+// NewCounter registers a counter. The label values are passed in the same
+// order as labels when the counter is incremented.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newVector(name, help, labels)}
+	register(name, c)
+	return c
+}
+
+func (c *Counter) Inc(labels ...string) {
+	c.add(1, labels)
+}
+
+func (c *Counter) Add(delta float64, labels ...string) {
+	if delta < 0 {
+		panic("counter cannot decrease")
+	}
+	c.add(delta, labels)
+}
+
+func (c *Counter) write(b *bytes.Buffer) {
+	writeHeader(b, c.name, "counter", c.help)
+	c.writeValues(b)
+}
+
+// A Gauge is a value that can go up and down, like a number of runs in
+// progress.
+type Gauge struct {
+	*vector
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newVector(name, help, labels)}
+	register(name, g)
+	return g
+}
+
+func (g *Gauge) Inc(labels ...string) {
+	g.add(1, labels)
+}
+
+func (g *Gauge) Dec(labels ...string) {
+	g.add(-1, labels)
+}
+
+func (g *Gauge) write(b *bytes.Buffer) {
+	writeHeader(b, g.name, "gauge", g.help)
+	g.writeValues(b)
+}
+
+// A GaugeFunc is a gauge whose samples are computed each time the metrics are
+// collected.
+type GaugeFunc struct {
+	name   string
+	help   string
+	labels []string
+	fn     func() ([]Sample, error)
+}
+
+func NewGaugeFunc(name, help string, labels []string, fn func() ([]Sample, error)) *GaugeFunc {
+	g := &GaugeFunc{
+		name:   name,
+		help:   help,
+		labels: labels,
+		fn:     fn,
+	}
+	register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(b *bytes.Buffer) {
+	samples, err := g.fn()
+	if err != nil {
+		// A collection error must not hide the other metrics.
+		fmt.Fprintf(b, "# %s not collected: %s\n", g.name, strings.Replace(err.Error(), "\n", " ", -1))
+		return
+	}
+
+	writeHeader(b, g.name, "gauge", g.help)
+	for _, s := range samples {
+		fmt.Fprintf(b, "%s%s %s\n", g.name, formatLabels(g.labels, s.Labels), formatValue(s.Value))
+	}
+}
+
+// Default buckets of the histograms, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// A Histogram counts observations, like request durations, in buckets.
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	lock   sync.Mutex
+	values map[string]*histogramValue
+}
+
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labels ...string) {
+	key := strings.Join(labels, "\xff")
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	value, exists := h.values[key]
+	if !exists {
+		value = &histogramValue{
+			labels: labels,
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = value
+	}
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			value.counts[i]++
+		}
+	}
+	value.count++
+	value.sum += v
+}
+
+func (h *Histogram) write(b *bytes.Buffer) {
+	writeHeader(b, h.name, "histogram", h.help)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+
+	for _, key := range keys {
+		value := h.values[key]
+		for i, bound := range h.buckets {
+			labels := append(append([]string{}, value.labels...), formatValue(bound))
+			fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, labels), value.counts[i])
+		}
+		labels := append(append([]string{}, value.labels...), "+Inf")
+		fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, labels), value.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", h.name, formatLabels(h.labels, value.labels), formatValue(value.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", h.name, formatLabels(h.labels, value.labels), value.count)
+	}
+}
+
+// Write writes all the registered metrics, sorted by name.
+func Write(b *bytes.Buffer) {
+	registryLock.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	metrics := make([]metric, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		metrics[i] = registry[name]
+	}
+	registryLock.Unlock()
+
+	for _, m := range metrics {
+		m.write(b)
+	}
+}
+
+// Handler serves the metrics. If METRICS_TOKEN is set, the request must be
+// authenticated with `Authorization: Bearer {METRICS_TOKEN}`.
+func Handler(c *echo.Context) error {
+	token := utils.Env("METRICS_TOKEN", "")
+	auth := c.Request().Header.Get("Authorization")
+	if token != "" && subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+		c.Response().WriteHeader(http.StatusUnauthorized)
+		return nil
+	}
+
+	b := new(bytes.Buffer)
+	Write(b)
+
+	r := c.Response()
+	r.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteHeader(http.StatusOK)
+	_, err := r.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_counter_total", "Test counter.", "method")
+	c.Inc("GET")
+	c.Inc("GET")
+	c.Add(3, `P"OST`)
+
+	b := new(bytes.Buffer)
+	c.write(b)
+	out := b.String()
+
+	expected := []string{
+		"# TYPE test_counter_total counter",
+		`test_counter_total{method="GET"} 2`,
+		`test_counter_total{method="P\"OST"} 3`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Missing line %q in:\n%s", line, out)
+		}
+	}
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("test_gauge", "Test gauge.")
+
+	b := new(bytes.Buffer)
+	g.write(b)
+	if !strings.Contains(b.String(), "test_gauge 0\n") {
+		t.Errorf("A gauge without labels should be reported before its first update")
+	}
+
+	g.Inc()
+	g.Inc()
+	g.Dec()
+
+	b.Reset()
+	g.write(b)
+	if !strings.Contains(b.String(), "test_gauge 1\n") {
+		t.Errorf("Unexpected gauge value:\n%s", b.String())
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "Test histogram.", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/")
+	h.Observe(0.5, "/")
+	h.Observe(5, "/")
+
+	b := new(bytes.Buffer)
+	h.write(b)
+	out := b.String()
+
+	expected := []string{
+		`test_duration_seconds_bucket{route="/",le="0.1"} 1`,
+		`test_duration_seconds_bucket{route="/",le="1"} 2`,
+		`test_duration_seconds_bucket{route="/",le="+Inf"} 3`,
+		`test_duration_seconds_sum{route="/"} 5.55`,
+		`test_duration_seconds_count{route="/"} 3`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Missing line %q in:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/Nanocloud/community/nanocloud/models/audit"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// responseStatus returns the status code of the response sent for err.
+func responseStatus(c *echo.Context, err error) int {
+	if err != nil {
+		if e, ok := err.(interface {
+			StatusCode() int
+		}); ok {
+			return e.StatusCode()
+		}
+		return http.StatusInternalServerError
+	}
+
+	status := c.Response().Status()
+	if status == 0 {
+		return http.StatusOK
+	}
+	return status
+}
+
+func auditAction(c *echo.Context, action, resourceType string, handler echo.HandlerFunc) error {
+	err := handler(c)
+
+	entry := audit.Entry{
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceId:   c.Param("app_id"),
+		Ip:           utils.ClientIP(c.Request()),
+		Status:       responseStatus(c, err),
+	}
+
+	if entry.ResourceId == "" {
+		entry.ResourceId = c.Param("id")
+	}
+
+	if user, ok := c.Get("user").(*users.User); ok {
+		entry.ActorId = user.GetID()
+		entry.ActorMail = user.Email
+	}
+
+	if entry.Status < http.StatusBadRequest {
+		entry.Outcome = audit.Success
+	} else {
+		entry.Outcome = audit.Failure
+	}
+
+	auditErr := audit.Record(&entry)
+	if auditErr != nil {
+		log.Errorf("Unable to record the %s action: %s", action, auditErr)
+	}
+	return err
+}
+
+// Audit records who performed the action on the resource, from where, and
+// whether it succeeded. It must be wrapped by OAuth2 to know the actor.
+func Audit(action, resourceType string, handler echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		return auditAction(c, action, resourceType, handler)
+	}
+}
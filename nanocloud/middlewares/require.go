@@ -0,0 +1,67 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/labstack/echo"
+)
+
+// Permissions returns the permissions of the current user. Access tokens
+// without the admin scope have none.
+func Permissions(c *echo.Context) (roles.Permissions, error) {
+	if !hasScope(c, oauth.AdminScope) {
+		return roles.Permissions{}, nil
+	}
+
+	user := c.Get("user").(*users.User)
+	return roles.UserPermissions(user)
+}
+
+// Can reports whether the current user has the permission with the access
+// token of the request.
+func Can(c *echo.Context, permission string) bool {
+	user := c.Get("user").(*users.User)
+	return hasScope(c, oauth.AdminScope) && roles.Can(user, permission)
+}
+
+func require(c *echo.Context, permission string, handler echo.HandlerFunc) error {
+	if !Can(c, permission) {
+		return c.JSON(http.StatusForbidden, hash{
+			"error": "forbidden",
+		})
+	}
+	return handler(c)
+}
+
+// Require only lets the users having the permission reach the handler, with
+// an access token granted the admin scope.
+func Require(permission string, handler echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		return require(c, permission, handler)
+	}
+}
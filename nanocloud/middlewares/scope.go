@@ -0,0 +1,71 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/oauth2"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// hasScope reports whether the access token of the request was granted the
+// scope.
+func hasScope(c *echo.Context, scope string) bool {
+	token, oauthErr := oauth2.GetAccessToken(c.Request())
+	if oauthErr != nil {
+		return false
+	}
+
+	scopes, err := oauth.TokenScopes(token)
+	if err != nil {
+		log.Errorf("Unable to retrieve the scopes of the access token: %s", err)
+		return false
+	}
+
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func requireScope(c *echo.Context, scope string, handler echo.HandlerFunc) error {
+	if !hasScope(c, scope) {
+		return c.JSON(http.StatusForbidden, hash{
+			"error": "insufficient_scope",
+		})
+	}
+	return handler(c)
+}
+
+// Scope only lets the access tokens granted the scope reach the handler.
+// Routes protected by Require need the admin scope.
+func Scope(scope string, handler echo.HandlerFunc) echo.HandlerFunc {
+	return func(c *echo.Context) error {
+		return requireScope(c, scope, handler)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audit
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+	log "github.com/Sirupsen/logrus"
+)
+
+func Migrate() error {
+	// Actors are not referenced so the entries outlive the deleted users.
+	created, err := schema.CreateTable("audit_logs",
+		`CREATE TABLE audit_logs (
+			id              varchar(36)                PRIMARY KEY,
+			created_at      timestamp with time zone   NOT NULL DEFAULT current_timestamp,
+			actor_id        varchar(36)                NOT NULL DEFAULT '',
+			actor_mail      varchar(255)               NOT NULL DEFAULT '',
+			action          varchar(255)               NOT NULL,
+			resource_type   varchar(255)               NOT NULL,
+			resource_id     varchar(255)               NOT NULL DEFAULT '',
+			ip              varchar(255)               NOT NULL DEFAULT '',
+			outcome         varchar(16)                NOT NULL,
+			status          integer                    NOT NULL
+		);`)
+	if err != nil {
+		return err
+	}
+
+	if !created {
+		return nil
+	}
+
+	// Entries can be added but never modified nor removed.
+	_, err = db.Exec(
+		`CREATE FUNCTION audit_logs_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_logs is append-only';
+		END;
+		$$ LANGUAGE plpgsql;`)
+	if err != nil {
+		log.Errorf("Unable to create the audit_logs trigger function: %s", err)
+		return err
+	}
+
+	_, err = db.Exec(
+		`CREATE TRIGGER audit_logs_append_only
+		BEFORE UPDATE OR DELETE ON audit_logs
+		FOR EACH ROW EXECUTE PROCEDURE audit_logs_append_only();`)
+	if err != nil {
+		log.Errorf("Unable to create the audit_logs trigger: %s", err)
+		return err
+	}
+	return nil
+}
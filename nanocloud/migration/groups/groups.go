@@ -0,0 +1,89 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package groups
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+	log "github.com/Sirupsen/logrus"
+)
+
+func Migrate() error {
+	_, err := schema.CreateTable("groups",
+		`CREATE TABLE groups (
+			id           varchar(36)                PRIMARY KEY,
+			name         varchar(255)               NOT NULL UNIQUE,
+			created_at   timestamp with time zone   NOT NULL DEFAULT current_timestamp
+		);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = schema.CreateTable("groups_users",
+		`CREATE TABLE groups_users (
+			group_id     varchar(36)   NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+			user_id      varchar(36)   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			PRIMARY KEY (group_id, user_id)
+		);`)
+	if err != nil {
+		return err
+	}
+
+	// DN of the LDAP group the group is synchronized with
+	_, err = schema.AddColumn("groups", "directory_dn", "varchar(255) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	created, err := schema.CreateTable("apps_assignments",
+		`CREATE TABLE apps_assignments (
+			id           varchar(36)   PRIMARY KEY,
+			app_id       varchar(36)   NOT NULL REFERENCES apps (id) ON DELETE CASCADE,
+			user_id      varchar(36)   REFERENCES users (id) ON DELETE CASCADE,
+			group_id     varchar(36)   REFERENCES groups (id) ON DELETE CASCADE,
+			CHECK ((user_id IS NULL) <> (group_id IS NULL)),
+			UNIQUE (app_id, user_id),
+			UNIQUE (app_id, group_id)
+		);`)
+	if err != nil {
+		return err
+	}
+
+	if !created {
+		return nil
+	}
+
+	// Users could open every application before they were assigned. Keep
+	// them entitled to the applications published so far.
+	_, err = db.Exec(
+		`INSERT INTO apps_assignments (id, app_id, user_id)
+		SELECT md5(random()::text || apps.id || users.id)::uuid::varchar, apps.id, users.id
+		FROM apps, users
+		WHERE apps.alias NOT IN ('hapticPowershell', 'Desktop')
+		AND NOT COALESCE(users.is_admin, false)`)
+	if err != nil {
+		log.Errorf("Unable to assign the applications to the users: %s", err)
+		return err
+	}
+	return nil
+}
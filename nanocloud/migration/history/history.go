@@ -23,10 +23,198 @@
 package history
 
 import (
+	"database/sql"
+	"strings"
+	"time"
+
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
 	log "github.com/Sirupsen/logrus"
 )
 
+// historyDateLayouts are the formats the dates of the histories were sent in
+// by the webapp and guacamole, which used Java's Date.toString.
+var historyDateLayouts = []string{
+	time.RFC3339Nano,
+	time.UnixDate,
+	time.RubyDate,
+	time.RFC1123,
+	time.RFC1123Z,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// parseHistoryDate parses a date stored as a string. ok is false when the
+// date is empty or in none of the known formats, e.g. "fake-start-date".
+func parseHistoryDate(value string) (date time.Time, ok bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range historyDateLayouts {
+		date, err := time.Parse(layout, value)
+		if err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// convertHistoryDates converts the dates of the histories, stored as strings,
+// to timestamps. The conversion is done row by row as the strings are not all
+// understood by PostgreSQL: a start date that can't be parsed becomes the
+// epoch and an end date the start date, so the session isn't seen as running.
+// An empty end date stays NULL.
+func convertHistoryDates() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`ALTER TABLE histories
+		ADD COLUMN startdate_ts timestamp with time zone,
+		ADD COLUMN enddate_ts timestamp with time zone`)
+	if err != nil {
+		return err
+	}
+
+	type dates struct {
+		id    string
+		start time.Time
+		end   *time.Time
+	}
+
+	rows, err := tx.Query(`SELECT id, startdate, enddate FROM histories`)
+	if err != nil {
+		return err
+	}
+
+	var histories []dates
+	for rows.Next() {
+		var id string
+		var startDate, endDate sql.NullString
+		err = rows.Scan(&id, &startDate, &endDate)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		start, ok := parseHistoryDate(startDate.String)
+		if !ok {
+			log.Warnf("History %s: unknown start date %q, using the epoch", id, startDate.String)
+			start = time.Unix(0, 0)
+		}
+
+		history := dates{id: id, start: start}
+		if strings.TrimSpace(endDate.String) != "" {
+			end, ok := parseHistoryDate(endDate.String)
+			if !ok {
+				log.Warnf("History %s: unknown end date %q, using the start date", id, endDate.String)
+				end = start
+			}
+			history.end = &end
+		}
+		histories = append(histories, history)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, history := range histories {
+		_, err = tx.Exec(
+			`UPDATE histories SET startdate_ts = $2, enddate_ts = $3
+			WHERE id = $1::varchar`,
+			history.id, history.start, history.end)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		`ALTER TABLE histories
+		DROP COLUMN startdate,
+		DROP COLUMN enddate,
+		ALTER COLUMN usermail TYPE varchar(255)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`ALTER TABLE histories RENAME COLUMN startdate_ts TO startdate`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`ALTER TABLE histories RENAME COLUMN enddate_ts TO enddate`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`ALTER TABLE histories
+		ALTER COLUMN startdate SET NOT NULL,
+		ALTER COLUMN startdate SET DEFAULT now()`)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Sessions are recorded by the backend since the histories used to be sent
+// by the webapp. Dates were stored as strings and are converted to
+// timestamps; the end date is NULL while the session is running.
+func upgradeHistoriesTable() error {
+	rows, err := db.Query(
+		`SELECT data_type
+		FROM information_schema.columns
+		WHERE table_name = 'histories'
+		AND column_name = 'startdate'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var dataType string
+	if rows.Next() {
+		err = rows.Scan(&dataType)
+		if err != nil {
+			return err
+		}
+	}
+
+	if dataType == "character varying" {
+		err = convertHistoryDates()
+		if err != nil {
+			return err
+		}
+	}
+
+	columns := []string{"machine_id", "app_alias"}
+	for _, column := range columns {
+		rows, err := db.Query(
+			`SELECT column_name
+			FROM information_schema.columns
+			WHERE table_name = 'histories'
+			AND column_name = $1::varchar`, column)
+		if err != nil {
+			return err
+		}
+
+		exists := rows.Next()
+		rows.Close()
+		if exists {
+			continue
+		}
+
+		_, err = db.Exec(`ALTER TABLE histories ADD COLUMN ` + column + ` varchar(255) NOT NULL DEFAULT ''`)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func Migrate() error {
 	rows, err := db.Query(
 		`SELECT table_name
@@ -40,19 +228,21 @@ func Migrate() error {
 
 	if rows.Next() {
 		log.Info("Histories table already set up")
-		return nil
+		return upgradeHistoriesTable()
 	}
 
 	rows, err = db.Query(
 		`CREATE TABLE histories (
 			id			varchar(36) PRIMARY KEY,
 			userid			varchar(36) NOT NULL DEFAULT '',
-			usermail		varchar(36) NOT NULL DEFAULT '',
+			usermail		varchar(255) NOT NULL DEFAULT '',
 			userfirstname	varchar(36) NOT NULL DEFAULT '',
 			userlastname	varchar(36) NOT NULL DEFAULT '',
 			connectionid	varchar(36) NOT NULL DEFAULT '',
-			startdate		varchar(36) NOT NULL DEFAULT '',
-			enddate			varchar(36) NOT NULL DEFAULT ''
+			machine_id		varchar(255) NOT NULL DEFAULT '',
+			app_alias		varchar(255) NOT NULL DEFAULT '',
+			startdate		timestamp with time zone NOT NULL DEFAULT now(),
+			enddate			timestamp with time zone
 		);`)
 	if err != nil {
 		log.Errorf("Unable to create histories table: %s", err)
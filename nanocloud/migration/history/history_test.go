@@ -0,0 +1,36 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHistoryDate(t *testing.T) {
+	expected := time.Date(2016, time.March, 4, 10, 30, 15, 0, time.UTC)
+
+	dates := []string{
+		"2016-03-04T10:30:15Z",
+		"2016-03-04T11:30:15+01:00",
+		"2016-03-04T10:30:15.000Z",
+		"Fri Mar 04 10:30:15 UTC 2016",
+		" Fri Mar 04 10:30:15 UTC 2016\n",
+		"2016-03-04 10:30:15",
+	}
+	for _, date := range dates {
+		parsed, ok := parseHistoryDate(date)
+		if !ok {
+			t.Errorf("%q not parsed", date)
+			continue
+		}
+		if !parsed.Equal(expected) {
+			t.Errorf("%q parsed as %s, expected %s", date, parsed, expected)
+		}
+	}
+
+	for _, date := range []string{"", "fake-start-date", "yesterday"} {
+		_, ok := parseHistoryDate(date)
+		if ok {
+			t.Errorf("%q should not be parsed", date)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mfa
+
+import (
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+)
+
+func Migrate() error {
+	// last_step is the time step of the last accepted code, so that a
+	// code cannot be used twice.
+	_, err := schema.CreateTable("users_mfa",
+		`CREATE TABLE users_mfa (
+			user_id      varchar(36)                PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+			secret       varchar(64)                NOT NULL DEFAULT '',
+			enabled      boolean                    NOT NULL DEFAULT false,
+			required     boolean                    NOT NULL DEFAULT false,
+			last_step    bigint                     NOT NULL DEFAULT 0,
+			enabled_at   timestamp with time zone
+		);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = schema.CreateTable("users_recovery_codes",
+		`CREATE TABLE users_recovery_codes (
+			id           varchar(36)                PRIMARY KEY,
+			user_id      varchar(36)                NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			code_hash    varchar(64)                NOT NULL,
+			used_at      timestamp with time zone
+		);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = schema.CreateTable("oauth_mfa_challenges",
+		`CREATE TABLE oauth_mfa_challenges (
+			token             varchar(255)               PRIMARY KEY,
+			oauth_client_id   integer                    NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+			user_id           varchar(36)                NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			enroll            boolean                    NOT NULL,
+			attempts          integer                    NOT NULL DEFAULT 0,
+			expires_at        timestamp with time zone   NOT NULL
+		);`)
+	return err
+}
@@ -24,10 +24,19 @@ package migration
 
 import (
 	"github.com/Nanocloud/community/nanocloud/migration/apps"
+	"github.com/Nanocloud/community/nanocloud/migration/audit"
 	"github.com/Nanocloud/community/nanocloud/migration/config"
+	"github.com/Nanocloud/community/nanocloud/migration/groups"
 	"github.com/Nanocloud/community/nanocloud/migration/history"
 	"github.com/Nanocloud/community/nanocloud/migration/machines"
+	"github.com/Nanocloud/community/nanocloud/migration/mfa"
 	"github.com/Nanocloud/community/nanocloud/migration/oauth"
+	"github.com/Nanocloud/community/nanocloud/migration/passwords"
+	"github.com/Nanocloud/community/nanocloud/migration/roles"
+	"github.com/Nanocloud/community/nanocloud/migration/signup"
+	"github.com/Nanocloud/community/nanocloud/migration/sso"
+	"github.com/Nanocloud/community/nanocloud/migration/throttle"
+	"github.com/Nanocloud/community/nanocloud/migration/uploads"
 	"github.com/Nanocloud/community/nanocloud/migration/users"
 
 	log "github.com/Sirupsen/logrus"
@@ -52,6 +61,18 @@ func Migrate() error {
 		return err
 	}
 
+	err = groups.Migrate()
+	if err != nil {
+		log.Error("groups migration failed")
+		return err
+	}
+
+	err = roles.Migrate()
+	if err != nil {
+		log.Error("roles migration failed")
+		return err
+	}
+
 	err = history.Migrate()
 	if err != nil {
 		log.Error("history migration failed")
@@ -70,5 +91,47 @@ func Migrate() error {
 		return err
 	}
 
+	err = uploads.Migrate()
+	if err != nil {
+		log.Error("uploads migration failed")
+		return err
+	}
+
+	err = audit.Migrate()
+	if err != nil {
+		log.Error("audit migration failed")
+		return err
+	}
+
+	err = mfa.Migrate()
+	if err != nil {
+		log.Error("mfa migration failed")
+		return err
+	}
+
+	err = throttle.Migrate()
+	if err != nil {
+		log.Error("throttle migration failed")
+		return err
+	}
+
+	err = passwords.Migrate()
+	if err != nil {
+		log.Error("passwords migration failed")
+		return err
+	}
+
+	err = signup.Migrate()
+	if err != nil {
+		log.Error("signup migration failed")
+		return err
+	}
+
+	err = sso.Migrate()
+	if err != nil {
+		log.Error("sso migration failed")
+		return err
+	}
+
 	return nil
 }
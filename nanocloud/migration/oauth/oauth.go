@@ -24,9 +24,12 @@ package oauth
 
 import (
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
 	log "github.com/Sirupsen/logrus"
 )
 
+const scopeColumn = "varchar(255) NOT NULL DEFAULT 'profile apps files admin'"
+
 func Migrate() error {
 	rows, err := db.Query(
 		`SELECT table_name
@@ -101,5 +104,103 @@ func Migrate() error {
 		}
 		defer rows.Close()
 	}
+
+	// Tokens issued from the same login form a family: refreshing a token
+	// keeps its family, and logging out revokes the whole family.
+	_, err = schema.AddColumn("oauth_access_tokens", "family_id", "varchar(36) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	// Third-party clients use the authorization code grant.
+	columns := [][2]string{
+		{"redirect_uris", "text NOT NULL DEFAULT ''"},
+		{"scopes", "text NOT NULL DEFAULT ''"},
+		{"confidential", "boolean NOT NULL DEFAULT false"},
+	}
+	for _, column := range columns {
+		added, err := schema.AddColumn("oauth_clients", column[0], column[1])
+		if err != nil {
+			return err
+		}
+
+		// The secret of the clients registered before, the webapp among them,
+		// must still be checked.
+		if added && column[0] == "confidential" {
+			_, err = db.Exec(
+				`UPDATE oauth_clients SET confidential = true
+				WHERE secret <> ''`)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Tokens issued before the scopes existed have every scope.
+	_, err = schema.AddColumn("oauth_access_tokens", "scope", scopeColumn)
+	if err != nil {
+		return err
+	}
+
+	_, err = schema.CreateTable("oauth_authorization_codes",
+		`CREATE TABLE oauth_authorization_codes (
+			code              varchar(255)               PRIMARY KEY,
+			oauth_client_id   integer                    NOT NULL REFERENCES oauth_clients (id),
+			user_id           varchar(255)               NOT NULL,
+			redirect_uri      text                       NOT NULL,
+			scope             varchar(255)               NOT NULL,
+			code_challenge    varchar(255)               NOT NULL,
+			family_id         varchar(36)                NOT NULL,
+			expires_at        timestamp with time zone   NOT NULL,
+			used              boolean                    NOT NULL DEFAULT false
+		);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = schema.CreateTable("oauth_refresh_tokens",
+		`CREATE TABLE oauth_refresh_tokens (
+			id                varchar(36)                PRIMARY KEY,
+			token             varchar(255)               NOT NULL UNIQUE,
+			family_id         varchar(36)                NOT NULL,
+			oauth_client_id   integer                    REFERENCES oauth_clients (id),
+			user_id           varchar(255)               NOT NULL,
+			created_at        timestamp with time zone   NOT NULL DEFAULT current_timestamp,
+			expires_at        timestamp with time zone   NOT NULL,
+			used_at           timestamp with time zone,
+			revoked           boolean                    NOT NULL DEFAULT false
+		);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = schema.AddColumn("oauth_refresh_tokens", "scope", scopeColumn)
+	if err != nil {
+		return err
+	}
+
+	// Time of the last request authenticated with the access token.
+	_, err = schema.AddColumn("oauth_access_tokens", "last_used_at", "timestamp with time zone")
+	if err != nil {
+		return err
+	}
+
+	// Personal API keys are long-lived access tokens created by the users.
+	// Only their hash is stored, along with a prefix to recognize them.
+	_, err = schema.CreateTable("api_keys",
+		`CREATE TABLE api_keys (
+			id                varchar(36)                PRIMARY KEY,
+			user_id           varchar(36)                NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			name              varchar(255)               NOT NULL,
+			prefix            varchar(16)                NOT NULL,
+			token_hash        varchar(64)                NOT NULL UNIQUE,
+			scope             varchar(255)               NOT NULL,
+			created_at        timestamp with time zone   NOT NULL DEFAULT current_timestamp,
+			expires_at        timestamp with time zone,
+			last_used_at      timestamp with time zone
+		);`)
+	if err != nil {
+		return err
+	}
 	return nil
 }
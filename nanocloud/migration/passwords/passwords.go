@@ -0,0 +1,56 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package passwords
+
+import (
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+)
+
+func Migrate() error {
+	_, err := schema.AddColumn("users", "password_changed_at", "timestamp with time zone NOT NULL DEFAULT current_timestamp")
+	if err != nil {
+		return err
+	}
+
+	_, err = schema.CreateTable("users_password_history",
+		`CREATE TABLE users_password_history (
+			id           varchar(36)                PRIMARY KEY,
+			user_id      varchar(36)                NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			password     varchar(255)               NOT NULL,
+			created_at   timestamp with time zone   NOT NULL DEFAULT current_timestamp
+		);`)
+	if err != nil {
+		return err
+	}
+
+	// The tokens are signed: only their nonce is stored, to use them once.
+	_, err = schema.CreateTable("password_reset_tokens",
+		`CREATE TABLE password_reset_tokens (
+			nonce        varchar(64)                PRIMARY KEY,
+			user_id      varchar(36)                NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			created_at   timestamp with time zone   NOT NULL DEFAULT current_timestamp,
+			expires_at   timestamp with time zone   NOT NULL,
+			used_at      timestamp with time zone
+		);`)
+	return err
+}
@@ -0,0 +1,107 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package roles
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+	log "github.com/Sirupsen/logrus"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Roles available out of the box.
+var defaultRoles = map[string][]string{
+	"helpdesk": {"users:read", "users:password", "sessions:read", "sessions:manage"},
+	"auditor":  {"histories:read", "sessions:read", "audit:read"},
+}
+
+func createDefaultRoles() error {
+	for name, permissions := range defaultRoles {
+		id := uuid.NewV4().String()
+
+		_, err := db.Exec(
+			`INSERT INTO roles (id, name) VALUES ($1::varchar, $2::varchar)`,
+			id, name,
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, permission := range permissions {
+			_, err = db.Exec(
+				`INSERT INTO roles_permissions (role_id, permission)
+				VALUES ($1::varchar, $2::varchar)`,
+				id, permission,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func Migrate() error {
+	created, err := schema.CreateTable("roles",
+		`CREATE TABLE roles (
+			id           varchar(36)                PRIMARY KEY,
+			name         varchar(255)               NOT NULL UNIQUE,
+			created_at   timestamp with time zone   NOT NULL DEFAULT current_timestamp
+		);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = schema.CreateTable("roles_permissions",
+		`CREATE TABLE roles_permissions (
+			role_id      varchar(36)    NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+			permission   varchar(255)   NOT NULL,
+			PRIMARY KEY (role_id, permission)
+		);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = schema.CreateTable("roles_assignments",
+		`CREATE TABLE roles_assignments (
+			id           varchar(36)   PRIMARY KEY,
+			role_id      varchar(36)   NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+			user_id      varchar(36)   REFERENCES users (id) ON DELETE CASCADE,
+			group_id     varchar(36)   REFERENCES groups (id) ON DELETE CASCADE,
+			CHECK ((user_id IS NULL) <> (group_id IS NULL)),
+			UNIQUE (role_id, user_id),
+			UNIQUE (role_id, group_id)
+		);`)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		err = createDefaultRoles()
+		if err != nil {
+			log.Errorf("Unable to create the default roles: %s", err)
+			return err
+		}
+	}
+	return nil
+}
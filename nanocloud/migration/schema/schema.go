@@ -0,0 +1,98 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package schema contains helpers shared by the migrations.
+package schema
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	log "github.com/Sirupsen/logrus"
+)
+
+func TableExists(name string) (bool, error) {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = $1::varchar`, name)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+// CreateTable creates a table unless it already exists. It reports whether
+// the table was created.
+func CreateTable(name, query string) (bool, error) {
+	exists, err := TableExists(name)
+	if err != nil {
+		log.Error("Select tables names failed: ", err.Error())
+		return false, err
+	}
+
+	if exists {
+		log.Infof("%s table already set up", name)
+		return false, nil
+	}
+
+	_, err = db.Exec(query)
+	if err != nil {
+		log.Errorf("Unable to create %s table: %s", name, err)
+		return false, err
+	}
+	return true, nil
+}
+
+func ColumnExists(table, column string) (bool, error) {
+	rows, err := db.Query(
+		`SELECT column_name
+		FROM information_schema.columns
+		WHERE table_name = $1::varchar
+		AND column_name = $2::varchar`, table, column)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+// AddColumn adds a column to an existing table unless it is already there.
+// It reports whether the column was added.
+func AddColumn(table, column, definition string) (bool, error) {
+	exists, err := ColumnExists(table, column)
+	if err != nil {
+		return false, err
+	}
+
+	if exists {
+		return false, nil
+	}
+
+	_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	if err != nil {
+		log.Errorf("Unable to add %s.%s column: %s", table, column, err)
+		return false, err
+	}
+	return true, nil
+}
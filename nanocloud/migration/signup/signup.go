@@ -0,0 +1,69 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package signup
+
+import (
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+)
+
+func Migrate() error {
+	// Only the hashes of the tokens are stored.
+	_, err := schema.CreateTable("invitations",
+		`CREATE TABLE invitations (
+			id           varchar(36)                PRIMARY KEY,
+			email        varchar(255)               NOT NULL,
+			token_hash   varchar(64)                NOT NULL UNIQUE,
+			invited_by   varchar(36)                REFERENCES users (id) ON DELETE SET NULL,
+			created_at   timestamp with time zone   NOT NULL DEFAULT current_timestamp,
+			expires_at   timestamp with time zone   NOT NULL,
+			accepted_at  timestamp with time zone
+		);`)
+	if err != nil {
+		return err
+	}
+
+	// The invitations of a bulk import carry the rank and the groups of
+	// the user to create.
+	_, err = schema.AddColumn("invitations", "is_admin", "boolean NOT NULL DEFAULT false")
+	if err != nil {
+		return err
+	}
+
+	_, err = schema.CreateTable("invitations_groups",
+		`CREATE TABLE invitations_groups (
+			invitation_id  varchar(36)  NOT NULL REFERENCES invitations (id) ON DELETE CASCADE,
+			group_id       varchar(36)  NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+			PRIMARY KEY (invitation_id, group_id)
+		);`)
+	if err != nil {
+		return err
+	}
+
+	_, err = schema.CreateTable("user_activations",
+		`CREATE TABLE user_activations (
+			token_hash   varchar(64)                PRIMARY KEY,
+			user_id      varchar(36)                NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			expires_at   timestamp with time zone   NOT NULL
+		);`)
+	return err
+}
@@ -0,0 +1,40 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sso
+
+import (
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+)
+
+func Migrate() error {
+	// The logins started at an identity provider and not finished yet,
+	// keyed by the OIDC state or the SAML request id.
+	_, err := schema.CreateTable("sso_logins",
+		`CREATE TABLE sso_logins (
+			key            varchar(255)               PRIMARY KEY,
+			nonce          varchar(255)               NOT NULL DEFAULT '',
+			code_verifier  varchar(255)               NOT NULL DEFAULT '',
+			expires_at     timestamp with time zone   NOT NULL
+		);`)
+	return err
+}
@@ -0,0 +1,60 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package throttle
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
+)
+
+func Migrate() error {
+	// The emails are not referenced: attempts on unknown accounts are
+	// throttled too.
+	created, err := schema.CreateTable("login_attempts",
+		`CREATE TABLE login_attempts (
+			id           varchar(36)                PRIMARY KEY,
+			email        varchar(255)               NOT NULL,
+			ip           varchar(255)               NOT NULL DEFAULT '',
+			user_agent   varchar(255)               NOT NULL DEFAULT '',
+			success      boolean                    NOT NULL,
+			created_at   timestamp with time zone   NOT NULL DEFAULT current_timestamp
+		);`)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		_, err = db.Exec(`CREATE INDEX login_attempts_email ON login_attempts (email, created_at)`)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(`CREATE INDEX login_attempts_ip ON login_attempts (ip, created_at)`)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = schema.AddColumn("users", "locked_until", "timestamp with time zone")
+	return err
+}
@@ -0,0 +1,62 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package uploads
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	log "github.com/Sirupsen/logrus"
+)
+
+func Migrate() error {
+	rows, err := db.Query(
+		`SELECT table_name
+		FROM information_schema.tables
+		WHERE table_name = 'uploads'`)
+	if err != nil {
+		log.Error("Select tables names failed: ", err.Error())
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		log.Info("uploads table already set up")
+		return nil
+	}
+
+	rows, err = db.Query(
+		`CREATE TABLE uploads (
+			id           varchar(36)                PRIMARY KEY,
+			user_id      varchar(36)                NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			filename     varchar(255)               NOT NULL DEFAULT '',
+			length       bigint                     NOT NULL DEFAULT 0,
+			completed    boolean                    NOT NULL DEFAULT false,
+			created_at   timestamp with time zone   NOT NULL DEFAULT current_timestamp
+		);`)
+	if err != nil {
+		log.Errorf("Unable to create uploads table: %s", err)
+		return err
+	}
+
+	rows.Close()
+	return nil
+}
@@ -24,6 +24,7 @@ package users
 
 import (
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/migration/schema"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/utils"
 )
@@ -51,7 +52,8 @@ func createUsersTable() (bool, error) {
 			password         varchar(60)                NOT NULL DEFAULT '',
 			signup_date      timestamp with time zone   NOT NULL DEFAULT current_timestamp,
 			is_admin         boolean,
-			activated        boolean
+			activated        boolean,
+			storage_quota    bigint
 		);`)
 	if err != nil {
 		return false, err
@@ -61,12 +63,43 @@ func createUsersTable() (bool, error) {
 	return true, nil
 }
 
+// Add the storage_quota column to users tables created before it existed.
+// A NULL quota means the user has the default quota.
+func addStorageQuotaColumn() error {
+	rows, err := db.Query(
+		`SELECT column_name
+		FROM information_schema.columns
+		WHERE table_name = 'users'
+		AND column_name = 'storage_quota'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		return nil
+	}
+
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN storage_quota bigint`)
+	return err
+}
+
 func Migrate() error {
 	insertAdmin, err := createUsersTable()
 	if err != nil {
 		return err
 	}
 
+	err = addStorageQuotaColumn()
+	if err != nil {
+		return err
+	}
+
+	_, err = schema.AddColumn("users", "auth_provider", "varchar(36) NOT NULL DEFAULT 'local'")
+	if err != nil {
+		return err
+	}
+
 	if insertAdmin {
 		adminpwd := utils.Env("ADMIN_PASSWORD", "Nanocloud123+")
 		adminfirstname := utils.Env("ADMIN_FIRSTNAME", "Admin")
@@ -23,6 +23,7 @@
 package apps
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"math/rand"
@@ -33,6 +34,7 @@ import (
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/plaza"
+	"github.com/Nanocloud/community/nanocloud/query"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
 	uuid "github.com/satori/go.uuid"
@@ -173,7 +175,23 @@ func GetApp(appId string) (*App, error) {
 	return nil, nil
 }
 
-func GetAllApps() ([]*App, error) {
+// Columns the applications can be filtered and sorted by.
+var Columns = query.Columns{
+	"alias":           "alias",
+	"display-name":    "display_name",
+	"collection-name": "collection_name",
+	"file-path":       "file_path",
+}
+
+func findApps(q *query.Query, conditions []string, args []interface{}) ([]*App, int, error) {
+	where, args := q.Where(Columns, conditions, args)
+
+	total, err := db.Count(`SELECT count(*) FROM apps`+where, args...)
+	if err != nil {
+		log.Error("Connection to postgres failed: ", err.Error())
+		return nil, 0, GetAppsFailed
+	}
+
 	rows, err := db.Query(
 		`SELECT
 		id,
@@ -182,11 +200,13 @@ func GetAllApps() ([]*App, error) {
 		alias,
 		icon_content,
 		file_path
-		FROM apps`)
+		FROM apps`+where+q.OrderBy(Columns, "display_name")+q.Limit(),
+		args...,
+	)
 
 	if err != nil {
 		log.Error("Connection to postgres failed: ", err.Error())
-		return nil, GetAppsFailed
+		return nil, 0, GetAppsFailed
 	}
 
 	defer rows.Close()
@@ -208,45 +228,26 @@ func GetAllApps() ([]*App, error) {
 
 	}
 
-	return applications, nil
+	return applications, total, nil
+}
 
+// GetAllApps returns the page of applications selected by q and the number
+// of applications matching its filters.
+func GetAllApps(q *query.Query) ([]*App, int, error) {
+	return findApps(q, nil, nil)
 }
 
-func GetUserApps(userId string) ([]*App, error) {
-	rows, err := db.Query(
-		`SELECT id, collection_name,
-		alias, display_name,
-		file_path,
-		icon_content
-		FROM apps`,
+// GetUserApps returns the applications a user is entitled to. The desktop
+// and the powershell used internally are not listed.
+func GetUserApps(userId string, q *query.Query) ([]*App, int, error) {
+	return findApps(
+		q,
+		[]string{
+			`alias NOT IN ('hapticPowershell', 'Desktop')`,
+			entitledCondition(1),
+		},
+		[]interface{}{userId},
 	)
-
-	if err != nil {
-		log.Error("Connection to postgres failed: ", err.Error())
-		return nil, GetAppsFailed
-	}
-
-	defer rows.Close()
-
-	applications := make([]*App, 0)
-
-	for rows.Next() {
-		appParam := App{}
-
-		rows.Scan(
-			&appParam.Id,
-			&appParam.CollectionName,
-			&appParam.Alias,
-			&appParam.DisplayName,
-			&appParam.FilePath,
-			&appParam.IconContents,
-		)
-		if appParam.Alias != "hapticPowershell" && appParam.Alias != "Desktop" {
-			applications = append(applications, &appParam)
-		}
-	}
-
-	return applications, nil
 }
 
 func getCredentials() (string, string) {
@@ -416,11 +417,20 @@ func CreateApp(app *App) (*App, error) {
 	return app, err
 }
 
+// RetrieveConnections returns a connection to each application the user is
+// entitled to.
 func RetrieveConnections(user *users.User) ([]Connection, error) {
 	rand.Seed(time.Now().UTC().UnixNano())
 	var connections []Connection
 
-	rows, err := db.Query("SELECT alias FROM apps")
+	// Administrators can open every application
+	var rows *sql.Rows
+	var err error
+	if user.IsAdmin {
+		rows, err = db.Query("SELECT alias FROM apps")
+	} else {
+		rows, err = db.Query("SELECT alias FROM apps WHERE "+entitledCondition(1), user.Id)
+	}
 	if err != nil {
 		log.Error("Unable to retrieve apps list from Postgres: ", err.Error())
 		return nil, AppsListUnavailable
@@ -4,7 +4,9 @@ import (
 	"log"
 	"testing"
 
+	"github.com/Nanocloud/community/nanocloud/models/groups"
 	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/query"
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -55,6 +57,16 @@ func getApp(app_id string, error string) *App {
 	return get_app
 }
 
+func indexOf(app_id string) int {
+	for i, app := range list_apps {
+		if app.Id == app_id {
+			return i
+		}
+	}
+	log.Panicf("Unexpected app %s", app_id)
+	return -1
+}
+
 func compareApp(get_app *App, i int) {
 	switch {
 	case get_app.Id == "":
@@ -118,7 +130,6 @@ func TestChangeName(t *testing.T) {
 func TestGetUserApps(t *testing.T) {
 	id = uuid.NewV4().String()
 	new_app := &App{Id: id, CollectionName: collectionName, Alias: "Libre Office", DisplayName: displayName, FilePath: filePath}
-	i := 0
 
 	new_app, err := CreateApp(new_app)
 	if err != nil {
@@ -127,25 +138,112 @@ func TestGetUserApps(t *testing.T) {
 	list_apps = append(list_apps, new_app)
 	app_num++
 
-	apps, err := GetUserApps(user.GetID())
+	apps, _, err := GetUserApps(user.GetID(), query.All())
 	if err != nil {
 		t.Error("Unable to get user apps")
 	}
+	if len(apps) != 0 {
+		t.Errorf("The user should not be entitled to any application, have %d", len(apps))
+	}
+
+	for _, app := range list_apps {
+		_, err = app.Assign(user.GetID(), "")
+		if err != nil {
+			log.Fatalln("Cannot assign the app:", err.Error())
+		}
+	}
+
+	apps, _, err = GetUserApps(user.GetID(), query.All())
+	if err != nil {
+		t.Error("Unable to get user apps")
+	}
+	if len(apps) != app_num {
+		t.Errorf("Expected %d applications, have %d", app_num, len(apps))
+	}
 
+	// The applications are sorted by name, not in the order they were created
 	for _, get_app := range apps {
 		if get_app == nil {
 			t.Error("A nil app was returned")
 		}
 		if get_app.Alias != "hapticDesktop" {
-			compareApp(get_app, i)
+			compareApp(get_app, indexOf(get_app.Id))
 			err = get_app.Delete()
 			if err != nil {
 				log.Fatalln("Can't delete application:", err.Error())
 			}
-			i++
 		}
 	}
 
+}
+
+func TestAssignments(t *testing.T) {
+	app, err := CreateApp(&App{CollectionName: collectionName, Alias: "assigned", DisplayName: "Assigned", FilePath: filePath})
+	if err != nil {
+		log.Fatalln("Cannot create the app:", err.Error())
+	}
+
+	group, err := groups.CreateGroup("assignment-test")
+	if err != nil {
+		log.Fatalln("Cannot create the group:", err.Error())
+	}
+
+	_, err = app.Assign(user.GetID(), group.Id)
+	if err != InvalidAssignment {
+		t.Errorf("An assignment to both a user and a group should be rejected")
+	}
+
+	assignment, err := app.Assign("", group.Id)
+	if err != nil {
+		t.Fatalf("Cannot assign the app to the group: %s", err.Error())
+	}
+
+	_, err = app.Assign("", group.Id)
+	if err != AssignmentDuplicated {
+		t.Errorf("An application should not be assigned twice to a group")
+	}
+
+	// Members of the group are entitled to the application
+	apps, _, err := GetUserApps(user.GetID(), query.All())
+	if err != nil || len(apps) != 0 {
+		t.Errorf("The user should not be entitled to the application yet")
+	}
+
+	err = group.AddMember(user.GetID())
+	if err != nil {
+		t.Fatalf("Cannot add the user to the group: %s", err.Error())
+	}
+
+	apps, _, err = GetUserApps(user.GetID(), query.All())
+	if err != nil || len(apps) != 1 || apps[0].Id != app.Id {
+		t.Errorf("The user should be entitled to the application of the group")
+	}
+
+	list, err := app.Assignments()
+	if err != nil || len(list) != 1 || list[0].GroupId != group.Id {
+		t.Errorf("Unexpected assignments")
+	}
+
+	err = app.Unassign(assignment.Id)
+	if err != nil {
+		t.Errorf("Cannot unassign the app: %s", err.Error())
+	}
+
+	apps, _, err = GetUserApps(user.GetID(), query.All())
+	if err != nil || len(apps) != 0 {
+		t.Errorf("The user should not be entitled to the application anymore")
+	}
+
+	err = group.Delete()
+	if err != nil {
+		t.Errorf("Can't delete group: %s", err.Error())
+	}
+
+	err = app.Delete()
+	if err != nil {
+		t.Errorf("Can't delete application: %s", err.Error())
+	}
+
 	err = users.DeleteUser(user.GetID())
 	if err != nil {
 		t.Errorf("Can't delete user: %s\n", err.Error())
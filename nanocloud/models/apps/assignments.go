@@ -0,0 +1,166 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package apps
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	AssignmentNotFound   = errors.New("assignment not found")
+	AssignmentDuplicated = errors.New("assignment duplicated")
+	InvalidAssignment    = errors.New("an assignment needs either a user or a group")
+)
+
+// Entitles a user, or the members of a group, to an application.
+type Assignment struct {
+	Id      string `json:"-"`
+	AppId   string `json:"app-id"`
+	UserId  string `json:"user-id,omitempty"`
+	GroupId string `json:"group-id,omitempty"`
+}
+
+func (a *Assignment) GetID() string {
+	return a.Id
+}
+
+func (a *Assignment) SetID(id string) error {
+	a.Id = id
+	return nil
+}
+
+// The condition selecting the applications a user is entitled to, directly
+// or through one of their groups. The user id is the n-th argument.
+func entitledCondition(n int) string {
+	return fmt.Sprintf(`id IN (
+		SELECT app_id FROM apps_assignments
+		WHERE user_id = $%[1]d::varchar
+		OR group_id IN (SELECT group_id FROM groups_users WHERE user_id = $%[1]d::varchar)
+	)`, n)
+}
+
+func scanAssignments(rows *sql.Rows) ([]*Assignment, error) {
+	defer rows.Close()
+
+	assignments := make([]*Assignment, 0)
+	for rows.Next() {
+		var a Assignment
+		var userId, groupId sql.NullString
+
+		err := rows.Scan(&a.Id, &a.AppId, &userId, &groupId)
+		if err != nil {
+			return nil, err
+		}
+		a.UserId = userId.String
+		a.GroupId = groupId.String
+		assignments = append(assignments, &a)
+	}
+	return assignments, rows.Err()
+}
+
+// Return the users and groups entitled to the application.
+func (a *App) Assignments() ([]*Assignment, error) {
+	rows, err := db.Query(
+		`SELECT id, app_id, user_id, group_id
+		FROM apps_assignments
+		WHERE app_id = $1::varchar`,
+		a.Id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanAssignments(rows)
+}
+
+// Entitle a user or a group to the application. Exactly one of userId and
+// groupId must be set.
+func (a *App) Assign(userId, groupId string) (*Assignment, error) {
+	if (userId == "") == (groupId == "") {
+		return nil, InvalidAssignment
+	}
+
+	if userId != "" {
+		exists, err := users.UserExists(userId)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, users.UserNotFound
+		}
+	} else {
+		_, err := groups.GetGroup(groupId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	assignment := Assignment{
+		Id:      uuid.NewV4().String(),
+		AppId:   a.Id,
+		UserId:  userId,
+		GroupId: groupId,
+	}
+
+	_, err := db.Exec(
+		`INSERT INTO apps_assignments (id, app_id, user_id, group_id)
+		VALUES ($1::varchar, $2::varchar, NULLIF($3::varchar, ''), NULLIF($4::varchar, ''))`,
+		assignment.Id, assignment.AppId, assignment.UserId, assignment.GroupId,
+	)
+	if err != nil {
+		switch err.Error() {
+		case "pq: duplicate key value violates unique constraint \"apps_assignments_app_id_user_id_key\"",
+			"pq: duplicate key value violates unique constraint \"apps_assignments_app_id_group_id_key\"":
+			return nil, AssignmentDuplicated
+		}
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+func (a *App) Unassign(assignmentId string) error {
+	res, err := db.Exec(
+		`DELETE FROM apps_assignments
+		WHERE id = $1::varchar AND app_id = $2::varchar`,
+		assignmentId, a.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return AssignmentNotFound
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package audit records the administrative actions performed through the
+// API.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/query"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+type Entry struct {
+	Id           string    `json:"-"`
+	CreatedAt    time.Time `json:"created-at"`
+	ActorId      string    `json:"actor-id"`
+	ActorMail    string    `json:"actor-mail"`
+	Action       string    `json:"action"`
+	ResourceType string    `json:"resource-type"`
+	ResourceId   string    `json:"resource-id"`
+	Ip           string    `json:"ip"`
+	Outcome      string    `json:"outcome"`
+	Status       int       `json:"status"`
+}
+
+func (e *Entry) GetID() string {
+	return e.Id
+}
+
+func (e *Entry) SetID(id string) error {
+	e.Id = id
+	return nil
+}
+
+// Columns the entries can be filtered and sorted by.
+var Columns = query.Columns{
+	"created-at":    "created_at",
+	"actor-id":      "actor_id",
+	"actor-mail":    "actor_mail",
+	"action":        "action",
+	"resource-type": "resource_type",
+	"resource-id":   "resource_id",
+	"ip":            "ip",
+	"outcome":       "outcome",
+	"status":        "status",
+}
+
+// Select the entries recorded during a period. Zero dates are unbounded.
+type Filter struct {
+	From time.Time
+	To   time.Time
+}
+
+var sink struct {
+	sync.Mutex
+	file *os.File
+	path string
+}
+
+// writeFile appends the entry as a JSON line to the file set in
+// AUDIT_LOG_FILE, if any.
+func writeFile(e *Entry) error {
+	path := utils.Env("AUDIT_LOG_FILE", "")
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(struct {
+		Id string `json:"id"`
+		*Entry
+	}{e.Id, e})
+	if err != nil {
+		return err
+	}
+
+	sink.Lock()
+	defer sink.Unlock()
+
+	if sink.file == nil || sink.path != path {
+		if sink.file != nil {
+			sink.file.Close()
+		}
+
+		sink.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			sink.file = nil
+			return err
+		}
+		sink.path = path
+	}
+
+	_, err = sink.file.Write(append(b, '\n'))
+	return err
+}
+
+// Record stores the entry. It is also written to the file sink when one is
+// configured.
+func Record(e *Entry) error {
+	e.Id = uuid.NewV4().String()
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	err := writeFile(e)
+	if err != nil {
+		log.Errorf("Unable to write the audit entry to the file: %s", err)
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO audit_logs
+		(id, created_at, actor_id, actor_mail, action,
+		 resource_type, resource_id, ip, outcome, status)
+		VALUES
+		($1::varchar, $2, $3::varchar, $4::varchar, $5::varchar,
+		 $6::varchar, $7::varchar, $8::varchar, $9::varchar, $10::integer)`,
+		e.Id, e.CreatedAt, e.ActorId, e.ActorMail, e.Action,
+		e.ResourceType, e.ResourceId, e.Ip, e.Outcome, e.Status,
+	)
+	return err
+}
+
+// Find returns the page of entries selected by q (the most recent first by
+// default) that match the filter, and the number of matching entries.
+func Find(filter Filter, q *query.Query) ([]*Entry, int, error) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	where, args := q.Where(Columns, conditions, args)
+
+	total, err := db.Count(`SELECT count(*) FROM audit_logs`+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(
+		`SELECT id, created_at, actor_id, actor_mail, action,
+		resource_type, resource_id, ip, outcome, status
+		FROM audit_logs`+where+q.OrderBy(Columns, "created_at DESC")+q.Limit(),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := make([]*Entry, 0)
+	for rows.Next() {
+		var e Entry
+
+		err = rows.Scan(
+			&e.Id,
+			&e.CreatedAt,
+			&e.ActorId,
+			&e.ActorMail,
+			&e.Action,
+			&e.ResourceType,
+			&e.ResourceId,
+			&e.Ip,
+			&e.Outcome,
+			&e.Status,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, &e)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/query"
+)
+
+func TestRecord(t *testing.T) {
+	start := time.Now().Add(-time.Second)
+
+	entry := Entry{
+		ActorId:      "actor-id",
+		ActorMail:    "admin@nanocloud.com",
+		Action:       "machines.delete",
+		ResourceType: "machines",
+		ResourceId:   "test-machine",
+		Ip:           "127.0.0.1",
+		Outcome:      Success,
+		Status:       200,
+	}
+
+	err := Record(&entry)
+	if err != nil {
+		t.Fatalf("Cannot record entry: %s", err.Error())
+	}
+
+	q := query.All()
+	q.Filters["resource-id"] = []string{"test-machine"}
+
+	entries, total, err := Find(Filter{From: start}, q)
+	if err != nil {
+		t.Fatalf("Cannot find entries: %s", err.Error())
+	}
+
+	if total != 1 || len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, have %d", total)
+	}
+
+	e := entries[0]
+	if e.Id != entry.Id || e.ActorMail != entry.ActorMail || e.Action != entry.Action ||
+		e.Outcome != Success || e.Status != 200 {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+
+	_, total, err = Find(Filter{To: start}, q)
+	if err != nil || total != 0 {
+		t.Errorf("Entries recorded after the period should be ignored")
+	}
+}
+
+func TestAppendOnly(t *testing.T) {
+	entry := Entry{Action: "users.delete", ResourceType: "users", Outcome: Failure, Status: 403}
+
+	err := Record(&entry)
+	if err != nil {
+		t.Fatalf("Cannot record entry: %s", err.Error())
+	}
+
+	_, err = db.Exec(`DELETE FROM audit_logs WHERE id = $1::varchar`, entry.Id)
+	if err == nil {
+		t.Errorf("Audit entries should not be deleted")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	os.Setenv("AUDIT_LOG_FILE", path)
+	defer os.Setenv("AUDIT_LOG_FILE", "")
+
+	for _, action := range []string{"apps.publish", "apps.unpublish"} {
+		err = writeFile(&Entry{Id: action + "-id", Action: action})
+		if err != nil {
+			t.Fatalf("Cannot write entry: %s", err.Error())
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, have %d", len(lines))
+	}
+
+	var e map[string]interface{}
+	err = json.Unmarshal([]byte(lines[1]), &e)
+	if err != nil {
+		t.Fatalf("Invalid JSON line: %s", err.Error())
+	}
+
+	if e["id"] != "apps.unpublish-id" || e["action"] != "apps.unpublish" {
+		t.Errorf("Unexpected line %s", lines[1])
+	}
+}
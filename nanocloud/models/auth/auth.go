@@ -0,0 +1,211 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package auth checks the credentials of the users against the local users
+// table and the external identity providers enabled with AUTH_PROVIDERS.
+package auth
+
+import (
+	"strings"
+	"sync"
+
+	ad "github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Identity is a user as described by an external provider.
+type Identity struct {
+	Email     string
+	FirstName string
+	LastName  string
+	IsAdmin   bool
+
+	// AdminSynced is set when the provider maps a group to the
+	// administrators. IsAdmin is ignored otherwise: the rights granted
+	// locally are kept.
+	AdminSynced bool
+}
+
+// Provider checks credentials against an external directory.
+type Provider interface {
+	Name() string
+
+	// Authenticate returns users.InvalidCredentials when the password is
+	// wrong and users.UserNotFound when the provider does not know the user.
+	Authenticate(username, password string) (*Identity, error)
+}
+
+var (
+	providersOnce sync.Once
+	providers     []Provider
+)
+
+func loadProviders() {
+	for _, name := range strings.Split(utils.Env("AUTH_PROVIDERS", ""), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case LDAPProvider:
+			providers = append(providers, NewLDAP(LDAPConfigFromEnv()))
+		default:
+			log.Errorf("Unknown authentication provider %q", name)
+		}
+	}
+}
+
+func getProvider(name string) Provider {
+	providersOnce.Do(loadProviders)
+	for _, p := range providers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Authenticate checks the credentials and returns the matching user.
+// Users already known are checked by the provider they were created with;
+// unknown users are looked up in the external providers, in order, and
+// provisioned on their first login.
+func Authenticate(username, password string) (*users.User, error) {
+	user, err := users.GetUserByEmail(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if user != nil && user.AuthProvider == users.LocalProvider {
+		user, err = users.GetUserFromEmailPassword(username, password)
+		if err != nil {
+			return nil, err
+		}
+
+		expired, err := passwords.Expired(user.Id)
+		if err != nil {
+			return nil, err
+		}
+		if expired {
+			return nil, passwords.PasswordExpired
+		}
+		return user, nil
+	}
+
+	if user != nil {
+		p := getProvider(user.AuthProvider)
+		if p == nil {
+			return nil, users.InvalidCredentials
+		}
+		identity, err := p.Authenticate(username, password)
+		if err != nil {
+			return nil, err
+		}
+		return Provision(p.Name(), identity)
+	}
+
+	providersOnce.Do(loadProviders)
+	for _, p := range providers {
+		identity, err := p.Authenticate(username, password)
+		if err == users.UserNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return Provision(p.Name(), identity)
+	}
+	return nil, users.UserNotFound
+}
+
+// Provision creates or updates the local copy of a user authenticated by
+// the provider.
+func Provision(provider string, identity *Identity) (*users.User, error) {
+	email := users.Truncate(identity.Email)
+	firstName := users.Truncate(identity.FirstName)
+	lastName := users.Truncate(identity.LastName)
+
+	user, err := users.GetUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		log.Infof("Provisioning user %s from %s", email, provider)
+		return CreateUser(provider, email, firstName, lastName, identity.IsAdmin)
+	}
+
+	// Never let a directory take over a local account with the same email
+	if user.AuthProvider != provider {
+		return nil, users.InvalidCredentials
+	}
+
+	if !user.Activated {
+		return nil, users.UserDisabled
+	}
+
+	if user.FirstName != firstName {
+		err = users.UpdateUserFirstName(user.Id, firstName)
+		if err != nil {
+			return nil, err
+		}
+		user.FirstName = firstName
+	}
+
+	if user.LastName != lastName {
+		err = users.UpdateUserLastName(user.Id, lastName)
+		if err != nil {
+			return nil, err
+		}
+		user.LastName = lastName
+	}
+
+	if identity.AdminSynced && user.IsAdmin != identity.IsAdmin {
+		err = users.UpdateUserPrivilege(user.Id, identity.IsAdmin)
+		if err != nil {
+			return nil, err
+		}
+		user.IsAdmin = identity.IsAdmin
+	}
+	return user, nil
+}
+
+// CreateUser creates a user authenticated by the provider along with their
+// Windows account. The user is removed if the account can't be created so
+// the next login or synchronization starts over.
+func CreateUser(provider, email, firstName, lastName string, isAdmin bool) (*users.User, error) {
+	user, err := users.CreateExternalUser(provider, email, firstName, lastName, isAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ad.CreateAccount(user.Id)
+	if err != nil {
+		log.Errorf("Unable to create the Windows account of %s: %s", email, err)
+
+		deleteErr := users.DeleteUser(user.Id)
+		if deleteErr != nil {
+			log.Errorf("Unable to remove %s: %s", email, deleteErr)
+		}
+		return nil, err
+	}
+	return user, nil
+}
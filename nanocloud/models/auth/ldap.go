@@ -0,0 +1,215 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package auth
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/ldap.v2"
+)
+
+// LDAPProvider is the name of the LDAP provider in AUTH_PROVIDERS.
+const LDAPProvider = "ldap"
+
+// LDAPConfig describes the directory users authenticate against.
+type LDAPConfig struct {
+	URL           string
+	BindDN        string
+	BindPassword  string
+	BaseDN        string
+	TLSSkipVerify bool
+
+	// UserFilter finds the entry of a user, every %s is replaced by the
+	// escaped login.
+	UserFilter string
+
+	EmailAttribute     string
+	FirstNameAttribute string
+	LastNameAttribute  string
+	GroupsAttribute    string
+
+	// Members of AdminGroup (a DN) are Nanocloud administrators.
+	AdminGroup string
+}
+
+func LDAPConfigFromEnv() LDAPConfig {
+	return LDAPConfig{
+		URL:                utils.Env("AUTH_LDAP_URL", "ldap://localhost:389"),
+		BindDN:             utils.Env("AUTH_LDAP_BIND_DN", ""),
+		BindPassword:       utils.Env("AUTH_LDAP_BIND_PASSWORD", ""),
+		BaseDN:             utils.Env("AUTH_LDAP_BASE_DN", ""),
+		TLSSkipVerify:      utils.Env("AUTH_LDAP_TLS_SKIP_VERIFY", "false") == "true",
+		UserFilter:         utils.Env("AUTH_LDAP_USER_FILTER", "(&(objectClass=person)(|(mail=%s)(sAMAccountName=%s)(userPrincipalName=%s)))"),
+		EmailAttribute:     utils.Env("AUTH_LDAP_ATTR_EMAIL", "mail"),
+		FirstNameAttribute: utils.Env("AUTH_LDAP_ATTR_FIRSTNAME", "givenName"),
+		LastNameAttribute:  utils.Env("AUTH_LDAP_ATTR_LASTNAME", "sn"),
+		GroupsAttribute:    utils.Env("AUTH_LDAP_ATTR_GROUPS", "memberOf"),
+		AdminGroup:         utils.Env("AUTH_LDAP_ADMIN_GROUP", ""),
+	}
+}
+
+// Dial opens an unauthenticated connection to the server.
+func (config LDAPConfig) Dial() (*ldap.Conn, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		if u.Scheme == "ldaps" {
+			host += ":636"
+		} else {
+			host += ":389"
+		}
+	}
+
+	switch u.Scheme {
+	case "ldaps":
+		serverName, _, _ := net.SplitHostPort(host)
+		return ldap.DialTLS("tcp", host, &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: config.TLSSkipVerify,
+		})
+	case "ldap":
+		return ldap.Dial("tcp", host)
+	}
+	return nil, fmt.Errorf("unsupported LDAP scheme %q", u.Scheme)
+}
+
+// conn is the part of *ldap.Conn used by the provider.
+type conn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+type ldapProvider struct {
+	config LDAPConfig
+	dial   func() (conn, error)
+}
+
+// NewLDAP returns a provider binding as the user against an LDAP or
+// Active Directory server.
+func NewLDAP(config LDAPConfig) Provider {
+	return &ldapProvider{
+		config: config,
+		dial: func() (conn, error) {
+			c, err := config.Dial()
+			if err != nil {
+				return nil, err
+			}
+			return c, nil
+		},
+	}
+}
+
+func (p *ldapProvider) Name() string {
+	return LDAPProvider
+}
+
+func (p *ldapProvider) Authenticate(username, password string) (*Identity, error) {
+	// An empty password would be an unauthenticated bind, which succeeds
+	if username == "" || password == "" {
+		return nil, users.InvalidCredentials
+	}
+
+	c, err := p.dial()
+	if err != nil {
+		log.Error("Unable to reach the LDAP server: ", err)
+		return nil, err
+	}
+	defer c.Close()
+
+	if p.config.BindDN != "" {
+		err = c.Bind(p.config.BindDN, p.config.BindPassword)
+		if err != nil {
+			log.Error("LDAP service bind failed: ", err)
+			return nil, err
+		}
+	}
+
+	login := ldap.EscapeFilter(username)
+	filter := strings.Replace(p.config.UserFilter, "%s", login, -1)
+
+	res, err := c.Search(ldap.NewSearchRequest(
+		p.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		filter,
+		[]string{
+			p.config.EmailAttribute,
+			p.config.FirstNameAttribute,
+			p.config.LastNameAttribute,
+			p.config.GroupsAttribute,
+		},
+		nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res.Entries) == 0 {
+		return nil, users.UserNotFound
+	}
+	if len(res.Entries) > 1 {
+		log.Errorf("LDAP filter matches several entries for %s", username)
+		return nil, users.InvalidCredentials
+	}
+	entry := res.Entries[0]
+
+	err = c.Bind(entry.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, users.InvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identity := Identity{
+		Email:     entry.GetAttributeValue(p.config.EmailAttribute),
+		FirstName: entry.GetAttributeValue(p.config.FirstNameAttribute),
+		LastName:  entry.GetAttributeValue(p.config.LastNameAttribute),
+	}
+	if identity.Email == "" {
+		log.Errorf("LDAP entry %s has no %s attribute", entry.DN, p.config.EmailAttribute)
+		return nil, users.InvalidCredentials
+	}
+
+	if p.config.AdminGroup != "" {
+		identity.AdminSynced = true
+		for _, group := range entry.GetAttributeValues(p.config.GroupsAttribute) {
+			if strings.EqualFold(group, p.config.AdminGroup) {
+				identity.IsAdmin = true
+				break
+			}
+		}
+	}
+	return &identity, nil
+}
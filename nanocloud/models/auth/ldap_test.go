@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"gopkg.in/ldap.v2"
+)
+
+// stubDirectory is an in-process LDAP server holding a few entries.
+type stubDirectory struct {
+	passwords map[string]string
+	entries   []*ldap.Entry
+	filters   []string
+	closed    bool
+}
+
+func (d *stubDirectory) Bind(dn, password string) error {
+	if d.passwords[dn] != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	return nil
+}
+
+func (d *stubDirectory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	d.filters = append(d.filters, req.Filter)
+
+	// The stub only understands the mail clause of the default filter
+	res := &ldap.SearchResult{}
+	for _, entry := range d.entries {
+		if strings.Contains(req.Filter, "(mail="+entry.GetAttributeValue("mail")+")") {
+			res.Entries = append(res.Entries, entry)
+		}
+	}
+	return res, nil
+}
+
+func (d *stubDirectory) Close() {
+	d.closed = true
+}
+
+func newStub() (*stubDirectory, *ldapProvider) {
+	d := &stubDirectory{
+		passwords: map[string]string{
+			"cn=service,dc=example,dc=com": "service",
+			"cn=jane,dc=example,dc=com":    "secret",
+			"cn=john,dc=example,dc=com":    "secret",
+		},
+		entries: []*ldap.Entry{
+			ldap.NewEntry("cn=jane,dc=example,dc=com", map[string][]string{
+				"mail":      {"jane@example.com"},
+				"givenName": {"Jane"},
+				"sn":        {"Doe"},
+				"memberOf":  {"CN=Admins,DC=example,DC=com"},
+			}),
+			ldap.NewEntry("cn=john,dc=example,dc=com", map[string][]string{
+				"mail":      {"john@example.com"},
+				"givenName": {"John"},
+				"sn":        {"Doe"},
+			}),
+		},
+	}
+
+	config := LDAPConfig{
+		BindDN:             "cn=service,dc=example,dc=com",
+		BindPassword:       "service",
+		BaseDN:             "dc=example,dc=com",
+		UserFilter:         "(&(objectClass=person)(|(mail=%s)(sAMAccountName=%s)))",
+		EmailAttribute:     "mail",
+		FirstNameAttribute: "givenName",
+		LastNameAttribute:  "sn",
+		GroupsAttribute:    "memberOf",
+		AdminGroup:         "cn=admins,dc=example,dc=com",
+	}
+
+	p := NewLDAP(config).(*ldapProvider)
+	p.dial = func() (conn, error) {
+		return d, nil
+	}
+	return d, p
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	d, p := newStub()
+
+	identity, err := p.Authenticate("jane@example.com", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Email != "jane@example.com" || identity.FirstName != "Jane" || identity.LastName != "Doe" {
+		t.Errorf("unexpected identity: %+v", identity)
+	}
+	if !identity.IsAdmin {
+		t.Error("members of the admin group should be administrators")
+	}
+	if !d.closed {
+		t.Error("connection not closed")
+	}
+
+	identity, err = p.Authenticate("john@example.com", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.IsAdmin || !identity.AdminSynced {
+		t.Error("john is not a member of the admin group")
+	}
+}
+
+func TestLDAPWithoutAdminGroup(t *testing.T) {
+	_, p := newStub()
+	p.config.AdminGroup = ""
+
+	identity, err := p.Authenticate("jane@example.com", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.IsAdmin || identity.AdminSynced {
+		t.Errorf("the administrators are only synchronized with an admin group: %+v", identity)
+	}
+}
+
+func TestLDAPInvalidCredentials(t *testing.T) {
+	_, p := newStub()
+
+	_, err := p.Authenticate("jane@example.com", "wrong")
+	if err != users.InvalidCredentials {
+		t.Errorf("expected invalid credentials, got %v", err)
+	}
+
+	_, err = p.Authenticate("jane@example.com", "")
+	if err != users.InvalidCredentials {
+		t.Errorf("empty passwords must be refused, got %v", err)
+	}
+
+	_, err = p.Authenticate("nobody@example.com", "secret")
+	if err != users.UserNotFound {
+		t.Errorf("expected user not found, got %v", err)
+	}
+}
+
+func TestLDAPFilterEscaping(t *testing.T) {
+	d, p := newStub()
+
+	_, err := p.Authenticate("*)(mail=jane@example.com", "secret")
+	if err != users.UserNotFound {
+		t.Errorf("expected user not found, got %v", err)
+	}
+
+	filter := d.filters[len(d.filters)-1]
+	if strings.Contains(filter, "(mail=jane@example.com)") {
+		t.Errorf("login not escaped: %s", filter)
+	}
+}
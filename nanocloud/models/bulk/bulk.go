@@ -0,0 +1,353 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package bulk imports and exports many users at once. An import is
+// validated as a whole: either all its users are created or none.
+package bulk
+
+import (
+	"database/sql"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+	"github.com/Nanocloud/community/nanocloud/models/signup"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/query"
+	log "github.com/Sirupsen/logrus"
+)
+
+// RowError lists the problems of a row. Rows are numbered from 1.
+type RowError struct {
+	Row    int      `json:"row"`
+	Email  string   `json:"email"`
+	Errors []string `json:"errors"`
+}
+
+// Invitation created by an import, to be sent once it is committed.
+type Invitation struct {
+	Email string
+	Token string
+}
+
+// Report of an import. Nothing is created when there are errors or in a
+// dry run.
+type Report struct {
+	DryRun  bool        `json:"dry-run"`
+	Created int         `json:"created"`
+	Invited int         `json:"invited"`
+	Errors  []*RowError `json:"errors"`
+
+	Users       []*users.User `json:"-"`
+	Invitations []*Invitation `json:"-"`
+}
+
+func (r *Report) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+func (r *Report) fail(i int, row *Row, err string) {
+	if n := len(r.Errors); n > 0 && r.Errors[n-1].Row == i+1 {
+		r.Errors[n-1].Errors = append(r.Errors[n-1].Errors, err)
+		return
+	}
+
+	r.Errors = append(r.Errors, &RowError{
+		Row:    i + 1,
+		Email:  row.Email,
+		Errors: []string{err},
+	})
+}
+
+// discard forgets the users and the invitations of a transaction rolled
+// back.
+func (r *Report) discard() *Report {
+	r.Users = nil
+	r.Invitations = nil
+	return r
+}
+
+// checkRow returns the problems of a row that do not depend on the other
+// users.
+func checkRow(row *Row, policy passwords.Policy) []string {
+	errs := make([]string, 0)
+
+	if signup.NormalizeEmail(row.Email) == "" {
+		errs = append(errs, "invalid email")
+	}
+
+	if row.Invite {
+		if row.Password != "" {
+			errs = append(errs, "an invited user cannot have a password")
+		}
+		return errs
+	}
+
+	if row.FirstName == "" || row.LastName == "" {
+		errs = append(errs, "first-name and last-name are required")
+	}
+
+	if row.Password == "" {
+		errs = append(errs, "a password or an invitation is required")
+	} else if err := policy.Check(row.Password); err != nil {
+		errs = append(errs, err.Error())
+	}
+	return errs
+}
+
+// Validate checks the rows and returns the ids of the groups by name.
+func Validate(rows []*Row, report *Report) (map[string]string, error) {
+	all, _, err := groups.FindGroups(query.All())
+	if err != nil {
+		return nil, err
+	}
+
+	groupIds := make(map[string]string)
+	for _, group := range all {
+		groupIds[group.Name] = group.Id
+	}
+
+	policy := passwords.LoadPolicy()
+	emails := make(map[string]bool)
+
+	for i, row := range rows {
+		for _, err := range checkRow(row, policy) {
+			report.fail(i, row, err)
+		}
+
+		for _, name := range row.Groups {
+			if _, ok := groupIds[name]; !ok {
+				report.fail(i, row, "unknown group "+name)
+			}
+		}
+
+		email := signup.NormalizeEmail(row.Email)
+		if email == "" {
+			continue
+		}
+
+		if emails[email] {
+			report.fail(i, row, "the email is used by another row")
+			continue
+		}
+		emails[email] = true
+
+		user, err := users.GetUserByEmail(email)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			report.fail(i, row, "a user with this email already exists")
+		}
+	}
+	return groupIds, nil
+}
+
+func createRow(tx *sql.Tx, row *Row, groupIds []string, invitedBy string, report *Report) error {
+	email := signup.NormalizeEmail(row.Email)
+
+	if row.Invite {
+		_, token, err := signup.InviteTx(tx, email, invitedBy, row.IsAdmin, groupIds)
+		if err != nil {
+			return err
+		}
+
+		report.Invited++
+		report.Invitations = append(report.Invitations, &Invitation{email, token})
+		return nil
+	}
+
+	user, err := users.CreateUserTx(tx, true, email, row.FirstName, row.LastName, row.Password, row.IsAdmin)
+	if err != nil {
+		return err
+	}
+
+	for _, groupId := range groupIds {
+		_, err = tx.Exec(
+			`INSERT INTO groups_users (group_id, user_id)
+			VALUES ($1::varchar, $2::varchar)`,
+			groupId, user.Id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	report.Created++
+	report.Users = append(report.Users, user)
+	return nil
+}
+
+// Import creates the users of the rows in a single transaction, rolled
+// back when a row is invalid or in a dry run. create is called for each
+// user before the transaction is committed: if it fails, the row is
+// reported, nothing is imported and remove is called for the users
+// created before. The invitation emails are left to the caller.
+func Import(rows []*Row, invitedBy string, dryRun bool, create, remove func(userId string) error) (*Report, error) {
+	report := &Report{
+		DryRun: dryRun,
+		Errors: make([]*RowError, 0),
+	}
+
+	groupIds, err := Validate(rows, report)
+	if err != nil {
+		return nil, err
+	}
+
+	if !report.Valid() {
+		return report, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, row := range rows {
+		ids := make([]string, 0, len(row.Groups))
+		for _, name := range row.Groups {
+			ids = append(ids, groupIds[name])
+		}
+
+		err = createRow(tx, row, ids, invitedBy, report)
+		if err != nil {
+			tx.Rollback()
+			if !dryRun {
+				removeAll(report.Users, remove)
+			}
+
+			// The user was created since the validation
+			if err == users.UserDuplicated {
+				report.fail(i, row, "a user with this email already exists")
+				report.Created, report.Invited = 0, 0
+				return report.discard(), nil
+			}
+			return nil, err
+		}
+
+		if dryRun || row.Invite {
+			continue
+		}
+
+		user := report.Users[len(report.Users)-1]
+		err = create(user.Id)
+		if err != nil {
+			tx.Rollback()
+			removeAll(report.Users[:len(report.Users)-1], remove)
+
+			report.fail(i, row, "unable to create the Windows account")
+			report.Created, report.Invited = 0, 0
+			return report.discard(), nil
+		}
+	}
+
+	if dryRun {
+		return report.discard(), tx.Rollback()
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		removeAll(report.Users, remove)
+		return nil, err
+	}
+	return report, nil
+}
+
+// removeAll calls remove for the users of an import rolled back. Its
+// failures are only logged: the import already failed.
+func removeAll(created []*users.User, remove func(userId string) error) {
+	for _, user := range created {
+		err := remove(user.Id)
+		if err != nil {
+			log.Errorf("Unable to remove the Windows account of %s: %s", user.Email, err.Error())
+		}
+	}
+}
+
+// Export returns all the users, with their groups. They are read from a
+// single snapshot of the database.
+func Export() ([]*Row, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(
+		`SELECT id, email, first_name, last_name, is_admin
+		FROM users
+		ORDER BY email`,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Row, 0)
+	byId := make(map[string]*Row)
+	for rows.Next() {
+		var id string
+		row := &Row{Groups: make([]string, 0)}
+
+		err = rows.Scan(&id, &row.Email, &row.FirstName, &row.LastName, &row.IsAdmin)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		result = append(result, row)
+		byId[id] = row
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(
+		`SELECT groups_users.user_id, groups.name
+		FROM groups_users
+		JOIN groups ON groups.id = groups_users.group_id
+		ORDER BY groups.name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId, name string
+
+		err = rows.Scan(&userId, &name)
+		if err != nil {
+			return nil, err
+		}
+
+		if row, ok := byId[userId]; ok {
+			row.Groups = append(row.Groups, name)
+		}
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,124 @@
+package bulk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+)
+
+func TestParseCSV(t *testing.T) {
+	rows, err := ParseCSV(strings.NewReader(
+		"Email,first-name,last-name,groups,is-admin,password,invite\n" +
+			"john@example.com,John,Doe,Students; Math,no,Secret123,\n" +
+			"jane@example.com,,,,yes,,true\n",
+	))
+	if err != nil {
+		t.Fatalf("Cannot parse: %s", err.Error())
+	}
+
+	expected := []*Row{
+		{
+			Email:     "john@example.com",
+			FirstName: "John",
+			LastName:  "Doe",
+			Groups:    []string{"Students", "Math"},
+			Password:  "Secret123",
+		},
+		{
+			Email:   "jane@example.com",
+			IsAdmin: true,
+			Groups:  []string{},
+			Invite:  true,
+		},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Unexpected rows %v", rows)
+	}
+}
+
+func TestParseCSVErrors(t *testing.T) {
+	files := map[string]string{
+		"":                              "no users",
+		"email\n":                       "no users",
+		"name\njohn@example.com\n":      "unknown column",
+		"first-name\nJohn\n":            "email column",
+		"email,is-admin\na@b.com,maybe": "line 2",
+	}
+
+	for file, expected := range files {
+		_, err := ParseCSV(strings.NewReader(file))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Parsing %q: expected an error about %s, got %v", file, expected, err)
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	rows, err := ParseJSON([]byte(`{"data": [{"email": "jane@example.com", "invite": true, "groups": ["Staff"]}]}`))
+	if err != nil {
+		t.Fatalf("Cannot parse: %s", err.Error())
+	}
+
+	if len(rows) != 1 || rows[0].Email != "jane@example.com" || !rows[0].Invite || rows[0].Groups[0] != "Staff" {
+		t.Errorf("Unexpected rows %v", rows)
+	}
+
+	_, err = ParseJSON([]byte(`{"data": []}`))
+	if err != NoRows {
+		t.Errorf("Expected NoRows, got %v", err)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	rows := []*Row{
+		{
+			Email:     "john@example.com",
+			FirstName: "John",
+			LastName:  "Doe, Jr",
+			IsAdmin:   true,
+			Groups:    []string{"Math", "Students"},
+		},
+	}
+
+	var b bytes.Buffer
+	err := csv.NewWriter(&b).WriteAll(Records(rows))
+	if err != nil {
+		t.Fatalf("Cannot write: %s", err.Error())
+	}
+
+	parsed, err := ParseCSV(&b)
+	if err != nil {
+		t.Fatalf("Cannot parse the export: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(parsed, rows) {
+		t.Errorf("The export cannot be imported back: %v", parsed[0])
+	}
+}
+
+func TestCheckRow(t *testing.T) {
+	policy := passwords.Policy{MinLength: 8, MinClasses: 3}
+
+	cases := []struct {
+		row    Row
+		errors int
+	}{
+		{Row{Email: "john@example.com", FirstName: "John", LastName: "Doe", Password: "Secret123"}, 0},
+		{Row{Email: "jane@example.com", Invite: true}, 0},
+		{Row{Email: "jane", Invite: true}, 1},
+		{Row{Email: "jane@example.com", Invite: true, Password: "Secret123"}, 1},
+		{Row{Email: "john@example.com", Password: "Secret123"}, 1},
+		{Row{Email: "john@example.com", FirstName: "John", LastName: "Doe"}, 1},
+		{Row{Email: "john@example.com", FirstName: "John", LastName: "Doe", Password: "secret"}, 1},
+	}
+
+	for _, c := range cases {
+		if errs := checkRow(&c.row, policy); len(errs) != c.errors {
+			t.Errorf("Expected %d errors for %v, got %v", c.errors, c.row, errs)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package bulk
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Row describes a user of an import or of an export. The groups are given
+// by name. An imported user either gets a password or is invited.
+type Row struct {
+	Email     string   `json:"email"`
+	FirstName string   `json:"first-name"`
+	LastName  string   `json:"last-name"`
+	IsAdmin   bool     `json:"is-admin"`
+	Groups    []string `json:"groups"`
+	Password  string   `json:"password,omitempty"`
+	Invite    bool     `json:"invite,omitempty"`
+}
+
+// Header of the CSV files. The groups are separated by semicolons.
+var Header = []string{"email", "first-name", "last-name", "is-admin", "groups", "password", "invite"}
+
+const groupSeparator = ";"
+
+var NoRows = errors.New("no users to import")
+
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "0", "false", "no":
+		return false, nil
+	case "1", "true", "yes":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", value)
+}
+
+func splitGroups(value string) []string {
+	groups := make([]string, 0)
+	for _, name := range strings.Split(value, groupSeparator) {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return groups
+}
+
+// ParseCSV reads the rows of a CSV file. The first line names the columns,
+// in any order; only the email is required.
+func ParseCSV(r io.Reader) ([]*Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, NoRows
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		known := false
+		for _, column := range Header {
+			known = known || column == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[name] = i
+	}
+
+	if _, ok := columns["email"]; !ok {
+		return nil, errors.New("the email column is missing")
+	}
+
+	rows := make([]*Row, 0)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row := &Row{
+			Email:     field("email"),
+			FirstName: field("first-name"),
+			LastName:  field("last-name"),
+			Groups:    splitGroups(field("groups")),
+			Password:  field("password"),
+		}
+
+		row.IsAdmin, err = parseBool(field("is-admin"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		row.Invite, err = parseBool(field("invite"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return nil, NoRows
+	}
+	return rows, nil
+}
+
+// ParseJSON reads the rows of a JSON document like `{"data": [rows]}`.
+func ParseJSON(b []byte) ([]*Row, error) {
+	var body struct {
+		Data []*Row `json:"data"`
+	}
+
+	err := json.Unmarshal(b, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]*Row, 0, len(body.Data))
+	for _, row := range body.Data {
+		if row != nil {
+			rows = append(rows, row)
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil, NoRows
+	}
+	return rows, nil
+}
+
+// Records returns the rows as the records of a CSV file, header included.
+func Records(rows []*Row) [][]string {
+	records := make([][]string, 0, len(rows)+1)
+	records = append(records, Header)
+
+	for _, row := range rows {
+		invite := ""
+		if row.Invite {
+			invite = "true"
+		}
+
+		records = append(records, []string{
+			row.Email,
+			row.FirstName,
+			row.LastName,
+			strconv.FormatBool(row.IsAdmin),
+			strings.Join(row.Groups, groupSeparator),
+			row.Password,
+			invite,
+		})
+	}
+	return records
+}
@@ -0,0 +1,223 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package directory synchronizes the users and groups with an LDAP
+// directory. The users it creates log in with the LDAP provider.
+package directory
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/config"
+	"github.com/Nanocloud/community/nanocloud/models/auth"
+	"gopkg.in/ldap.v2"
+)
+
+// Keys of the config table read by LoadConfig.
+const (
+	EnabledKey              = "directory_sync_enabled"
+	IntervalKey             = "directory_sync_interval"
+	BaseDNKey               = "directory_sync_base_dn"
+	UserFilterKey           = "directory_sync_user_filter"
+	GroupFilterKey          = "directory_sync_group_filter"
+	EmailAttributeKey       = "directory_sync_attr_email"
+	FirstNameAttributeKey   = "directory_sync_attr_firstname"
+	LastNameAttributeKey    = "directory_sync_attr_lastname"
+	GroupNameAttributeKey   = "directory_sync_attr_group_name"
+	GroupMemberAttributeKey = "directory_sync_attr_group_member"
+	AdminGroupKey           = "directory_sync_admin_group"
+)
+
+// Config of the synchronization. The server and the service account are
+// the ones of the LDAP authentication provider.
+type Config struct {
+	LDAP     auth.LDAPConfig
+	Enabled  bool
+	Interval time.Duration
+
+	BaseDN      string
+	UserFilter  string
+	GroupFilter string
+
+	EmailAttribute       string
+	FirstNameAttribute   string
+	LastNameAttribute    string
+	GroupNameAttribute   string
+	GroupMemberAttribute string
+
+	// Members of AdminGroup (a DN) are Nanocloud administrators.
+	AdminGroup string
+}
+
+// LoadConfig reads the configuration from the config table. Missing keys
+// default to the settings of the LDAP authentication provider.
+func LoadConfig() Config {
+	values := config.Get(
+		EnabledKey, IntervalKey, BaseDNKey, UserFilterKey, GroupFilterKey,
+		EmailAttributeKey, FirstNameAttributeKey, LastNameAttributeKey,
+		GroupNameAttributeKey, GroupMemberAttributeKey, AdminGroupKey,
+	)
+
+	get := func(key, def string) string {
+		if v := values[key]; v != "" {
+			return v
+		}
+		return def
+	}
+
+	ldapConfig := auth.LDAPConfigFromEnv()
+
+	interval, err := strconv.Atoi(get(IntervalKey, "3600"))
+	if err != nil || interval <= 0 {
+		interval = 3600
+	}
+
+	return Config{
+		LDAP:                 ldapConfig,
+		Enabled:              get(EnabledKey, "false") == "true",
+		Interval:             time.Duration(interval) * time.Second,
+		BaseDN:               get(BaseDNKey, ldapConfig.BaseDN),
+		UserFilter:           get(UserFilterKey, "(&(objectClass=person)(mail=*))"),
+		GroupFilter:          get(GroupFilterKey, "(|(objectClass=group)(objectClass=groupOfNames))"),
+		EmailAttribute:       get(EmailAttributeKey, ldapConfig.EmailAttribute),
+		FirstNameAttribute:   get(FirstNameAttributeKey, ldapConfig.FirstNameAttribute),
+		LastNameAttribute:    get(LastNameAttributeKey, ldapConfig.LastNameAttribute),
+		GroupNameAttribute:   get(GroupNameAttributeKey, "cn"),
+		GroupMemberAttribute: get(GroupMemberAttributeKey, "member"),
+		AdminGroup:           get(AdminGroupKey, ldapConfig.AdminGroup),
+	}
+}
+
+// User is a user entry of the directory.
+type User struct {
+	DN        string
+	Email     string
+	FirstName string
+	LastName  string
+	IsAdmin   bool
+}
+
+// Group is a group entry of the directory. Members holds the DNs of its
+// members.
+type Group struct {
+	DN      string
+	Name    string
+	Members []string
+}
+
+// conn is the part of *ldap.Conn used by the synchronization.
+type conn interface {
+	Bind(username, password string) error
+	SearchWithPaging(req *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error)
+	Close()
+}
+
+func dial(cfg Config) (conn, error) {
+	c, err := cfg.LDAP.Dial()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// fetch reads the users and groups of the directory.
+func fetch(c conn, cfg Config) ([]*User, []*Group, error) {
+	if cfg.LDAP.BindDN != "" {
+		err := c.Bind(cfg.LDAP.BindDN, cfg.LDAP.BindPassword)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Active Directory returns at most 1000 entries without paging
+	res, err := c.SearchWithPaging(ldap.NewSearchRequest(
+		cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		cfg.UserFilter,
+		[]string{
+			cfg.EmailAttribute,
+			cfg.FirstNameAttribute,
+			cfg.LastNameAttribute,
+			cfg.LDAP.GroupsAttribute,
+		},
+		nil,
+	), 500)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	adminGroup := strings.ToLower(cfg.AdminGroup)
+
+	users := make([]*User, 0, len(res.Entries))
+	byDN := make(map[string]*User)
+	for _, entry := range res.Entries {
+		u := &User{
+			DN:        entry.DN,
+			Email:     entry.GetAttributeValue(cfg.EmailAttribute),
+			FirstName: entry.GetAttributeValue(cfg.FirstNameAttribute),
+			LastName:  entry.GetAttributeValue(cfg.LastNameAttribute),
+		}
+		if adminGroup != "" {
+			for _, dn := range entry.GetAttributeValues(cfg.LDAP.GroupsAttribute) {
+				if strings.ToLower(dn) == adminGroup {
+					u.IsAdmin = true
+				}
+			}
+		}
+		users = append(users, u)
+		byDN[strings.ToLower(entry.DN)] = u
+	}
+
+	res, err = c.SearchWithPaging(ldap.NewSearchRequest(
+		cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		cfg.GroupFilter,
+		[]string{cfg.GroupNameAttribute, cfg.GroupMemberAttribute},
+		nil,
+	), 500)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups := make([]*Group, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		g := &Group{
+			DN:      entry.DN,
+			Name:    entry.GetAttributeValue(cfg.GroupNameAttribute),
+			Members: entry.GetAttributeValues(cfg.GroupMemberAttribute),
+		}
+
+		// Directories without the memberOf overlay only list the members
+		// on the group entries
+		if adminGroup != "" && strings.ToLower(g.DN) == adminGroup {
+			for _, dn := range g.Members {
+				if u, ok := byDN[strings.ToLower(dn)]; ok {
+					u.IsAdmin = true
+				}
+			}
+		}
+		groups = append(groups, g)
+	}
+	return users, groups, nil
+}
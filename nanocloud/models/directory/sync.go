@@ -0,0 +1,525 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package directory
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/auth"
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	NotConfigured = errors.New("directory synchronization not configured")
+	Unreachable   = errors.New("directory unreachable")
+)
+
+// Actions of the changes.
+const (
+	CreateUser   = "create"
+	UpdateUser   = "update"
+	EnableUser   = "enable"
+	DisableUser  = "disable"
+	CreateGroup  = "create"
+	LinkGroup    = "link"
+	AddMember    = "add-member"
+	RemoveMember = "remove-member"
+)
+
+// UserChange is a modification of a local user. Id is empty for the users
+// to create.
+type UserChange struct {
+	Action    string `json:"action"`
+	Id        string `json:"id,omitempty"`
+	Email     string `json:"email"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	IsAdmin   bool   `json:"is-admin"`
+}
+
+// GroupChange is a modification of a local group or of its members. Id is
+// empty for the groups to create.
+type GroupChange struct {
+	Action string `json:"action"`
+	Id     string `json:"id,omitempty"`
+	Name   string `json:"name"`
+	DN     string `json:"dn"`
+	Email  string `json:"email,omitempty"`
+}
+
+// Diff lists the changes a synchronization makes. The directory entries
+// that cannot be synchronized are reported in Conflicts.
+type Diff struct {
+	Users     []UserChange  `json:"users"`
+	Groups    []GroupChange `json:"groups"`
+	Conflicts []string      `json:"conflicts"`
+}
+
+func (d *Diff) String() string {
+	return fmt.Sprintf(
+		"%d user changes, %d group changes, %d conflicts",
+		len(d.Users), len(d.Groups), len(d.Conflicts),
+	)
+}
+
+type localUser struct {
+	id        string
+	email     string
+	firstName string
+	lastName  string
+	provider  string
+	activated bool
+	isAdmin   bool
+}
+
+type localGroup struct {
+	id      string
+	name    string
+	dn      string
+	members map[string]bool
+}
+
+// snapshot loads the local users and groups.
+func snapshot() ([]*localUser, []*localGroup, error) {
+	rows, err := db.Query(
+		`SELECT id, email, first_name, last_name, auth_provider, activated, is_admin
+		FROM users`,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	localUsers := make([]*localUser, 0)
+	for rows.Next() {
+		var u localUser
+
+		err = rows.Scan(&u.id, &u.email, &u.firstName, &u.lastName, &u.provider, &u.activated, &u.isAdmin)
+		if err != nil {
+			return nil, nil, err
+		}
+		localUsers = append(localUsers, &u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = db.Query(`SELECT id, name, directory_dn FROM groups`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	localGroups := make([]*localGroup, 0)
+	byId := make(map[string]*localGroup)
+	for rows.Next() {
+		g := localGroup{members: make(map[string]bool)}
+
+		err = rows.Scan(&g.id, &g.name, &g.dn)
+		if err != nil {
+			return nil, nil, err
+		}
+		localGroups = append(localGroups, &g)
+		byId[g.id] = &g
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = db.Query(`SELECT group_id, user_id FROM groups_users`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var groupId, userId string
+
+		err = rows.Scan(&groupId, &userId)
+		if err != nil {
+			return nil, nil, err
+		}
+		if g, ok := byId[groupId]; ok {
+			g.members[userId] = true
+		}
+	}
+	return localUsers, localGroups, rows.Err()
+}
+
+// plan compares the directory with the local users and groups.
+//
+// Users are matched by email. The directory only manages the users it
+// created: a local account with the email of a directory user is reported
+// as a conflict, and the users of the directory missing from it are
+// disabled. Groups are matched by DN, then by name for the local groups
+// not linked yet. Only the directory users are added to or removed from
+// the linked groups; groups removed from the directory are left as is.
+func plan(dirUsers []*User, dirGroups []*Group, localUsers []*localUser, localGroups []*localGroup) *Diff {
+	diff := &Diff{
+		Users:     make([]UserChange, 0),
+		Groups:    make([]GroupChange, 0),
+		Conflicts: make([]string, 0),
+	}
+
+	byEmail := make(map[string]*localUser)
+	byId := make(map[string]*localUser)
+	for _, u := range localUsers {
+		byEmail[strings.ToLower(u.email)] = u
+		byId[u.id] = u
+	}
+
+	// Email of the synchronized users, by lower case DN
+	synced := make(map[string]string)
+	seen := make(map[string]bool)
+
+	for _, d := range dirUsers {
+		email := users.Truncate(strings.TrimSpace(d.Email))
+		key := strings.ToLower(email)
+
+		if email == "" {
+			diff.Conflicts = append(diff.Conflicts, fmt.Sprintf("%s has no email", d.DN))
+			continue
+		}
+		if seen[key] {
+			diff.Conflicts = append(diff.Conflicts, fmt.Sprintf("%s: %s is used by several entries", d.DN, email))
+			continue
+		}
+		seen[key] = true
+
+		change := UserChange{
+			Email:     email,
+			FirstName: users.Truncate(d.FirstName),
+			LastName:  users.Truncate(d.LastName),
+			IsAdmin:   d.IsAdmin,
+		}
+
+		local := byEmail[key]
+		if local != nil && local.provider != auth.LDAPProvider {
+			diff.Conflicts = append(diff.Conflicts, fmt.Sprintf("%s: %s is a local account", d.DN, email))
+			continue
+		}
+		synced[strings.ToLower(d.DN)] = key
+
+		if local == nil {
+			change.Action = CreateUser
+			diff.Users = append(diff.Users, change)
+			continue
+		}
+
+		change.Id = local.id
+		change.Email = local.email
+		if !local.activated {
+			change.Action = EnableUser
+			diff.Users = append(diff.Users, change)
+		}
+		if local.firstName != change.FirstName || local.lastName != change.LastName || local.isAdmin != change.IsAdmin {
+			change.Action = UpdateUser
+			diff.Users = append(diff.Users, change)
+		}
+	}
+
+	for _, u := range localUsers {
+		if u.provider == auth.LDAPProvider && u.activated && !seen[strings.ToLower(u.email)] {
+			diff.Users = append(diff.Users, UserChange{
+				Action:    DisableUser,
+				Id:        u.id,
+				Email:     u.email,
+				FirstName: u.firstName,
+				LastName:  u.lastName,
+				IsAdmin:   u.isAdmin,
+			})
+		}
+	}
+
+	groupsByDN := make(map[string]*localGroup)
+	groupsByName := make(map[string]*localGroup)
+	for _, g := range localGroups {
+		if g.dn != "" {
+			groupsByDN[strings.ToLower(g.dn)] = g
+		}
+		groupsByName[g.name] = g
+	}
+
+	for _, d := range dirGroups {
+		name := strings.TrimSpace(d.Name)
+		if name == "" {
+			diff.Conflicts = append(diff.Conflicts, fmt.Sprintf("%s has no name", d.DN))
+			continue
+		}
+
+		local := groupsByDN[strings.ToLower(d.DN)]
+		if local == nil {
+			local = groupsByName[name]
+			if local != nil && local.dn != "" {
+				diff.Conflicts = append(diff.Conflicts, fmt.Sprintf("%s: group %s is linked to %s", d.DN, name, local.dn))
+				continue
+			}
+
+			action := CreateGroup
+			id := ""
+			if local != nil {
+				action = LinkGroup
+				id = local.id
+			}
+			diff.Groups = append(diff.Groups, GroupChange{
+				Action: action,
+				Id:     id,
+				Name:   name,
+				DN:     d.DN,
+			})
+		}
+
+		members := make(map[string]bool)
+		for _, dn := range d.Members {
+			email, ok := synced[strings.ToLower(dn)]
+			if !ok || members[email] {
+				continue
+			}
+			members[email] = true
+
+			u := byEmail[email]
+			if local != nil && u != nil && local.members[u.id] {
+				continue
+			}
+
+			change := GroupChange{
+				Action: AddMember,
+				Name:   name,
+				DN:     d.DN,
+				Email:  email,
+			}
+			if local != nil {
+				change.Id = local.id
+			}
+			if u != nil {
+				change.Email = u.email
+			}
+			diff.Groups = append(diff.Groups, change)
+		}
+
+		if local == nil {
+			continue
+		}
+
+		for id := range local.members {
+			u := byId[id]
+			if u == nil || u.provider != auth.LDAPProvider || members[strings.ToLower(u.email)] {
+				continue
+			}
+			diff.Groups = append(diff.Groups, GroupChange{
+				Action: RemoveMember,
+				Id:     local.id,
+				Name:   name,
+				DN:     d.DN,
+				Email:  u.email,
+			})
+		}
+	}
+	return diff
+}
+
+// apply makes the changes of the diff.
+func apply(diff *Diff) error {
+	userIds := make(map[string]string)
+
+	for _, c := range diff.Users {
+		var err error
+
+		switch c.Action {
+		case CreateUser:
+			var user *users.User
+			user, err = auth.CreateUser(auth.LDAPProvider, c.Email, c.FirstName, c.LastName, c.IsAdmin)
+			if user != nil {
+				userIds[strings.ToLower(c.Email)] = user.Id
+			}
+		case EnableUser:
+			err = users.EnableUser(c.Id)
+		case DisableUser:
+			err = users.DisableUser(c.Id)
+		case UpdateUser:
+			err = users.UpdateUserFirstName(c.Id, c.FirstName)
+			if err == nil {
+				err = users.UpdateUserLastName(c.Id, c.LastName)
+			}
+			if err == nil {
+				err = users.UpdateUserPrivilege(c.Id, c.IsAdmin)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to %s user %s: %s", c.Action, c.Email, err)
+		}
+		if c.Id != "" {
+			userIds[strings.ToLower(c.Email)] = c.Id
+		}
+	}
+
+	// Groups by lower case DN
+	linked := make(map[string]*groups.Group)
+	getGroup := func(c GroupChange) (*groups.Group, error) {
+		dn := strings.ToLower(c.DN)
+		if g, ok := linked[dn]; ok {
+			return g, nil
+		}
+
+		g, err := groups.GetGroup(c.Id)
+		if err != nil {
+			return nil, err
+		}
+		linked[dn] = g
+		return g, nil
+	}
+
+	for _, c := range diff.Groups {
+		var err error
+
+		switch c.Action {
+		case CreateGroup:
+			var g *groups.Group
+			g, err = groups.CreateGroup(c.Name)
+			if err == nil {
+				err = g.LinkDirectory(c.DN)
+				linked[strings.ToLower(c.DN)] = g
+			}
+		case LinkGroup:
+			var g *groups.Group
+			g, err = getGroup(c)
+			if err == nil {
+				err = g.LinkDirectory(c.DN)
+			}
+		case AddMember, RemoveMember:
+			id, ok := userIds[strings.ToLower(c.Email)]
+			if !ok {
+				id, err = userId(c.Email)
+			}
+
+			var g *groups.Group
+			if err == nil {
+				g, err = getGroup(c)
+			}
+			if err == nil && c.Action == AddMember {
+				err = g.AddMember(id)
+			} else if err == nil {
+				err = g.RemoveMember(id)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("unable to %s group %s: %s", c.Action, c.Name, err)
+		}
+	}
+	return nil
+}
+
+func userId(email string) (string, error) {
+	user, err := users.GetUserByEmail(email)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		return "", users.UserNotFound
+	}
+	return user.Id, nil
+}
+
+// keepAdmins gives the directory users the administrator rights they have
+// locally. It is used when no group maps to the administrators, so that the
+// synchronization doesn't revoke the rights granted in Nanocloud.
+func keepAdmins(dirUsers []*User, localUsers []*localUser) {
+	admins := make(map[string]bool)
+	for _, u := range localUsers {
+		if u.isAdmin {
+			admins[strings.ToLower(u.email)] = true
+		}
+	}
+
+	for _, d := range dirUsers {
+		d.IsAdmin = admins[strings.ToLower(strings.TrimSpace(d.Email))]
+	}
+}
+
+// Only one synchronization runs at a time.
+var mutex sync.Mutex
+
+func compute(cfg Config) (*Diff, error) {
+	if cfg.BaseDN == "" {
+		return nil, NotConfigured
+	}
+
+	c, err := dial(cfg)
+	if err != nil {
+		log.Error("Unable to reach the LDAP server: ", err)
+		return nil, Unreachable
+	}
+	defer c.Close()
+
+	dirUsers, dirGroups, err := fetch(c, cfg)
+	if err != nil {
+		log.Error("Unable to read the directory: ", err)
+		return nil, Unreachable
+	}
+
+	localUsers, localGroups, err := snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.AdminGroup == "" {
+		keepAdmins(dirUsers, localUsers)
+	}
+	return plan(dirUsers, dirGroups, localUsers, localGroups), nil
+}
+
+// Preview returns the changes a synchronization would make.
+func Preview(cfg Config) (*Diff, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return compute(cfg)
+}
+
+// Sync synchronizes the local users and groups with the directory and
+// returns the changes made.
+func Sync(cfg Config) (*Diff, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	diff, err := compute(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = apply(diff)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Directory synchronized: %s", diff)
+	return diff, nil
+}
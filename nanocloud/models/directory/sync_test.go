@@ -0,0 +1,216 @@
+package directory
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/ldap.v2"
+)
+
+type stubDirectory struct {
+	users  []*ldap.Entry
+	groups []*ldap.Entry
+	binds  []string
+}
+
+func (d *stubDirectory) Bind(dn, password string) error {
+	d.binds = append(d.binds, dn)
+	return nil
+}
+
+func (d *stubDirectory) SearchWithPaging(req *ldap.SearchRequest, pagingSize uint32) (*ldap.SearchResult, error) {
+	if strings.Contains(req.Filter, "person") {
+		return &ldap.SearchResult{Entries: d.users}, nil
+	}
+	return &ldap.SearchResult{Entries: d.groups}, nil
+}
+
+func (d *stubDirectory) Close() {
+}
+
+func testConfig() Config {
+	return Config{
+		BaseDN:               "dc=example,dc=com",
+		UserFilter:           "(objectClass=person)",
+		GroupFilter:          "(objectClass=groupOfNames)",
+		EmailAttribute:       "mail",
+		FirstNameAttribute:   "givenName",
+		LastNameAttribute:    "sn",
+		GroupNameAttribute:   "cn",
+		GroupMemberAttribute: "member",
+		AdminGroup:           "cn=admins,dc=example,dc=com",
+	}
+}
+
+func TestFetch(t *testing.T) {
+	d := &stubDirectory{
+		users: []*ldap.Entry{
+			ldap.NewEntry("cn=jane,dc=example,dc=com", map[string][]string{
+				"mail":      {"jane@example.com"},
+				"givenName": {"Jane"},
+				"sn":        {"Doe"},
+			}),
+		},
+		groups: []*ldap.Entry{
+			ldap.NewEntry("cn=admins,dc=example,dc=com", map[string][]string{
+				"cn":     {"admins"},
+				"member": {"CN=Jane,DC=example,DC=com"},
+			}),
+		},
+	}
+
+	cfg := testConfig()
+	cfg.LDAP.BindDN = "cn=service,dc=example,dc=com"
+
+	dirUsers, dirGroups, err := fetch(d, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.binds) != 1 || d.binds[0] != cfg.LDAP.BindDN {
+		t.Errorf("expected a service bind, got %v", d.binds)
+	}
+
+	if len(dirUsers) != 1 || dirUsers[0].Email != "jane@example.com" || dirUsers[0].FirstName != "Jane" {
+		t.Fatalf("unexpected users: %+v", dirUsers)
+	}
+	if !dirUsers[0].IsAdmin {
+		t.Error("members of the admin group should be administrators")
+	}
+
+	if len(dirGroups) != 1 || dirGroups[0].Name != "admins" || len(dirGroups[0].Members) != 1 {
+		t.Errorf("unexpected groups: %+v", dirGroups)
+	}
+}
+
+func findUserChange(diff *Diff, action, email string) *UserChange {
+	for i, c := range diff.Users {
+		if c.Action == action && c.Email == email {
+			return &diff.Users[i]
+		}
+	}
+	return nil
+}
+
+func findGroupChange(diff *Diff, action, name, email string) *GroupChange {
+	for i, c := range diff.Groups {
+		if c.Action == action && c.Name == name && c.Email == email {
+			return &diff.Groups[i]
+		}
+	}
+	return nil
+}
+
+func TestPlanUsers(t *testing.T) {
+	dirUsers := []*User{
+		{DN: "cn=new", Email: "new@example.com", FirstName: "New", LastName: "User"},
+		{DN: "cn=renamed", Email: "renamed@example.com", FirstName: "Renamed", LastName: "User"},
+		{DN: "cn=disabled", Email: "disabled@example.com", FirstName: "Disabled", LastName: "User"},
+		{DN: "cn=same", Email: "same@example.com", FirstName: "Same", LastName: "User"},
+		{DN: "cn=local", Email: "local@example.com"},
+		{DN: "cn=noemail"},
+	}
+
+	localUsers := []*localUser{
+		{id: "1", email: "renamed@example.com", firstName: "Old", lastName: "User", provider: "ldap", activated: true},
+		{id: "2", email: "disabled@example.com", firstName: "Disabled", lastName: "User", provider: "ldap"},
+		{id: "3", email: "same@example.com", firstName: "Same", lastName: "User", provider: "ldap", activated: true},
+		{id: "4", email: "local@example.com", provider: "local", activated: true},
+		{id: "5", email: "gone@example.com", provider: "ldap", activated: true},
+		{id: "6", email: "admin@nanocloud.com", provider: "local", activated: true},
+	}
+
+	diff := plan(dirUsers, nil, localUsers, nil)
+
+	if findUserChange(diff, CreateUser, "new@example.com") == nil {
+		t.Error("new@example.com should be created")
+	}
+	if c := findUserChange(diff, UpdateUser, "renamed@example.com"); c == nil || c.Id != "1" || c.FirstName != "Renamed" {
+		t.Errorf("renamed@example.com should be updated, got %+v", c)
+	}
+	if findUserChange(diff, EnableUser, "disabled@example.com") == nil {
+		t.Error("disabled@example.com should be enabled")
+	}
+	if findUserChange(diff, DisableUser, "gone@example.com") == nil {
+		t.Error("gone@example.com should be disabled")
+	}
+	if len(diff.Users) != 4 {
+		t.Errorf("expected 4 user changes, got %+v", diff.Users)
+	}
+	if len(diff.Conflicts) != 2 {
+		t.Errorf("expected 2 conflicts, got %v", diff.Conflicts)
+	}
+}
+
+func TestKeepAdmins(t *testing.T) {
+	dirUsers := []*User{
+		{DN: "cn=promoted", Email: "Promoted@example.com"},
+		{DN: "cn=user", Email: "user@example.com", IsAdmin: true},
+	}
+
+	localUsers := []*localUser{
+		{id: "1", email: "promoted@example.com", provider: "ldap", activated: true, isAdmin: true},
+		{id: "2", email: "user@example.com", provider: "ldap", activated: true},
+	}
+
+	keepAdmins(dirUsers, localUsers)
+	diff := plan(dirUsers, nil, localUsers, nil)
+
+	if !dirUsers[0].IsAdmin || dirUsers[1].IsAdmin {
+		t.Errorf("the local administrator rights should be kept, got %+v %+v", dirUsers[0], dirUsers[1])
+	}
+	if len(diff.Users) != 0 {
+		t.Errorf("expected no user changes, got %+v", diff.Users)
+	}
+}
+
+func TestPlanGroups(t *testing.T) {
+	dirUsers := []*User{
+		{DN: "cn=jane", Email: "jane@example.com"},
+		{DN: "cn=john", Email: "john@example.com"},
+	}
+
+	dirGroups := []*Group{
+		{DN: "cn=sales", Name: "sales", Members: []string{"CN=Jane"}},
+		{DN: "cn=support", Name: "support", Members: []string{"cn=jane", "cn=john"}},
+		{DN: "cn=devs", Name: "devs", Members: []string{"cn=john"}},
+	}
+
+	localUsers := []*localUser{
+		{id: "1", email: "jane@example.com", provider: "ldap", activated: true},
+		{id: "2", email: "local@example.com", provider: "local", activated: true},
+		{id: "3", email: "old@example.com", provider: "ldap", activated: true},
+	}
+
+	localGroups := []*localGroup{
+		{id: "g1", name: "Sales", dn: "CN=sales", members: map[string]bool{"1": true, "2": true, "3": true}},
+		{id: "g2", name: "support", members: map[string]bool{}},
+	}
+
+	diff := plan(dirUsers, dirGroups, localUsers, localGroups)
+
+	if c := findGroupChange(diff, CreateGroup, "devs", ""); c == nil || c.DN != "cn=devs" {
+		t.Errorf("devs should be created, got %+v", c)
+	}
+	if c := findGroupChange(diff, LinkGroup, "support", ""); c == nil || c.Id != "g2" {
+		t.Errorf("support should be linked, got %+v", c)
+	}
+	if findGroupChange(diff, AddMember, "devs", "john@example.com") == nil {
+		t.Error("john should be added to the new devs group")
+	}
+	if findGroupChange(diff, AddMember, "support", "jane@example.com") == nil {
+		t.Error("jane should be added to support")
+	}
+	if c := findGroupChange(diff, RemoveMember, "sales", "old@example.com"); c == nil || c.Id != "g1" {
+		t.Errorf("old should be removed from sales, got %+v", c)
+	}
+	if findGroupChange(diff, RemoveMember, "sales", "local@example.com") != nil {
+		t.Error("local members must be kept")
+	}
+	if findGroupChange(diff, AddMember, "sales", "jane@example.com") != nil {
+		t.Error("jane is already a member of sales")
+	}
+	if len(diff.Groups) != 6 {
+		t.Errorf("expected 6 group changes, got %+v", diff.Groups)
+	}
+}
@@ -0,0 +1,296 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package groups
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/query"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	GroupNotFound   = errors.New("group not found")
+	GroupDuplicated = errors.New("group duplicated")
+	InvalidName     = errors.New("invalid group name")
+)
+
+type Group struct {
+	Id        string    `json:"-"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created-at"`
+	Members   int       `json:"members"`
+}
+
+func (g *Group) GetID() string {
+	return g.Id
+}
+
+func (g *Group) SetID(id string) error {
+	g.Id = id
+	return nil
+}
+
+// Columns the groups can be filtered and sorted by.
+var Columns = query.Columns{
+	"name":       "name",
+	"created-at": "created_at",
+}
+
+const groupColumns = `id, name, created_at,
+	(SELECT count(*) FROM groups_users WHERE group_id = groups.id)`
+
+func scanGroups(rows *sql.Rows) ([]*Group, error) {
+	defer rows.Close()
+
+	groups := make([]*Group, 0)
+	for rows.Next() {
+		var g Group
+
+		err := rows.Scan(&g.Id, &g.Name, &g.CreatedAt, &g.Members)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, &g)
+	}
+	return groups, rows.Err()
+}
+
+// FindGroups returns the page of groups selected by q and the number of
+// groups matching its filters.
+func FindGroups(q *query.Query) ([]*Group, int, error) {
+	where, args := q.Where(Columns, nil, nil)
+
+	total, err := db.Count(`SELECT count(*) FROM groups`+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(
+		`SELECT `+groupColumns+` FROM groups`+where+q.OrderBy(Columns, "name")+q.Limit(),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	groups, err := scanGroups(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return groups, total, nil
+}
+
+func GetGroup(id string) (*Group, error) {
+	rows, err := db.Query(
+		`SELECT `+groupColumns+` FROM groups WHERE id = $1::varchar`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := scanGroups(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups) == 0 {
+		return nil, GroupNotFound
+	}
+	return groups[0], nil
+}
+
+func isDuplicate(err error) bool {
+	return err.Error() == "pq: duplicate key value violates unique constraint \"groups_name_key\""
+}
+
+func CreateGroup(name string) (*Group, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, InvalidName
+	}
+
+	id := uuid.NewV4().String()
+	_, err := db.Exec(
+		`INSERT INTO groups (id, name) VALUES ($1::varchar, $2::varchar)`,
+		id, name,
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, GroupDuplicated
+		}
+		return nil, err
+	}
+	return GetGroup(id)
+}
+
+func (g *Group) Rename(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return InvalidName
+	}
+
+	res, err := db.Exec(
+		`UPDATE groups SET name = $2::varchar WHERE id = $1::varchar`,
+		g.Id, name,
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return GroupDuplicated
+		}
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return GroupNotFound
+	}
+	g.Name = name
+	return nil
+}
+
+// LinkDirectory marks the group as synchronized with the directory group
+// of the DN. An empty DN makes it a local group again.
+func (g *Group) LinkDirectory(dn string) error {
+	_, err := db.Exec(
+		`UPDATE groups SET directory_dn = $2::varchar WHERE id = $1::varchar`,
+		g.Id, dn,
+	)
+	return err
+}
+
+// Delete the group. Its memberships and assignments are deleted with it.
+func (g *Group) Delete() error {
+	res, err := db.Exec(`DELETE FROM groups WHERE id = $1::varchar`, g.Id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return GroupNotFound
+	}
+	return nil
+}
+
+// Return the ids of the members of the group.
+func (g *Group) MemberIds() ([]string, error) {
+	rows, err := db.Query(
+		`SELECT user_id FROM groups_users WHERE group_id = $1::varchar`,
+		g.Id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Add a user to the group. Adding a member twice has no effect.
+func (g *Group) AddMember(userId string) error {
+	exists, err := users.UserExists(userId)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return users.UserNotFound
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO groups_users (group_id, user_id)
+		SELECT $1::varchar, $2::varchar
+		WHERE NOT EXISTS (
+			SELECT 1 FROM groups_users
+			WHERE group_id = $1::varchar AND user_id = $2::varchar
+		)`,
+		g.Id, userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	g.Members, err = db.Count(`SELECT count(*) FROM groups_users WHERE group_id = $1::varchar`, g.Id)
+	return err
+}
+
+func (g *Group) RemoveMember(userId string) error {
+	res, err := db.Exec(
+		`DELETE FROM groups_users
+		WHERE group_id = $1::varchar AND user_id = $2::varchar`,
+		g.Id, userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return users.UserNotFound
+	}
+	g.Members--
+	return nil
+}
+
+// Return the groups a user belongs to.
+func UserGroups(userId string) ([]*Group, error) {
+	rows, err := db.Query(
+		`SELECT `+groupColumns+` FROM groups
+		WHERE id IN (SELECT group_id FROM groups_users WHERE user_id = $1::varchar)
+		ORDER BY name`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanGroups(rows)
+}
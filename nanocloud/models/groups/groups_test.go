@@ -0,0 +1,140 @@
+package groups
+
+import (
+	"log"
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/query"
+)
+
+var (
+	user  = &users.User{}
+	group = &Group{}
+)
+
+func init() {
+	new_user, err := users.CreateUser(
+		true,
+		"member@nanocloud.com",
+		"Test",
+		"member",
+		"secret",
+		false,
+	)
+
+	if err != nil {
+		log.Panicln("Can't create new account:", err.Error())
+	}
+	if new_user == nil {
+		log.Panicln("Can't create new account")
+	}
+	user = new_user
+}
+
+func TestCreateGroup(t *testing.T) {
+	var err error
+	group, err = CreateGroup(" Accounting ")
+	if err != nil {
+		t.Fatalf("Cannot create group: %s", err.Error())
+	}
+
+	if group.Id == "" || group.Name != "Accounting" || group.Members != 0 {
+		t.Errorf("Unexpected group %+v", group)
+	}
+
+	_, err = CreateGroup("Accounting")
+	if err != GroupDuplicated {
+		t.Errorf("Groups names should be unique")
+	}
+
+	_, err = CreateGroup(" ")
+	if err != InvalidName {
+		t.Errorf("Groups should have a name")
+	}
+}
+
+func TestFindGroups(t *testing.T) {
+	q := query.All()
+	q.Filters["name"] = []string{"Accounting"}
+
+	groups, total, err := FindGroups(q)
+	if err != nil {
+		t.Fatalf("Cannot find groups: %s", err.Error())
+	}
+
+	if total != 1 || len(groups) != 1 || groups[0].Id != group.Id {
+		t.Errorf("Unexpected groups %v", groups)
+	}
+}
+
+func TestMembers(t *testing.T) {
+	err := group.AddMember(user.GetID())
+	if err != nil {
+		t.Fatalf("Cannot add member: %s", err.Error())
+	}
+
+	// Adding a member twice has no effect
+	err = group.AddMember(user.GetID())
+	if err != nil {
+		t.Fatalf("Cannot add member: %s", err.Error())
+	}
+
+	if group.Members != 1 {
+		t.Errorf("Expected 1 member, have %d", group.Members)
+	}
+
+	err = group.AddMember("unknown-user")
+	if err != users.UserNotFound {
+		t.Errorf("Unknown users should not be added")
+	}
+
+	ids, err := group.MemberIds()
+	if err != nil || len(ids) != 1 || ids[0] != user.GetID() {
+		t.Errorf("Unexpected members %v", ids)
+	}
+
+	groups, err := UserGroups(user.GetID())
+	if err != nil || len(groups) != 1 || groups[0].Id != group.Id {
+		t.Errorf("Unexpected groups %v", groups)
+	}
+
+	err = group.RemoveMember(user.GetID())
+	if err != nil {
+		t.Errorf("Cannot remove member: %s", err.Error())
+	}
+
+	err = group.RemoveMember(user.GetID())
+	if err != users.UserNotFound {
+		t.Errorf("Removing a user twice should fail")
+	}
+}
+
+func TestRenameGroup(t *testing.T) {
+	err := group.Rename("Finance")
+	if err != nil {
+		t.Fatalf("Cannot rename group: %s", err.Error())
+	}
+
+	g, err := GetGroup(group.Id)
+	if err != nil || g.Name != "Finance" {
+		t.Errorf("The group was not renamed")
+	}
+}
+
+func TestDeleteGroup(t *testing.T) {
+	err := group.Delete()
+	if err != nil {
+		t.Fatalf("Cannot delete group: %s", err.Error())
+	}
+
+	_, err = GetGroup(group.Id)
+	if err != GroupNotFound {
+		t.Errorf("The group was not deleted")
+	}
+
+	err = users.DeleteUser(user.GetID())
+	if err != nil {
+		t.Errorf("Can't delete user: %s\n", err.Error())
+	}
+}
@@ -1,13 +1,17 @@
 package histories
 
 import (
+	"database/sql"
 	"errors"
+	"time"
+
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
 	uuid "github.com/satori/go.uuid"
 )
 
 var (
 	HistoryNotCreated = errors.New("history not created")
+	HistoryNotFound   = errors.New("history not found")
 )
 
 func isAlphaNum(c byte) bool {
@@ -30,88 +34,117 @@ func escapeId(id string) string {
 	return "'" + id + "'"
 }
 
-func FindAll() ([]*History, error) {
-
-	result := make([]*History, 0)
+const historyColumns = `id, userid, usermail, userfirstname, userlastname,
+	connectionid, machine_id, app_alias, startdate, enddate`
+
+func scanHistory(rows *sql.Rows) (*History, error) {
+	var h History
+	err := rows.Scan(
+		&h.Id,
+		&h.UserId,
+		&h.UserMail,
+		&h.UserFirstname,
+		&h.UserLastname,
+		&h.ConnectionId,
+		&h.MachineId,
+		&h.AppAlias,
+		&h.StartDate,
+		&h.EndDate,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
 
-	res, err := db.Query(
-		`SELECT id, userid, usermail, userfirstname,
-		userlastname, connectionid, startdate, enddate FROM histories`,
+func findWhere(condition string, args ...interface{}) ([]*History, error) {
+	rows, err := db.Query(
+		`SELECT `+historyColumns+` FROM histories`+condition,
+		args...,
 	)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	defer res.Close()
+	result := make([]*History, 0)
+	for rows.Next() {
+		h, err := scanHistory(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, h)
+	}
+	return result, rows.Err()
+}
 
-	for res.Next() {
-		h := History{}
+func FindAll() ([]*History, error) {
+	return findWhere(` ORDER BY startdate DESC`)
+}
 
-		res.Scan(
-			&h.Id,
-			&h.UserId,
-			&h.UserMail,
-			&h.UserFirstname,
-			&h.UserLastname,
-			&h.ConnectionId,
-			&h.StartDate,
-			&h.EndDate,
-		)
+// Return the sessions that are still running.
+func FindOpen() ([]*History, error) {
+	return findWhere(` WHERE enddate IS NULL`)
+}
 
-		result = append(result, &h)
+func GetHistory(id string) (*History, error) {
+	histories, err := findWhere(` WHERE id = $1::varchar`, id)
+	if err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	if len(histories) == 0 {
+		return nil, HistoryNotFound
+	}
+	return histories[0], nil
 }
 
+// Open the history of a session started at startDate.
 func CreateHistory(
 	userId string,
 	userMail string,
 	userFirstname string,
 	userLastname string,
 	connectionId string,
-	startDate string,
-	endDate string,
+	machineId string,
+	appAlias string,
+	startDate time.Time,
 ) (*History, error) {
 	id := uuid.NewV4().String()
 
-	rows, err := db.Query(
+	_, err := db.Exec(
 		`INSERT INTO histories
-		(id, userid, usermail, userfirstname, userlastname, connectionid, startdate, enddate)
-		VALUES(	$1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::varchar, $7::varchar, $8::varchar)`,
-		id, userId, userMail, userFirstname, userLastname, connectionId, startDate, endDate)
-
+		(id, userid, usermail, userfirstname, userlastname, connectionid, machine_id, app_alias, startdate)
+		VALUES($1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::varchar, $7::varchar, $8::varchar, $9)`,
+		id, userId, userMail, userFirstname, userLastname, connectionId, machineId, appAlias, startDate)
 	if err != nil {
 		return nil, err
 	}
 
-	rows.Close()
-
-	rows, err = db.Query(
-		`SELECT id, userid, usermail, userfirstname, userlastname, connectionid, startdate, enddate
-		FROM histories WHERE id = $1::varchar`, id)
+	history, err := GetHistory(id)
+	if err == HistoryNotFound {
+		return nil, HistoryNotCreated
+	}
+	return history, err
+}
 
+// Close the history of a session ended at endDate.
+func EndHistory(id string, endDate time.Time) error {
+	res, err := db.Exec(
+		`UPDATE histories SET enddate = $2
+		WHERE id = $1::varchar AND enddate IS NULL`,
+		id, endDate)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if !rows.Next() {
-		return nil, HistoryNotCreated
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
 	}
 
-	var history History
-	rows.Scan(
-		&history.Id,
-		&history.UserId,
-		&history.UserMail,
-		&history.UserFirstname,
-		&history.UserLastname,
-		&history.ConnectionId,
-		&history.StartDate,
-		&history.EndDate,
-	)
-
-	rows.Close()
-
-	return &history, err
+	if updated == 0 {
+		return HistoryNotFound
+	}
+	return nil
 }
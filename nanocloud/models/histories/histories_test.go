@@ -9,12 +9,11 @@ import (
 )
 
 var (
-	total        = 0
-	history_num  = 0
 	user         = &users.User{}
-	connectionId = "fake-connection-id"
-	startDate    []string
-	endDate      []string
+	connectionId = "2"
+	machineId    = "fake-machine-id"
+	alias        = "hapticPowershell"
+	history      *History
 )
 
 func init() {
@@ -36,22 +35,13 @@ func init() {
 	user = new_user
 }
 
-func countEntries() {
-	histories, err := FindAll()
-	if err != nil {
-		log.Panicln("Can't retreive histories:", err.Error())
-	}
-	for range histories {
-		total++
-	}
-}
-
 func TestCreateHistory(t *testing.T) {
-	startDate = append(startDate, time.Now().Format(time.RFC3339))
-	endDate = append(endDate, time.Now().Format(time.RFC3339))
-	history, err := CreateHistory(user.GetID(), user.Email, user.FirstName, user.LastName, connectionId, startDate[history_num], endDate[history_num])
+	startDate := time.Now().Truncate(time.Second)
+
+	var err error
+	history, err = CreateHistory(user.GetID(), user.Email, user.FirstName, user.LastName, connectionId, machineId, alias, startDate)
 	if err != nil {
-		t.Errorf("Cannot create history: %s", err.Error())
+		t.Fatalf("Cannot create history: %s", err.Error())
 	}
 
 	switch {
@@ -67,26 +57,72 @@ func TestCreateHistory(t *testing.T) {
 		t.Errorf("'user.LastName' field doesn't match the inserted value")
 	case history.ConnectionId != connectionId:
 		t.Errorf("'history.ConnectionId' field doesn't match the inserted value")
-	case history.StartDate != startDate[history_num]:
+	case history.MachineId != machineId:
+		t.Errorf("'history.MachineId' field doesn't match the inserted value")
+	case history.AppAlias != alias:
+		t.Errorf("'history.AppAlias' field doesn't match the inserted value")
+	case !history.StartDate.Equal(startDate):
 		t.Errorf("'history.StartDate' field doesn't match the inserted value")
-	case history.EndDate != endDate[history_num]:
+	case history.EndDate != nil:
+		t.Errorf("'history.EndDate' should be empty while the session is open")
+	}
+}
+
+func TestFindOpen(t *testing.T) {
+	open, err := FindOpen()
+	if err != nil {
+		t.Fatalf("Can't retrieve open histories: %s", err.Error())
+	}
+
+	for _, h := range open {
+		if h.Id == history.Id {
+			return
+		}
+	}
+	t.Errorf("History %s should be open", history.Id)
+}
+
+func TestEndHistory(t *testing.T) {
+	endDate := time.Now().Truncate(time.Second)
+
+	err := EndHistory(history.Id, endDate)
+	if err != nil {
+		t.Fatalf("Cannot end history: %s", err.Error())
+	}
+
+	h, err := GetHistory(history.Id)
+	if err != nil {
+		t.Fatalf("Can't retrieve history: %s", err.Error())
+	}
+
+	if h.EndDate == nil || !h.EndDate.Equal(endDate) {
 		t.Errorf("'history.EndDate' field doesn't match the inserted value")
 	}
-	history_num++
+
+	err = EndHistory(history.Id, endDate)
+	if err != HistoryNotFound {
+		t.Errorf("A closed history should not be closed again")
+	}
 }
 
 func TestFindAll(t *testing.T) {
-	var expected_num_rows int = history_num
+	before, err := FindAll()
+	if err != nil {
+		t.Fatalf("Can't retrieve histories: %s", err.Error())
+	}
 
-	startDate = append(startDate, time.Now().Format(time.RFC3339))
-	endDate = append(endDate, time.Now().Format(time.RFC3339))
-	_, err := CreateHistory(user.GetID(), user.Email, user.FirstName, user.LastName, connectionId, startDate[history_num], endDate[history_num])
+	_, err = CreateHistory(user.GetID(), user.Email, user.FirstName, user.LastName, connectionId, machineId, alias, time.Now())
 	if err != nil {
 		log.Panicln("Can't add historic:", err.Error())
 	}
 
-	if total-expected_num_rows+1 != 0 {
-		t.Errorf("Unexpected number of rows returned: Expected %d, have %d", expected_num_rows+1, total)
+	after, err := FindAll()
+	if err != nil {
+		t.Fatalf("Can't retrieve histories: %s", err.Error())
+	}
+
+	if len(after) != len(before)+1 {
+		t.Errorf("Unexpected number of rows returned: Expected %d, have %d", len(before)+1, len(after))
 	}
 
 	err = users.DeleteUser(user.GetID())
@@ -94,3 +130,27 @@ func TestFindAll(t *testing.T) {
 		t.Errorf("Can't delete user: %s\n", err.Error())
 	}
 }
+
+func TestAppAlias(t *testing.T) {
+	aliases := map[string]string{
+		`c:\windows\explorer.exe`:                  "Desktop",
+		`c:\program files\libreoffice\soffice.bin`: "LibreOffice",
+	}
+
+	tests := []struct {
+		programs []string
+		alias    string
+	}{
+		{[]string{}, ""},
+		{[]string{`C:\Windows\System32\rdpclip.exe`}, ""},
+		{[]string{`C:\Windows\explorer.exe`}, "Desktop"},
+		{[]string{`C:\Windows\explorer.exe`, `C:\Program Files\LibreOffice\soffice.bin`}, "LibreOffice"},
+	}
+
+	for _, test := range tests {
+		rt := appAlias(test.programs, aliases)
+		if rt != test.alias {
+			t.Errorf("appAlias(%v) = %q, expected %q", test.programs, rt, test.alias)
+		}
+	}
+}
@@ -1,14 +1,22 @@
 package histories
 
+import (
+	"time"
+)
+
+// A session of a user on one of the machines. EndDate is nil while the
+// session is running.
 type History struct {
-	Id            string `json:"-"`
-	UserId        string `json:"user-id"`
-	UserMail      string `json:"user-mail"`
-	UserFirstname string `json:"user-firstname"`
-	UserLastname  string `json:"user-lastname"`
-	ConnectionId  string `json:"connection-id"`
-	StartDate     string `json:"start-date"`
-	EndDate       string `json:"end-date"`
+	Id            string     `json:"-"`
+	UserId        string     `json:"user-id"`
+	UserMail      string     `json:"user-mail"`
+	UserFirstname string     `json:"user-firstname"`
+	UserLastname  string     `json:"user-lastname"`
+	ConnectionId  string     `json:"connection-id"`
+	MachineId     string     `json:"machine-id"`
+	AppAlias      string     `json:"app-alias"`
+	StartDate     time.Time  `json:"start-date"`
+	EndDate       *time.Time `json:"end-date"`
 }
 
 func (h *History) GetID() string {
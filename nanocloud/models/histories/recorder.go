@@ -0,0 +1,151 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package histories
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/sessions"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+)
+
+const desktopAlias = "Desktop"
+
+// Return the aliases of the applications indexed by the lower cased path of
+// their executable.
+func appAliases() (map[string]string, error) {
+	rows, err := db.Query(`SELECT alias, file_path FROM apps`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := make(map[string]string)
+	for rows.Next() {
+		var alias, path string
+
+		err = rows.Scan(&alias, &path)
+		if err != nil {
+			return nil, err
+		}
+		aliases[strings.ToLower(path)] = alias
+	}
+	return aliases, rows.Err()
+}
+
+// appAlias returns the alias of the application run in a session. The desktop
+// is only reported when no other application is running since the explorer
+// may be started along with the published applications.
+func appAlias(programs []string, aliases map[string]string) string {
+	rt := ""
+	for _, program := range programs {
+		alias, ok := aliases[strings.ToLower(program)]
+		if !ok {
+			continue
+		}
+
+		if alias != desktopAlias {
+			return alias
+		}
+		rt = alias
+	}
+	return rt
+}
+
+func sessionKey(machineId, connectionId, userId string) string {
+	return machineId + ":" + connectionId + ":" + userId
+}
+
+// Record updates the histories from the sessions opened on the machines.
+// Active sessions without history are opened and open histories whose
+// session is gone or disconnected are closed. The histories of the machines
+// that could not be reached are left untouched.
+func Record(list []*sessions.MachineSession, reached []string, now time.Time) error {
+	open, err := FindOpen()
+	if err != nil {
+		return err
+	}
+
+	aliases, err := appAliases()
+	if err != nil {
+		return err
+	}
+
+	active := make(map[string]*sessions.MachineSession)
+	for _, s := range list {
+		if s.UserId != "" && s.State == "active" {
+			active[sessionKey(s.MachineId, s.SessionId, s.UserId)] = s
+		}
+	}
+
+	isReached := make(map[string]bool)
+	for _, id := range reached {
+		isReached[id] = true
+	}
+
+	for _, h := range open {
+		key := sessionKey(h.MachineId, h.ConnectionId, h.UserId)
+		if _, ok := active[key]; ok {
+			delete(active, key)
+			continue
+		}
+
+		if !isReached[h.MachineId] {
+			continue
+		}
+
+		err = EndHistory(h.Id, now)
+		if err != nil {
+			log.Errorf("Unable to close history %s: %s", h.Id, err)
+		}
+	}
+
+	for _, s := range active {
+		user, err := users.GetUser(s.UserId)
+		if err != nil {
+			log.Errorf("Unable to get user %s: %s", s.UserId, err)
+			continue
+		}
+		if user == nil {
+			continue
+		}
+
+		_, err = CreateHistory(
+			user.GetID(),
+			user.Email,
+			user.FirstName,
+			user.LastName,
+			s.SessionId,
+			s.MachineId,
+			appAlias(s.Programs, aliases),
+			now,
+		)
+		if err != nil {
+			log.Errorf("Unable to record session %s: %s", s.Id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package histories
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/query"
+)
+
+var InvalidGroup = errors.New("invalid group")
+
+// Columns the histories can be filtered and sorted by.
+var Columns = query.Columns{
+	"user-id":        "userid",
+	"user-mail":      "usermail",
+	"user-firstname": "userfirstname",
+	"user-lastname":  "userlastname",
+	"connection-id":  "connectionid",
+	"machine-id":     "machine_id",
+	"app-alias":      "app_alias",
+	"start-date":     "startdate",
+	"end-date":       "enddate",
+}
+
+// Select the sessions running during a period. Zero dates are unbounded.
+type Filter struct {
+	From time.Time
+	To   time.Time
+}
+
+// Find returns the page of histories selected by q (the most recent first
+// by default) that match the filter, and the number of matching histories.
+func Find(filter Filter, q *query.Query) ([]*History, int, error) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("(enddate IS NULL OR enddate > $%d)", len(args)))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("startdate < $%d", len(args)))
+	}
+
+	where, args := q.Where(Columns, conditions, args)
+
+	total, err := db.Count(`SELECT count(*) FROM histories`+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	histories, err := findWhere(where+q.OrderBy(Columns, "startdate DESC")+q.Limit(), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return histories, total, nil
+}
+
+// Fields the usage can be grouped by.
+const (
+	ByUser    = "user"
+	ByApp     = "app"
+	ByMachine = "machine"
+	ByDay     = "day"
+)
+
+// Connection time of a group of histories.
+type Usage struct {
+	Id        string `json:"-"`
+	UserId    string `json:"user-id,omitempty"`
+	UserMail  string `json:"user-mail,omitempty"`
+	AppAlias  string `json:"app-alias,omitempty"`
+	MachineId string `json:"machine-id,omitempty"`
+	Day       string `json:"day,omitempty"`
+	Sessions  int    `json:"sessions"`
+	Duration  int64  `json:"duration"`
+}
+
+func (u *Usage) GetID() string {
+	return u.Id
+}
+
+func (u *Usage) SetID(id string) error {
+	u.Id = id
+	return nil
+}
+
+// The largest number of sessions running at the same time during a day.
+type Peak struct {
+	Id       string    `json:"-"`
+	Day      string    `json:"day"`
+	Sessions int       `json:"sessions"`
+	At       time.Time `json:"at"`
+}
+
+func (p *Peak) GetID() string {
+	return p.Id
+}
+
+func (p *Peak) SetID(id string) error {
+	p.Id = id
+	return nil
+}
+
+const dayFormat = "2006-01-02"
+
+func ValidateGroups(groups []string) error {
+	for _, group := range groups {
+		switch group {
+		case ByUser, ByApp, ByMachine, ByDay:
+		default:
+			return InvalidGroup
+		}
+	}
+	return nil
+}
+
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// Return the part of the session that happened between from and to. The
+// sessions still running end at to.
+func clip(h *History, from, to time.Time) (time.Time, time.Time, bool) {
+	start := h.StartDate
+	if start.Before(from) {
+		start = from
+	}
+
+	end := to
+	if h.EndDate != nil && h.EndDate.Before(to) {
+		end = *h.EndDate
+	}
+	return start, end, end.After(start)
+}
+
+// Aggregate sums the connection time of the histories between from and to
+// for each group. Sessions running over several days are split at midnight
+// in loc when grouping by day. Durations are in seconds.
+func Aggregate(histories []*History, groups []string, from, to time.Time, loc *time.Location) []*Usage {
+	byDay := false
+	for _, group := range groups {
+		if group == ByDay {
+			byDay = true
+		}
+	}
+
+	usages := make(map[string]*Usage)
+	rt := make([]*Usage, 0)
+
+	add := func(h *History, day string, duration time.Duration) {
+		u := Usage{Day: day}
+		key := make([]string, 0, len(groups))
+		for _, group := range groups {
+			switch group {
+			case ByUser:
+				u.UserId = h.UserId
+				u.UserMail = h.UserMail
+				key = append(key, h.UserId)
+			case ByApp:
+				u.AppAlias = h.AppAlias
+				key = append(key, h.AppAlias)
+			case ByMachine:
+				u.MachineId = h.MachineId
+				key = append(key, h.MachineId)
+			case ByDay:
+				key = append(key, day)
+			}
+		}
+
+		id := strings.Join(key, ":")
+		if id == "" {
+			id = "total"
+		}
+
+		usage, ok := usages[id]
+		if !ok {
+			usage = &u
+			usage.Id = id
+			usages[id] = usage
+			rt = append(rt, usage)
+		}
+		usage.Sessions++
+		usage.Duration += int64(duration / time.Second)
+	}
+
+	for _, h := range histories {
+		start, end, ok := clip(h, from, to)
+		if !ok {
+			continue
+		}
+
+		if !byDay {
+			add(h, "", end.Sub(start))
+			continue
+		}
+
+		for day := startOfDay(start, loc); day.Before(end); day = day.AddDate(0, 0, 1) {
+			s, e := day, day.AddDate(0, 0, 1)
+			if s.Before(start) {
+				s = start
+			}
+			if e.After(end) {
+				e = end
+			}
+			add(h, day.Format(dayFormat), e.Sub(s))
+		}
+	}
+
+	sort.Sort(usagesById(rt))
+	return rt
+}
+
+type usagesById []*Usage
+
+func (u usagesById) Len() int           { return len(u) }
+func (u usagesById) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+func (u usagesById) Less(i, j int) bool { return u[i].Id < u[j].Id }
+
+type event struct {
+	at    time.Time
+	delta int
+}
+
+type eventsByTime []event
+
+func (e eventsByTime) Len() int      { return len(e) }
+func (e eventsByTime) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+
+// A session ending when an other one starts does not overlap it.
+func (e eventsByTime) Less(i, j int) bool {
+	if e[i].at.Equal(e[j].at) {
+		return e[i].delta < e[j].delta
+	}
+	return e[i].at.Before(e[j].at)
+}
+
+// Peaks returns the largest number of concurrent sessions of each day
+// (midnight in loc) between from and to.
+func Peaks(histories []*History, from, to time.Time, loc *time.Location) []*Peak {
+	events := make([]event, 0, 2*len(histories))
+	for _, h := range histories {
+		start, end, ok := clip(h, from, to)
+		if !ok {
+			continue
+		}
+		events = append(events, event{start, 1}, event{end, -1})
+	}
+	sort.Sort(eventsByTime(events))
+
+	rt := make([]*Peak, 0)
+	count := 0
+	i := 0
+	for day := startOfDay(from, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+
+		at := day
+		if at.Before(from) {
+			at = from
+		}
+		for i < len(events) && !events[i].at.After(at) {
+			count += events[i].delta
+			i++
+		}
+
+		peak := &Peak{
+			Id:       day.Format(dayFormat),
+			Day:      day.Format(dayFormat),
+			Sessions: count,
+			At:       at,
+		}
+		for i < len(events) && events[i].at.Before(next) {
+			count += events[i].delta
+			if count > peak.Sessions {
+				peak.Sessions = count
+				peak.At = events[i].at
+			}
+			i++
+		}
+		rt = append(rt, peak)
+	}
+	return rt
+}
@@ -0,0 +1,100 @@
+package histories
+
+import (
+	"testing"
+	"time"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2016, 6, 1, hour, minute, 0, 0, time.UTC)
+}
+
+func session(userId, alias string, start time.Time, end *time.Time) *History {
+	return &History{
+		UserId:    userId,
+		AppAlias:  alias,
+		StartDate: start,
+		EndDate:   end,
+	}
+}
+
+func ptr(t time.Time) *time.Time {
+	return &t
+}
+
+var reportHistories = []*History{
+	session("alice", "Desktop", at(9, 0), ptr(at(10, 0))),
+	session("alice", "LibreOffice", at(9, 30), ptr(at(11, 0))),
+	session("bob", "Desktop", at(10, 0), ptr(at(12, 0))),
+	// Runs over midnight and is still open.
+	session("bob", "LibreOffice", at(23, 0), nil),
+}
+
+func TestAggregateByUser(t *testing.T) {
+	from := at(0, 0)
+	to := from.AddDate(0, 0, 2)
+
+	usages := Aggregate(reportHistories, []string{ByUser}, from, to, time.UTC)
+	if len(usages) != 2 {
+		t.Fatalf("Expected 2 groups, have %d", len(usages))
+	}
+
+	alice, bob := usages[0], usages[1]
+	if alice.UserId != "alice" || alice.Sessions != 2 || alice.Duration != 150*60 {
+		t.Errorf("Unexpected usage for alice: %+v", alice)
+	}
+	// The open session ends at the end of the period.
+	if bob.UserId != "bob" || bob.Sessions != 2 || bob.Duration != (2+25)*3600 {
+		t.Errorf("Unexpected usage for bob: %+v", bob)
+	}
+}
+
+func TestAggregateByDay(t *testing.T) {
+	from := at(0, 0)
+	to := from.AddDate(0, 0, 2)
+
+	usages := Aggregate(reportHistories, []string{ByApp, ByDay}, from, to, time.UTC)
+
+	expected := map[string]int64{
+		"Desktop:2016-06-01":     3 * 3600,
+		"LibreOffice:2016-06-01": 150 * 60,
+		"LibreOffice:2016-06-02": 24 * 3600,
+	}
+	if len(usages) != len(expected) {
+		t.Fatalf("Expected %d groups, have %d", len(expected), len(usages))
+	}
+	for _, u := range usages {
+		if u.Duration != expected[u.Id] {
+			t.Errorf("%s: expected %d seconds, have %d", u.Id, expected[u.Id], u.Duration)
+		}
+	}
+}
+
+func TestAggregatePeriod(t *testing.T) {
+	usages := Aggregate(reportHistories, nil, at(9, 45), at(10, 15), time.UTC)
+	if len(usages) != 1 || usages[0].Id != "total" {
+		t.Fatalf("Expected a single total, have %+v", usages)
+	}
+	if usages[0].Sessions != 3 || usages[0].Duration != (15+30+15)*60 {
+		t.Errorf("Unexpected total: %+v", usages[0])
+	}
+}
+
+func TestPeaks(t *testing.T) {
+	from := at(0, 0)
+	to := from.AddDate(0, 0, 2)
+
+	peaks := Peaks(reportHistories, from, to, time.UTC)
+	if len(peaks) != 2 {
+		t.Fatalf("Expected 2 days, have %d", len(peaks))
+	}
+
+	// The first session ends when bob's starts.
+	if peaks[0].Day != "2016-06-01" || peaks[0].Sessions != 2 || !peaks[0].At.Equal(at(9, 30)) {
+		t.Errorf("Unexpected peak: %+v", peaks[0])
+	}
+	// The session opened the day before is still running.
+	if peaks[1].Day != "2016-06-02" || peaks[1].Sessions != 1 || !peaks[1].At.Equal(to.AddDate(0, 0, -1)) {
+		t.Errorf("Unexpected peak: %+v", peaks[1])
+	}
+}
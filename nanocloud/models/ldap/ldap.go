@@ -23,13 +23,13 @@ package ldap
 import (
 	"crypto/tls"
 	"errors"
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
 	"gopkg.in/ldap.v2"
 	"net/url"
 	"strconv"
 	"strings"
-	"unicode"
 	"unicode/utf16"
 )
 
@@ -82,40 +82,6 @@ func DialandBind() (*ldap.Conn, error) {
 	return ldapConnection, nil
 }
 
-func test_password(pass string) bool {
-	// Windows AD password needs at leat 7 characters password,  and must contain characters from three of the following five categories:
-	// uppercase character
-	// lowercase character
-	// digit character
-	// nonalphanumeric characters
-	// any Unicode character that is categorized as an alphabetic character but is not uppercase or lowercase
-	if len(pass) < 7 {
-		return false
-	}
-	d := 0
-	l := 0
-	u := 0
-	p := 0
-	o := 0
-	for _, c := range pass {
-		if unicode.IsDigit(c) { // check digit character
-			d = 1
-		} else if unicode.IsLower(c) { // check lowercase character
-			l = 1
-		} else if unicode.IsUpper(c) { // check uppercase character
-			u = 1
-		} else if unicode.IsPunct(c) { // check nonalphanumeric character
-			p = 1
-		} else { // other unicode character
-			o = 1
-		}
-	}
-	if d+l+u+p+o < 3 {
-		return false
-	}
-	return true
-}
-
 func encodePassword(pass string) []byte {
 	s := pass
 	// Windows AD needs a UTF16-LE encoded password, with double quotes at the beginning and at the end
@@ -143,7 +109,7 @@ func AddUser(id, password string) (string, error) {
 	defer ldapConnection.Close()
 
 	var sam string
-	if !test_password(password) {
+	if passwords.WindowsPolicy().Check(password) != nil {
 		return "", WeakPassword
 	}
 
@@ -182,6 +148,16 @@ func AddUser(id, password string) (string, error) {
 	return sam, nil
 }
 
+// CreateAccount adds the Windows account of the user with a generated
+// password and returns its sAMAccountName.
+func CreateAccount(id string) (string, error) {
+	password, err := passwords.Generate(passwords.WindowsPolicy())
+	if err != nil {
+		return "", err
+	}
+	return AddUser(id, password)
+}
+
 func GetUsers() (Res, error) {
 	ldapConnection, err := DialandBind()
 	if err != nil {
@@ -224,6 +200,10 @@ func GetUsers() (Res, error) {
 }
 
 func ChangePassword(id, password string) error {
+	if passwords.WindowsPolicy().Check(password) != nil {
+		return WeakPassword
+	}
+
 	ldapConnection, err := DialandBind()
 	if err != nil {
 		log.Error("Error while connection to Active Directory: " + err.Error())
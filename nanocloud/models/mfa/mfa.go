@@ -0,0 +1,355 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package mfa manages the second authentication factor of the users: a
+// TOTP authenticator app and single-use recovery codes.
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Name of the account in the authenticator apps.
+const issuer = "Nanocloud"
+
+// Number of recovery codes generated at once.
+const recoveryCodes = 10
+
+var (
+	NotEnrolled    = errors.New("two-factor authentication not enrolled")
+	AlreadyEnabled = errors.New("two-factor authentication already enabled")
+	InvalidCode    = errors.New("invalid authentication code")
+)
+
+// Status of the second factor of a user.
+type Status struct {
+	Enabled       bool `json:"enabled"`
+	Required      bool `json:"required"`
+	RecoveryCodes int  `json:"recovery-codes"`
+}
+
+// Enrollment is returned when a user starts the enrollment: the secret to
+// type or scan in the authenticator app and the recovery codes, shown
+// only once.
+type Enrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery-codes"`
+}
+
+func GetStatus(userId string) (*Status, error) {
+	rows, err := db.Query(
+		`SELECT enabled, required,
+			(SELECT count(*) FROM users_recovery_codes
+			WHERE user_id = $1::varchar AND used_at IS NULL)
+		FROM users_mfa
+		WHERE user_id = $1::varchar`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var status Status
+	if rows.Next() {
+		err = rows.Scan(&status.Enabled, &status.Required, &status.RecoveryCodes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &status, rows.Err()
+}
+
+// IsEnabled tells whether the user must give a code to log in.
+func IsEnabled(userId string) (bool, error) {
+	status, err := GetStatus(userId)
+	if err != nil {
+		return false, err
+	}
+	return status.Enabled, nil
+}
+
+// SetRequired forces the user to enroll before getting an access token.
+func SetRequired(userId string, required bool) error {
+	_, err := db.Exec(
+		`INSERT INTO users_mfa (user_id, required)
+		VALUES ($1::varchar, $2::boolean)
+		ON CONFLICT (user_id) DO UPDATE SET required = excluded.required`,
+		userId, required,
+	)
+	return err
+}
+
+func hashCode(code string) string {
+	code = strings.ToUpper(strings.Replace(strings.Replace(code, "-", "", -1), " ", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateRecoveryCodes replaces the recovery codes of the user.
+func generateRecoveryCodes(tx *sql.Tx, userId string) ([]string, error) {
+	_, err := tx.Exec(`DELETE FROM users_recovery_codes WHERE user_id = $1::varchar`, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodes)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err = rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		code := base32.StdEncoding.EncodeToString(b)
+		codes[i] = code[:4] + "-" + code[4:]
+
+		_, err = tx.Exec(
+			`INSERT INTO users_recovery_codes (id, user_id, code_hash)
+			VALUES ($1::varchar, $2::varchar, $3::varchar)`,
+			uuid.NewV4().String(), userId, hashCode(code),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// Enroll generates a new secret and new recovery codes for the user. They
+// are used once the enrollment is confirmed with a code of the app.
+func Enroll(user *users.User) (*Enrollment, error) {
+	enabled, err := IsEnabled(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, AlreadyEnabled
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO users_mfa (user_id, secret)
+		VALUES ($1::varchar, $2::varchar)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = excluded.secret, enabled = false, last_step = 0`,
+		user.Id, secret,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	codes, err := generateRecoveryCodes(tx, user.Id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Enrollment{
+		Secret:        secret,
+		URI:           provisioningURI(secret, user.Email),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// checkTOTP validates a code of the app and records its time step.
+func checkTOTP(userId, code string) error {
+	rows, err := db.Query(
+		`SELECT secret, last_step FROM users_mfa WHERE user_id = $1::varchar`,
+		userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	var secret string
+	var lastStep int64
+	if rows.Next() {
+		err = rows.Scan(&secret, &lastStep)
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return NotEnrolled
+	}
+
+	step, ok := validateCode(secret, code, time.Now(), lastStep)
+	if !ok {
+		return InvalidCode
+	}
+
+	// Concurrent uses of the same code: only one updates the step
+	res, err := db.Exec(
+		`UPDATE users_mfa SET last_step = $2::bigint
+		WHERE user_id = $1::varchar AND last_step < $2::bigint`,
+		userId, step,
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return InvalidCode
+	}
+	return nil
+}
+
+// Confirm enables the second factor once the user proved the app is set up.
+func Confirm(userId, code string) error {
+	status, err := GetStatus(userId)
+	if err != nil {
+		return err
+	}
+	if status.Enabled {
+		return AlreadyEnabled
+	}
+
+	err = checkTOTP(userId, code)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`UPDATE users_mfa SET enabled = true, enabled_at = NOW()
+		WHERE user_id = $1::varchar`,
+		userId,
+	)
+	return err
+}
+
+// Verify checks a code of the app or a recovery code. A recovery code can
+// only be used once.
+func Verify(userId, code string) error {
+	enabled, err := IsEnabled(userId)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return NotEnrolled
+	}
+
+	err = checkTOTP(userId, code)
+	if err != InvalidCode {
+		return err
+	}
+
+	res, err := db.Exec(
+		`UPDATE users_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1::varchar AND code_hash = $2::varchar AND used_at IS NULL`,
+		userId, hashCode(code),
+	)
+	if err != nil {
+		return err
+	}
+
+	used, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if used == 0 {
+		return InvalidCode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes invalidates the recovery codes of the user and
+// returns new ones.
+func RegenerateRecoveryCodes(userId string) ([]string, error) {
+	enabled, err := IsEnabled(userId)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, NotEnrolled
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := generateRecoveryCodes(tx, userId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Reset removes the second factor of the user. Whether it is required is
+// kept: such a user enrolls again at the next login.
+func Reset(userId string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE users_mfa SET secret = '', enabled = false, last_step = 0, enabled_at = NULL
+		WHERE user_id = $1::varchar`,
+		userId,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM users_recovery_codes WHERE user_id = $1::varchar`, userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
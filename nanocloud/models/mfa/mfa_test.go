@@ -0,0 +1,144 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+var user = &users.User{}
+
+func init() {
+	new_user, err := users.CreateUser(
+		true,
+		"mfa@nanocloud.com",
+		"Test",
+		"mfa",
+		"secret",
+		false,
+	)
+
+	if err != nil {
+		log.Panicln("Can't create new account:", err.Error())
+	}
+	if new_user == nil {
+		log.Panicln("Can't create new account")
+	}
+	user = new_user
+}
+
+// currentCode returns the code the authenticator app shows at the given
+// step offset.
+func currentCode(t *testing.T, secret string, offset int64) string {
+	key, err := base32.StdEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("Invalid secret: %s", err.Error())
+	}
+	return hotp(key, uint64(time.Now().Unix()/period+offset), digits)
+}
+
+func TestEnrollment(t *testing.T) {
+	err := Verify(user.Id, "000000")
+	if err != NotEnrolled {
+		t.Fatalf("Verify should fail before the enrollment, got %v", err)
+	}
+
+	enrollment, err := Enroll(user)
+	if err != nil {
+		t.Fatalf("Cannot enroll: %s", err.Error())
+	}
+	if len(enrollment.RecoveryCodes) != recoveryCodes {
+		t.Errorf("Expected %d recovery codes, got %d", recoveryCodes, len(enrollment.RecoveryCodes))
+	}
+
+	enabled, err := IsEnabled(user.Id)
+	if err != nil {
+		t.Fatalf("Cannot get the status: %s", err.Error())
+	}
+	if enabled {
+		t.Errorf("The second factor should not be enabled before the confirmation")
+	}
+
+	err = Confirm(user.Id, "000000")
+	if err != InvalidCode {
+		t.Errorf("Confirm should reject an invalid code, got %v", err)
+	}
+
+	code := currentCode(t, enrollment.Secret, 0)
+	err = Confirm(user.Id, code)
+	if err != nil {
+		t.Fatalf("Cannot confirm the enrollment: %s", err.Error())
+	}
+
+	_, err = Enroll(user)
+	if err != AlreadyEnabled {
+		t.Errorf("Enroll should fail once enabled, got %v", err)
+	}
+
+	err = Verify(user.Id, code)
+	if err != InvalidCode {
+		t.Errorf("Verify should reject a used code, got %v", err)
+	}
+
+	// The next code is accepted to allow for clock drift
+	err = Verify(user.Id, currentCode(t, enrollment.Secret, 1))
+	if err != nil {
+		t.Errorf("Cannot verify a code: %s", err.Error())
+	}
+
+	err = Verify(user.Id, enrollment.RecoveryCodes[0])
+	if err != nil {
+		t.Errorf("Cannot verify a recovery code: %s", err.Error())
+	}
+
+	err = Verify(user.Id, enrollment.RecoveryCodes[0])
+	if err != InvalidCode {
+		t.Errorf("A recovery code should only be used once, got %v", err)
+	}
+
+	status, err := GetStatus(user.Id)
+	if err != nil {
+		t.Fatalf("Cannot get the status: %s", err.Error())
+	}
+	if !status.Enabled || status.RecoveryCodes != recoveryCodes-1 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	codes, err := RegenerateRecoveryCodes(user.Id)
+	if err != nil {
+		t.Fatalf("Cannot regenerate the recovery codes: %s", err.Error())
+	}
+
+	err = Verify(user.Id, enrollment.RecoveryCodes[1])
+	if err != InvalidCode {
+		t.Errorf("The old recovery codes should be invalid, got %v", err)
+	}
+
+	err = Verify(user.Id, codes[0])
+	if err != nil {
+		t.Errorf("Cannot verify a new recovery code: %s", err.Error())
+	}
+}
+
+func TestReset(t *testing.T) {
+	err := SetRequired(user.Id, true)
+	if err != nil {
+		t.Fatalf("Cannot require the second factor: %s", err.Error())
+	}
+
+	err = Reset(user.Id)
+	if err != nil {
+		t.Fatalf("Cannot reset the second factor: %s", err.Error())
+	}
+
+	status, err := GetStatus(user.Id)
+	if err != nil {
+		t.Fatalf("Cannot get the status: %s", err.Error())
+	}
+	if status.Enabled || !status.Required || status.RecoveryCodes != 0 {
+		t.Errorf("Unexpected status after a reset: %+v", status)
+	}
+}
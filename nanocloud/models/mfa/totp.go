@@ -0,0 +1,116 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mfa
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238), the defaults of the authenticator apps.
+const (
+	period = 30
+	digits = 6
+
+	// Number of time steps accepted before and after the current one, to
+	// tolerate clock drifts.
+	window = 1
+)
+
+// generateSecret returns a random 160 bits secret encoded in base32.
+func generateSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b), nil
+}
+
+// hotp computes the HOTP code (RFC 4226) of the counter.
+func hotp(key []byte, counter uint64, length int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < length; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", length, value%mod)
+}
+
+// validateCode checks the code against the secret at the time t and returns
+// the time step it matches. Only steps after lastStep are accepted so that
+// a code cannot be replayed.
+func validateCode(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	key, err := base32.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != digits {
+		return 0, false
+	}
+
+	current := t.Unix() / period
+	for step := current - window; step <= current+window; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected := hotp(key, uint64(step), digits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// provisioningURI returns the otpauth URI to show as a QR code to the
+// authenticator apps.
+func provisioningURI(secret, account string) string {
+	v := url.Values{}
+	v.Set("secret", strings.TrimRight(secret, "="))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(digits))
+	v.Set("period", fmt.Sprint(period))
+
+	label := url.QueryEscape(issuer + ":" + account)
+	return "otpauth://totp/" + strings.Replace(label, "+", "%20", -1) + "?" + v.Encode()
+}
@@ -0,0 +1,87 @@
+package mfa
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test vectors of RFC 6238 for SHA-1.
+func TestHOTPVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	vectors := []struct {
+		time int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, v := range vectors {
+		code := hotp(key, uint64(v.time/period), 8)
+		if code != v.code {
+			t.Errorf("TOTP(%d) = %s, want %s", v.time, code, v.code)
+		}
+	}
+}
+
+func TestValidateCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	key, _ := base32.StdEncoding.DecodeString(secret)
+	step := now.Unix() / period
+
+	current := hotp(key, uint64(step), digits)
+	s, ok := validateCode(secret, current, now, 0)
+	if !ok || s != step {
+		t.Fatalf("current code refused")
+	}
+
+	// Codes of the previous and next steps are accepted
+	if _, ok = validateCode(secret, hotp(key, uint64(step-1), digits), now, 0); !ok {
+		t.Error("previous code refused")
+	}
+	if _, ok = validateCode(secret, hotp(key, uint64(step+1), digits), now, 0); !ok {
+		t.Error("next code refused")
+	}
+
+	if _, ok = validateCode(secret, hotp(key, uint64(step-2), digits), now, 0); ok {
+		t.Error("old code accepted")
+	}
+
+	// Replay of a code already used
+	if _, ok = validateCode(secret, current, now, step); ok {
+		t.Error("code replayed")
+	}
+
+	if _, ok = validateCode(secret, "000000", now, 0); ok && current != "000000" {
+		t.Error("wrong code accepted")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	secret, err := generateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri := provisioningURI(secret, "jane+test@example.com")
+	if !strings.HasPrefix(uri, "otpauth://totp/Nanocloud%3Ajane%2Btest%40example.com?") {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	if !strings.Contains(uri, "secret="+strings.TrimRight(secret, "=")) || !strings.Contains(uri, "issuer=Nanocloud") {
+		t.Errorf("unexpected URI %s", uri)
+	}
+}
+
+func TestHashCode(t *testing.T) {
+	if hashCode("abcd-efgh") != hashCode("ABCDEFGH") {
+		t.Error("recovery codes must be normalized")
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	uuid "github.com/satori/go.uuid"
+)
+
+// APIKeyPrefix starts the personal API keys, to tell them from the access
+// tokens issued by the OAuth endpoints.
+const APIKeyPrefix = "nck_"
+
+var (
+	APIKeyNotFound   = errors.New("API key not found")
+	InvalidKeyName   = errors.New("the name of the API key is required")
+	InvalidKeyExpiry = errors.New("the expiry of the API key must be in the future")
+)
+
+// APIKey is a long-lived access token created by a user for the scripts.
+// The key itself is only known when it is created.
+type APIKey struct {
+	Id         string
+	Name       string
+	Prefix     string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	Key        string
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateScopes checks that the scopes are known, and granted to the
+// token creating the key: a key cannot do more than its creator.
+func ValidateScopes(scopes, granted []string) error {
+	if len(scopes) == 0 {
+		return InvalidScopes
+	}
+
+	for _, scope := range scopes {
+		if !contains(Scopes, scope) || !contains(granted, scope) {
+			return InvalidScopes
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKey creates a key of the user. A nil expiry makes a key that
+// never expires.
+func CreateAPIKey(userId, name string, scopes []string, expiresAt *time.Time) (*APIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, InvalidKeyName
+	}
+
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, InvalidKeyExpiry
+	}
+
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{
+		Id:        uuid.NewV4().String(),
+		Name:      name,
+		Key:       APIKeyPrefix + hex.EncodeToString(b),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	key.Prefix = key.Key[:len(APIKeyPrefix)+8]
+
+	_, err = db.Exec(
+		`INSERT INTO api_keys
+		(id, user_id, name, prefix, token_hash, scope, created_at, expires_at)
+		VALUES
+		($1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::varchar,
+		 $6::varchar, $7::timestamp with time zone, $8::timestamp with time zone)`,
+		key.Id, userId, key.Name, key.Prefix, hashAPIKey(key.Key),
+		strings.Join(scopes, " "), key.CreatedAt, expiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// RevokeAPIKey deletes the key of the user identified by id.
+func RevokeAPIKey(userId, id string) error {
+	res, err := db.Exec(
+		`DELETE FROM api_keys
+		WHERE user_id = $1::varchar AND id = $2::varchar`,
+		userId, id,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return APIKeyNotFound
+	}
+	return nil
+}
+
+// RevokeAPIKeys deletes all the API keys of the user.
+func RevokeAPIKeys(userId string) error {
+	_, err := db.Exec(`DELETE FROM api_keys WHERE user_id = $1::varchar`, userId)
+	return err
+}
+
+// apiKeyUser returns the activated user of a key not expired, or nil. The
+// use of the key is recorded.
+func apiKeyUser(key string) (*users.User, error) {
+	rows, err := db.Query(
+		`UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE token_hash = $1::varchar
+		AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING user_id`,
+		hashAPIKey(key),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var userId string
+	if rows.Next() {
+		err = rows.Scan(&userId)
+	}
+	rows.Close()
+	if err != nil || userId == "" {
+		return nil, err
+	}
+
+	user, err := users.GetUser(userId)
+	if err != nil || user == nil || !user.Activated {
+		return nil, err
+	}
+	return user, nil
+}
+
+// apiKeyScopes returns the scopes of a key.
+func apiKeyScopes(key string) ([]string, error) {
+	rows, err := db.Query(
+		`SELECT scope FROM api_keys WHERE token_hash = $1::varchar`,
+		hashAPIKey(key),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scope string
+	if rows.Next() {
+		err = rows.Scan(&scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return strings.Fields(scope), rows.Err()
+}
@@ -0,0 +1,26 @@
+package oauth
+
+import "testing"
+
+func TestValidateScopes(t *testing.T) {
+	granted := []string{ProfileScope, AppsScope}
+
+	cases := []struct {
+		scopes []string
+		valid  bool
+	}{
+		{[]string{ProfileScope}, true},
+		{[]string{ProfileScope, AppsScope}, true},
+		{[]string{}, false},
+		{[]string{"unknown"}, false},
+		{[]string{AdminScope}, false},
+		{[]string{AppsScope, FilesScope}, false},
+	}
+
+	for _, c := range cases {
+		err := ValidateScopes(c.scopes, granted)
+		if (err == nil) != c.valid {
+			t.Errorf("ValidateScopes(%v): expected valid %v, got %v", c.scopes, c.valid, err)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oauth
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/query"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Scopes limit what the access tokens issued to third-party clients can do.
+const (
+	ProfileScope = "profile"
+	AppsScope    = "apps"
+	FilesScope   = "files"
+	AdminScope   = "admin"
+)
+
+var Scopes = []string{ProfileScope, AppsScope, FilesScope, AdminScope}
+
+// The scope of the tokens issued to the first-party clients.
+var FullScope = strings.Join(Scopes, " ")
+
+// The client used by the webapp, created by the migration.
+const webappClientKey = "9405fb6b0e59d2997e3c777a22d8f0e617a9f5b36b6565c7579e5be6deb8f7ae"
+
+var (
+	ClientNotFound      = errors.New("client not found")
+	ClientDuplicated    = errors.New("client duplicated")
+	ClientProtected     = errors.New("the webapp client cannot be deleted")
+	InvalidName         = errors.New("invalid client name")
+	InvalidRedirectURIs = errors.New("at least one absolute redirect URI without fragment is required")
+	InvalidScopes       = errors.New("at least one known scope is required")
+)
+
+type Client struct {
+	Id           int      `json:"-"`
+	Name         string   `json:"name"`
+	Key          string   `json:"key"`
+	Secret       string   `json:"secret,omitempty"`
+	RedirectURIs []string `json:"redirect-uris"`
+	Scopes       []string `json:"scopes"`
+	Confidential bool     `json:"confidential"`
+}
+
+func (c *Client) GetID() string {
+	return strconv.Itoa(c.Id)
+}
+
+func (c *Client) SetID(id string) error {
+	var err error
+	c.Id, err = strconv.Atoi(id)
+	return err
+}
+
+func (c *Client) ThirdParty() bool {
+	return len(c.RedirectURIs) > 0
+}
+
+func (c *Client) DisplayName() string {
+	return c.Name
+}
+
+func (c *Client) HasRedirectURI(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) AllowsScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) checkSecret(secret string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(c.Secret), []byte(secret)) == nil
+}
+
+// Columns the clients can be filtered and sorted by.
+var Columns = query.Columns{
+	"name":         "name",
+	"key":          "key",
+	"confidential": "confidential",
+}
+
+const clientColumns = `id, name, key, secret, redirect_uris, scopes, confidential`
+
+func scanClient(rows *sql.Rows) (*Client, error) {
+	var c Client
+	var redirectURIs, scopes string
+
+	err := rows.Scan(&c.Id, &c.Name, &c.Key, &c.Secret, &redirectURIs, &scopes, &c.Confidential)
+	if err != nil {
+		return nil, err
+	}
+
+	c.RedirectURIs = strings.Fields(redirectURIs)
+	c.Scopes = strings.Fields(scopes)
+	return &c, nil
+}
+
+// findClient returns the first client matching the condition, or nil. The
+// secret is kept to authenticate the client.
+func findClient(condition string, args ...interface{}) (*Client, error) {
+	rows, err := db.Query(`SELECT `+clientColumns+` FROM oauth_clients `+condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	return scanClient(rows)
+}
+
+// FindClients returns the page of clients selected by q and the number of
+// clients matching its filters. Secrets are not returned.
+func FindClients(q *query.Query) ([]*Client, int, error) {
+	where, args := q.Where(Columns, nil, nil)
+
+	total, err := db.Count(`SELECT count(*) FROM oauth_clients`+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(
+		`SELECT `+clientColumns+` FROM oauth_clients`+where+q.OrderBy(Columns, "name")+q.Limit(),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	clients := make([]*Client, 0)
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		c.Secret = ""
+		clients = append(clients, c)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, 0, err
+	}
+	return clients, total, nil
+}
+
+// GetClient returns the client, without its secret.
+func GetClient(id string) (*Client, error) {
+	clientId, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ClientNotFound
+	}
+
+	client, err := findClient(`WHERE id = $1::integer`, clientId)
+	if err != nil {
+		return nil, err
+	}
+
+	if client == nil {
+		return nil, ClientNotFound
+	}
+
+	client.Secret = ""
+	return client, nil
+}
+
+func validateClient(name string, redirectURIs, scopes []string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", InvalidName
+	}
+
+	if len(redirectURIs) == 0 {
+		return "", InvalidRedirectURIs
+	}
+
+	for _, uri := range redirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" || strings.ContainsAny(uri, " \t\n") {
+			return "", InvalidRedirectURIs
+		}
+	}
+
+	if len(scopes) == 0 {
+		return "", InvalidScopes
+	}
+
+	for _, scope := range scopes {
+		valid := false
+		for _, s := range Scopes {
+			if s == scope {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return "", InvalidScopes
+		}
+	}
+	return name, nil
+}
+
+func isDuplicate(err error) bool {
+	return err.Error() == "pq: duplicate key value violates unique constraint \"oauth_clients_name_key\""
+}
+
+// CreateClient registers a third-party client. Confidential clients get a
+// secret, returned in the Secret field only by this function. Public
+// clients (e.g. single-page or native applications) have none and rely on
+// PKCE.
+func CreateClient(name string, redirectURIs, scopes []string, confidential bool) (*Client, error) {
+	name, err := validateClient(name, redirectURIs, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	client := Client{
+		Name:         name,
+		Key:          utils.RandomString(64),
+		RedirectURIs: redirectURIs,
+		Scopes:       scopes,
+		Confidential: confidential,
+	}
+
+	var hash string
+	if confidential {
+		client.Secret = utils.RandomString(64)
+
+		b, err := bcrypt.GenerateFromPassword([]byte(client.Secret), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		hash = string(b)
+	}
+
+	rows, err := db.Query(
+		`INSERT INTO oauth_clients
+		(name, key, secret, redirect_uris, scopes, confidential)
+		VALUES
+		($1::varchar, $2::varchar, $3::varchar, $4::text, $5::text, $6::boolean)
+		RETURNING id`,
+		client.Name, client.Key, hash,
+		strings.Join(redirectURIs, " "), strings.Join(scopes, " "), confidential,
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return nil, ClientDuplicated
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err = rows.Scan(&client.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &client, rows.Err()
+}
+
+// Update renames the client and replaces its redirect URIs and scopes. The
+// tokens already issued keep their scope.
+func (c *Client) Update(name string, redirectURIs, scopes []string) error {
+	name, err := validateClient(name, redirectURIs, scopes)
+	if err != nil {
+		return err
+	}
+
+	res, err := db.Exec(
+		`UPDATE oauth_clients
+		SET name = $2::varchar, redirect_uris = $3::text, scopes = $4::text
+		WHERE id = $1::integer`,
+		c.Id, name, strings.Join(redirectURIs, " "), strings.Join(scopes, " "),
+	)
+	if err != nil {
+		if isDuplicate(err) {
+			return ClientDuplicated
+		}
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ClientNotFound
+	}
+
+	c.Name = name
+	c.RedirectURIs = redirectURIs
+	c.Scopes = scopes
+	return nil
+}
+
+// Delete the client along with the tokens and codes issued to it.
+func (c *Client) Delete() error {
+	if c.Key == webappClientKey {
+		return ClientProtected
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"oauth_access_tokens", "oauth_refresh_tokens", "oauth_authorization_codes"} {
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE oauth_client_id = $1::integer`, c.Id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	res, err := tx.Exec(`DELETE FROM oauth_clients WHERE id = $1::integer`, c.Id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if deleted == 0 {
+		tx.Rollback()
+		return ClientNotFound
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,170 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oauth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/oauth2"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Lifetime in seconds of the authorization codes.
+const authorizationCodeLifetime = 10 * 60
+
+func (c oauthConnector) CreateAuthorizationCode(rawClient, rawUser interface{}, grant oauth2.AuthorizationGrant) (string, error) {
+	client := rawClient.(*Client)
+	user := rawUser.(*users.User)
+
+	db.Exec(`DELETE FROM oauth_authorization_codes WHERE expires_at < NOW()`)
+
+	code := utils.RandomString(40)
+	_, err := db.Exec(
+		`INSERT INTO oauth_authorization_codes
+		(code, oauth_client_id, user_id, redirect_uri, scope,
+		 code_challenge, family_id, expires_at)
+		VALUES
+		($1::varchar, $2::integer, $3::varchar, $4::text, $5::varchar,
+		 $6::varchar, $7::varchar, NOW() + $8::integer * interval '1 second')`,
+		code, client.Id, user.Id, grant.RedirectURI, grant.Scope,
+		grant.CodeChallenge, uuid.NewV4().String(), authorizationCodeLifetime,
+	)
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// ExchangeAuthorizationCode issues the tokens for a code. A code can only be
+// used once: if it is presented again, the tokens it was exchanged for are
+// revoked.
+func (c oauthConnector) ExchangeAuthorizationCode(rawClient interface{}, code, redirectURI, codeVerifier string, req *http.Request) (interface{}, error) {
+	client := rawClient.(*Client)
+
+	rows, err := db.Query(
+		`SELECT user_id, redirect_uri, scope, code_challenge, family_id,
+		expires_at < NOW(), used
+		FROM oauth_authorization_codes
+		WHERE code = $1::varchar
+		AND oauth_client_id = $2::integer`,
+		code, client.Id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if !rows.Next() {
+		rows.Close()
+		return nil, oauth2.InvalidAuthorizationCode
+	}
+
+	var userId, uri, scope, challenge, familyId string
+	var expired, used bool
+	err = rows.Scan(&userId, &uri, &scope, &challenge, &familyId, &expired, &used)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if used {
+		log.Warnf("[OAuth] Authorization code reused, revoking the tokens of the user %s", userId)
+		err = revokeFamily(familyId)
+		if err != nil {
+			return nil, err
+		}
+		return nil, oauth2.InvalidAuthorizationCode
+	}
+
+	if expired || uri != redirectURI || !oauth2.VerifyCodeChallenge(codeVerifier, challenge) {
+		return nil, oauth2.InvalidAuthorizationCode
+	}
+
+	res, err := db.Exec(
+		`UPDATE oauth_authorization_codes
+		SET used = true
+		WHERE code = $1::varchar AND NOT used`,
+		code,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if updated == 0 {
+		return nil, oauth2.InvalidAuthorizationCode
+	}
+
+	user, err := users.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil || !user.Activated {
+		return nil, oauth2.InvalidAuthorizationCode
+	}
+
+	token, err := issueTokens(familyId, client.Id, user.Id, scope, req)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Scope = scope
+	return token, nil
+}
+
+// TokenScopes returns the scopes granted to the access token or to the API
+// key.
+func TokenScopes(accessToken string) ([]string, error) {
+	if strings.HasPrefix(accessToken, APIKeyPrefix) {
+		return apiKeyScopes(accessToken)
+	}
+
+	rows, err := db.Query(
+		`SELECT scope
+		FROM oauth_access_tokens
+		WHERE token = $1::varchar`,
+		accessToken,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scope string
+	if rows.Next() {
+		err = rows.Scan(&scope)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return strings.Fields(scope), rows.Err()
+}
@@ -0,0 +1,215 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/mfa"
+	"github.com/Nanocloud/community/nanocloud/models/throttle"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/oauth2"
+	"github.com/Nanocloud/community/nanocloud/utils"
+)
+
+// Lifetime in seconds of the second factor challenges.
+const mfaChallengeLifetime = 5 * 60
+
+// Number of codes that can be tried for a challenge.
+const mfaChallengeAttempts = 5
+
+// MFAChallenge returns a challenge token if the user must give a code of
+// the authenticator app, or enroll one, before getting an access token.
+func (c oauthConnector) MFAChallenge(rawClient, rawUser interface{}) (string, bool, error) {
+	client := rawClient.(*Client)
+	user := rawUser.(*users.User)
+
+	status, err := mfa.GetStatus(user.Id)
+	if err != nil {
+		return "", false, err
+	}
+
+	if !status.Enabled && !status.Required {
+		return "", false, nil
+	}
+
+	db.Exec(`DELETE FROM oauth_mfa_challenges WHERE expires_at < NOW()`)
+
+	token := utils.RandomString(40)
+	enroll := !status.Enabled
+	_, err = db.Exec(
+		`INSERT INTO oauth_mfa_challenges
+		(token, oauth_client_id, user_id, enroll, expires_at)
+		VALUES
+		($1::varchar, $2::integer, $3::varchar, $4::boolean,
+		 NOW() + $5::integer * interval '1 second')`,
+		token, client.Id, user.Id, enroll, mfaChallengeLifetime,
+	)
+	if err != nil {
+		return "", false, err
+	}
+	return token, enroll, nil
+}
+
+// WebappMFAChallenge is MFAChallenge for the webapp client, for the users
+// authenticated outside of the OAuth endpoints like the single sign-on.
+func WebappMFAChallenge(user *users.User) (string, bool, error) {
+	client, err := findClient(`WHERE key = $1::varchar`, webappClientKey)
+	if err != nil {
+		return "", false, err
+	}
+	if client == nil {
+		return "", false, ClientNotFound
+	}
+
+	return oauthConnector{}.MFAChallenge(client, user)
+}
+
+// getChallenge returns the user and the kind of a pending challenge of the
+// client.
+func getChallenge(client *Client, token string) (*users.User, bool, error) {
+	rows, err := db.Query(
+		`SELECT user_id, enroll
+		FROM oauth_mfa_challenges
+		WHERE token = $1::varchar
+		AND oauth_client_id = $2::integer
+		AND attempts < $3::integer
+		AND expires_at > NOW()`,
+		token, client.Id, mfaChallengeAttempts,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !rows.Next() {
+		rows.Close()
+		return nil, false, oauth2.InvalidMFAToken
+	}
+
+	var userId string
+	var enroll bool
+	err = rows.Scan(&userId, &enroll)
+	rows.Close()
+	if err != nil {
+		return nil, false, err
+	}
+
+	user, err := users.GetUser(userId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if user == nil || !user.Activated {
+		return nil, false, oauth2.InvalidMFAToken
+	}
+	return user, enroll, nil
+}
+
+// EnrollMFA starts the enrollment of a user who must set up a second
+// factor before logging in.
+func (c oauthConnector) EnrollMFA(rawClient interface{}, token string) (interface{}, error) {
+	user, enroll, err := getChallenge(rawClient.(*Client), token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !enroll {
+		return nil, oauth2.InvalidMFAToken
+	}
+
+	enrollment, err := mfa.Enroll(user)
+	if err == mfa.AlreadyEnabled {
+		return nil, oauth2.InvalidMFAToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return enrollment, nil
+}
+
+// VerifyMFA checks the code given for a challenge and returns its user. A
+// challenge is consumed by a valid code or after too many invalid ones.
+// Codes are throttled like the passwords: an invalid one is a failed login
+// of the account and a locked account cannot complete its challenges.
+func (c oauthConnector) VerifyMFA(rawClient interface{}, token, code string, req *http.Request) (interface{}, error) {
+	user, enroll, err := getChallenge(rawClient.(*Client), token)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := utils.ClientIP(req)
+	wait, err := throttle.Check(user.Email, ip)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		return nil, oauth2.InvalidMFAToken
+	}
+
+	if enroll {
+		err = mfa.Confirm(user.Id, code)
+	} else {
+		err = mfa.Verify(user.Id, code)
+	}
+
+	if err == mfa.InvalidCode || err == mfa.NotEnrolled {
+		_, err = db.Exec(
+			`UPDATE oauth_mfa_challenges SET attempts = attempts + 1
+			WHERE token = $1::varchar`,
+			token,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = throttle.Record(user.Email, ip, req.UserAgent(), false)
+		if err != nil {
+			return nil, err
+		}
+		return nil, oauth2.InvalidMFACode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := db.Exec(`DELETE FROM oauth_mfa_challenges WHERE token = $1::varchar`, token)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if deleted == 0 {
+		return nil, oauth2.InvalidMFAToken
+	}
+
+	err = throttle.Record(user.Email, ip, req.UserAgent(), true)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -23,43 +23,89 @@
 package oauth
 
 import (
+	"errors"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Nanocloud/community/nanocloud/config"
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/metrics"
+	"github.com/Nanocloud/community/nanocloud/models/auth"
+	"github.com/Nanocloud/community/nanocloud/models/throttle"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/oauth2"
 	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
 	uuid "github.com/satori/go.uuid"
 )
 
+var TokenNotFound = errors.New("token not found")
+
+// Default lifetimes of the tokens, in seconds.
+const (
+	defaultAccessTokenLifetime  = 60 * 60
+	defaultRefreshTokenLifetime = 60 * 60 * 24 * 30
+)
+
 type oauthConnector struct{}
 
-type Client struct {
-	Id   int
-	Name string
-	Key  string
+type AccessToken struct {
+	Token        string        `json:"access_token"`
+	Type         string        `json:"token_type"`
+	ExpiresIn    time.Duration `json:"expires_in"`
+	RefreshToken string        `json:"refresh_token,omitempty"`
+	Scope        string        `json:"scope,omitempty"`
 }
 
-type AccessToken struct {
-	Token     string        `json:"access_token"`
-	Type      string        `json:"token_type"`
-	ExpiresIn time.Duration `json:"expires_in"`
+func parseLifetime(value string, def int) int {
+	lifetime, err := strconv.Atoi(value)
+	if err != nil || lifetime <= 0 {
+		return def
+	}
+	return lifetime
+}
+
+// tokenLifetimes returns the lifetimes in seconds of the access and refresh
+// tokens, set by the oauth_access_token_lifetime and
+// oauth_refresh_token_lifetime configuration keys.
+func tokenLifetimes() (int, int) {
+	values := config.Get("oauth_access_token_lifetime", "oauth_refresh_token_lifetime")
+
+	access := parseLifetime(values["oauth_access_token_lifetime"], defaultAccessTokenLifetime)
+	refresh := parseLifetime(values["oauth_refresh_token_lifetime"], defaultRefreshTokenLifetime)
+	return access, refresh
 }
 
 func (c oauthConnector) AuthenticateUser(username, password string) (interface{}, error) {
-	return users.GetUserFromEmailPassword(username, password)
+	return auth.Authenticate(username, password)
+}
+
+func (c oauthConnector) CheckLogin(username string, req *http.Request) (time.Duration, error) {
+	return throttle.Check(username, utils.ClientIP(req))
+}
+
+func (c oauthConnector) RecordLogin(username string, req *http.Request, success bool) error {
+	return throttle.Record(username, utils.ClientIP(req), req.UserAgent(), success)
 }
 
 func (c oauthConnector) GetUserFromAccessToken(accessToken string) (interface{}, error) {
+	if strings.HasPrefix(accessToken, APIKeyPrefix) {
+		user, err := apiKeyUser(accessToken)
+		if err != nil || user == nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	// The use of the token is recorded for the list of the sessions
 	rows, err := db.Query(
-		`SELECT user_id
-		FROM oauth_access_tokens
+		`UPDATE oauth_access_tokens
+		SET last_used_at = NOW()
 		WHERE token = $1::varchar
 		AND expires_at > NOW()
-		`,
+		RETURNING user_id`,
 		accessToken,
 	)
 
@@ -82,25 +128,23 @@ func (c oauthConnector) GetUserFromAccessToken(accessToken string) (interface{},
 }
 
 func (c oauthConnector) GetClient(key string, secret string) (interface{}, error) {
-	rows, err := db.Query(
-		`SELECT id, name,
-		key
-		FROM oauth_clients
-		WHERE key = $1::varchar`,
-		key,
-	)
-	if err != nil {
+	client, err := findClient(`WHERE key = $1::varchar`, key)
+	if err != nil || client == nil {
 		return nil, err
 	}
 
-	defer rows.Close()
-	if rows.Next() {
-		client := Client{}
+	if client.Confidential && !client.checkSecret(secret) {
+		return nil, nil
+	}
+	return client, nil
+}
 
-		err = rows.Scan(&client.Id, &client.Name, &client.Key)
-		return &client, nil
+func (c oauthConnector) FindClient(key string) (interface{}, error) {
+	client, err := findClient(`WHERE key = $1::varchar`, key)
+	if err != nil || client == nil {
+		return nil, err
 	}
-	return nil, nil
+	return client, nil
 }
 
 func removeExpiredTokens() {
@@ -108,6 +152,102 @@ func removeExpiredTokens() {
 		`DELETE FROM oauth_access_tokens
 		WHERE expires_at < NOW()`,
 	)
+
+	db.Exec(
+		`DELETE FROM oauth_refresh_tokens
+		WHERE expires_at < NOW()`,
+	)
+}
+
+// issueTokens creates an access token and a refresh token in the family.
+func issueTokens(familyId string, clientId int, userId, scope string, req *http.Request) (*AccessToken, error) {
+	accessLifetime, refreshLifetime := tokenLifetimes()
+
+	token := utils.RandomString(25)
+	refreshToken := utils.RandomString(40)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO oauth_access_tokens
+		(id, token, oauth_client_id, user_id,
+		 created_at, user_agent, ip, expires_at, family_id, scope)
+		VALUES
+		($1::varchar, $2::varchar, $3::integer, $4::varchar,
+		 NOW(), $5::varchar, $6::varchar, NOW() + $7::integer * interval '1 second', $8::varchar, $9::varchar)`,
+		uuid.NewV4().String(), token, clientId, userId,
+		req.UserAgent(), utils.ClientIP(req), accessLifetime, familyId, scope,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO oauth_refresh_tokens
+		(id, token, family_id, oauth_client_id, user_id, expires_at, scope)
+		VALUES
+		($1::varchar, $2::varchar, $3::varchar, $4::integer, $5::varchar,
+		 NOW() + $6::integer * interval '1 second', $7::varchar)`,
+		uuid.NewV4().String(), refreshToken, familyId, clientId, userId,
+		refreshLifetime, scope,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessToken{
+		Token:        token,
+		Type:         "Bearer",
+		ExpiresIn:    time.Duration(accessLifetime),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// IssueWebappTokens creates the tokens of a user authenticated outside of
+// the OAuth endpoints, like the single sign-on. They belong to the webapp
+// client.
+func IssueWebappTokens(user *users.User, req *http.Request) (*AccessToken, error) {
+	client, err := findClient(`WHERE key = $1::varchar`, webappClientKey)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, ClientNotFound
+	}
+
+	removeExpiredTokens()
+	return issueTokens(uuid.NewV4().String(), client.Id, user.Id, FullScope, req)
+}
+
+// revokeFamily removes the access tokens of the family and revokes its
+// refresh tokens.
+func revokeFamily(familyId string) error {
+	_, err := db.Exec(
+		`DELETE FROM oauth_access_tokens
+		WHERE family_id = $1::varchar`,
+		familyId,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`UPDATE oauth_refresh_tokens
+		SET revoked = true
+		WHERE family_id = $1::varchar`,
+		familyId,
+	)
+	return err
 }
 
 func (c oauthConnector) GetAccessToken(rawUser, rawClient interface{}, req *http.Request) (interface{}, error) {
@@ -116,68 +256,322 @@ func (c oauthConnector) GetAccessToken(rawUser, rawClient interface{}, req *http
 	user := rawUser.(*users.User)
 	client := rawClient.(*Client)
 
-	ua := req.UserAgent()
+	return issueTokens(uuid.NewV4().String(), client.Id, user.Id, FullScope, req)
+}
 
-	// Get IP client address
-	var ip string
-	if os.Getenv("TRUST_PROXY") == "true" {
-		xForwardedFor := req.Header["X-Forwarded-For"]
-		if len(xForwardedFor) > 0 {
-			ip = xForwardedFor[0]
-		}
+// RefreshAccessToken rotates the refresh token: it can only be used once. If
+// a refresh token is presented again, it has probably been stolen and its
+// whole family is revoked.
+func (c oauthConnector) RefreshAccessToken(rawClient interface{}, refreshToken string, req *http.Request) (interface{}, error) {
+	client := rawClient.(*Client)
+
+	rows, err := db.Query(
+		`SELECT id, family_id, user_id, scope,
+		expires_at < NOW(), used_at IS NOT NULL OR revoked
+		FROM oauth_refresh_tokens
+		WHERE token = $1::varchar
+		AND oauth_client_id = $2::integer`,
+		refreshToken, client.Id,
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(ip) == 0 {
-		addr := req.RemoteAddr
-		i := strings.LastIndex(addr, ":")
-		ip = addr[0:i]
+	if !rows.Next() {
+		rows.Close()
+		return nil, oauth2.InvalidRefreshToken
 	}
 
-	id := uuid.NewV4().String()
-	token := utils.RandomString(25)
+	var id, familyId, userId, scope string
+	var expired, used bool
+	err = rows.Scan(&id, &familyId, &userId, &scope, &expired, &used)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := db.Query(
-		`INSERT INTO oauth_access_tokens
-		(id, token, oauth_client_id, user_id,
-		 created_at, user_agent, ip, expires_at)
-		VALUES
-		($1::varchar, $2::varchar, $3::integer, $4::varchar,
-		 NOW(), $5::varchar, $6::varchar, NOW() + interval '1 day')`,
-		id, token, client.Id, user.Id,
-		ua, ip,
+	if used {
+		log.Warnf("[OAuth] Refresh token reused, revoking the tokens of the user %s", userId)
+		return nil, reuseDetected(familyId)
+	}
+
+	if expired {
+		return nil, oauth2.InvalidRefreshToken
+	}
+
+	res, err := db.Exec(
+		`UPDATE oauth_refresh_tokens
+		SET used_at = NOW()
+		WHERE id = $1::varchar
+		AND used_at IS NULL AND NOT revoked`,
+		id,
 	)
+	if err != nil {
+		return nil, err
+	}
 
+	updated, err := res.RowsAffected()
 	if err != nil {
 		return nil, err
 	}
 
-	rows.Close()
+	// Used concurrently
+	if updated == 0 {
+		log.Warnf("[OAuth] Refresh token reused, revoking the tokens of the user %s", userId)
+		return nil, reuseDetected(familyId)
+	}
+
+	user, err := users.GetUser(userId)
+	if err != nil {
+		return nil, err
+	}
 
-	accessToken := AccessToken{
-		Token:     token,
-		Type:      "Bearer",
-		ExpiresIn: 60 * 60 * 24,
+	if user == nil || !user.Activated {
+		return nil, oauth2.InvalidRefreshToken
+	}
+
+	token, err := issueTokens(familyId, client.Id, user.Id, scope, req)
+	if err != nil {
+		return nil, err
 	}
-	return accessToken, nil
+
+	if client.ThirdParty() {
+		token.Scope = scope
+	}
+	return token, nil
+}
+
+func reuseDetected(familyId string) error {
+	err := revokeFamily(familyId)
+	if err != nil {
+		return err
+	}
+	return oauth2.InvalidRefreshToken
 }
 
+// RevokeAccessToken logs the user out: every token issued since the login
+// is revoked.
 func (c oauthConnector) RevokeAccessToken(rawClient interface{}, rawUser interface{}, accessToken string) error {
 	user := rawUser.(*users.User)
 	client := rawClient.(*Client)
 
-	_, err := db.Exec(
+	rows, err := db.Query(
+		`SELECT family_id
+		FROM oauth_access_tokens
+		WHERE user_id = $1::varchar
+		AND token = $2::varchar
+		AND oauth_client_id = $3::integer`,
+		user.Id, accessToken, client.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	var familyId string
+	if rows.Next() {
+		err = rows.Scan(&familyId)
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	if familyId != "" {
+		return revokeFamily(familyId)
+	}
+
+	// Tokens issued before the refresh tokens have no family
+	_, err = db.Exec(
 		`DELETE FROM oauth_access_tokens
 		WHERE user_id = $1::varchar
 		AND token = $2::varchar
 		AND oauth_client_id = $3::integer`,
 		user.Id, accessToken, client.Id,
 	)
+	return err
+}
+
+func (c oauthConnector) RevokeRefreshToken(rawClient interface{}, refreshToken string) error {
+	client := rawClient.(*Client)
+
+	rows, err := db.Query(
+		`SELECT family_id
+		FROM oauth_refresh_tokens
+		WHERE token = $1::varchar
+		AND oauth_client_id = $2::integer`,
+		refreshToken, client.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	var familyId string
+	if rows.Next() {
+		err = rows.Scan(&familyId)
+	}
+	rows.Close()
+	if err != nil || familyId == "" {
+		return err
+	}
+
+	return revokeFamily(familyId)
+}
+
+// RevokeToken revokes the access token of the user identified by id, along
+// with the other tokens of its family.
+func RevokeToken(userId, id string) error {
+	rows, err := db.Query(
+		`SELECT family_id
+		FROM oauth_access_tokens
+		WHERE user_id = $1::varchar
+		AND id = $2::varchar`,
+		userId, id,
+	)
+	if err != nil {
+		return err
+	}
+
+	if !rows.Next() {
+		rows.Close()
+		return TokenNotFound
+	}
+
+	var familyId string
+	err = rows.Scan(&familyId)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	if familyId != "" {
+		return revokeFamily(familyId)
+	}
+
+	_, err = db.Exec(`DELETE FROM oauth_access_tokens WHERE id = $1::varchar`, id)
+	return err
+}
+
+// TokenId returns the id of the access token or of the API key, or an
+// empty string if it is unknown.
+func TokenId(accessToken string) (string, error) {
+	query := `SELECT id FROM oauth_access_tokens WHERE token = $1::varchar`
+	if strings.HasPrefix(accessToken, APIKeyPrefix) {
+		query = `SELECT id FROM api_keys WHERE token_hash = $1::varchar`
+		accessToken = hashAPIKey(accessToken)
+	}
+
+	rows, err := db.Query(query, accessToken)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var id string
+	if rows.Next() {
+		err = rows.Scan(&id)
+	}
+	return id, err
+}
+
+// RevokeSessions revokes the access and refresh tokens of the user, except
+// those of the family of the access token identified by keepId. The API
+// keys are kept.
+func RevokeSessions(userId, keepId string) error {
+	keepFamily := ""
+	if keepId != "" {
+		rows, err := db.Query(
+			`SELECT family_id FROM oauth_access_tokens
+			WHERE user_id = $1::varchar AND id = $2::varchar`,
+			userId, keepId,
+		)
+		if err != nil {
+			return err
+		}
+
+		if rows.Next() {
+			err = rows.Scan(&keepFamily)
+		}
+		rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Tokens issued before the families existed have none
+	_, err = tx.Exec(
+		`DELETE FROM oauth_access_tokens
+		WHERE user_id = $1::varchar
+		AND id <> $2::varchar
+		AND (family_id = '' OR family_id <> $3::varchar)`,
+		userId, keepId, keepFamily,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE oauth_refresh_tokens
+		SET revoked = true
+		WHERE user_id = $1::varchar
+		AND family_id <> $2::varchar`,
+		userId, keepFamily,
+	)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
-	return nil
+	return tx.Commit()
+}
+
+// Return the number of access tokens not expired and the number of distinct
+// users they belong to.
+func countActiveTokens() (int, int, error) {
+	rows, err := db.Query(
+		`SELECT COUNT(*), COUNT(DISTINCT user_id)
+		FROM oauth_access_tokens
+		WHERE expires_at > NOW()`,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	var tokens, users int
+	if rows.Next() {
+		err = rows.Scan(&tokens, &users)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return tokens, users, rows.Err()
 }
 
 func init() {
 	oauth2.SetConnector(oauthConnector{})
+
+	metrics.NewGaugeFunc(
+		"nanocloud_oauth_active_tokens",
+		"Access tokens not expired.",
+		nil,
+		func() ([]metrics.Sample, error) {
+			tokens, _, err := countActiveTokens()
+			return []metrics.Sample{{Value: float64(tokens)}}, err
+		},
+	)
+
+	metrics.NewGaugeFunc(
+		"nanocloud_connected_users",
+		"Users with at least one access token not expired.",
+		nil,
+		func() ([]metrics.Sample, error) {
+			_, users, err := countActiveTokens()
+			return []metrics.Sample{{Value: float64(users)}}, err
+		},
+	)
 }
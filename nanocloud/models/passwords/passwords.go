@@ -0,0 +1,191 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package passwords
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var PasswordExpired = errors.New("password expired")
+
+// previousHashes returns the hash of the current password of the user and
+// the ones of the history that cannot be used again.
+func previousHashes(userId string, history int) ([]string, error) {
+	rows, err := db.Query(
+		`(SELECT password FROM users WHERE id = $1::varchar)
+		UNION ALL
+		(SELECT password FROM users_password_history
+		WHERE user_id = $1::varchar
+		ORDER BY created_at DESC
+		LIMIT $2::integer)`,
+		userId, history,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := make([]string, 0)
+	for rows.Next() {
+		var hash string
+		err = rows.Scan(&hash)
+		if err != nil {
+			return nil, err
+		}
+		if hash != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, rows.Err()
+}
+
+// Validate checks the password against the policy and the previous
+// passwords of the user.
+func Validate(userId, password string) error {
+	policy := LoadPolicy()
+
+	err := policy.Check(password)
+	if err != nil {
+		return err
+	}
+
+	if policy.History == 0 {
+		return nil
+	}
+
+	hashes, err := previousHashes(userId, policy.History)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return &Violation{"The password was used recently"}
+		}
+	}
+	return nil
+}
+
+// setPassword replaces the password of the user and remembers it.
+func setPassword(tx *sql.Tx, userId, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(
+		`UPDATE users
+		SET password = $2::varchar, password_changed_at = NOW()
+		WHERE id = $1::varchar`,
+		userId, hash,
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return users.UserNotFound
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO users_password_history (id, user_id, password)
+		VALUES ($1::varchar, $2::varchar, $3::varchar)`,
+		uuid.NewV4().String(), userId, hash,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Only the passwords the policy may check are kept
+	_, err = tx.Exec(
+		`DELETE FROM users_password_history
+		WHERE user_id = $1::varchar
+		AND id NOT IN (
+			SELECT id FROM users_password_history
+			WHERE user_id = $1::varchar
+			ORDER BY created_at DESC
+			LIMIT $2::integer
+		)`,
+		userId, LoadPolicy().History,
+	)
+	return err
+}
+
+// Change sets a new password for the user if it follows the policy. It
+// returns a *Violation otherwise.
+func Change(userId, password string) error {
+	err := Validate(userId, password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = setPassword(tx, userId, password)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Expired tells whether the local password of the user is too old.
+func Expired(userId string) (bool, error) {
+	policy := LoadPolicy()
+	if policy.MaxAge == 0 {
+		return false, nil
+	}
+
+	rows, err := db.Query(
+		`SELECT password_changed_at < NOW() - $2::integer * interval '1 second'
+		FROM users
+		WHERE id = $1::varchar`,
+		userId, int(policy.MaxAge.Seconds()),
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var expired bool
+	if rows.Next() {
+		err = rows.Scan(&expired)
+		if err != nil {
+			return false, err
+		}
+	}
+	return expired, rows.Err()
+}
@@ -0,0 +1,193 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package passwords applies the password policy to the local passwords and
+// to the ones of the Windows accounts, keeps the history of the passwords
+// and lets the users reset a forgotten password.
+package passwords
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+	"unicode"
+
+	"github.com/Nanocloud/community/nanocloud/config"
+)
+
+// Policy the passwords must follow.
+type Policy struct {
+	MinLength int
+
+	// Number of character classes among uppercase, lowercase, digits,
+	// punctuation and other characters.
+	MinClasses int
+
+	// Number of previous passwords that cannot be used again, 0 to allow
+	// any.
+	History int
+
+	// Time after which a local password must be changed, 0 for never.
+	MaxAge time.Duration
+}
+
+// ADPolicy is the complexity required by Active Directory.
+var ADPolicy = Policy{MinLength: 7, MinClasses: 3}
+
+// Violation explains why a password is refused.
+type Violation struct {
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return v.Reason
+}
+
+func parseInt(value string, def int) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+// LoadPolicy reads the policy from the password_* configuration keys.
+func LoadPolicy() Policy {
+	values := config.Get(
+		"password_min_length",
+		"password_min_classes",
+		"password_history",
+		"password_max_age",
+	)
+
+	return Policy{
+		MinLength:  parseInt(values["password_min_length"], 8),
+		MinClasses: parseInt(values["password_min_classes"], 3),
+		History:    parseInt(values["password_history"], 0),
+		MaxAge:     time.Duration(parseInt(values["password_max_age"], 0)) * 24 * time.Hour,
+	}
+}
+
+// WindowsPolicy is the policy of the passwords of the Windows accounts:
+// the configured one, at least as strict as Active Directory.
+func WindowsPolicy() Policy {
+	return LoadPolicy().Stricter(ADPolicy)
+}
+
+// Stricter returns the policy with the strictest rules of both.
+func (p Policy) Stricter(o Policy) Policy {
+	if o.MinLength > p.MinLength {
+		p.MinLength = o.MinLength
+	}
+	if o.MinClasses > p.MinClasses {
+		p.MinClasses = o.MinClasses
+	}
+	if o.History > p.History {
+		p.History = o.History
+	}
+	if o.MaxAge != 0 && (p.MaxAge == 0 || o.MaxAge < p.MaxAge) {
+		p.MaxAge = o.MaxAge
+	}
+	return p
+}
+
+// Classes returns the number of character classes of the password.
+func Classes(password string) int {
+	var upper, lower, digit, punct, other int
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = 1
+		case unicode.IsLower(c):
+			lower = 1
+		case unicode.IsDigit(c):
+			digit = 1
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			punct = 1
+		default:
+			other = 1
+		}
+	}
+	return upper + lower + digit + punct + other
+}
+
+// Check verifies the length and the complexity of the password.
+func (p Policy) Check(password string) error {
+	if len([]rune(password)) < p.MinLength {
+		return &Violation{fmt.Sprintf("The password must contain at least %d characters", p.MinLength)}
+	}
+
+	classes := p.MinClasses
+	if classes > 5 {
+		classes = 5
+	}
+	if Classes(password) < classes {
+		return &Violation{fmt.Sprintf("The password must mix at least %d of uppercase letters, lowercase letters, digits and symbols", classes)}
+	}
+	return nil
+}
+
+var alphabets = []string{
+	"ABCDEFGHJKLMNPQRSTUVWXYZ",
+	"abcdefghijkmnopqrstuvwxyz",
+	"23456789",
+	"+-=_!@#%*?",
+}
+
+func randomInt(max int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()), nil
+}
+
+// Generate returns a random password following the policy, with at least
+// 16 characters of the four classes.
+func Generate(p Policy) (string, error) {
+	length := p.MinLength
+	if length < 16 {
+		length = 16
+	}
+
+	password := make([]byte, length)
+	for i := range password {
+		alphabet := alphabets[i%len(alphabets)]
+		n, err := randomInt(len(alphabet))
+		if err != nil {
+			return "", err
+		}
+		password[i] = alphabet[n]
+	}
+
+	// Fisher-Yates shuffle so the classes are not in a fixed order
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
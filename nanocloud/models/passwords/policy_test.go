@@ -0,0 +1,78 @@
+package passwords
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	policy := Policy{MinLength: 8, MinClasses: 3}
+
+	valid := []string{"Nano@123", "Nanocloud123+", "ÉtéBrûlant9", "correct horse 7A"}
+	for _, password := range valid {
+		if err := policy.Check(password); err != nil {
+			t.Errorf("%q should be valid: %s", password, err.Error())
+		}
+	}
+
+	invalid := []string{"", "Na@1", "nanocloud", "nanocloud123", "NANOCLOUD+"}
+	for _, password := range invalid {
+		err := policy.Check(password)
+		if _, ok := err.(*Violation); !ok {
+			t.Errorf("%q should be refused, got %v", password, err)
+		}
+	}
+}
+
+func TestClasses(t *testing.T) {
+	classes := map[string]int{
+		"":          0,
+		"abc":       1,
+		"aBc":       2,
+		"aB1":       3,
+		"aB1+":      4,
+		"aB1+ ":     5,
+		"日本語":       1,
+		"Nanocloud": 2,
+	}
+
+	for password, expected := range classes {
+		if n := Classes(password); n != expected {
+			t.Errorf("Classes(%q) = %d, want %d", password, n, expected)
+		}
+	}
+}
+
+func TestStricter(t *testing.T) {
+	policy := Policy{MinLength: 12, MinClasses: 2, MaxAge: 90 * 24 * time.Hour}.Stricter(ADPolicy)
+
+	expected := Policy{MinLength: 12, MinClasses: 3, MaxAge: 90 * 24 * time.Hour}
+	if policy != expected {
+		t.Errorf("Expected %+v, got %+v", expected, policy)
+	}
+
+	policy = Policy{}.Stricter(Policy{MaxAge: time.Hour})
+	if policy.MaxAge != time.Hour {
+		t.Errorf("A max age should be stricter than none, got %s", policy.MaxAge)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	policies := []Policy{ADPolicy, {MinLength: 24, MinClasses: 4}}
+
+	for _, policy := range policies {
+		password, err := Generate(policy)
+		if err != nil {
+			t.Fatalf("Cannot generate a password: %s", err.Error())
+		}
+
+		err = policy.Check(password)
+		if err != nil {
+			t.Errorf("%q does not follow %+v: %s", password, policy, err.Error())
+		}
+
+		if Classes(password) != 4 {
+			t.Errorf("%q should contain the four classes", password)
+		}
+	}
+}
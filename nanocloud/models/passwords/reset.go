@@ -0,0 +1,228 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package passwords
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/config"
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/utils"
+)
+
+// Lifetime of the reset tokens.
+const resetTokenLifetime = time.Hour
+
+// Time before another token can be sent to the same user.
+const resetTokenInterval = time.Minute
+
+var (
+	InvalidResetToken = errors.New("invalid reset token")
+	ResetTooSoon      = errors.New("reset token requested too soon")
+)
+
+var (
+	secretOnce sync.Once
+	secretKey  []byte
+	secretErr  error
+)
+
+// resetSecret returns the key signing the tokens: PASSWORD_RESET_SECRET or
+// a random key generated on first use and kept in the config table.
+func resetSecret() ([]byte, error) {
+	secretOnce.Do(func() {
+		if secret := utils.Env("PASSWORD_RESET_SECRET", ""); secret != "" {
+			secretKey = []byte(secret)
+			return
+		}
+
+		stored := config.Get("password_reset_secret")["password_reset_secret"]
+		if stored == "" {
+			b := make([]byte, 32)
+			_, secretErr = rand.Read(b)
+			if secretErr != nil {
+				return
+			}
+			stored = hex.EncodeToString(b)
+			config.Set("password_reset_secret", stored)
+		}
+		secretKey = []byte(stored)
+	})
+	return secretKey, secretErr
+}
+
+func signature(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signToken returns "payload.signature", the payload being the user, the
+// expiration date and the nonce.
+func signToken(key []byte, userId string, expiresAt time.Time, nonce string) string {
+	payload := base64.RawURLEncoding.EncodeToString(
+		[]byte(userId + ":" + strconv.FormatInt(expiresAt.Unix(), 10) + ":" + nonce),
+	)
+	return payload + "." + signature(key, payload)
+}
+
+// parseToken checks the signature and the expiration date of the token and
+// returns its user and nonce.
+func parseToken(key []byte, token string, now time.Time) (string, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", "", InvalidResetToken
+	}
+
+	if !hmac.Equal([]byte(signature(key, parts[0])), []byte(parts[1])) {
+		return "", "", InvalidResetToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", InvalidResetToken
+	}
+
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 3 {
+		return "", "", InvalidResetToken
+	}
+
+	expiresAt, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return "", "", InvalidResetToken
+	}
+	return fields[0], fields[2], nil
+}
+
+// CreateResetToken returns a token letting the user choose a new password.
+// The previous tokens of the user are revoked.
+func CreateResetToken(userId string) (string, error) {
+	key, err := resetSecret()
+	if err != nil {
+		return "", err
+	}
+
+	recent, err := db.Count(
+		`SELECT count(*) FROM password_reset_tokens
+		WHERE user_id = $1::varchar
+		AND created_at > NOW() - $2::integer * interval '1 second'`,
+		userId, int(resetTokenInterval.Seconds()),
+	)
+	if err != nil {
+		return "", err
+	}
+	if recent > 0 {
+		return "", ResetTooSoon
+	}
+
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(b)
+	expiresAt := time.Now().Add(resetTokenLifetime)
+
+	_, err = db.Exec(
+		`DELETE FROM password_reset_tokens
+		WHERE user_id = $1::varchar OR expires_at < NOW()`,
+		userId,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO password_reset_tokens (nonce, user_id, expires_at)
+		VALUES ($1::varchar, $2::varchar, to_timestamp($3::bigint))`,
+		nonce, userId, expiresAt.Unix(),
+	)
+	if err != nil {
+		return "", err
+	}
+	return signToken(key, userId, expiresAt, nonce), nil
+}
+
+// Reset sets the password of the user of the token and returns the user.
+// A token can only be used once.
+func Reset(token, password string) (string, error) {
+	key, err := resetSecret()
+	if err != nil {
+		return "", err
+	}
+
+	userId, nonce, err := parseToken(key, token, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	err = Validate(userId, password)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	res, err := tx.Exec(
+		`UPDATE password_reset_tokens SET used_at = NOW()
+		WHERE nonce = $1::varchar
+		AND user_id = $2::varchar
+		AND used_at IS NULL
+		AND expires_at > NOW()`,
+		nonce, userId,
+	)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	used, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if used == 0 {
+		tx.Rollback()
+		return "", InvalidResetToken
+	}
+
+	err = setPassword(tx, userId, password)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	return userId, tx.Commit()
+}
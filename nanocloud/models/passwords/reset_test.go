@@ -0,0 +1,47 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResetToken(t *testing.T) {
+	key := []byte("secret")
+	now := time.Unix(1460000000, 0)
+	token := signToken(key, "6ed3b1d6-b1c4-4f8a-a4a3-b8c2d1e4f5a6", now.Add(time.Hour), "0123456789abcdef")
+
+	userId, nonce, err := parseToken(key, token, now)
+	if err != nil {
+		t.Fatalf("Cannot parse the token: %s", err.Error())
+	}
+	if userId != "6ed3b1d6-b1c4-4f8a-a4a3-b8c2d1e4f5a6" || nonce != "0123456789abcdef" {
+		t.Errorf("Unexpected user %q and nonce %q", userId, nonce)
+	}
+
+	_, _, err = parseToken(key, token, now.Add(2*time.Hour))
+	if err != InvalidResetToken {
+		t.Errorf("An expired token should be refused, got %v", err)
+	}
+
+	_, _, err = parseToken([]byte("other"), token, now)
+	if err != InvalidResetToken {
+		t.Errorf("A token signed with another key should be refused, got %v", err)
+	}
+
+	// The payload cannot be changed without the key
+	forged := signToken(key, "admin", now.Add(time.Hour), "0123456789abcdef")
+	parts := strings.Split(token, ".")
+	forged = strings.Split(forged, ".")[0] + "." + parts[1]
+	_, _, err = parseToken(key, forged, now)
+	if err != InvalidResetToken {
+		t.Errorf("A forged token should be refused, got %v", err)
+	}
+
+	for _, invalid := range []string{"", ".", "abc", token + ".x"} {
+		_, _, err = parseToken(key, invalid, now)
+		if err != InvalidResetToken {
+			t.Errorf("%q should be refused, got %v", invalid, err)
+		}
+	}
+}
@@ -0,0 +1,579 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package roles
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/query"
+	log "github.com/Sirupsen/logrus"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	MachinesRead   = "machines:read"
+	MachinesWrite  = "machines:write"
+	AppsRead       = "apps:read"
+	AppsPublish    = "apps:publish"
+	AppsAssign     = "apps:assign"
+	UsersRead      = "users:read"
+	UsersManage    = "users:manage"
+	UsersPassword  = "users:password"
+	GroupsRead     = "groups:read"
+	GroupsManage   = "groups:manage"
+	HistoriesRead  = "histories:read"
+	SessionsRead   = "sessions:read"
+	SessionsManage = "sessions:manage"
+	RolesManage    = "roles:manage"
+	AuditRead      = "audit:read"
+
+	OAuthClientsManage = "oauth-clients:manage"
+)
+
+// Every permission that can be granted to a role.
+var All = []string{
+	MachinesRead,
+	MachinesWrite,
+	AppsRead,
+	AppsPublish,
+	AppsAssign,
+	UsersRead,
+	UsersManage,
+	UsersPassword,
+	GroupsRead,
+	GroupsManage,
+	HistoriesRead,
+	SessionsRead,
+	SessionsManage,
+	RolesManage,
+	AuditRead,
+	OAuthClientsManage,
+}
+
+var (
+	RoleNotFound         = errors.New("role not found")
+	RoleDuplicated       = errors.New("role duplicated")
+	InvalidName          = errors.New("invalid role name")
+	AssignmentNotFound   = errors.New("assignment not found")
+	AssignmentDuplicated = errors.New("assignment duplicated")
+	InvalidAssignment    = errors.New("an assignment needs either a user or a group")
+)
+
+// An unknown permission.
+type InvalidPermission string
+
+func (p InvalidPermission) Error() string {
+	return "unknown permission " + string(p)
+}
+
+type Role struct {
+	Id          string   `json:"-"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+func (r *Role) GetID() string {
+	return r.Id
+}
+
+func (r *Role) SetID(id string) error {
+	r.Id = id
+	return nil
+}
+
+// Grants a role to a user or to the members of a group.
+type Assignment struct {
+	Id      string `json:"-"`
+	RoleId  string `json:"role-id"`
+	UserId  string `json:"user-id,omitempty"`
+	GroupId string `json:"group-id,omitempty"`
+}
+
+func (a *Assignment) GetID() string {
+	return a.Id
+}
+
+func (a *Assignment) SetID(id string) error {
+	a.Id = id
+	return nil
+}
+
+// The set of permissions of a user.
+type Permissions map[string]bool
+
+func (p Permissions) Has(permission string) bool {
+	return p[permission]
+}
+
+// Return the sorted list of the permissions.
+func (p Permissions) List() []string {
+	list := make([]string, 0, len(p))
+	for permission, granted := range p {
+		if granted {
+			list = append(list, permission)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// UserPermissions returns the permissions granted to the user, directly or
+// through their groups. Administrators have every permission.
+func UserPermissions(user *users.User) (Permissions, error) {
+	permissions := make(Permissions)
+	if user.IsAdmin {
+		for _, permission := range All {
+			permissions[permission] = true
+		}
+		return permissions, nil
+	}
+
+	rows, err := db.Query(
+		`SELECT DISTINCT permission FROM roles_permissions
+		WHERE role_id IN (
+			SELECT role_id FROM roles_assignments
+			WHERE user_id = $1::varchar
+			OR group_id IN (SELECT group_id FROM groups_users WHERE user_id = $1::varchar)
+		)`,
+		user.GetID(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var permission string
+
+		err = rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions[permission] = true
+	}
+	return permissions, rows.Err()
+}
+
+// Can reports whether the user has the permission. Errors are logged and
+// deny the permission.
+func Can(user *users.User, permission string) bool {
+	permissions, err := UserPermissions(user)
+	if err != nil {
+		log.Errorf("Unable to retrieve the permissions of %s: %s", user.GetID(), err)
+		return false
+	}
+	return permissions.Has(permission)
+}
+
+// CanChangeCredentials reports whether actor may change the password, the
+// email or the second factor of target. Taking over target must not give
+// more rights: administrators can change the credentials of every user,
+// the others only those of the users whose permissions they all hold. So
+// users:password or users:manage lead neither to an admin account nor to an
+// account holding roles:manage. Errors are logged and deny the change.
+func CanChangeCredentials(actor, target *users.User) bool {
+	if actor.IsAdmin {
+		return true
+	}
+	if target.IsAdmin {
+		return false
+	}
+
+	actorPermissions, err := UserPermissions(actor)
+	if err != nil {
+		log.Errorf("Unable to retrieve the permissions of %s: %s", actor.GetID(), err)
+		return false
+	}
+
+	targetPermissions, err := UserPermissions(target)
+	if err != nil {
+		log.Errorf("Unable to retrieve the permissions of %s: %s", target.GetID(), err)
+		return false
+	}
+
+	for permission, granted := range targetPermissions {
+		if granted && !actorPermissions.Has(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+func validatePermissions(permissions []string) ([]string, error) {
+	rt := make([]string, 0, len(permissions))
+	seen := make(map[string]bool)
+	for _, permission := range permissions {
+		valid := false
+		for _, p := range All {
+			if p == permission {
+				valid = true
+				break
+			}
+		}
+
+		if !valid {
+			return nil, InvalidPermission(permission)
+		}
+
+		if !seen[permission] {
+			seen[permission] = true
+			rt = append(rt, permission)
+		}
+	}
+	sort.Strings(rt)
+	return rt, nil
+}
+
+// Columns the roles can be filtered and sorted by.
+var Columns = query.Columns{
+	"name": "name",
+}
+
+func (r *Role) loadPermissions() error {
+	rows, err := db.Query(
+		`SELECT permission FROM roles_permissions
+		WHERE role_id = $1::varchar
+		ORDER BY permission`,
+		r.Id,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	r.Permissions = make([]string, 0)
+	for rows.Next() {
+		var permission string
+
+		err = rows.Scan(&permission)
+		if err != nil {
+			return err
+		}
+		r.Permissions = append(r.Permissions, permission)
+	}
+	return rows.Err()
+}
+
+func scanRoles(rows *sql.Rows) ([]*Role, error) {
+	roles := make([]*Role, 0)
+	for rows.Next() {
+		var r Role
+
+		err := rows.Scan(&r.Id, &r.Name)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		roles = append(roles, &r)
+	}
+	err := rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range roles {
+		err = r.loadPermissions()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return roles, nil
+}
+
+// FindRoles returns the page of roles selected by q and the number of roles
+// matching its filters.
+func FindRoles(q *query.Query) ([]*Role, int, error) {
+	where, args := q.Where(Columns, nil, nil)
+
+	total, err := db.Count(`SELECT count(*) FROM roles`+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(
+		`SELECT id, name FROM roles`+where+q.OrderBy(Columns, "name")+q.Limit(),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	roles, err := scanRoles(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return roles, total, nil
+}
+
+func GetRole(id string) (*Role, error) {
+	rows, err := db.Query(`SELECT id, name FROM roles WHERE id = $1::varchar`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := scanRoles(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(roles) == 0 {
+		return nil, RoleNotFound
+	}
+	return roles[0], nil
+}
+
+func isDuplicate(err error) bool {
+	return err.Error() == "pq: duplicate key value violates unique constraint \"roles_name_key\""
+}
+
+// savePermissions replaces the permissions of the role in a transaction.
+func (r *Role) savePermissions(tx *sql.Tx) error {
+	_, err := tx.Exec(`DELETE FROM roles_permissions WHERE role_id = $1::varchar`, r.Id)
+	if err != nil {
+		return err
+	}
+
+	for _, permission := range r.Permissions {
+		_, err = tx.Exec(
+			`INSERT INTO roles_permissions (role_id, permission)
+			VALUES ($1::varchar, $2::varchar)`,
+			r.Id, permission,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func CreateRole(name string, permissions []string) (*Role, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, InvalidName
+	}
+
+	permissions, err := validatePermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	role := Role{
+		Id:          uuid.NewV4().String(),
+		Name:        name,
+		Permissions: permissions,
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO roles (id, name) VALUES ($1::varchar, $2::varchar)`,
+		role.Id, role.Name,
+	)
+	if err != nil {
+		tx.Rollback()
+		if isDuplicate(err) {
+			return nil, RoleDuplicated
+		}
+		return nil, err
+	}
+
+	err = role.savePermissions(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// Update renames the role and replaces its permissions.
+func (r *Role) Update(name string, permissions []string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return InvalidName
+	}
+
+	permissions, err := validatePermissions(permissions)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE roles SET name = $2::varchar WHERE id = $1::varchar`,
+		r.Id, name,
+	)
+	if err != nil {
+		tx.Rollback()
+		if isDuplicate(err) {
+			return RoleDuplicated
+		}
+		return err
+	}
+
+	role := Role{Id: r.Id, Name: name, Permissions: permissions}
+	err = role.savePermissions(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	*r = role
+	return nil
+}
+
+// Delete the role. The users it was granted to lose its permissions.
+func (r *Role) Delete() error {
+	res, err := db.Exec(`DELETE FROM roles WHERE id = $1::varchar`, r.Id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return RoleNotFound
+	}
+	return nil
+}
+
+// Return the users and groups the role is granted to.
+func (r *Role) Assignments() ([]*Assignment, error) {
+	rows, err := db.Query(
+		`SELECT id, role_id, user_id, group_id
+		FROM roles_assignments
+		WHERE role_id = $1::varchar`,
+		r.Id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := make([]*Assignment, 0)
+	for rows.Next() {
+		var a Assignment
+		var userId, groupId sql.NullString
+
+		err := rows.Scan(&a.Id, &a.RoleId, &userId, &groupId)
+		if err != nil {
+			return nil, err
+		}
+		a.UserId = userId.String
+		a.GroupId = groupId.String
+		assignments = append(assignments, &a)
+	}
+	return assignments, rows.Err()
+}
+
+// Grant the role to a user or to a group. Exactly one of userId and groupId
+// must be set.
+func (r *Role) Assign(userId, groupId string) (*Assignment, error) {
+	if (userId == "") == (groupId == "") {
+		return nil, InvalidAssignment
+	}
+
+	if userId != "" {
+		exists, err := users.UserExists(userId)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, users.UserNotFound
+		}
+	} else {
+		_, err := groups.GetGroup(groupId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	assignment := Assignment{
+		Id:      uuid.NewV4().String(),
+		RoleId:  r.Id,
+		UserId:  userId,
+		GroupId: groupId,
+	}
+
+	_, err := db.Exec(
+		`INSERT INTO roles_assignments (id, role_id, user_id, group_id)
+		VALUES ($1::varchar, $2::varchar, NULLIF($3::varchar, ''), NULLIF($4::varchar, ''))`,
+		assignment.Id, assignment.RoleId, assignment.UserId, assignment.GroupId,
+	)
+	if err != nil {
+		switch err.Error() {
+		case "pq: duplicate key value violates unique constraint \"roles_assignments_role_id_user_id_key\"",
+			"pq: duplicate key value violates unique constraint \"roles_assignments_role_id_group_id_key\"":
+			return nil, AssignmentDuplicated
+		}
+		return nil, err
+	}
+	return &assignment, nil
+}
+
+func (r *Role) Unassign(assignmentId string) error {
+	res, err := db.Exec(
+		`DELETE FROM roles_assignments
+		WHERE id = $1::varchar AND role_id = $2::varchar`,
+		assignmentId, r.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return AssignmentNotFound
+	}
+	return nil
+}
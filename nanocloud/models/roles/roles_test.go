@@ -0,0 +1,228 @@
+package roles
+
+import (
+	"log"
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/query"
+)
+
+var (
+	user  = &users.User{}
+	group = &groups.Group{}
+	role  = &Role{}
+)
+
+func init() {
+	new_user, err := users.CreateUser(
+		true,
+		"helpdesk@nanocloud.com",
+		"Test",
+		"helpdesk",
+		"secret",
+		false,
+	)
+
+	if err != nil {
+		log.Panicln("Can't create new account:", err.Error())
+	}
+	if new_user == nil {
+		log.Panicln("Can't create new account")
+	}
+	user = new_user
+
+	group, err = groups.CreateGroup("Support")
+	if err != nil {
+		log.Panicln("Can't create group:", err.Error())
+	}
+}
+
+func TestCreateRole(t *testing.T) {
+	var err error
+	role, err = CreateRole(" Support ", []string{UsersPassword, UsersRead, UsersRead})
+	if err != nil {
+		t.Fatalf("Cannot create role: %s", err.Error())
+	}
+
+	if role.Name != "Support" || len(role.Permissions) != 2 ||
+		role.Permissions[0] != UsersPassword || role.Permissions[1] != UsersRead {
+		t.Errorf("Unexpected role %+v", role)
+	}
+
+	_, err = CreateRole("Support", nil)
+	if err != RoleDuplicated {
+		t.Errorf("Roles names should be unique")
+	}
+
+	_, err = CreateRole("Other", []string{"machines:destroy"})
+	if _, ok := err.(InvalidPermission); !ok {
+		t.Errorf("Unknown permissions should be rejected")
+	}
+}
+
+func TestFindRoles(t *testing.T) {
+	q := query.All()
+	q.Filters["name"] = []string{"Support"}
+
+	roles, total, err := FindRoles(q)
+	if err != nil {
+		t.Fatalf("Cannot find roles: %s", err.Error())
+	}
+
+	if total != 1 || len(roles) != 1 || len(roles[0].Permissions) != 2 {
+		t.Errorf("Unexpected roles %v", roles)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	if Can(user, UsersPassword) {
+		t.Errorf("The role is not granted yet")
+	}
+
+	assignment, err := role.Assign(user.GetID(), "")
+	if err != nil {
+		t.Fatalf("Cannot assign role: %s", err.Error())
+	}
+
+	if !Can(user, UsersPassword) || Can(user, MachinesWrite) {
+		t.Errorf("The user should only have the permissions of the role")
+	}
+
+	_, err = role.Assign(user.GetID(), "")
+	if err != AssignmentDuplicated {
+		t.Errorf("A role cannot be granted twice")
+	}
+
+	err = role.Unassign(assignment.Id)
+	if err != nil {
+		t.Fatalf("Cannot unassign role: %s", err.Error())
+	}
+
+	if Can(user, UsersPassword) {
+		t.Errorf("The role was not revoked")
+	}
+
+	_, err = role.Assign("", "")
+	if err != InvalidAssignment {
+		t.Errorf("A role needs a user or a group")
+	}
+}
+
+func TestGroupPermissions(t *testing.T) {
+	err := group.AddMember(user.GetID())
+	if err != nil {
+		t.Fatalf("Cannot add member: %s", err.Error())
+	}
+
+	_, err = role.Assign("", group.Id)
+	if err != nil {
+		t.Fatalf("Cannot assign role: %s", err.Error())
+	}
+
+	if !Can(user, UsersRead) {
+		t.Errorf("Members should have the permissions of the group roles")
+	}
+
+	assignments, err := role.Assignments()
+	if err != nil || len(assignments) != 1 || assignments[0].GroupId != group.Id {
+		t.Errorf("Unexpected assignments %v", assignments)
+	}
+}
+
+func TestAdminPermissions(t *testing.T) {
+	admin := &users.User{IsAdmin: true}
+
+	permissions, err := UserPermissions(admin)
+	if err != nil {
+		t.Fatalf("Cannot retrieve permissions: %s", err.Error())
+	}
+
+	if len(permissions.List()) != len(All) {
+		t.Errorf("Administrators should have every permission")
+	}
+}
+
+func TestCanChangeCredentials(t *testing.T) {
+	admin := &users.User{IsAdmin: true}
+	other := &users.User{}
+
+	if !Can(user, UsersPassword) {
+		t.Fatalf("The helpdesk user should hold users:password through the group role")
+	}
+
+	if CanChangeCredentials(user, admin) {
+		t.Errorf("Helpdesk users should not change the credentials of an administrator")
+	}
+	if !CanChangeCredentials(user, other) {
+		t.Errorf("Helpdesk users should change the credentials of other users")
+	}
+	if !CanChangeCredentials(admin, admin) || !CanChangeCredentials(admin, other) {
+		t.Errorf("Administrators should change the credentials of every user")
+	}
+
+	manager, err := users.CreateUser(true, "roles-manager@nanocloud.com", "Roles", "Manager", "secret", false)
+	if err != nil {
+		t.Fatalf("Can't create new account: %s", err.Error())
+	}
+
+	managers, err := CreateRole("Roles managers", []string{RolesManage})
+	if err != nil {
+		t.Fatalf("Cannot create role: %s", err.Error())
+	}
+
+	_, err = managers.Assign(manager.GetID(), "")
+	if err != nil {
+		t.Fatalf("Cannot assign role: %s", err.Error())
+	}
+
+	if CanChangeCredentials(user, manager) {
+		t.Errorf("Helpdesk users should not change the credentials of a user holding roles:manage")
+	}
+	if !CanChangeCredentials(manager, manager) || !CanChangeCredentials(admin, manager) {
+		t.Errorf("Users and administrators should change the credentials of the roles manager")
+	}
+}
+
+func TestUpdateRole(t *testing.T) {
+	err := role.Update("Helpdesk", []string{SessionsRead})
+	if err != nil {
+		t.Fatalf("Cannot update role: %s", err.Error())
+	}
+
+	r, err := GetRole(role.Id)
+	if err != nil || r.Name != "Helpdesk" || len(r.Permissions) != 1 || r.Permissions[0] != SessionsRead {
+		t.Errorf("The role was not updated: %+v", r)
+	}
+
+	if Can(user, UsersRead) || !Can(user, SessionsRead) {
+		t.Errorf("The permissions were not replaced")
+	}
+}
+
+func TestDeleteRole(t *testing.T) {
+	err := role.Delete()
+	if err != nil {
+		t.Fatalf("Cannot delete role: %s", err.Error())
+	}
+
+	_, err = GetRole(role.Id)
+	if err != RoleNotFound {
+		t.Errorf("The role was not deleted")
+	}
+
+	if Can(user, SessionsRead) {
+		t.Errorf("The permissions of a deleted role should be revoked")
+	}
+
+	err = group.Delete()
+	if err != nil {
+		t.Errorf("Can't delete group: %s\n", err.Error())
+	}
+
+	err = users.DeleteUser(user.GetID())
+	if err != nil {
+		t.Errorf("Can't delete user: %s\n", err.Error())
+	}
+}
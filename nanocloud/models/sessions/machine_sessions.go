@@ -0,0 +1,231 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sessions
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/plaza"
+	"github.com/Nanocloud/community/nanocloud/vms"
+	log "github.com/Sirupsen/logrus"
+)
+
+var InvalidSessionId = errors.New("invalid session id")
+
+// A session opened on one of the machines.
+// Its id is made of the machine id and the Windows session id so a session can
+// be addressed without knowing on which machine it runs.
+type MachineSession struct {
+	Id          string `json:"-"`
+	MachineId   string `json:"machine-id"`
+	MachineName string `json:"machine-name"`
+	SessionId   string `json:"session-id"`
+	SessionName string `json:"session-name"`
+	Username    string `json:"username"`
+	State       string `json:"state"`
+	UserId      string `json:"user-id,omitempty"`
+	UserEmail   string `json:"user-email,omitempty"`
+
+	// Paths of the executables running in the session.
+	Programs []string `json:"programs"`
+}
+
+func (s *MachineSession) GetID() string {
+	return s.Id
+}
+
+func (s *MachineSession) SetID(id string) error {
+	s.Id = id
+	return nil
+}
+
+// Empty fields match all sessions.
+type Filter struct {
+	UserId    string
+	MachineId string
+	State     string
+}
+
+func (f *Filter) match(s *MachineSession) bool {
+	return (f.UserId == "" || f.UserId == s.UserId) &&
+		(f.MachineId == "" || f.MachineId == s.MachineId) &&
+		(f.State == "" || f.State == s.State)
+}
+
+func MachineSessionId(machineId, sessionId string) string {
+	return machineId + ":" + sessionId
+}
+
+// Split a session id into the machine id and the Windows session id.
+func ParseMachineSessionId(id string) (string, string, error) {
+	i := strings.LastIndex(id, ":")
+	if i <= 0 || i == len(id)-1 {
+		return "", "", InvalidSessionId
+	}
+	return id[:i], id[i+1:], nil
+}
+
+// Windows reports abbreviated states like `Disc`.
+func normalizeState(state string) string {
+	switch state {
+	case "Disc":
+		return "disconnected"
+	}
+	return strings.ToLower(state)
+}
+
+type windowsAccount struct {
+	userId string
+	email  string
+}
+
+// Return the nanocloud users indexed by the SAM of their Windows account.
+func windowsAccounts() (map[string]windowsAccount, error) {
+	rows, err := db.Query(
+		`SELECT machines.username, users.id, users.email
+		FROM machines_users
+		JOIN machines ON machines_users.machine_id = machines.id
+		JOIN users ON machines_users.user_id = users.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := make(map[string]windowsAccount)
+	for rows.Next() {
+		var sam string
+		var account windowsAccount
+
+		err = rows.Scan(&sam, &account.userId, &account.email)
+		if err != nil {
+			return nil, err
+		}
+		accounts[strings.ToLower(sam)] = account
+	}
+	return accounts, rows.Err()
+}
+
+type machineSessions struct {
+	machine  vms.Machine
+	name     string
+	sessions []plaza.Session
+	err      error
+}
+
+func querySessions(machine vms.Machine, port int) machineSessions {
+	rt := machineSessions{machine: machine}
+
+	rt.name, rt.err = machine.Name()
+	if rt.err != nil {
+		return rt
+	}
+
+	ip, err := machine.IP()
+	if err != nil || ip == nil {
+		rt.err = errors.New("machine has no IP address")
+		return rt
+	}
+
+	rt.sessions, rt.err = plaza.Sessions(ip.String(), port)
+	return rt
+}
+
+// ListReachableSessions returns the sessions opened on the machines and the
+// ids of the machines that answered. Sessions of the machines that cannot be
+// reached are unknown rather than closed.
+func ListReachableSessions(machines []vms.Machine, port int) ([]*MachineSession, []string, error) {
+	accounts, err := windowsAccounts()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan machineSessions)
+	for _, machine := range machines {
+		go func(machine vms.Machine) {
+			ch <- querySessions(machine, port)
+		}(machine)
+	}
+
+	rt := make([]*MachineSession, 0)
+	reached := make([]string, 0)
+	for range machines {
+		res := <-ch
+		if res.err != nil {
+			log.Errorf("Unable to list the sessions of %s: %s", res.machine.Id(), res.err)
+			continue
+		}
+		reached = append(reached, res.machine.Id())
+
+		for _, s := range res.sessions {
+			session := &MachineSession{
+				Id:          MachineSessionId(res.machine.Id(), s.Id),
+				MachineId:   res.machine.Id(),
+				MachineName: res.name,
+				SessionId:   s.Id,
+				SessionName: s.Name,
+				Username:    s.Username,
+				State:       normalizeState(s.State),
+				Programs:    s.Programs,
+			}
+
+			account, ok := accounts[strings.ToLower(s.Username)]
+			if ok {
+				session.UserId = account.userId
+				session.UserEmail = account.email
+			}
+			rt = append(rt, session)
+		}
+	}
+	return rt, reached, nil
+}
+
+// ListMachineSessions returns the sessions opened on the machines that match
+// the filter. The machines are queried in parallel; the ones that cannot be
+// reached are skipped.
+func ListMachineSessions(machines []vms.Machine, port int, filter Filter) ([]*MachineSession, error) {
+	if filter.MachineId != "" {
+		selected := make([]vms.Machine, 0)
+		for _, machine := range machines {
+			if machine.Id() == filter.MachineId {
+				selected = append(selected, machine)
+			}
+		}
+		machines = selected
+	}
+
+	sessions, _, err := ListReachableSessions(machines, port)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := make([]*MachineSession, 0)
+	for _, session := range sessions {
+		if filter.match(session) {
+			rt = append(rt, session)
+		}
+	}
+	return rt, nil
+}
@@ -0,0 +1,40 @@
+package sessions
+
+import "testing"
+
+func TestParseMachineSessionId(t *testing.T) {
+	id := MachineSessionId("2c1e5b4a-0b3d-4f5e-9a8b-7c6d5e4f3a2b", "3")
+
+	machineId, sessionId, err := ParseMachineSessionId(id)
+	if err != nil {
+		t.Fatalf("Cannot parse session id: %s", err.Error())
+	}
+	if machineId != "2c1e5b4a-0b3d-4f5e-9a8b-7c6d5e4f3a2b" || sessionId != "3" {
+		t.Errorf("Unexpected ids: %s, %s", machineId, sessionId)
+	}
+
+	for _, invalid := range []string{"", "machine", ":3", "machine:"} {
+		_, _, err = ParseMachineSessionId(invalid)
+		if err != InvalidSessionId {
+			t.Errorf("%q should not be a valid session id", invalid)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	session := &MachineSession{
+		MachineId: "machine",
+		UserId:    "user",
+		State:     normalizeState("Disc"),
+	}
+
+	if !(&Filter{}).match(session) {
+		t.Errorf("An empty filter should match all sessions")
+	}
+	if !(&Filter{State: "disconnected", UserId: "user"}).match(session) {
+		t.Errorf("The filter should match the session")
+	}
+	if (&Filter{MachineId: "other"}).match(session) {
+		t.Errorf("The filter should not match sessions of other machines")
+	}
+}
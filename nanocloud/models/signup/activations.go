@@ -0,0 +1,141 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package signup
+
+import (
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+// activationLifetime is the number of hours a self-registered user has to
+// activate the account.
+const activationLifetime = 48
+
+// SignUp creates a user who is not activated yet and returns the token
+// activating it. An email of a self-registration never activated can be
+// used again once its token expired.
+func SignUp(email, firstName, lastName, password string) (*users.User, string, error) {
+	settings := LoadSettings()
+	if !settings.Enabled {
+		return nil, "", SignupDisabled
+	}
+
+	email = NormalizeEmail(email)
+	if email == "" {
+		return nil, "", InvalidEmail
+	}
+
+	if !settings.Allows(email) {
+		return nil, "", DomainNotAllowed
+	}
+
+	_, err := db.Exec(
+		`DELETE FROM users
+		WHERE email = $1::varchar
+		AND activated = false
+		AND id IN (
+			SELECT user_id FROM user_activations WHERE expires_at < NOW()
+		)`,
+		email,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	user, err := users.CreateUser(false, email, firstName, lastName, password, false)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		users.DeleteUser(user.Id)
+		return nil, "", err
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO user_activations (token_hash, user_id, expires_at)
+		VALUES ($1::varchar, $2::varchar, NOW() + $3::integer * interval '1 hour')`,
+		hash, user.Id, activationLifetime,
+	)
+	if err != nil {
+		users.DeleteUser(user.Id)
+		return nil, "", err
+	}
+	return user, token, nil
+}
+
+// Activate activates the user of the token and returns its id. The token
+// can only be used once. create is called before the activation is
+// committed: if it fails, the token can be used again.
+func Activate(token string, create func(userId string) error) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+
+	rows, err := tx.Query(
+		`DELETE FROM user_activations
+		WHERE token_hash = $1::varchar
+		AND expires_at > NOW()
+		RETURNING user_id`,
+		hashToken(token),
+	)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	var userId string
+	if rows.Next() {
+		err = rows.Scan(&userId)
+	}
+	rows.Close()
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	if userId == "" {
+		tx.Rollback()
+		return "", InvalidActivationToken
+	}
+
+	_, err = tx.Exec(
+		`UPDATE users
+		SET activated = true
+		WHERE id = $1::varchar`,
+		userId,
+	)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	err = create(userId)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	return userId, tx.Commit()
+}
@@ -0,0 +1,331 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package signup
+
+import (
+	"database/sql"
+	"net/url"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/mail"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/query"
+	uuid "github.com/satori/go.uuid"
+)
+
+type Invitation struct {
+	Id        string `json:"-"`
+	Email     string `json:"email"`
+	InvitedBy string `json:"invited-by"`
+	CreatedAt int    `json:"created-at"`
+	ExpiresAt int    `json:"expires-at"`
+	Accepted  bool   `json:"accepted"`
+}
+
+func (i *Invitation) GetID() string {
+	return i.Id
+}
+
+func (i *Invitation) SetID(id string) error {
+	i.Id = id
+	return nil
+}
+
+// Columns the invitations can be filtered and sorted by.
+var Columns = query.Columns{
+	"email":      "email",
+	"created-at": "created_at",
+}
+
+const invitationColumns = `id, email, COALESCE(invited_by, ''),
+	extract(epoch from created_at), extract(epoch from expires_at),
+	accepted_at IS NOT NULL`
+
+func scanInvitations(rows *sql.Rows) ([]*Invitation, error) {
+	defer rows.Close()
+
+	invitations := make([]*Invitation, 0)
+	for rows.Next() {
+		var invitation Invitation
+		var createdAt, expiresAt float64
+
+		err := rows.Scan(
+			&invitation.Id, &invitation.Email, &invitation.InvitedBy,
+			&createdAt, &expiresAt,
+			&invitation.Accepted,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// javascript time is in millisecond not in second
+		invitation.CreatedAt = int(1000 * createdAt)
+		invitation.ExpiresAt = int(1000 * expiresAt)
+		invitations = append(invitations, &invitation)
+	}
+	return invitations, rows.Err()
+}
+
+func FindInvitations(q *query.Query) ([]*Invitation, int, error) {
+	where, args := q.Where(Columns, nil, nil)
+
+	total, err := db.Count(`SELECT count(*) FROM invitations`+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(
+		`SELECT `+invitationColumns+` FROM invitations`+where+q.OrderBy(Columns, "created_at DESC")+q.Limit(),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	invitations, err := scanInvitations(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return invitations, total, nil
+}
+
+func GetInvitation(id string) (*Invitation, error) {
+	rows, err := db.Query(`SELECT `+invitationColumns+` FROM invitations WHERE id = $1::varchar`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations, err := scanInvitations(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(invitations) == 0 {
+		return nil, InvitationNotFound
+	}
+	return invitations[0], nil
+}
+
+// FindInvitation returns the pending invitation of the token.
+func FindInvitation(token string) (*Invitation, error) {
+	rows, err := db.Query(
+		`SELECT `+invitationColumns+` FROM invitations
+		WHERE token_hash = $1::varchar
+		AND accepted_at IS NULL
+		AND expires_at > NOW()`,
+		hashToken(token),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations, err := scanInvitations(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(invitations) == 0 {
+		return nil, InvalidInvitation
+	}
+	return invitations[0], nil
+}
+
+// Invite creates an invitation for the email and returns the token to send
+// to it. A pending invitation of the same email is replaced.
+func Invite(email, invitedBy string) (*Invitation, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+
+	id, token, err := InviteTx(tx, email, invitedBy, false, nil)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, "", err
+	}
+
+	invitation, err := GetInvitation(id)
+	if err != nil {
+		return nil, "", err
+	}
+	return invitation, token, nil
+}
+
+// InviteTx creates the invitation within tx and returns its id and token.
+// The user created when it is accepted gets the rank and joins the groups
+// given.
+func InviteTx(tx *sql.Tx, email, invitedBy string, isAdmin bool, groupIds []string) (string, string, error) {
+	email = NormalizeEmail(email)
+	if email == "" {
+		return "", "", InvalidEmail
+	}
+
+	user, err := users.GetUserByEmail(email)
+	if err != nil {
+		return "", "", err
+	}
+	if user != nil {
+		return "", "", users.UserDuplicated
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM invitations
+		WHERE email = $1::varchar AND accepted_at IS NULL`,
+		email,
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	id := uuid.NewV4().String()
+	_, err = tx.Exec(
+		`INSERT INTO invitations (id, email, token_hash, invited_by, is_admin, expires_at)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::bool,
+			NOW() + $6::integer * interval '1 day')`,
+		id, email, hash, invitedBy, isAdmin, invitationLifetime(),
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, groupId := range groupIds {
+		_, err = tx.Exec(
+			`INSERT INTO invitations_groups (invitation_id, group_id)
+			VALUES ($1::varchar, $2::varchar)`,
+			id, groupId,
+		)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return id, token, nil
+}
+
+// InvitationMessage returns the email sending the invitation token.
+func InvitationMessage(email string, from *users.User, token string) *mail.Message {
+	link := mail.Link("sign-up", url.Values{"invitation": {token}})
+
+	return &mail.Message{
+		To:      email,
+		Subject: "You are invited to Nanocloud",
+		Body: "Hello,\n\n" +
+			from.FirstName + " " + from.LastName + " invited you to use Nanocloud. " +
+			"Follow this link to create your account:\n\n" +
+			link + "\n\n" +
+			"The invitation expires in a few days.\n",
+	}
+}
+
+// Revoke deletes the invitation. An accepted invitation is kept.
+func (i *Invitation) Revoke() error {
+	res, err := db.Exec(
+		`DELETE FROM invitations
+		WHERE id = $1::varchar AND accepted_at IS NULL`,
+		i.Id,
+	)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return InvalidInvitation
+	}
+	return nil
+}
+
+// Accept creates the activated user of the invitation, with its rank and
+// its groups. The token can only be used once. create is called before the
+// user is committed: if it fails, the invitation can be accepted again.
+func Accept(token, firstName, lastName, password string, create func(userId string) error) (*users.User, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(
+		`UPDATE invitations SET accepted_at = NOW()
+		WHERE token_hash = $1::varchar
+		AND accepted_at IS NULL
+		AND expires_at > NOW()
+		RETURNING id, email, is_admin`,
+		hashToken(token),
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var id, email string
+	var isAdmin bool
+	if rows.Next() {
+		err = rows.Scan(&id, &email, &isAdmin)
+	}
+	rows.Close()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if email == "" {
+		tx.Rollback()
+		return nil, InvalidInvitation
+	}
+
+	user, err := users.CreateUserTx(tx, true, email, firstName, lastName, password, isAdmin)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO groups_users (group_id, user_id)
+		SELECT group_id, $2::varchar FROM invitations_groups
+		WHERE invitation_id = $1::varchar`,
+		id, user.Id,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = create(user.Id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return user, tx.Commit()
+}
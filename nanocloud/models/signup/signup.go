@@ -0,0 +1,124 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package signup lets new users join Nanocloud: invited by an administrator
+// or, when enabled, signing up with an email of an allowed domain. The
+// accounts are only activated once the users prove they own the email.
+package signup
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Nanocloud/community/nanocloud/config"
+)
+
+var (
+	SignupDisabled         = errors.New("signup disabled")
+	DomainNotAllowed       = errors.New("email domain not allowed")
+	InvalidEmail           = errors.New("invalid email")
+	InvalidInvitation      = errors.New("invalid invitation")
+	InvalidActivationToken = errors.New("invalid activation token")
+	InvitationNotFound     = errors.New("invitation not found")
+)
+
+// Settings of the public signup, set by the signup_* configuration keys.
+type Settings struct {
+	Enabled bool     `json:"enabled"`
+	Domains []string `json:"domains"`
+}
+
+func LoadSettings() Settings {
+	values := config.Get("signup_enabled", "signup_allowed_domains")
+
+	settings := Settings{
+		Enabled: values["signup_enabled"] == "true",
+		Domains: make([]string, 0),
+	}
+
+	for _, domain := range strings.Split(values["signup_allowed_domains"], ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" {
+			settings.Domains = append(settings.Domains, domain)
+		}
+	}
+	return settings
+}
+
+// NormalizeEmail returns the email in lower case, or an empty string if it
+// is not an address.
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	at := strings.LastIndex(email, "@")
+	if at < 1 || at == len(email)-1 || strings.ContainsAny(email, " \t\r\n,;<>") {
+		return ""
+	}
+	return email
+}
+
+// Allows tells whether the email can be used to sign up. Without allowed
+// domains, any email can.
+func (s Settings) Allows(email string) bool {
+	if len(s.Domains) == 0 {
+		return true
+	}
+
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, allowed := range s.Domains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// invitationLifetime returns the number of days an invitation can be
+// accepted, set by the invitation_lifetime configuration key.
+func invitationLifetime() int {
+	days, err := strconv.Atoi(config.Get("invitation_lifetime")["invitation_lifetime"])
+	if err != nil || days <= 0 {
+		return 7
+	}
+	return days
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a random token and its hash.
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+
+	token := hex.EncodeToString(b)
+	return token, hashToken(token), nil
+}
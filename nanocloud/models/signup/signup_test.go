@@ -0,0 +1,43 @@
+package signup
+
+import "testing"
+
+func TestNormalizeEmail(t *testing.T) {
+	cases := map[string]string{
+		" John.Doe@Example.COM ": "john.doe@example.com",
+		"john@example.com":       "john@example.com",
+		"john":                   "",
+		"@example.com":           "",
+		"john@":                  "",
+		"john doe@example.com":   "",
+		"a@b.com, c@d.com":       "",
+	}
+
+	for email, expected := range cases {
+		if actual := NormalizeEmail(email); actual != expected {
+			t.Errorf("NormalizeEmail(%q) = %q, expected %q", email, actual, expected)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	any := Settings{Enabled: true}
+	if !any.Allows("john@example.com") {
+		t.Error("without domains any email should be allowed")
+	}
+
+	settings := Settings{Enabled: true, Domains: []string{"example.com", "nanocloud.com"}}
+	cases := map[string]bool{
+		"john@example.com":      true,
+		"jane@nanocloud.com":    true,
+		"john@mail.example.com": false,
+		"john@example.com.evil": false,
+		"john@notexample.com":   false,
+	}
+
+	for email, expected := range cases {
+		if actual := settings.Allows(email); actual != expected {
+			t.Errorf("Allows(%q) = %v, expected %v", email, actual, expected)
+		}
+	}
+}
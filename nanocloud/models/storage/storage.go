@@ -0,0 +1,197 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/Nanocloud/community/nanocloud/config"
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/uploads"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/plaza"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	QuotaExceeded = errors.New("quota exceeded")
+)
+
+// Storage usage of a user in bytes.
+// A quota of 0 means the storage is unlimited, in which case Available is
+// meaningless.
+type Usage struct {
+	Used      int64
+	Quota     int64
+	Available int64
+}
+
+// Return the quota of the users that don't have their own. The `storage_quota`
+// configuration key takes precedence over the STORAGE_QUOTA environment
+// variable. 0 means unlimited.
+func DefaultQuota() int64 {
+	value, ok := config.Get("storage_quota")["storage_quota"]
+	if !ok {
+		value = utils.Env("STORAGE_QUOTA", "0")
+	}
+
+	quota, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || quota < 0 {
+		log.Errorf("Invalid storage quota: %s", value)
+		return 0
+	}
+	return quota
+}
+
+// Set the quota of the users that don't have their own.
+func SetDefaultQuota(quota int64) {
+	config.Set("storage_quota", strconv.FormatInt(quota, 10))
+}
+
+// Return the quota of the user: its own quota if any, the default one otherwise.
+func UserQuota(userId string) (int64, error) {
+	rows, err := db.Query(
+		`SELECT storage_quota
+		FROM users
+		WHERE id = $1::varchar`,
+		userId,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return 0, users.UserNotFound
+	}
+
+	var quota sql.NullInt64
+	err = rows.Scan(&quota)
+	if err != nil {
+		return 0, err
+	}
+
+	if quota.Valid {
+		return quota.Int64, nil
+	}
+	return DefaultQuota(), nil
+}
+
+// Override the quota of the user. A negative quota removes the override so
+// the default quota applies again.
+func SetUserQuota(userId string, quota int64) error {
+	value := sql.NullInt64{Int64: quota, Valid: quota >= 0}
+
+	res, err := db.Exec(
+		`UPDATE users
+		SET storage_quota = $1
+		WHERE id = $2::varchar`,
+		value, userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return users.UserNotFound
+	}
+	return nil
+}
+
+// Return the number of bytes stored by the user on the execution server plus
+// the length of its uploads in progress.
+func used(user *users.User) (int64, error) {
+	winUser, err := user.WindowsCredentials()
+	if err != nil {
+		return 0, err
+	}
+
+	port, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
+	if err != nil {
+		return 0, err
+	}
+
+	stored, err := plaza.StorageUsage(utils.Env("PLAZA_ADDRESS", "iaas-module"), port, winUser.Sam)
+	if err != nil {
+		return 0, err
+	}
+
+	pending, err := uploads.PendingLength(user.GetID())
+	if err != nil {
+		return 0, err
+	}
+	return stored + pending, nil
+}
+
+func GetUsage(user *users.User) (*Usage, error) {
+	quota, err := UserQuota(user.GetID())
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := used(user)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := Usage{
+		Used:  used,
+		Quota: quota,
+	}
+	if quota > 0 {
+		usage.Available = quota - used
+		if usage.Available < 0 {
+			usage.Available = 0
+		}
+	}
+	return &usage, nil
+}
+
+// Check that the user can store length more bytes.
+func CheckQuota(user *users.User, length int64) error {
+	quota, err := UserQuota(user.GetID())
+	if err != nil {
+		return err
+	}
+
+	if quota == 0 {
+		return nil
+	}
+
+	used, err := used(user)
+	if err != nil {
+		return err
+	}
+
+	if used+length > quota {
+		return QuotaExceeded
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"log"
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+var user = &users.User{}
+
+func init() {
+	new_user, err := users.CreateUser(
+		true,
+		"quota@nanocloud.com",
+		"Test",
+		"user",
+		"secret",
+		false,
+	)
+
+	if err != nil {
+		log.Panicln("Can't create new account:", err.Error())
+	}
+	if new_user == nil {
+		log.Panicln("Can't create new account")
+	}
+	user = new_user
+}
+
+func TestUserQuota(t *testing.T) {
+	quota, err := UserQuota(user.GetID())
+	if err != nil {
+		t.Fatalf("Cannot get quota: %s", err.Error())
+	}
+	if quota != DefaultQuota() {
+		t.Errorf("A user without quota should have the default quota")
+	}
+
+	_, err = UserQuota("unknown-user")
+	if err != users.UserNotFound {
+		t.Errorf("Unknown users should not have a quota")
+	}
+}
+
+func TestSetUserQuota(t *testing.T) {
+	err := SetUserQuota(user.GetID(), 1024)
+	if err != nil {
+		t.Fatalf("Cannot set quota: %s", err.Error())
+	}
+
+	quota, err := UserQuota(user.GetID())
+	if err != nil {
+		t.Fatalf("Cannot get quota: %s", err.Error())
+	}
+	if quota != 1024 {
+		t.Errorf("Unexpected quota: Expected 1024, have %d", quota)
+	}
+
+	err = SetUserQuota(user.GetID(), -1)
+	if err != nil {
+		t.Fatalf("Cannot reset quota: %s", err.Error())
+	}
+
+	quota, err = UserQuota(user.GetID())
+	if err != nil {
+		t.Fatalf("Cannot get quota: %s", err.Error())
+	}
+	if quota != DefaultQuota() {
+		t.Errorf("A reset quota should be the default quota")
+	}
+
+	err = users.DeleteUser(user.GetID())
+	if err != nil {
+		t.Errorf("Can't delete user: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,304 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package throttle slows down password guessing on the login endpoints.
+// Failed attempts are counted per account and per address over a sliding
+// window: each failure of an account past a few ones doubles the delay
+// before the next attempt, too many lock the account for a while and too
+// many from an address block it until older failures leave the window.
+package throttle
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/config"
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	log "github.com/Sirupsen/logrus"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Attempts older than this are removed.
+const retention = 30 * 24 * time.Hour
+
+// Settings of the throttling, set by the login_* configuration keys.
+type Settings struct {
+	// Failures older than Window are not counted.
+	Window time.Duration
+
+	// Failures of an account allowed before the delays.
+	DelayAfter int
+	MaxDelay   time.Duration
+
+	// Failures of an account locking it for LockoutDuration.
+	MaxFailures     int
+	LockoutDuration time.Duration
+
+	// Failures from an address blocking it.
+	IPMaxFailures int
+}
+
+func parseInt(value string, def int) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+func LoadSettings() Settings {
+	values := config.Get(
+		"login_window",
+		"login_delay_after",
+		"login_max_delay",
+		"login_max_failures",
+		"login_lockout_duration",
+		"login_ip_max_failures",
+	)
+
+	return Settings{
+		Window:          time.Duration(parseInt(values["login_window"], 900)) * time.Second,
+		DelayAfter:      parseInt(values["login_delay_after"], 3),
+		MaxDelay:        time.Duration(parseInt(values["login_max_delay"], 30)) * time.Second,
+		MaxFailures:     parseInt(values["login_max_failures"], 10),
+		LockoutDuration: time.Duration(parseInt(values["login_lockout_duration"], 900)) * time.Second,
+		IPMaxFailures:   parseInt(values["login_ip_max_failures"], 50),
+	}
+}
+
+// counters are the recent failures of an account and an address.
+type counters struct {
+	// Failures of the account since its last success or unlock.
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+
+	// Failure from the address that must leave the window for the address
+	// to be below IPMaxFailures, zero if it already is.
+	ipThreshold time.Time
+}
+
+// delay is the time to wait after the last of the failures.
+func (s Settings) delay(failures int) time.Duration {
+	if failures < s.DelayAfter {
+		return 0
+	}
+
+	n := uint(failures - s.DelayAfter)
+	if n > 30 {
+		return s.MaxDelay
+	}
+
+	d := time.Second << n
+	if d > s.MaxDelay {
+		return s.MaxDelay
+	}
+	return d
+}
+
+// wait returns how long the next attempt must be delayed.
+func (s Settings) wait(c counters, now time.Time) time.Duration {
+	var wait time.Duration
+
+	if c.lockedUntil.After(now) {
+		wait = c.lockedUntil.Sub(now)
+	}
+
+	if c.failures > 0 {
+		d := c.lastFailure.Add(s.delay(c.failures)).Sub(now)
+		if d > wait {
+			wait = d
+		}
+	}
+
+	if !c.ipThreshold.IsZero() {
+		d := c.ipThreshold.Add(s.Window).Sub(now)
+		if d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+func normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func fromEpoch(epoch float64) time.Time {
+	if epoch == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(epoch*float64(time.Second)))
+}
+
+// accountCounters returns the failures of the account and the current time
+// of the database the attempts are dated with.
+func accountCounters(s Settings, email string) (counters, time.Time, error) {
+	var c counters
+
+	rows, err := db.Query(
+		`SELECT count(*),
+			COALESCE(extract(epoch from max(created_at)), 0),
+			COALESCE((SELECT extract(epoch from max(locked_until))
+				FROM users WHERE lower(email) = $1::varchar), 0),
+			extract(epoch from NOW())
+		FROM login_attempts
+		WHERE email = $1::varchar
+		AND NOT success
+		AND created_at > NOW() - $2::integer * interval '1 second'
+		AND created_at > COALESCE((SELECT max(created_at) FROM login_attempts
+			WHERE email = $1::varchar AND success), 'epoch')
+		AND created_at > COALESCE((SELECT max(locked_until) FROM users
+			WHERE lower(email) = $1::varchar), 'epoch')`,
+		email, int(s.Window/time.Second),
+	)
+	if err != nil {
+		return c, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var last, locked, now float64
+	if rows.Next() {
+		err = rows.Scan(&c.failures, &last, &locked, &now)
+		if err != nil {
+			return c, time.Time{}, err
+		}
+	}
+
+	c.lastFailure = fromEpoch(last)
+	c.lockedUntil = fromEpoch(locked)
+	return c, fromEpoch(now), rows.Err()
+}
+
+// ipThreshold returns the date of the IPMaxFailures-th most recent failure
+// from the address in the window.
+func ipThreshold(s Settings, ip string) (time.Time, error) {
+	rows, err := db.Query(
+		`SELECT extract(epoch from created_at)
+		FROM login_attempts
+		WHERE ip = $1::varchar
+		AND NOT success
+		AND created_at > NOW() - $2::integer * interval '1 second'
+		ORDER BY created_at DESC
+		OFFSET $3::integer LIMIT 1`,
+		ip, int(s.Window/time.Second), s.IPMaxFailures-1,
+	)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer rows.Close()
+
+	var epoch float64
+	if rows.Next() {
+		err = rows.Scan(&epoch)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return fromEpoch(epoch), rows.Err()
+}
+
+// Check returns how long a client must wait before trying to log in as
+// email from ip, 0 if it can try now.
+func Check(email, ip string) (time.Duration, error) {
+	s := LoadSettings()
+
+	c, now, err := accountCounters(s, normalize(email))
+	if err != nil {
+		return 0, err
+	}
+
+	c.ipThreshold, err = ipThreshold(s, ip)
+	if err != nil {
+		return 0, err
+	}
+	return s.wait(c, now), nil
+}
+
+// Record saves the outcome of a login attempt and locks the account after
+// too many failures.
+func Record(email, ip, userAgent string, success bool) error {
+	email = normalize(email)
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	_, err := db.Exec(
+		`INSERT INTO login_attempts (id, email, ip, user_agent, success)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::varchar, $5::boolean)`,
+		uuid.NewV4().String(), email, ip, userAgent, success,
+	)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		db.Exec(
+			`DELETE FROM login_attempts
+			WHERE created_at < NOW() - $1::integer * interval '1 second'`,
+			int(retention/time.Second),
+		)
+		return nil
+	}
+
+	s := LoadSettings()
+	c, _, err := accountCounters(s, email)
+	if err != nil {
+		return err
+	}
+
+	if c.failures < s.MaxFailures {
+		return nil
+	}
+
+	res, err := db.Exec(
+		`UPDATE users
+		SET locked_until = NOW() + $2::integer * interval '1 second'
+		WHERE lower(email) = $1::varchar
+		AND (locked_until IS NULL OR locked_until < NOW())`,
+		email, int(s.LockoutDuration/time.Second),
+	)
+	if err != nil {
+		return err
+	}
+
+	locked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if locked > 0 {
+		log.Warnf("[Throttle] %s locked after %d failed login attempts, the last one from %s", email, c.failures, ip)
+	}
+	return nil
+}
+
+// Unlock lifts the lockout of the user and resets the count of failures.
+func Unlock(userId string) error {
+	_, err := db.Exec(
+		`UPDATE users SET locked_until = NOW()
+		WHERE id = $1::varchar AND locked_until > NOW()`,
+		userId,
+	)
+	return err
+}
@@ -0,0 +1,87 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+var settings = Settings{
+	Window:          15 * time.Minute,
+	DelayAfter:      3,
+	MaxDelay:        30 * time.Second,
+	MaxFailures:     10,
+	LockoutDuration: 15 * time.Minute,
+	IPMaxFailures:   50,
+}
+
+func TestDelay(t *testing.T) {
+	expected := []time.Duration{
+		0, 0, 0,
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		16 * time.Second,
+		30 * time.Second,
+		30 * time.Second,
+	}
+
+	for failures, want := range expected {
+		got := settings.delay(failures)
+		if got != want {
+			t.Errorf("delay(%d) = %s, want %s", failures, got, want)
+		}
+	}
+
+	if settings.delay(1000) != settings.MaxDelay {
+		t.Errorf("The delay should not overflow")
+	}
+}
+
+func TestWait(t *testing.T) {
+	now := time.Unix(1460000000, 0)
+
+	wait := settings.wait(counters{}, now)
+	if wait != 0 {
+		t.Errorf("A client without failures should not wait, got %s", wait)
+	}
+
+	wait = settings.wait(counters{
+		failures:    5,
+		lastFailure: now.Add(-time.Second),
+	}, now)
+	if wait != 3*time.Second {
+		t.Errorf("Expected to wait 3s after the fifth failure, got %s", wait)
+	}
+
+	wait = settings.wait(counters{
+		failures:    5,
+		lastFailure: now.Add(-time.Minute),
+	}, now)
+	if wait > 0 {
+		t.Errorf("The delay should be over, got %s", wait)
+	}
+
+	wait = settings.wait(counters{
+		lockedUntil: now.Add(10 * time.Minute),
+	}, now)
+	if wait != 10*time.Minute {
+		t.Errorf("A locked account should wait for the end of the lockout, got %s", wait)
+	}
+
+	wait = settings.wait(counters{
+		lockedUntil: now.Add(-time.Minute),
+	}, now)
+	if wait > 0 {
+		t.Errorf("An expired lockout should not delay, got %s", wait)
+	}
+
+	wait = settings.wait(counters{
+		failures:    4,
+		lastFailure: now,
+		ipThreshold: now.Add(-5 * time.Minute),
+	}, now)
+	if wait != 10*time.Minute {
+		t.Errorf("A blocked address should wait for failures to leave the window, got %s", wait)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package uploads
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	UploadNotFound   = errors.New("upload not found")
+	UploadNotCreated = errors.New("upload not created")
+	UploadTooLarge   = errors.New("upload too large")
+)
+
+// Maximum size of a single upload in bytes. 0 means no limit.
+var kMaxSize int64
+
+type Upload struct {
+	Id        string `json:"-"`
+	UserId    string `json:"user-id"`
+	Filename  string `json:"filename"`
+	Length    int64  `json:"length"`
+	Offset    int64  `json:"offset"`
+	Completed bool   `json:"completed"`
+}
+
+func (u *Upload) GetID() string {
+	return u.Id
+}
+
+func (u *Upload) SetID(id string) error {
+	u.Id = id
+	return nil
+}
+
+// Return the number of bytes of the user's uploads that are not completed yet.
+func PendingLength(userId string) (int64, error) {
+	rows, err := db.Query(
+		`SELECT COALESCE(SUM(length), 0)
+		FROM uploads
+		WHERE user_id = $1::varchar
+		AND completed = false`,
+		userId,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var length int64
+	if rows.Next() {
+		err = rows.Scan(&length)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return length, rows.Err()
+}
+
+// Check that the length doesn't exceed the maximum size of an upload.
+func CheckSize(length int64) error {
+	if kMaxSize > 0 && length > kMaxSize {
+		return UploadTooLarge
+	}
+	return nil
+}
+
+func CreateUpload(userId string, filename string, length int64) (*Upload, error) {
+	id := uuid.NewV4().String()
+
+	rows, err := db.Query(
+		`INSERT INTO uploads
+		(id, user_id, filename, length)
+		VALUES($1::varchar, $2::varchar, $3::varchar, $4::bigint)
+		RETURNING id, user_id, filename, length, completed`,
+		id, userId, filename, length,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, UploadNotCreated
+	}
+
+	var upload Upload
+	err = rows.Scan(
+		&upload.Id, &upload.UserId,
+		&upload.Filename, &upload.Length,
+		&upload.Completed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// Return the upload with the specified id if it belongs to the user.
+func GetUpload(userId string, id string) (*Upload, error) {
+	rows, err := db.Query(
+		`SELECT id, user_id, filename, length, completed
+		FROM uploads
+		WHERE id = $1::varchar
+		AND user_id = $2::varchar`,
+		id, userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, UploadNotFound
+	}
+
+	var upload Upload
+	err = rows.Scan(
+		&upload.Id, &upload.UserId,
+		&upload.Filename, &upload.Length,
+		&upload.Completed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func SetCompleted(id string) error {
+	res, err := db.Exec(
+		`UPDATE uploads
+		SET completed = true
+		WHERE id = $1::varchar`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return UploadNotFound
+	}
+	return nil
+}
+
+func DeleteUpload(id string) error {
+	res, err := db.Exec("DELETE FROM uploads WHERE id = $1::varchar", id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return UploadNotFound
+	}
+	return nil
+}
+
+func init() {
+	var err error
+	kMaxSize, err = strconv.ParseInt(utils.Env("UPLOAD_MAX_SIZE", "0"), 10, 64)
+	if err != nil {
+		panic("UPLOAD_MAX_SIZE must be a number of bytes")
+	}
+}
@@ -0,0 +1,136 @@
+package uploads
+
+import (
+	"log"
+	"testing"
+
+	"github.com/Nanocloud/community/nanocloud/models/users"
+)
+
+var (
+	user     = &users.User{}
+	id       = ""
+	filename = "report.pdf"
+	length   = int64(4096)
+)
+
+func init() {
+	new_user, err := users.CreateUser(
+		true,
+		"uploader@nanocloud.com",
+		"Test",
+		"user",
+		"secret",
+		false,
+	)
+
+	if err != nil {
+		log.Panicln("Can't create new account:", err.Error())
+	}
+	if new_user == nil {
+		log.Panicln("Can't create new account")
+	}
+	user = new_user
+}
+
+func TestCreateUpload(t *testing.T) {
+	upload, err := CreateUpload(user.GetID(), filename, length)
+	if err != nil {
+		t.Fatalf("Cannot create upload: %s", err.Error())
+	}
+
+	switch {
+	case upload.Id == "":
+		t.Errorf("'upload.Id' field should be set")
+	case upload.UserId != user.GetID():
+		t.Errorf("'upload.UserId' field doesn't match the inserted value")
+	case upload.Filename != filename:
+		t.Errorf("'upload.Filename' field doesn't match the inserted value")
+	case upload.Length != length:
+		t.Errorf("'upload.Length' field doesn't match the inserted value")
+	case upload.Completed:
+		t.Errorf("'upload.Completed' field should be false")
+	}
+	id = upload.Id
+}
+
+func TestGetUpload(t *testing.T) {
+	upload, err := GetUpload(user.GetID(), id)
+	if err != nil {
+		t.Fatalf("Cannot get upload: %s", err.Error())
+	}
+	if upload.Id != id {
+		t.Errorf("Unexpected upload returned")
+	}
+
+	_, err = GetUpload("another-user", id)
+	if err != UploadNotFound {
+		t.Errorf("An upload should only be returned to its owner")
+	}
+}
+
+func TestPendingLength(t *testing.T) {
+	pending, err := PendingLength(user.GetID())
+	if err != nil {
+		t.Fatalf("Cannot get pending length: %s", err.Error())
+	}
+	if pending != length {
+		t.Errorf("Unexpected pending length: Expected %d, have %d", length, pending)
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	kMaxSize = 1000
+	defer func() {
+		kMaxSize = 0
+	}()
+
+	if CheckSize(1001) != UploadTooLarge {
+		t.Errorf("An upload larger than the maximum size should be rejected")
+	}
+
+	err := CheckSize(1000)
+	if err != nil {
+		t.Errorf("An upload within the maximum size should be accepted: %s", err)
+	}
+}
+
+func TestSetCompleted(t *testing.T) {
+	err := SetCompleted(id)
+	if err != nil {
+		t.Fatalf("Cannot complete upload: %s", err.Error())
+	}
+
+	upload, err := GetUpload(user.GetID(), id)
+	if err != nil {
+		t.Fatalf("Cannot get upload: %s", err.Error())
+	}
+	if !upload.Completed {
+		t.Errorf("'upload.Completed' field should be true")
+	}
+
+	pending, err := PendingLength(user.GetID())
+	if err != nil {
+		t.Fatalf("Cannot get pending length: %s", err.Error())
+	}
+	if pending != 0 {
+		t.Errorf("Completed uploads should not be pending")
+	}
+}
+
+func TestDeleteUpload(t *testing.T) {
+	err := DeleteUpload(id)
+	if err != nil {
+		t.Fatalf("Cannot delete upload: %s", err.Error())
+	}
+
+	_, err = GetUpload(user.GetID(), id)
+	if err != UploadNotFound {
+		t.Errorf("Upload exists even after deletion")
+	}
+
+	err = users.DeleteUser(user.GetID())
+	if err != nil {
+		t.Errorf("Can't delete user: %s\n", err.Error())
+	}
+}
@@ -21,6 +21,19 @@ type User struct {
 	FirstName  string `json:"first-name"`
 	LastName   string `json:"last-name"`
 	SignupDate int    `json:"signup-date,omitempty"`
+
+	// Locked is true while the user cannot log in after too many failed
+	// attempts.
+	Locked bool `json:"locked"`
+
+	// Name of the provider checking the credentials of the user, "local"
+	// for the password stored in the users table.
+	AuthProvider string `json:"-"`
+
+	// Storage in bytes. A quota of 0 means unlimited.
+	StorageQuota     *int64 `json:"storage-quota,omitempty"`
+	StorageUsed      *int64 `json:"storage-used,omitempty"`
+	StorageAvailable *int64 `json:"storage-available,omitempty"`
 }
 
 func (u *User) GetID() string {
@@ -23,14 +23,19 @@
 package users
 
 import (
+	"database/sql"
 	errors "errors"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/query"
 	log "github.com/Sirupsen/logrus"
 	uuid "github.com/satori/go.uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Provider of the users authenticated with the password of the users table.
+const LocalProvider = "local"
+
 var (
 	UserNotFound       = errors.New("user not found")
 	InvalidCredentials = errors.New("invalid credentials")
@@ -48,7 +53,7 @@ func GetUserFromEmailPassword(email, password string) (*User, error) {
 		`SELECT id, activated,
 		email, password,
 		first_name, last_name,
-		is_admin
+		is_admin, auth_provider
 		FROM users
 		WHERE email = $1::varchar`,
 		email,
@@ -67,10 +72,15 @@ func GetUserFromEmailPassword(email, password string) (*User, error) {
 		&user.Id, &user.Activated,
 		&user.Email, &passwordHash,
 		&user.FirstName, &user.LastName,
-		&user.IsAdmin,
+		&user.IsAdmin, &user.AuthProvider,
 	)
 	rows.Close()
 
+	// The local password of the users of a directory is not used
+	if user.AuthProvider != LocalProvider {
+		return nil, InvalidCredentials
+	}
+
 	err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
 
 	if err != nil {
@@ -84,17 +94,38 @@ func GetUserFromEmailPassword(email, password string) (*User, error) {
 	return &user, nil
 }
 
-func FindUsers() ([]*User, error) {
+// Columns the users can be filtered and sorted by.
+var Columns = query.Columns{
+	"email":       "email",
+	"first-name":  "first_name",
+	"last-name":   "last_name",
+	"is-admin":    "is_admin",
+	"activated":   "activated",
+	"signup-date": "signup_date",
+}
+
+// FindUsers returns the page of users selected by q and the number of users
+// matching its filters.
+func FindUsers(q *query.Query) ([]*User, int, error) {
+	where, args := q.Where(Columns, nil, nil)
+
+	total, err := db.Count(`SELECT count(*) FROM users`+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	rows, err := db.Query(
 		`SELECT id, first_name, last_name, email,
-			is_admin, activated, extract(epoch from signup_date)
-		FROM users`,
+			is_admin, activated, extract(epoch from signup_date),
+			COALESCE(locked_until > NOW(), false)
+		FROM users`+where+q.OrderBy(Columns, "email")+q.Limit(),
+		args...,
 	)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	var users []*User
+	users := make([]*User, 0)
 	var timestamp float64
 
 	defer rows.Close()
@@ -108,6 +139,7 @@ func FindUsers() ([]*User, error) {
 			&user.IsAdmin,
 			&user.Activated,
 			&timestamp,
+			&user.Locked,
 		)
 		// javascript time is in millisecond not in second
 		user.SignupDate = int(1000 * timestamp)
@@ -116,10 +148,10 @@ func FindUsers() ([]*User, error) {
 
 	err = rows.Err()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return users, nil
+	return users, total, nil
 }
 
 func UserExists(id string) (bool, error) {
@@ -151,6 +183,15 @@ func DisableUser(id string) error {
 	return err
 }
 
+func EnableUser(id string) error {
+	_, err := db.Exec(
+		`UPDATE users
+		SET activated = true
+		WHERE id = $1::varchar`,
+		id)
+	return err
+}
+
 func CreateUser(
 	activated bool,
 	email string,
@@ -158,6 +199,30 @@ func CreateUser(
 	lastName string,
 	password string,
 	isAdmin bool,
+) (*User, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := CreateUserTx(tx, activated, email, firstName, lastName, password, isAdmin)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return user, tx.Commit()
+}
+
+// CreateUserTx creates the user within tx, for the operations creating
+// several users at once.
+func CreateUserTx(
+	tx *sql.Tx,
+	activated bool,
+	email string,
+	firstName string,
+	lastName string,
+	password string,
+	isAdmin bool,
 ) (*User, error) {
 	id := uuid.NewV4().String()
 
@@ -166,7 +231,7 @@ func CreateUser(
 		return nil, err
 	}
 
-	rows, err := db.Query(
+	rows, err := tx.Query(
 		`INSERT INTO users
     (id, email, activated,
     first_name, last_name,
@@ -201,7 +266,7 @@ func CreateUser(
 	}
 
 	var user User
-	rows.Scan(
+	err = rows.Scan(
 		&user.Id, &user.Email,
 		&user.Activated, &user.FirstName,
 		&user.LastName, &user.IsAdmin,
@@ -333,10 +398,63 @@ func UpdateUserLastName(id string, lastname string) error {
 	return nil
 }
 
+// Truncate fits a value in the varchar(36) columns of the users table.
+func Truncate(s string) string {
+	r := []rune(s)
+	if len(r) > 36 {
+		return string(r[:36])
+	}
+	return s
+}
+
+// GetUserByEmail returns the user with the email, or nil.
+func GetUserByEmail(email string) (*User, error) {
+	rows, err := db.Query(
+		`SELECT id FROM users WHERE email = $1::varchar`,
+		email,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var id string
+	if rows.Next() {
+		err = rows.Scan(&id)
+	}
+	rows.Close()
+	if err != nil || id == "" {
+		return nil, err
+	}
+	return GetUser(id)
+}
+
+// CreateExternalUser creates an activated user whose credentials are
+// checked by the provider. The user has no usable local password.
+func CreateExternalUser(provider, email, firstName, lastName string, isAdmin bool) (*User, error) {
+	user, err := CreateUser(true, email, firstName, lastName, uuid.NewV4().String(), isAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(
+		`UPDATE users
+		SET auth_provider = $2::varchar, password = ''
+		WHERE id = $1::varchar`,
+		user.Id, provider,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.AuthProvider = provider
+	return user, nil
+}
+
 func GetUser(id string) (*User, error) {
 	rows, err := db.Query(
 		`SELECT id, first_name, last_name, email, is_admin,
-			activated, extract(epoch from signup_date)
+			activated, extract(epoch from signup_date), auth_provider,
+			COALESCE(locked_until > NOW(), false)
 		FROM users
 		WHERE id = $1::varchar`,
 		id)
@@ -357,6 +475,8 @@ func GetUser(id string) (*User, error) {
 			&user.IsAdmin,
 			&user.Activated,
 			&timestamp,
+			&user.AuthProvider,
+			&user.Locked,
 		)
 		if err != nil {
 			return nil, err
@@ -2,6 +2,7 @@ package users
 
 import (
 	"log"
+	"strings"
 	"testing"
 )
 
@@ -146,6 +147,30 @@ func TestDisableUser(t *testing.T) {
 	}
 }
 
+func TestEnableUser(t *testing.T) {
+	err := EnableUser(id)
+
+	if err != nil {
+		t.Fatalf("Cannot enable user: %s", err.Error())
+	}
+
+	user := getUser(id, "Nil user was returned")
+	if user.Activated != true {
+		t.Fatalf("'user.Activated' field should be true\n")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if s := Truncate("short"); s != "short" {
+		t.Errorf("unexpected %q", s)
+	}
+
+	long := strings.Repeat("é", 40)
+	if s := Truncate(long); len([]rune(s)) != 36 {
+		t.Errorf("expected 36 runes, got %d", len([]rune(s)))
+	}
+}
+
 func TestDeleteUser(t *testing.T) {
 	err := DeleteUser(id)
 
@@ -0,0 +1,353 @@
+package oauth2
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// AuthorizationClient is implemented by the clients that can use the
+// authorization code grant.
+type AuthorizationClient interface {
+	// Clients without redirect URIs are first-party applications.
+	ThirdParty() bool
+	DisplayName() string
+	HasRedirectURI(uri string) bool
+	AllowsScope(scope string) bool
+}
+
+// What the user authorized the client to do.
+type AuthorizationGrant struct {
+	RedirectURI   string
+	Scope         string
+	CodeChallenge string
+}
+
+// Clients that registered redirect URIs are third-party applications.
+func isThirdParty(client interface{}) bool {
+	c, ok := client.(AuthorizationClient)
+	return ok && c.ThirdParty()
+}
+
+// VerifyCodeChallenge checks a PKCE verifier against the S256 challenge sent
+// with the authorization request.
+func VerifyCodeChallenge(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sign in with Nanocloud</title>
+<style>
+body { font-family: sans-serif; background: #f5f5f5; }
+form { width: 320px; margin: 80px auto; padding: 24px; background: #fff; border-radius: 4px; }
+input[type=email], input[type=password], input[type=text] { width: 100%; margin-bottom: 12px; padding: 6px; box-sizing: border-box; }
+.error { color: #c0392b; }
+</style>
+</head>
+<body>
+<form method="post" action="/oauth/authorize">
+	<h2>Sign in with Nanocloud</h2>
+	<p><strong>{{.Client}}</strong> wants to access your account:</p>
+	<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+	{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+	{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+	{{end}}
+	{{if .MFAToken}}<input type="hidden" name="mfa_token" value="{{.MFAToken}}">
+	<input type="text" name="otp" placeholder="Authentication code" autocomplete="one-time-code" required>
+	{{else}}<input type="email" name="username" placeholder="Email" value="{{.Username}}" required>
+	<input type="password" name="password" placeholder="Password">
+	{{end}}	<button type="submit" name="action" value="allow">Allow</button>
+	<button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+</form>
+</body>
+</html>
+`))
+
+type consentPage struct {
+	Client   string
+	Scopes   []string
+	Params   map[string]string
+	Username string
+	MFAToken string
+	Error    string
+}
+
+func renderConsent(res http.ResponseWriter, page consentPage) {
+	res.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	res.Header().Set("X-Frame-Options", "DENY")
+
+	err := consentTemplate.Execute(res, page)
+	if err != nil {
+		log.Error("[OAuth] Unable to render the consent page: " + err.Error())
+	}
+}
+
+// authorizeErrorPage is shown when the client or the redirect URI cannot be
+// trusted, so the user is not redirected.
+func authorizeErrorPage(res http.ResponseWriter, description string) {
+	res.Header().Set("Content-Type", "text/plain;charset=UTF-8")
+	res.WriteHeader(http.StatusBadRequest)
+	res.Write([]byte(description))
+}
+
+func redirectWith(res http.ResponseWriter, req *http.Request, redirectURI string, params url.Values) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		authorizeErrorPage(res, "Invalid redirect_uri")
+		return
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(res, req, u.String(), http.StatusFound)
+}
+
+func redirectError(res http.ResponseWriter, req *http.Request, redirectURI, state, code, description string) {
+	redirectWith(res, req, redirectURI, url.Values{
+		"error":             {code},
+		"error_description": {description},
+		"state":             {state},
+	})
+}
+
+// authorize implements the authorization endpoint of the authorization code
+// grant. GET shows a login and consent page that posts back to the same
+// endpoint. PKCE (S256) is mandatory.
+func authorize(res http.ResponseWriter, req *http.Request) {
+	fail := req.ParseForm()
+	if fail != nil {
+		authorizeErrorPage(res, "Unable to parse the request")
+		return
+	}
+
+	clientKey := req.FormValue("client_id")
+	redirectURI := req.FormValue("redirect_uri")
+	state := req.FormValue("state")
+
+	if clientKey == "" {
+		authorizeErrorPage(res, "client_id is missing")
+		return
+	}
+
+	rawClient, fail := kConnector.FindClient(clientKey)
+	if fail != nil {
+		log.Error("[OAuth] Unable to retreive client: " + fail.Error())
+		authorizeErrorPage(res, "Internal Server Error")
+		return
+	}
+
+	client, ok := rawClient.(AuthorizationClient)
+	if rawClient == nil || !ok || !client.ThirdParty() {
+		authorizeErrorPage(res, "Unknown client")
+		return
+	}
+
+	if redirectURI == "" || !client.HasRedirectURI(redirectURI) {
+		authorizeErrorPage(res, "The redirect_uri is not registered for this client")
+		return
+	}
+
+	if req.FormValue("response_type") != "code" {
+		redirectError(res, req, redirectURI, state, UNSUPPORTED_RESPONSE_TYPE, "Only the code response type is supported")
+		return
+	}
+
+	codeChallenge := req.FormValue("code_challenge")
+	if codeChallenge == "" || req.FormValue("code_challenge_method") != "S256" {
+		redirectError(res, req, redirectURI, state, INVALID_REQUEST, "A S256 code_challenge is required")
+		return
+	}
+
+	scopes := strings.Fields(req.FormValue("scope"))
+	if len(scopes) == 0 {
+		redirectError(res, req, redirectURI, state, INVALID_SCOPE, "scope is missing")
+		return
+	}
+
+	for _, scope := range scopes {
+		if !client.AllowsScope(scope) {
+			redirectError(res, req, redirectURI, state, INVALID_SCOPE, "The client cannot request the "+scope+" scope")
+			return
+		}
+	}
+
+	page := consentPage{
+		Client: client.DisplayName(),
+		Scopes: scopes,
+		Params: map[string]string{
+			"client_id":             clientKey,
+			"redirect_uri":          redirectURI,
+			"response_type":         "code",
+			"scope":                 strings.Join(scopes, " "),
+			"state":                 state,
+			"code_challenge":        codeChallenge,
+			"code_challenge_method": "S256",
+		},
+	}
+
+	if req.Method != "POST" {
+		renderConsent(res, page)
+		return
+	}
+
+	if req.FormValue("action") != "allow" {
+		redirectError(res, req, redirectURI, state, ACCESS_DENIED, "The user denied the request")
+		return
+	}
+
+	var user interface{}
+	if mfaToken := req.FormValue("mfa_token"); mfaToken != "" {
+		user, fail = kConnector.VerifyMFA(rawClient, mfaToken, req.FormValue("otp"), req)
+		if fail == InvalidMFACode {
+			page.MFAToken = mfaToken
+			page.Error = "Invalid authentication code"
+			renderConsent(res, page)
+			return
+		}
+		if fail == InvalidMFAToken {
+			page.Error = "The sign in has expired, please try again"
+			renderConsent(res, page)
+			return
+		}
+		if fail != nil {
+			log.Error("[OAuth] Cannot verify the MFA code: " + fail.Error())
+			redirectError(res, req, redirectURI, state, SERVER_ERROR, "Internal Server Error")
+			return
+		}
+	} else {
+		page.Username = req.FormValue("username")
+		wait, fail := kConnector.CheckLogin(page.Username, req)
+		if fail != nil {
+			log.Error("[OAuth] Cannot check the login attempts: " + fail.Error())
+			redirectError(res, req, redirectURI, state, SERVER_ERROR, "Internal Server Error")
+			return
+		}
+
+		if wait > 0 {
+			page.Error = fmt.Sprintf("Too many failed attempts, retry in %d seconds", retryAfter(wait))
+			renderConsent(res, page)
+			return
+		}
+
+		user, fail = kConnector.AuthenticateUser(page.Username, req.FormValue("password"))
+		if fail != nil {
+			if fail.Error() == "invalid credentials" || fail.Error() == "user not found" {
+				recordLogin(page.Username, req, false)
+				page.Error = "Invalid email or password"
+				renderConsent(res, page)
+				return
+			}
+			if fail.Error() == "user disabled" {
+				recordLogin(page.Username, req, true)
+				page.Error = "Your account is disabled or not activated yet"
+				renderConsent(res, page)
+				return
+			}
+			if fail.Error() == "password expired" {
+				recordLogin(page.Username, req, true)
+				page.Error = "Your password has expired, reset it in Nanocloud first"
+				renderConsent(res, page)
+				return
+			}
+			log.Error("[OAuth] Cannot Authenticate User: " + fail.Error())
+			redirectError(res, req, redirectURI, state, SERVER_ERROR, "Internal Server Error")
+			return
+		}
+		mfaToken, enroll, fail := kConnector.MFAChallenge(rawClient, user)
+		if fail != nil {
+			log.Error("[OAuth] Cannot create the MFA challenge: " + fail.Error())
+			redirectError(res, req, redirectURI, state, SERVER_ERROR, "Internal Server Error")
+			return
+		}
+
+		// The enrollment shows the secret and the recovery codes: it is
+		// done in Nanocloud, not on behalf of a third-party application.
+		if enroll {
+			page.Error = "Two-factor authentication must be set up in Nanocloud first"
+			renderConsent(res, page)
+			return
+		}
+
+		// The login succeeds once the code is verified.
+		if mfaToken != "" {
+			page.MFAToken = mfaToken
+			renderConsent(res, page)
+			return
+		}
+		recordLogin(page.Username, req, true)
+	}
+
+	code, fail := kConnector.CreateAuthorizationCode(rawClient, user, AuthorizationGrant{
+		RedirectURI:   redirectURI,
+		Scope:         strings.Join(scopes, " "),
+		CodeChallenge: codeChallenge,
+	})
+	if fail != nil {
+		log.Error("[OAuth] Cannot create the authorization code: " + fail.Error())
+		redirectError(res, req, redirectURI, state, SERVER_ERROR, "Internal Server Error")
+		return
+	}
+
+	redirectWith(res, req, redirectURI, url.Values{
+		"code":  {code},
+		"state": {state},
+	})
+}
+
+// exchangeCode handles the authorization_code grant.
+func exchangeCode(res http.ResponseWriter, req *http.Request, client interface{}) {
+	code := req.FormValue("code")
+	if code == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "code is missing"})
+		return
+	}
+
+	redirectURI := req.FormValue("redirect_uri")
+	if redirectURI == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "redirect_uri is missing"})
+		return
+	}
+
+	codeVerifier := req.FormValue("code_verifier")
+	if codeVerifier == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "code_verifier is missing"})
+		return
+	}
+
+	accessToken, fail := kConnector.ExchangeAuthorizationCode(client, code, redirectURI, codeVerifier, req)
+	if fail == InvalidAuthorizationCode {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_GRANT, "Invalid authorization code"})
+		return
+	}
+	if fail != nil {
+		log.Error("[OAuth] Cannot exchange the authorization code: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	tokenReply(res, accessToken)
+}
@@ -0,0 +1,21 @@
+package oauth2
+
+import "testing"
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// Example from RFC 7636, appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !VerifyCodeChallenge(verifier, challenge) {
+		t.Errorf("The verifier should match the challenge")
+	}
+
+	if VerifyCodeChallenge(verifier+"x", challenge) {
+		t.Errorf("Another verifier should not match the challenge")
+	}
+
+	if VerifyCodeChallenge("short", "short") {
+		t.Errorf("Verifiers shorter than 43 characters should be rejected")
+	}
+}
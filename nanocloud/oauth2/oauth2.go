@@ -4,8 +4,11 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -21,16 +24,69 @@ const (
 	SERVER_ERROR              = "server_error"
 	TEMPORARILY_UNAVAILABLE   = "temporarily_unavailable"
 	UNSUPPORTED_GRANT_TYPE    = "unsupported_grant_type"
+	MFA_REQUIRED              = "mfa_required"
+	MFA_ENROLLMENT_REQUIRED   = "mfa_enrollment_required"
+	PASSWORD_EXPIRED          = "password_expired"
 )
 
 type Connector interface {
 	GetClient(key, secret string) (interface{}, error)
 	GetUserFromAccessToken(accessToken string) (interface{}, error)
 	AuthenticateUser(username, password string) (interface{}, error)
+
+	// CheckLogin returns how long the client must wait before trying to
+	// log in as username, 0 if it can try now.
+	CheckLogin(username string, req *http.Request) (time.Duration, error)
+
+	// RecordLogin records the outcome of a password check.
+	RecordLogin(username string, req *http.Request, success bool) error
+
 	GetAccessToken(interface{}, interface{}, *http.Request) (interface{}, error)
 	RevokeAccessToken(interface{}, interface{}, string) error
+
+	// RefreshAccessToken exchanges a refresh token issued to the client for
+	// a new access token. It returns InvalidRefreshToken if the refresh
+	// token is unknown, expired or already used.
+	RefreshAccessToken(client interface{}, refreshToken string, req *http.Request) (interface{}, error)
+	RevokeRefreshToken(client interface{}, refreshToken string) error
+
+	// FindClient returns the client identified by key, without checking
+	// its secret.
+	FindClient(key string) (interface{}, error)
+
+	// CreateAuthorizationCode returns a code the client can exchange for
+	// an access token of the user.
+	CreateAuthorizationCode(client, user interface{}, grant AuthorizationGrant) (string, error)
+
+	// ExchangeAuthorizationCode returns an access token for the code. It
+	// returns InvalidAuthorizationCode if the code is unknown, expired,
+	// already used or if the redirect URI or the PKCE verifier do not
+	// match.
+	ExchangeAuthorizationCode(client interface{}, code, redirectURI, codeVerifier string, req *http.Request) (interface{}, error)
+
+	// MFAChallenge returns a token if the user must give a second factor
+	// before getting an access token. enroll is true if the user has to
+	// set up the second factor first.
+	MFAChallenge(client, user interface{}) (token string, enroll bool, err error)
+
+	// EnrollMFA starts the enrollment of the user of the challenge and
+	// returns what the authenticator app needs.
+	EnrollMFA(client interface{}, token string) (interface{}, error)
+
+	// VerifyMFA returns the user of the challenge if the code is valid. It
+	// returns InvalidMFAToken if the challenge is unknown, expired or
+	// exhausted and InvalidMFACode if the code is wrong. Like the password,
+	// the code is throttled and its outcome recorded.
+	VerifyMFA(client interface{}, token, code string, req *http.Request) (interface{}, error)
 }
 
+var (
+	InvalidRefreshToken      = errors.New("invalid refresh token")
+	InvalidAuthorizationCode = errors.New("invalid authorization code")
+	InvalidMFAToken          = errors.New("invalid mfa token")
+	InvalidMFACode           = errors.New("invalid mfa code")
+)
+
 var kConnector Connector
 
 type OAuthError struct {
@@ -158,6 +214,28 @@ func clientBasicAuth(req *http.Request) (interface{}, *OAuthError) {
 	return client, nil
 }
 
+// clientAuth authenticates the client with the Authorization header, or with
+// the client_id and client_secret parameters when the header is missing.
+// Public clients only send their client_id. The form must be parsed.
+func clientAuth(req *http.Request) (interface{}, *OAuthError) {
+	if req.Header.Get("Authorization") != "" {
+		return clientBasicAuth(req)
+	}
+
+	clientKey := req.FormValue("client_id")
+	if clientKey == "" {
+		return nil, &OAuthError{http.StatusUnauthorized, INVALID_REQUEST, "Authorization header is missing"}
+	}
+
+	client, fail := kConnector.GetClient(clientKey, req.FormValue("client_secret"))
+	if fail != nil {
+		log.Error("[Oauth] Unable to retreive client: " + fail.Error())
+		return nil, &OAuthError{500, SERVER_ERROR, "Internal Server Error"}
+	}
+
+	return client, nil
+}
+
 func oauthErrorReply(res http.ResponseWriter, oauthErr OAuthError) error {
 	res.Header().Set("Content-Type", "application/json;charset=UTF-8")
 	ret, err := oauthErr.ToJSON()
@@ -178,7 +256,7 @@ func revokeToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	client, err := clientBasicAuth(req)
+	client, err := clientAuth(req)
 	if err != nil {
 		oauthErrorReply(res, *err)
 		return
@@ -196,7 +274,7 @@ func revokeToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if tokenTypeHint != "access_token" {
+	if tokenTypeHint != "access_token" && tokenTypeHint != "refresh_token" {
 		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "unsupported token type"})
 		return
 	}
@@ -208,6 +286,19 @@ func revokeToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if tokenTypeHint == "refresh_token" {
+		fail := kConnector.RevokeRefreshToken(client, accessToken)
+		if fail != nil {
+			log.Error("[OAuth] Cannot revoke refresh token: " + fail.Error())
+			oauthErrorReply(res, OAuthError{500, SERVER_ERROR, "Internal Server Error"})
+			return
+		}
+
+		// Unknown tokens are not an error (RFC 7009)
+		res.WriteHeader(http.StatusOK)
+		return
+	}
+
 	user, fail := kConnector.GetUserFromAccessToken(accessToken)
 	if fail != nil {
 		log.Error("[OAuth] Cannot retreive user form access token: " + fail.Error())
@@ -232,7 +323,14 @@ func revokeToken(res http.ResponseWriter, req *http.Request) {
 }
 
 func createToken(res http.ResponseWriter, req *http.Request) {
-	client, err := clientBasicAuth(req)
+	fail := req.ParseForm()
+
+	if fail != nil {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Unable to parse the request body"})
+		return
+	}
+
+	client, err := clientAuth(req)
 	if err != nil {
 		oauthErrorReply(res, *err)
 		return
@@ -243,13 +341,6 @@ func createToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	fail := req.ParseForm()
-
-	if fail != nil {
-		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Unable to parse the request body"})
-		return
-	}
-
 	// grant_type
 	grantType := req.FormValue("grant_type")
 	if grantType == "" {
@@ -257,11 +348,32 @@ func createToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if grantType == "refresh_token" {
+		refreshToken(res, req, client)
+		return
+	}
+
+	if grantType == "authorization_code" {
+		exchangeCode(res, req, client)
+		return
+	}
+
+	if grantType == "mfa_otp" {
+		verifyMFA(res, req, client)
+		return
+	}
+
 	if grantType != "password" {
 		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Invalid grant_type"})
 		return
 	}
 
+	// Third-party clients must not see the passwords of the users
+	if isThirdParty(client) {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, UNAUTHORIZED_CLIENT, "The client must use the authorization_code grant"})
+		return
+	}
+
 	// username
 	username := req.FormValue("username")
 	if username == "" {
@@ -276,18 +388,142 @@ func createToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	wait, fail := kConnector.CheckLogin(username, req)
+	if fail != nil {
+		log.Error("[OAuth] Cannot check the login attempts: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	if wait > 0 {
+		throttledReply(res, wait)
+		return
+	}
+
 	user, fail := kConnector.AuthenticateUser(username, password)
 
 	if fail != nil {
 		if fail.Error() == "invalid credentials" || fail.Error() == "user not found" {
+			recordLogin(username, req, false)
 			oauthErrorReply(res, OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "Invalid User Credentials"})
 			return
 		}
+		if fail.Error() == "user disabled" {
+			recordLogin(username, req, true)
+			oauthErrorReply(res, OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "The account is disabled or not activated yet"})
+			return
+		}
+		if fail.Error() == "password expired" {
+			recordLogin(username, req, true)
+			oauthErrorReply(res, OAuthError{http.StatusForbidden, PASSWORD_EXPIRED, "The password has expired and must be reset"})
+			return
+		}
 		log.Error("[OAuth] Cannot Authenticate User: " + fail.Error())
 		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
 		return
 	}
 
+	mfaToken, enroll, fail := kConnector.MFAChallenge(client, user)
+	if fail != nil {
+		log.Error("[OAuth] Cannot create the MFA challenge: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	// With a second factor, the login succeeds once the code is verified:
+	// until then, the failed codes add up to the failed passwords.
+	if mfaToken != "" {
+		if enroll {
+			mfaReply(res, OAuthError{http.StatusForbidden, MFA_ENROLLMENT_REQUIRED, "Two-factor authentication must be set up"}, mfaToken)
+		} else {
+			mfaReply(res, OAuthError{http.StatusForbidden, MFA_REQUIRED, "A two-factor authentication code is required"}, mfaToken)
+		}
+		return
+	}
+	recordLogin(username, req, true)
+
+	accessToken, fail := kConnector.GetAccessToken(user, client, req)
+	if fail != nil {
+		log.Error("[OAuth] Cannot Get Access Token: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	tokenReply(res, accessToken)
+}
+
+// recordLogin records a login attempt. A failure to do so does not fail the
+// request.
+func recordLogin(username string, req *http.Request, success bool) {
+	fail := kConnector.RecordLogin(username, req, success)
+	if fail != nil {
+		log.Error("[OAuth] Cannot record the login attempt: " + fail.Error())
+	}
+}
+
+// retryAfter rounds up the delay in seconds.
+func retryAfter(wait time.Duration) int {
+	return int((wait + time.Second - 1) / time.Second)
+}
+
+func throttledReply(res http.ResponseWriter, wait time.Duration) {
+	seconds := retryAfter(wait)
+	res.Header().Set("Retry-After", strconv.Itoa(seconds))
+	oauthErrorReply(res, OAuthError{
+		http.StatusTooManyRequests,
+		TEMPORARILY_UNAVAILABLE,
+		fmt.Sprintf("Too many failed login attempts, retry in %d seconds", seconds),
+	})
+}
+
+// mfaReply is the error of the password grant when a second factor is
+// needed. The client sends the mfa_token back with the code using the
+// mfa_otp grant.
+func mfaReply(res http.ResponseWriter, oauthErr OAuthError, mfaToken string) {
+	rt, fail := json.Marshal(map[string]string{
+		"error":             oauthErr.Err,
+		"error_description": oauthErr.Description,
+		"mfa_token":         mfaToken,
+	})
+	if fail != nil {
+		log.Error("[OAuth] Cannot write JSON error: " + fail.Error())
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	res.WriteHeader(oauthErr.HTTPStatusCode)
+	res.Write(rt)
+}
+
+// verifyMFA handles the mfa_otp grant.
+func verifyMFA(res http.ResponseWriter, req *http.Request, client interface{}) {
+	mfaToken := req.FormValue("mfa_token")
+	if mfaToken == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "mfa_token is missing"})
+		return
+	}
+
+	otp := req.FormValue("otp")
+	if otp == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "otp is missing"})
+		return
+	}
+
+	user, fail := kConnector.VerifyMFA(client, mfaToken, otp, req)
+	if fail == InvalidMFAToken {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_GRANT, "Invalid mfa_token"})
+		return
+	}
+	if fail == InvalidMFACode {
+		oauthErrorReply(res, OAuthError{http.StatusForbidden, ACCESS_DENIED, "Invalid two-factor authentication code"})
+		return
+	}
+	if fail != nil {
+		log.Error("[OAuth] Cannot verify the MFA code: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
 	accessToken, fail := kConnector.GetAccessToken(user, client, req)
 	if fail != nil {
 		log.Error("[OAuth] Cannot Get Access Token: " + fail.Error())
@@ -295,6 +531,81 @@ func createToken(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	tokenReply(res, accessToken)
+}
+
+// enrollMFA lets a user whose second factor is required set it up with the
+// token of the mfa_enrollment_required error. The enrollment is confirmed
+// with the mfa_otp grant.
+func enrollMFA(res http.ResponseWriter, req *http.Request) {
+	fail := req.ParseForm()
+	if fail != nil {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "Unable to parse the request body"})
+		return
+	}
+
+	client, err := clientAuth(req)
+	if err != nil {
+		oauthErrorReply(res, *err)
+		return
+	}
+
+	if client == nil {
+		oauthErrorReply(res, OAuthError{http.StatusUnauthorized, INVALID_CLIENT, "Invalid OAuth Client Credentials"})
+		return
+	}
+
+	mfaToken := req.FormValue("mfa_token")
+	if mfaToken == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "mfa_token is missing"})
+		return
+	}
+
+	enrollment, fail := kConnector.EnrollMFA(client, mfaToken)
+	if fail == InvalidMFAToken {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_GRANT, "Invalid mfa_token"})
+		return
+	}
+	if fail != nil {
+		log.Error("[OAuth] Cannot enroll the second factor: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	rt, fail := json.Marshal(enrollment)
+	if fail != nil {
+		log.Error("[OAuth] Unable to serialize the enrollment: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	res.Write(rt)
+}
+
+// refreshToken handles the refresh_token grant.
+func refreshToken(res http.ResponseWriter, req *http.Request, client interface{}) {
+	token := req.FormValue("refresh_token")
+	if token == "" {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_REQUEST, "refresh_token is missing"})
+		return
+	}
+
+	accessToken, fail := kConnector.RefreshAccessToken(client, token, req)
+	if fail == InvalidRefreshToken {
+		oauthErrorReply(res, OAuthError{http.StatusBadRequest, INVALID_GRANT, "Invalid refresh token"})
+		return
+	}
+	if fail != nil {
+		log.Error("[OAuth] Cannot Refresh Access Token: " + fail.Error())
+		oauthErrorReply(res, OAuthError{http.StatusInternalServerError, SERVER_ERROR, "Internal Server Error"})
+		return
+	}
+
+	tokenReply(res, accessToken)
+}
+
+func tokenReply(res http.ResponseWriter, accessToken interface{}) {
 	if accessToken == nil {
 		oauthErrorReply(res, OAuthError{http.StatusUnauthorized, ACCESS_DENIED, "Access token request denied for the given client"})
 		return
@@ -317,6 +628,11 @@ func HandleRequest(res http.ResponseWriter, req *http.Request) {
 	res.Header().Add("Cache-Control", "no-store")
 	res.Header().Add("Pragma", "no-cache")
 
+	if req.URL.Path == "/oauth/authorize" && (req.Method == "GET" || req.Method == "POST") {
+		authorize(res, req)
+		return
+	}
+
 	if req.Method == "POST" {
 		if req.URL.Path == "/oauth/revoke" {
 			revokeToken(res, req)
@@ -327,6 +643,11 @@ func HandleRequest(res http.ResponseWriter, req *http.Request) {
 			createToken(res, req)
 			return
 		}
+
+		if req.URL.Path == "/oauth/mfa/enroll" {
+			enrollMFA(res, req)
+			return
+		}
 	}
 
 	oauthErrorReply(res, OAuthError{http.StatusNotFound, INVALID_REQUEST, "Invalid Endpoint"})
@@ -346,6 +667,14 @@ func (c dummyConnector) AuthenticateUser(username, password string) (interface{}
 	return nil, errors.New("AuthenticateUser is not implemented")
 }
 
+func (c dummyConnector) CheckLogin(username string, req *http.Request) (time.Duration, error) {
+	return 0, errors.New("CheckLogin is not implemented")
+}
+
+func (c dummyConnector) RecordLogin(username string, req *http.Request, success bool) error {
+	return errors.New("RecordLogin is not implemented")
+}
+
 func (c dummyConnector) GetAccessToken(user, client interface{}, req *http.Request) (interface{}, error) {
 	return nil, errors.New("GetAccessToken is not implemented")
 }
@@ -354,6 +683,38 @@ func (c dummyConnector) RevokeAccessToken(client, user interface{}, accessToken
 	return errors.New("RevokeAccessToken is not implemented")
 }
 
+func (c dummyConnector) RefreshAccessToken(client interface{}, refreshToken string, req *http.Request) (interface{}, error) {
+	return nil, errors.New("RefreshAccessToken is not implemented")
+}
+
+func (c dummyConnector) RevokeRefreshToken(client interface{}, refreshToken string) error {
+	return errors.New("RevokeRefreshToken is not implemented")
+}
+
+func (c dummyConnector) FindClient(key string) (interface{}, error) {
+	return nil, errors.New("FindClient is not implemented")
+}
+
+func (c dummyConnector) CreateAuthorizationCode(client, user interface{}, grant AuthorizationGrant) (string, error) {
+	return "", errors.New("CreateAuthorizationCode is not implemented")
+}
+
+func (c dummyConnector) ExchangeAuthorizationCode(client interface{}, code, redirectURI, codeVerifier string, req *http.Request) (interface{}, error) {
+	return nil, errors.New("ExchangeAuthorizationCode is not implemented")
+}
+
+func (c dummyConnector) MFAChallenge(client, user interface{}) (string, bool, error) {
+	return "", false, errors.New("MFAChallenge is not implemented")
+}
+
+func (c dummyConnector) EnrollMFA(client interface{}, token string) (interface{}, error) {
+	return nil, errors.New("EnrollMFA is not implemented")
+}
+
+func (c dummyConnector) VerifyMFA(client interface{}, token, code string, req *http.Request) (interface{}, error) {
+	return nil, errors.New("VerifyMFA is not implemented")
+}
+
 func init() {
 	SetConnector(dummyConnector{})
 }
@@ -0,0 +1,119 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package plaza
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The sessions of all the machines are polled to record the histories: a
+// plaza which doesn't answer must not block the others.
+var sessionsClient = &http.Client{Timeout: 10 * time.Second}
+
+// A Windows session opened on a machine.
+type Session struct {
+	Id       string   `json:"id"`
+	Name     string   `json:"name"`
+	Username string   `json:"username"`
+	State    string   `json:"state"`
+	Programs []string `json:"programs"`
+}
+
+func sessionsURL(address string, port int) string {
+	return fmt.Sprintf("http://%s:%d/sessions", address, port)
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("plaza replied %d: %s", resp.StatusCode, string(body))
+}
+
+// Return the sessions opened by users on the machine.
+func Sessions(address string, port int) ([]Session, error) {
+	resp, err := sessionsClient.Get(sessionsURL(address, port))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	err = checkResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Data []Session `json:"data"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+	return body.Data, nil
+}
+
+func sessionAction(address string, port int, id, action string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := sessionsClient.Post(
+		sessionsURL(address, port)+"/"+url.QueryEscape(id)+"/"+action,
+		"application/json",
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkResponse(resp)
+}
+
+// Disconnect the session. The applications of the user keep running.
+func DisconnectSession(address string, port int, id string) error {
+	return sessionAction(address, port, id, "disconnect", nil)
+}
+
+// Close the session.
+func LogoffSession(address string, port int, id string) error {
+	return sessionAction(address, port, id, "logoff", nil)
+}
+
+// Display a message in the session.
+func SendSessionMessage(address string, port int, id, message string) error {
+	return sessionAction(address, port, id, "message", map[string]string{
+		"message": message,
+	})
+}
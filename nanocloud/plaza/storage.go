@@ -0,0 +1,69 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package plaza
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The usage is read while serving the users: an offline execution server
+// must not hang the requests.
+var storageClient = &http.Client{Timeout: 5 * time.Second}
+
+// Return the number of bytes used by the files of the Windows user.
+func StorageUsage(address string, port int, username string) (int64, error) {
+	resp, err := storageClient.Get(fmt.Sprintf(
+		"http://%s:%d/files/usage?username=%s",
+		address, port, url.QueryEscape(username),
+	))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("plaza replied %d: %s", resp.StatusCode, string(body))
+	}
+
+	var usage struct {
+		Data struct {
+			Used int64 `json:"used"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(body, &usage)
+	if err != nil {
+		return 0, err
+	}
+	return usage.Data.Used, nil
+}
@@ -0,0 +1,313 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package plaza
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/utils"
+	"github.com/Nanocloud/community/nanocloud/vms"
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	UpdateRejected = errors.New("plaza rejected the update")
+	UpdateFailed   = errors.New("plaza did not restart with the new version")
+)
+
+// A plaza binary signed to be pushed to the machines.
+type Release struct {
+	Version   string
+	Platform  string
+	Signature string
+	Binary    []byte
+}
+
+// Load the release stored in PLAZA_RELEASE_DIR. The directory contains the
+// binary (plaza.exe), the base64 encoded signature of its manifest
+// (plaza.exe.sig), its version (VERSION) and optionally the system and the
+// architecture it is built for (PLATFORM, windows/amd64 by default).
+func LoadRelease() (*Release, error) {
+	dir := utils.Env("PLAZA_RELEASE_DIR", "/opt/plaza")
+
+	version, err := ioutil.ReadFile(filepath.Join(dir, "VERSION"))
+	if err != nil {
+		return nil, err
+	}
+
+	platform, err := ioutil.ReadFile(filepath.Join(dir, "PLATFORM"))
+	if os.IsNotExist(err) {
+		platform, err = []byte("windows/amd64"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := ioutil.ReadFile(filepath.Join(dir, "plaza.exe.sig"))
+	if err != nil {
+		return nil, err
+	}
+
+	binary, err := ioutil.ReadFile(filepath.Join(dir, "plaza.exe"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Release{
+		Version:   strings.TrimSpace(string(version)),
+		Platform:  strings.TrimSpace(string(platform)),
+		Signature: strings.TrimSpace(string(signature)),
+		Binary:    binary,
+	}, nil
+}
+
+// The version of plaza running on a machine and the platform it runs on.
+type VersionInfo struct {
+	Version      string `json:"version"`
+	System       string `json:"system"`
+	Architecture string `json:"architecture"`
+}
+
+// Platform returns the system and the architecture, like windows/amd64.
+func (v *VersionInfo) Platform() string {
+	return v.System + "/" + v.Architecture
+}
+
+// Return the version of plaza running on the machine.
+func Version(address string, port int) (*VersionInfo, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://%s:%d/version", address, port))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions of plaza older than the update mechanism don't have the
+	// /version route. They only ran on Windows.
+	if resp.StatusCode == http.StatusNotFound {
+		return &VersionInfo{System: "windows", Architecture: "amd64"}, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(string(body))
+	}
+
+	var rt struct {
+		Data VersionInfo `json:"data"`
+	}
+
+	err = json.Unmarshal(body, &rt)
+	if err != nil {
+		return nil, err
+	}
+	return &rt.Data, nil
+}
+
+// compareVersions compares two versions given by `git describe`, like 1.2.0
+// or 1.2.0-4-gd2a1c3e, and returns -1, 0 or 1. The versions that cannot be
+// parsed, like dev, come before the others. It matches the comparison plaza
+// does before accepting an update.
+func compareVersions(a, b string) int {
+	va, vb := parseVersion(a), parseVersion(b)
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseVersion returns the numbers of the version followed by the number of
+// commits since its tag, nil if it is not a version.
+func parseVersion(version string) []int {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), "-")
+
+	numbers := make([]int, 0)
+	for _, field := range strings.Split(parts[0], ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil
+		}
+		numbers = append(numbers, n)
+	}
+
+	// The commits since the tag are compared after the major, minor and
+	// patch numbers
+	for len(numbers) < 3 {
+		numbers = append(numbers, 0)
+	}
+	commits := 0
+	if len(parts) > 1 {
+		commits, _ = strconv.Atoi(parts[1])
+	}
+	return append(numbers, commits)
+}
+
+// Send the release to the machine. Plaza restarts itself once the binary is
+// verified.
+func Update(address string, port int, release *Release) error {
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf("http://%s:%d/update", address, port),
+		bytes.NewReader(release.Binary),
+	)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Plaza-Version", release.Version)
+	req.Header.Set("X-Plaza-Signature", release.Signature)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return nil
+	case http.StatusForbidden:
+		return UpdateRejected
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("plaza replied %d: %s", resp.StatusCode, string(body))
+}
+
+// UpdateMachine pushes the release to the machine if its plaza runs an older
+// version on the platform of the release, and waits for plaza to restart.
+// Returns the version running on the machine.
+func UpdateMachine(machine vms.Machine, port int, release *Release) (string, error) {
+	ip, err := machine.IP()
+	if err != nil {
+		return "", err
+	}
+	if ip == nil {
+		return "", errors.New("machine has no IP address")
+	}
+	address := ip.String()
+
+	info, err := Version(address, port)
+	if err != nil {
+		return "", err
+	}
+
+	if compareVersions(info.Version, release.Version) >= 0 {
+		return info.Version, nil
+	}
+
+	if info.Platform() != release.Platform {
+		log.Infof("Not updating plaza on %s: it runs on %s, the release is built for %s", machine.Id(), info.Platform(), release.Platform)
+		return info.Version, nil
+	}
+
+	log.Infof("Updating plaza on %s from %q to %s", machine.Id(), info.Version, release.Version)
+	err = Update(address, port, release)
+	if err != nil {
+		return info.Version, err
+	}
+
+	// Plaza rolls back to the previous version if the new one doesn't answer
+	// within a minute.
+	version := info.Version
+	for i := 0; i < 30; i++ {
+		time.Sleep(3 * time.Second)
+
+		info, err = Version(address, port)
+		if err == nil {
+			version = info.Version
+			if version == release.Version {
+				return version, nil
+			}
+		}
+	}
+	return version, UpdateFailed
+}
+
+// Result of the update of a machine.
+type UpdateResult struct {
+	MachineId string
+	Version   string
+	Err       error
+}
+
+// UpdateMachines updates plaza on all the machines up or degraded, in
+// parallel.
+func UpdateMachines(machines []vms.Machine, port int, release *Release) []UpdateResult {
+	results := make([]UpdateResult, 0, len(machines))
+	ch := make(chan UpdateResult)
+
+	n := 0
+	for _, machine := range machines {
+		status, err := machine.Status()
+		if err != nil || (status != vms.StatusUp && status != vms.StatusDegraded) {
+			continue
+		}
+
+		n++
+		go func(machine vms.Machine) {
+			version, err := UpdateMachine(machine, port, release)
+			ch <- UpdateResult{
+				MachineId: machine.Id(),
+				Version:   version,
+				Err:       err,
+			}
+		}(machine)
+	}
+
+	for i := 0; i < n; i++ {
+		result := <-ch
+		if result.Err != nil {
+			log.Errorf("Unable to update plaza on %s: %s", result.MachineId, result.Err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,164 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package plaza
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Client side of the plaza resumable upload routes (see plaza/routes/files/uploads.go).
+
+const (
+	tusVersion             = "1.0.0"
+	statusChecksumMismatch = 460
+)
+
+var (
+	UploadNotFound         = errors.New("upload not found")
+	UploadOffsetMismatch   = errors.New("upload offset mismatch")
+	UploadChecksumMismatch = errors.New("upload checksum mismatch")
+	UploadChunkTooLarge    = errors.New("chunk exceeds the upload length")
+)
+
+func uploadURL(address string, port int, id string) string {
+	return fmt.Sprintf("http://%s:%d/uploads/%s", address, port, url.QueryEscape(id))
+}
+
+func uploadResponseError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return UploadNotFound
+	case http.StatusConflict:
+		return UploadOffsetMismatch
+	case statusChecksumMismatch:
+		return UploadChecksumMismatch
+	case http.StatusRequestEntityTooLarge:
+		return UploadChunkTooLarge
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("plaza replied %d: %s", resp.StatusCode, string(body))
+}
+
+func uploadOffset(resp *http.Response) (int64, error) {
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+func CreateUpload(address string, port int, id string, username string, filename string, length int64) error {
+	req, err := http.NewRequest(
+		"POST",
+		fmt.Sprintf(
+			"http://%s:%d/uploads?id=%s&username=%s&filename=%s",
+			address, port,
+			url.QueryEscape(id), url.QueryEscape(username), url.QueryEscape(filename),
+		),
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", strconv.FormatInt(length, 10))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return uploadResponseError(resp)
+	}
+	return nil
+}
+
+// Return the number of bytes of the upload received by plaza.
+func UploadOffset(address string, port int, id string) (int64, error) {
+	req, err := http.NewRequest("HEAD", uploadURL(address, port, id), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, uploadResponseError(resp)
+	}
+	return uploadOffset(resp)
+}
+
+// Send a chunk of an upload starting at offset. checksum is an optional
+// Upload-Checksum header value. Return the new offset of the upload.
+func PatchUpload(address string, port int, id string, offset int64, checksum string, body io.Reader) (int64, error) {
+	req, err := http.NewRequest("PATCH", uploadURL(address, port, id), body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if checksum != "" {
+		req.Header.Set("Upload-Checksum", checksum)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return 0, uploadResponseError(resp)
+	}
+	return uploadOffset(resp)
+}
+
+func DeleteUpload(address string, port int, id string) error {
+	req, err := http.NewRequest("DELETE", uploadURL(address, port, id), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return uploadResponseError(resp)
+	}
+	return nil
+}
@@ -5,6 +5,12 @@ import (
 	"sync"
 
 	"github.com/Nanocloud/community/nanocloud/broadcaster"
+	"github.com/Nanocloud/community/nanocloud/metrics"
+)
+
+var runsInProgress = metrics.NewGauge(
+	"nanocloud_provisioning_runs_in_progress",
+	"Machine provisionings currently running.",
 )
 
 type ProvFunc func(io.Writer)
@@ -27,7 +33,9 @@ func New(fn ProvFunc) *Provisioner {
 }
 
 func (p *Provisioner) _run() {
+	runsInProgress.Inc()
 	p.fn(&p.b)
+	runsInProgress.Dec()
 
 	p.cond.L.Lock()
 	p.done = true
@@ -0,0 +1,336 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package query implements the pagination (`page[number]`, `page[size]`),
+// filtering (`filter[field]`) and sorting (`sort`) parameters of the JSON API
+// list endpoints.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+
+	// The offset of the last page must fit in an int32, for the slices and
+	// the SQL OFFSET.
+	MaxPageNumber = math.MaxInt32 / MaxPageSize
+)
+
+var InvalidPage = errors.New("page[number] and page[size] must be positive integers")
+
+// Maps the fields of a resource to the SQL expressions they are read from.
+type Columns map[string]string
+
+// Return the names of the fields, sorted.
+func (c Columns) Fields() []string {
+	fields := make([]string, 0, len(c))
+	for field := range c {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// A Query without page number returns all the items.
+type Query struct {
+	Number  int
+	Size    int
+	Filters map[string][]string
+	Sort    []Sort
+}
+
+// The query returning all the items unsorted.
+func All() *Query {
+	return &Query{Filters: make(map[string][]string)}
+}
+
+func contains(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePageParameter(values url.Values, name string) (int, bool, error) {
+	value := values.Get(name)
+	if value == "" {
+		return 0, false, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, true, InvalidPage
+	}
+	return n, true, nil
+}
+
+// Parse reads the query parameters. Only the listed fields can be filtered
+// and sorted by. Filters with comma separated values match any of them.
+func Parse(values url.Values, fields []string) (*Query, error) {
+	q := All()
+
+	number, paginated, err := parsePageParameter(values, "page[number]")
+	if err != nil {
+		return nil, err
+	}
+	if number > MaxPageNumber {
+		return nil, InvalidPage
+	}
+
+	size, sized, err := parsePageParameter(values, "page[size]")
+	if err != nil {
+		return nil, err
+	}
+
+	if paginated || sized {
+		q.Number = 1
+		if paginated {
+			q.Number = number
+		}
+
+		q.Size = DefaultPageSize
+		if sized {
+			q.Size = size
+		}
+		if q.Size > MaxPageSize {
+			q.Size = MaxPageSize
+		}
+	}
+
+	for key, value := range values {
+		if !strings.HasPrefix(key, "filter[") || !strings.HasSuffix(key, "]") {
+			continue
+		}
+
+		field := key[len("filter[") : len(key)-1]
+		if !contains(fields, field) {
+			return nil, fmt.Errorf("cannot filter by %q", field)
+		}
+		q.Filters[field] = strings.Split(value[0], ",")
+	}
+
+	s := values.Get("sort")
+	if s != "" {
+		for _, field := range strings.Split(s, ",") {
+			desc := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(field, "-")
+
+			if !contains(fields, field) {
+				return nil, fmt.Errorf("cannot sort by %q", field)
+			}
+			q.Sort = append(q.Sort, Sort{Field: field, Desc: desc})
+		}
+	}
+	return q, nil
+}
+
+// Where returns the WHERE clause combining the conditions to the filters of
+// the query. The filter values are appended to args.
+func (q *Query) Where(columns Columns, conditions []string, args []interface{}) (string, []interface{}) {
+	conditions = append([]string{}, conditions...)
+
+	fields := make([]string, 0, len(q.Filters))
+	for field := range q.Filters {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		column, ok := columns[field]
+		if !ok {
+			continue
+		}
+
+		placeholders := make([]string, 0)
+		for _, value := range q.Filters[field] {
+			args = append(args, value)
+			placeholders = append(placeholders, fmt.Sprintf("$%d::varchar", len(args)))
+		}
+		conditions = append(conditions, fmt.Sprintf("%s::varchar IN (%s)", column, strings.Join(placeholders, ", ")))
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// OrderBy returns the ORDER BY clause of the query, or sorts by order when the
+// query is not sorted.
+func (q *Query) OrderBy(columns Columns, order string) string {
+	expressions := make([]string, 0)
+	for _, s := range q.Sort {
+		column, ok := columns[s.Field]
+		if !ok {
+			continue
+		}
+
+		if s.Desc {
+			column += " DESC"
+		}
+		expressions = append(expressions, column)
+	}
+
+	if len(expressions) == 0 {
+		if order == "" {
+			return ""
+		}
+		return " ORDER BY " + order
+	}
+	return " ORDER BY " + strings.Join(expressions, ", ")
+}
+
+// Limit returns the LIMIT and OFFSET clauses selecting the page.
+func (q *Query) Limit() string {
+	if q.Number == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" LIMIT %d OFFSET %d", q.Size, (q.Number-1)*q.Size)
+}
+
+// Return the number of the last page.
+func (q *Query) LastPage(total int) int {
+	if q.Number == 0 || total == 0 {
+		return 1
+	}
+	return int(math.Ceil(float64(total) / float64(q.Size)))
+}
+
+// less compares numbers numerically and other values as strings.
+func less(a, b string) bool {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return x < y
+	}
+	return a < b
+}
+
+type sorter struct {
+	indexes []int
+	value   func(i int, field string) string
+	sort    []Sort
+}
+
+func (s *sorter) Len() int      { return len(s.indexes) }
+func (s *sorter) Swap(i, j int) { s.indexes[i], s.indexes[j] = s.indexes[j], s.indexes[i] }
+
+func (s *sorter) Less(i, j int) bool {
+	for _, field := range s.sort {
+		a := s.value(s.indexes[i], field.Field)
+		b := s.value(s.indexes[j], field.Field)
+		if a == b {
+			continue
+		}
+
+		if field.Desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	}
+	return false
+}
+
+// Apply filters, sorts and paginates n items kept in memory. value returns
+// the value of a field of the i-th item. It returns the indexes of the items
+// of the page and the number of items matching the filters.
+func (q *Query) Apply(n int, value func(i int, field string) string) ([]int, int) {
+	indexes := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		match := true
+		for field, values := range q.Filters {
+			if !contains(values, value(i, field)) {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			indexes = append(indexes, i)
+		}
+	}
+
+	sort.Stable(&sorter{indexes, value, q.Sort})
+
+	total := len(indexes)
+	if q.Number == 0 {
+		return indexes, total
+	}
+
+	start := (q.Number - 1) * q.Size
+	if start > total {
+		start = total
+	}
+
+	end := start + q.Size
+	if end > total {
+		end = total
+	}
+	return indexes[start:end], total
+}
+
+// Links returns the JSON API links to the pages of the collection at u.
+func (q *Query) Links(u *url.URL, total int) map[string]string {
+	links := map[string]string{
+		"self": u.String(),
+	}
+	if q.Number == 0 {
+		return links
+	}
+
+	page := func(number int) string {
+		values := u.Query()
+		values.Set("page[number]", strconv.Itoa(number))
+		values.Set("page[size]", strconv.Itoa(q.Size))
+
+		l := *u
+		l.RawQuery = values.Encode()
+		return l.String()
+	}
+
+	last := q.LastPage(total)
+	links["first"] = page(1)
+	links["last"] = page(last)
+	if q.Number > 1 {
+		links["prev"] = page(q.Number - 1)
+	}
+	if q.Number < last {
+		links["next"] = page(q.Number + 1)
+	}
+	return links
+}
@@ -0,0 +1,174 @@
+package query
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+var columns = Columns{
+	"email":      "email",
+	"first-name": "first_name",
+	"is-admin":   "is_admin",
+}
+
+func parse(t *testing.T, rawQuery string) *Query {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := Parse(values, columns.Fields())
+	if err != nil {
+		t.Fatalf("Unable to parse %q: %s", rawQuery, err)
+	}
+	return q
+}
+
+func TestParse(t *testing.T) {
+	q := parse(t, "page[number]=3&page[size]=10&filter[is-admin]=true&filter[email]=a@b.c,d@e.f&sort=-first-name,email")
+
+	if q.Number != 3 || q.Size != 10 {
+		t.Errorf("Unexpected page %d of size %d", q.Number, q.Size)
+	}
+
+	filters := map[string][]string{
+		"is-admin": {"true"},
+		"email":    {"a@b.c", "d@e.f"},
+	}
+	if !reflect.DeepEqual(q.Filters, filters) {
+		t.Errorf("Unexpected filters %v", q.Filters)
+	}
+
+	sort := []Sort{{"first-name", true}, {"email", false}}
+	if !reflect.DeepEqual(q.Sort, sort) {
+		t.Errorf("Unexpected sort %v", q.Sort)
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	q := parse(t, "")
+	if q.Number != 0 {
+		t.Errorf("Queries without page parameters should not be paginated")
+	}
+
+	q = parse(t, "page[size]=1000")
+	if q.Number != 1 || q.Size != MaxPageSize {
+		t.Errorf("Unexpected page %d of size %d", q.Number, q.Size)
+	}
+
+	q = parse(t, "page[number]=2")
+	if q.Number != 2 || q.Size != DefaultPageSize {
+		t.Errorf("Unexpected page %d of size %d", q.Number, q.Size)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	invalid := []string{
+		"page[number]=0",
+		"page[number]=100000000000000000",
+		"page[number]=21474837",
+		"page[size]=abc",
+		"filter[password]=secret",
+		"sort=password",
+	}
+
+	for _, rawQuery := range invalid {
+		values, _ := url.ParseQuery(rawQuery)
+		_, err := Parse(values, columns.Fields())
+		if err == nil {
+			t.Errorf("%q should be rejected", rawQuery)
+		}
+	}
+}
+
+func TestSQL(t *testing.T) {
+	q := parse(t, "page[number]=3&page[size]=10&filter[is-admin]=true&filter[email]=a@b.c,d@e.f&sort=-first-name,email")
+
+	where, args := q.Where(columns, []string{"id <> $1::varchar"}, []interface{}{"admin"})
+	expected := " WHERE id <> $1::varchar AND email::varchar IN ($2::varchar, $3::varchar) AND is_admin::varchar IN ($4::varchar)"
+	if where != expected {
+		t.Errorf("Unexpected WHERE clause %q", where)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"admin", "a@b.c", "d@e.f", "true"}) {
+		t.Errorf("Unexpected arguments %v", args)
+	}
+
+	if orderBy := q.OrderBy(columns, "email"); orderBy != " ORDER BY first_name DESC, email" {
+		t.Errorf("Unexpected ORDER BY clause %q", orderBy)
+	}
+	if limit := q.Limit(); limit != " LIMIT 10 OFFSET 20" {
+		t.Errorf("Unexpected LIMIT clause %q", limit)
+	}
+
+	q = parse(t, "")
+	if where, _ := q.Where(columns, nil, nil); where != "" {
+		t.Errorf("Unexpected WHERE clause %q", where)
+	}
+	if orderBy := q.OrderBy(columns, "email"); orderBy != " ORDER BY email" {
+		t.Errorf("Unexpected ORDER BY clause %q", orderBy)
+	}
+	if limit := q.Limit(); limit != "" {
+		t.Errorf("Unexpected LIMIT clause %q", limit)
+	}
+}
+
+func TestApply(t *testing.T) {
+	items := []map[string]string{
+		{"name": "c", "status": "up", "progress": "9"},
+		{"name": "a", "status": "down", "progress": "100"},
+		{"name": "b", "status": "up", "progress": "10"},
+		{"name": "d", "status": "up", "progress": "50"},
+	}
+	value := func(i int, field string) string {
+		return items[i][field]
+	}
+	fields := []string{"name", "status", "progress"}
+
+	values, _ := url.ParseQuery("filter[status]=up&sort=-progress&page[size]=2")
+	q, err := Parse(values, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexes, total := q.Apply(len(items), value)
+	if total != 3 {
+		t.Errorf("Expected 3 items, have %d", total)
+	}
+	// Progresses are compared as numbers.
+	if !reflect.DeepEqual(indexes, []int{3, 2}) {
+		t.Errorf("Unexpected page %v", indexes)
+	}
+
+	q.Number = 2
+	indexes, _ = q.Apply(len(items), value)
+	if !reflect.DeepEqual(indexes, []int{0}) {
+		t.Errorf("Unexpected page %v", indexes)
+	}
+
+	q.Number = 3
+	indexes, _ = q.Apply(len(items), value)
+	if len(indexes) != 0 {
+		t.Errorf("Unexpected page %v", indexes)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	u, _ := url.Parse("/api/users?page[number]=2&page[size]=10&sort=email")
+	q, err := Parse(u.Query(), columns.Fields())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := q.Links(u, 25)
+	expected := map[string]string{
+		"self":  "/api/users?page[number]=2&page[size]=10&sort=email",
+		"first": "/api/users?page%5Bnumber%5D=1&page%5Bsize%5D=10&sort=email",
+		"prev":  "/api/users?page%5Bnumber%5D=1&page%5Bsize%5D=10&sort=email",
+		"next":  "/api/users?page%5Bnumber%5D=3&page%5Bsize%5D=10&sort=email",
+		"last":  "/api/users?page%5Bnumber%5D=3&page%5Bsize%5D=10&sort=email",
+	}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("Unexpected links %v", links)
+	}
+}
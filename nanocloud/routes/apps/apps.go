@@ -27,7 +27,9 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	m "github.com/Nanocloud/community/nanocloud/middlewares"
 	"github.com/Nanocloud/community/nanocloud/models/apps"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
@@ -63,34 +65,24 @@ func GetConnections(c *echo.Context) error {
 	return c.JSON(http.StatusOK, hash{"data": response})
 }
 
+// ListApplications returns the applications published, or, for the users
+// without the apps:read permission, the ones they can open.
 func ListApplications(c *echo.Context) error {
 	user := c.Get("user").(*users.User)
 
-	if !user.IsAdmin {
-		applications, err := apps.GetUserApps(user.Id)
-		if err == apps.GetAppsFailed {
-			return c.JSON(http.StatusInternalServerError, hash{
-				"error": [1]hash{
-					hash{
-						"detail": err.Error(),
-					},
-				},
-			})
-		}
+	q, err := utils.ParseQuery(c, apps.Columns.Fields())
+	if err != nil {
+		return err
+	}
 
-		var response = make([]hash, len(applications))
-		for i, val := range applications {
-			res := hash{
-				"id":         val.Id,
-				"type":       "apps",
-				"attributes": val,
-			}
-			response[i] = res
-		}
-		return c.JSON(http.StatusOK, hash{"data": response})
+	var applications []*apps.App
+	var total int
+	if m.Can(c, roles.AppsRead) {
+		applications, total, err = apps.GetAllApps(q)
+	} else {
+		applications, total, err = apps.GetUserApps(user.Id, q)
 	}
 
-	applications, err := apps.GetAllApps()
 	if err == apps.GetAppsFailed {
 		return c.JSON(http.StatusInternalServerError, hash{
 			"error": [1]hash{
@@ -101,16 +93,7 @@ func ListApplications(c *echo.Context) error {
 		})
 	}
 
-	var response = make([]hash, len(applications))
-	for i, val := range applications {
-		res := hash{
-			"id":         val.Id,
-			"type":       "apps",
-			"attributes": val,
-		}
-		response[i] = res
-	}
-	return c.JSON(http.StatusOK, hash{"data": response})
+	return utils.JSONList(c, http.StatusOK, applications, q, total)
 }
 
 // Make an application unusable
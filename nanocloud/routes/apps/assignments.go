@@ -0,0 +1,121 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package apps
+
+import (
+	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/apps"
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// getApp returns the application specified in the route.
+func getApp(c *echo.Context) (*apps.App, error) {
+	app, err := apps.GetApp(c.Param("app_id"))
+	if err != nil {
+		log.Error(err)
+		return nil, apiErrors.InternalError.Detail("Unable to retrieve the application")
+	}
+
+	if app == nil {
+		return nil, apiErrors.AppNotFound
+	}
+	return app, nil
+}
+
+func assignmentError(err error) error {
+	switch err {
+	case apps.InvalidAssignment:
+		return apiErrors.InvalidRequest.Detail(err.Error())
+	case apps.AssignmentDuplicated:
+		return apiErrors.AssignmentDuplicated
+	case apps.AssignmentNotFound:
+		return apiErrors.AssignmentNotFound
+	case users.UserNotFound:
+		return apiErrors.UserNotFound
+	case groups.GroupNotFound:
+		return apiErrors.GroupNotFound
+	}
+
+	log.Error(err)
+	return apiErrors.InternalError
+}
+
+// ListAssignments returns the users and groups entitled to the application.
+func ListAssignments(c *echo.Context) error {
+	app, err := getApp(c)
+	if err != nil {
+		return err
+	}
+
+	assignments, err := app.Assignments()
+	if err != nil {
+		return assignmentError(err)
+	}
+
+	return utils.JSON(c, http.StatusOK, assignments)
+}
+
+// CreateAssignment entitles the user (`user-id`) or the group (`group-id`)
+// to the application.
+func CreateAssignment(c *echo.Context) error {
+	app, err := getApp(c)
+	if err != nil {
+		return err
+	}
+
+	var attributes apps.Assignment
+
+	err = utils.ParseJSONBody(c, &attributes)
+	if err != nil {
+		return err
+	}
+
+	assignment, err := app.Assign(attributes.UserId, attributes.GroupId)
+	if err != nil {
+		return assignmentError(err)
+	}
+
+	return utils.JSON(c, http.StatusCreated, assignment)
+}
+
+func DeleteAssignment(c *echo.Context) error {
+	app, err := getApp(c)
+	if err != nil {
+		return err
+	}
+
+	err = app.Unassign(c.Param("id"))
+	if err != nil {
+		return assignmentError(err)
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
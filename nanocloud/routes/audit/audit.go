@@ -0,0 +1,89 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package audit
+
+import (
+	"net/http"
+	"time"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/audit"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// parseDate parses a RFC3339 date or a UTC day like `2016-06-01`. When used
+// as an upper bound, a day includes its whole duration.
+func parseDate(value string, upper bool) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+
+	t, err = time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, err
+	}
+
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// List returns the audit entries, the most recent first. Besides the
+// `filter[...]` parameters, `from` and `to` restrict the period.
+func List(c *echo.Context) error {
+	var filter audit.Filter
+	var err error
+
+	from := c.Query("from")
+	if from != "" {
+		filter.From, err = parseDate(from, false)
+		if err != nil {
+			return apiErrors.InvalidRequest.Detail("Invalid from date")
+		}
+	}
+
+	to := c.Query("to")
+	if to != "" {
+		filter.To, err = parseDate(to, true)
+		if err != nil {
+			return apiErrors.InvalidRequest.Detail("Invalid to date")
+		}
+	}
+
+	q, err := utils.ParseQuery(c, audit.Columns.Fields())
+	if err != nil {
+		return err
+	}
+
+	entries, total, err := audit.Find(filter, q)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the audit log")
+	}
+
+	return utils.JSONList(c, http.StatusOK, entries, q, total)
+}
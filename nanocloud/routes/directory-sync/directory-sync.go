@@ -0,0 +1,71 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package directorysync
+
+import (
+	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/directory"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+func syncError(err error) error {
+	switch err {
+	case directory.NotConfigured:
+		return apiErrors.DirectorySyncNotConfigured
+	case directory.Unreachable:
+		return apiErrors.DirectoryUnreachable
+	}
+
+	log.Error(err)
+	return apiErrors.InternalError.Detail("Unable to synchronize the directory")
+}
+
+// Preview returns the changes the next synchronization would make,
+// without applying them.
+func Preview(c *echo.Context) error {
+	diff, err := directory.Preview(directory.LoadConfig())
+	if err != nil {
+		return syncError(err)
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"data": diff,
+	})
+}
+
+// Apply synchronizes the directory now and returns the changes made.
+func Apply(c *echo.Context) error {
+	diff, err := directory.Sync(directory.LoadConfig())
+	if err != nil {
+		return syncError(err)
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"data": diff,
+	})
+}
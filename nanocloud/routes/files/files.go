@@ -8,7 +8,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"path/filepath"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -75,7 +75,8 @@ func oauthError(c *echo.Context, fail *oauth2.OAuthError) error {
  * Where:
  *  - access_token_id is the id of a OAuth access token of the file owner
  *  - oauth_access_token is the token associated to access_token_id
- *  - filename is the name of the filename associated to the token
+ *  - filename is the name of the file (or of the directory downloaded as an
+ *    archive) associated to the token
  *  - time_stone is this (($NOW + $NOW % 3600) + 3600) where NOW is a unix timestamp.
  *    It makes a download token valid for current and the next hour.
  *    (is the token is generate at 1:55am then the token is valid from 1:00am to 2:59)
@@ -171,6 +172,12 @@ func GetDownloadToken(c *echo.Context) error {
 		)
 	}
 
+	// The token is only given for the files the user can download
+	_, err := relativePath(filename)
+	if err != nil {
+		return err
+	}
+
 	accessToken, fail := oauth2.GetAccessToken(c.Request())
 	if fail != nil {
 		return oauthError(c, fail)
@@ -189,101 +196,156 @@ func GetDownloadToken(c *echo.Context) error {
 	)
 }
 
-func Get(c *echo.Context) error {
-	w := c.Response()
-	r := c.Request()
+func plazaURL() string {
+	return "http://" + utils.Env("PLAZA_ADDRESS", "iaas-module") + ":" + utils.Env("PLAZA_PORT", "9090")
+}
 
+func setNoCacheHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Cashe-Control", "no-store")
 	w.Header().Set("Expires", "Sat, 01 Jan 2000 00:00:00 GMT")
 	w.Header().Set("Pragma", "no-cache")
+}
 
-	path := c.Query("path")
-
-	filename := c.Query("filename")
-	if len(filename) == 0 {
-		return c.JSON(
-			http.StatusBadRequest,
-			hash{
-				"error": "Invalid Path",
-			},
-		)
-	}
-
-	var user *users.User
-	var err error
-
+/*
+ * Return the user owning the download token of the request for the specified
+ * filename, or the user authenticated by the OAuth access token if there is no
+ * download token.
+ * If the user cannot be authenticated, the error response is sent and a nil
+ * user is returned.
+ */
+func downloadUser(c *echo.Context, filename string) (*users.User, error) {
 	downloadToken := c.Query("token")
 	if len(downloadToken) > 0 {
-		user, err = checkDownloadToken(downloadToken, filename)
-		if err != nil {
-			return c.JSON(
+		user, err := checkDownloadToken(downloadToken, filename)
+		if err != nil || user == nil {
+			return nil, c.JSON(
 				http.StatusBadRequest,
 				hash{
 					"error": "Invalid Download Token",
 				},
 			)
 		}
+		return user, nil
 	}
 
-	if user == nil {
-		u, fail := oauth2.GetUser(w, r)
-		if u == nil {
-			return errors.New("no authenticated user")
-		}
+	u, fail := oauth2.GetUser(c.Response(), c.Request())
+	if fail != nil {
+		return nil, oauthError(c, fail)
+	}
+
+	if u == nil {
+		return nil, errors.New("no authenticated user")
+	}
+	return u.(*users.User), nil
+}
+
+// relativePath returns the components of a filename relative to the user's
+// directory. Absolute paths, drives and parent references are refused so
+// that a user cannot reach the files of the others.
+func relativePath(filename string) ([]string, error) {
+	filename = strings.Replace(filename, "\\", "/", -1)
+	if strings.HasPrefix(filename, "/") || strings.Contains(filename, ":") {
+		return nil, apiErrors.InvalidRequest.Detail("The filename must be relative to the user's directory")
+	}
 
-		if fail != nil {
-			return oauthError(c, fail)
+	parts := make([]string, 0)
+	for _, part := range strings.Split(filename, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			return nil, apiErrors.InvalidRequest.Detail("The filename cannot leave the user's directory")
 		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
 
-		user = u.(*users.User)
+/*
+ * Return the path of filename on the execution server.
+ * The filename is relative to the user's directory and confined to it.
+ */
+func userPath(user *users.User, filename string) (string, error) {
+	parts, err := relativePath(filename)
+	if err != nil {
+		return "", err
 	}
 
 	winUser, err := user.WindowsCredentials()
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	var plazaPlatform struct {
 		System       string `json:"System"`
 		Architecture string `json:"Architecture"`
 	}
-	resp, err := http.Get("http://" + utils.Env("PLAZA_ADDRESS", "iaas-module") + ":" + utils.Env("PLAZA_PORT", "9090"))
+	resp, err := http.Get(plazaURL())
 	if err != nil {
 		log.Error(err)
-		return apiErrors.WindowsNotOnline.Detail(err.Error())
+		return "", apiErrors.WindowsNotOnline.Detail(err.Error())
 	}
+	defer resp.Body.Close()
+
 	plazaPlatformJson, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Error(err)
+		return "", apiErrors.WindowsNotOnline.Detail(err.Error())
+	}
 	err = json.Unmarshal(plazaPlatformJson, &plazaPlatform)
 	if err != nil {
 		log.Error(err)
-		return apiErrors.WindowsNotOnline.Detail(err.Error())
+		return "", apiErrors.WindowsNotOnline.Detail(err.Error())
 	}
 
-	if filename[0] == '.' {
-		if plazaPlatform.System == "linux" {
-			path = filepath.Join(
-				fmt.Sprintf(
-					utils.Env("PLAZA_USER_DIR", "/opt/Users/%s"),
-					"u"+user.Id[:20],
-				),
-				filename,
-			)
-		} else {
-			path = filepath.Join(
-				fmt.Sprintf(
-					utils.Env("PLAZA_USER_DIR", "C:\\Users\\%s\\Desktop\\Nanocloud"),
-					winUser.Sam,
-				),
-				filename,
-			)
-		}
-	} else {
-		filename = strings.Replace(filename, "/", "\\", -1)
-		path = filename
+	if plazaPlatform.System == "linux" {
+		return path.Join(
+			fmt.Sprintf(
+				utils.Env("PLAZA_USER_DIR", "/opt/Users/%s"),
+				"u"+user.Id[:20],
+			),
+			strings.Join(parts, "/"),
+		), nil
+	}
+
+	dir := fmt.Sprintf(
+		utils.Env("PLAZA_USER_DIR", "C:\\Users\\%s\\Desktop\\Nanocloud"),
+		winUser.Sam,
+	)
+	if len(parts) == 0 {
+		return dir, nil
+	}
+	return strings.TrimRight(dir, "\\") + "\\" + strings.Join(parts, "\\"), nil
+}
+
+func Get(c *echo.Context) error {
+	w := c.Response()
+	r := c.Request()
+
+	setNoCacheHeaders(w)
+
+	filename := c.Query("filename")
+	if len(filename) == 0 {
+		return c.JSON(
+			http.StatusBadRequest,
+			hash{
+				"error": "Invalid Path",
+			},
+		)
+	}
+
+	user, err := downloadUser(c, filename)
+	if user == nil {
+		return err
 	}
 
-	resp, err = http.Get("http://" + utils.Env("PLAZA_ADDRESS", "iaas-module") + ":" + utils.Env("PLAZA_PORT", "9090") + "/files?create=true&path=" + url.QueryEscape(path))
+	path, err := userPath(user, filename)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Get(plazaURL() + "/files?create=true&path=" + url.QueryEscape(path))
 	if err != nil {
 		log.Error(err)
 		return apiErrors.WindowsNotOnline.Detail(err.Error())
@@ -362,3 +424,78 @@ func Get(c *echo.Context) error {
 	}
 	return nil
 }
+
+// GetArchive streams a zip (or tar.gz if format is `tar.gz`) archive of the
+// directory specified by filename. Like Get, it accepts a download token so
+// the browser can download the archive without an OAuth header.
+func GetArchive(c *echo.Context) error {
+	w := c.Response()
+
+	setNoCacheHeaders(w)
+
+	filename := c.Query("filename")
+	if len(filename) == 0 {
+		return c.JSON(
+			http.StatusBadRequest,
+			hash{
+				"error": "Invalid Path",
+			},
+		)
+	}
+
+	format := c.Query("format")
+	if format == "" {
+		format = "zip"
+	}
+
+	if format != "zip" && format != "tar.gz" {
+		return c.JSON(
+			http.StatusBadRequest,
+			hash{
+				"error": "Unsupported archive format",
+			},
+		)
+	}
+
+	user, err := downloadUser(c, filename)
+	if user == nil {
+		return err
+	}
+
+	path, err := userPath(user, filename)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Get(plazaURL() + "/files/archive?format=" + url.QueryEscape(format) + "&path=" + url.QueryEscape(path))
+	if err != nil {
+		log.Error(err)
+		return apiErrors.WindowsNotOnline.Detail(err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		jsonResponse(w, c.Request(), http.StatusNotFound, hash{
+			"error": "Directory Not Found",
+		})
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		contents, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return errors.New("Unable to retrieve the archive")
+		}
+		return apiErrors.InvalidRequest.Detail(string(contents))
+	}
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.Header().Set("Content-Disposition", resp.Header.Get("Content-Disposition"))
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		log.Errorf("Unable to stream the archive of %s: %s", path, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package files
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRelativePath(t *testing.T) {
+	valid := map[string][]string{
+		"./report.pdf":       {"report.pdf"},
+		".":                  {},
+		"./docs\\2016/a.txt": {"docs", "2016", "a.txt"},
+		"docs//a.txt":        {"docs", "a.txt"},
+	}
+
+	for filename, expected := range valid {
+		parts, err := relativePath(filename)
+		if err != nil || !reflect.DeepEqual(parts, expected) {
+			t.Errorf("relativePath(%q) = %v, %v, expected %v", filename, parts, err, expected)
+		}
+	}
+
+	refused := []string{
+		"C:\\Users\\admin",
+		"C:/Users/admin",
+		"/etc/passwd",
+		"\\\\server\\share",
+		"./../..",
+		"../other",
+		"./docs/../../other",
+		"./docs/../x",
+		"./file.txt:stream",
+	}
+
+	for _, filename := range refused {
+		if _, err := relativePath(filename); err == nil {
+			t.Errorf("%q should be refused", filename)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package groups
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// getGroup returns the group specified in the route.
+func getGroup(c *echo.Context) (*groups.Group, error) {
+	group, err := groups.GetGroup(c.Param("id"))
+	if err == groups.GroupNotFound {
+		return nil, apiErrors.GroupNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return nil, apiErrors.InternalError.Detail("Unable to retrieve the group")
+	}
+	return group, nil
+}
+
+func groupError(err error) error {
+	switch err {
+	case groups.InvalidName:
+		return apiErrors.InvalidRequest.Detail("name is missing")
+	case groups.GroupDuplicated:
+		return apiErrors.GroupDuplicated
+	case groups.GroupNotFound:
+		return apiErrors.GroupNotFound
+	case users.UserNotFound:
+		return apiErrors.UserNotFound
+	}
+
+	log.Error(err)
+	return apiErrors.InternalError
+}
+
+func List(c *echo.Context) error {
+	q, err := utils.ParseQuery(c, groups.Columns.Fields())
+	if err != nil {
+		return err
+	}
+
+	list, total, err := groups.FindGroups(q)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the groups")
+	}
+
+	return utils.JSONList(c, http.StatusOK, list, q, total)
+}
+
+func Get(c *echo.Context) error {
+	group, err := getGroup(c)
+	if err != nil {
+		return err
+	}
+
+	return utils.JSON(c, http.StatusOK, group)
+}
+
+func Create(c *echo.Context) error {
+	var attributes groups.Group
+
+	err := utils.ParseJSONBody(c, &attributes)
+	if err != nil {
+		return err
+	}
+
+	group, err := groups.CreateGroup(attributes.Name)
+	if err != nil {
+		return groupError(err)
+	}
+
+	return utils.JSON(c, http.StatusCreated, group)
+}
+
+// Update renames the group.
+func Update(c *echo.Context) error {
+	group, err := getGroup(c)
+	if err != nil {
+		return err
+	}
+
+	var attributes groups.Group
+
+	err = utils.ParseJSONBody(c, &attributes)
+	if err != nil {
+		return err
+	}
+
+	err = group.Rename(attributes.Name)
+	if err != nil {
+		return groupError(err)
+	}
+
+	return utils.JSON(c, http.StatusOK, group)
+}
+
+// Delete the group. Its members keep their accounts but lose the
+// applications assigned to the group.
+func Delete(c *echo.Context) error {
+	group, err := getGroup(c)
+	if err != nil {
+		return err
+	}
+
+	err = group.Delete()
+	if err != nil {
+		return groupError(err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"meta": map[string]interface{}{},
+	})
+}
+
+// ListMembers returns the users of the group.
+func ListMembers(c *echo.Context) error {
+	group, err := getGroup(c)
+	if err != nil {
+		return err
+	}
+
+	ids, err := group.MemberIds()
+	if err != nil {
+		return groupError(err)
+	}
+
+	members := make([]*users.User, 0, len(ids))
+	for _, id := range ids {
+		user, err := users.GetUser(id)
+		if err != nil {
+			return groupError(err)
+		}
+		if user != nil {
+			members = append(members, user)
+		}
+	}
+
+	return utils.JSON(c, http.StatusOK, members)
+}
+
+// AddMember adds the user identified in the body
+// (`{"data": {"type": "users", "id": "..."}}`) to the group.
+func AddMember(c *echo.Context) error {
+	group, err := getGroup(c)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Data struct {
+			Type string `json:"type"`
+			Id   string `json:"id"`
+		} `json:"data"`
+	}
+
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return apiErrors.InvalidRequest
+	}
+
+	err = json.Unmarshal(b, &body)
+	if err != nil || body.Data.Type != "users" || body.Data.Id == "" {
+		return apiErrors.InvalidRequest.Detail("A user is expected")
+	}
+
+	err = group.AddMember(body.Data.Id)
+	if err != nil {
+		return groupError(err)
+	}
+
+	return utils.JSON(c, http.StatusOK, group)
+}
+
+func RemoveMember(c *echo.Context) error {
+	group, err := getGroup(c)
+	if err != nil {
+		return err
+	}
+
+	err = group.RemoveMember(c.Param("user_id"))
+	if err != nil {
+		return groupError(err)
+	}
+
+	return utils.JSON(c, http.StatusOK, group)
+}
@@ -23,60 +23,269 @@
 package histories
 
 import (
+	"encoding/csv"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
 	"github.com/Nanocloud/community/nanocloud/models/histories"
+	"github.com/Nanocloud/community/nanocloud/query"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 )
 
-type hash map[string]interface{}
+const dayFormat = "2006-01-02"
 
-// Get a list of all the log entries of the database
-func List(c *echo.Context) error {
+// parseDate parses a RFC3339 date or a day like `2016-06-01` in loc. When
+// used as an upper bound, a day includes its whole duration.
+func parseDate(value string, loc *time.Location, upper bool) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
 
-	histories, err := histories.FindAll()
+	t, err = time.ParseInLocation(dayFormat, value, loc)
 	if err != nil {
-		return err
+		return t, err
+	}
+
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// parseFilter reads the `from` and `to` query parameters. Days are
+// interpreted in the time zone named by `tz` (UTC by default).
+func parseFilter(c *echo.Context) (histories.Filter, *time.Location, error) {
+	filter := histories.Filter{}
+
+	loc, err := time.LoadLocation(c.Query("tz"))
+	if err != nil {
+		return filter, nil, apiErrors.InvalidRequest.Detail("Unknown time zone")
+	}
+
+	from := c.Query("from")
+	if from != "" {
+		filter.From, err = parseDate(from, loc, false)
+		if err != nil {
+			return filter, nil, apiErrors.InvalidRequest.Detail("Invalid from date")
+		}
+	}
+
+	to := c.Query("to")
+	if to != "" {
+		filter.To, err = parseDate(to, loc, true)
+		if err != nil {
+			return filter, nil, apiErrors.InvalidRequest.Detail("Invalid to date")
+		}
+	}
+
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, nil, apiErrors.InvalidRequest.Detail("from must be before to")
+	}
+	return filter, loc, nil
+}
+
+// The reports cover the current month unless a period is given.
+func reportFilter(c *echo.Context) (histories.Filter, *time.Location, error) {
+	filter, loc, err := parseFilter(c)
+	if err != nil {
+		return filter, loc, err
 	}
-	return utils.JSON(c, http.StatusOK, histories)
+
+	now := time.Now()
+	if filter.To.IsZero() || filter.To.After(now) {
+		filter.To = now
+	}
+	if filter.From.IsZero() {
+		t := now.In(loc)
+		filter.From = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	}
+
+	if !filter.From.Before(filter.To) {
+		return filter, nil, apiErrors.InvalidRequest.Detail("from must be in the past")
+	}
+	return filter, loc, nil
+}
+
+func wantsCSV(c *echo.Context) bool {
+	return c.Query("format") == "csv"
+}
+
+func writeCSV(c *echo.Context, filename string, records [][]string) error {
+	w := c.Response()
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	err := writer.WriteAll(records)
+	if err != nil {
+		log.Errorf("Unable to write %s: %s", filename, err)
+	}
+	return nil
 }
 
-// Add a new log entry to the database
-func Add(c *echo.Context) error {
-	history := histories.History{}
+func formatDate(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(time.RFC3339)
+}
+
+// findHistories returns the histories selected by the query parameters.
+// Reports are computed over all the histories matching the filters.
+func findHistories(c *echo.Context, filter histories.Filter, paginated bool) ([]*histories.History, *query.Query, int, error) {
+	q, err := utils.ParseQuery(c, histories.Columns.Fields())
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	if !paginated {
+		q.Number = 0
+	}
 
-	err := utils.ParseJSONBody(c, &history)
+	list, total, err := histories.Find(filter, q)
 	if err != nil {
 		log.Error(err)
+		return nil, nil, 0, apiErrors.InternalError.Detail("Unable to retrieve the histories")
+	}
+	return list, q, total, nil
+}
+
+// Get the sessions recorded, the most recent first. Besides the `filter`,
+// `sort` and `page` parameters, `from` and `to` select the sessions running
+// during a period (see parseFilter). Histories are recorded by the backend
+// (see histories.Record); clients cannot add entries. `format=csv` exports
+// all the matching histories as CSV.
+func List(c *echo.Context) error {
+	filter, loc, err := parseFilter(c)
+	if err != nil {
+		return err
+	}
+
+	list, q, total, err := findHistories(c, filter, !wantsCSV(c))
+	if err != nil {
 		return err
 	}
 
-	if history.UserId == "" || history.UserMail == "" || history.UserFirstname == "" || history.UserLastname == "" || history.ConnectionId == "" || history.StartDate == "" || history.EndDate == "" {
-		log.Error("Missing one or several parameters to create entry")
-		return c.JSON(http.StatusBadRequest, hash{
-			"error": [1]hash{
-				hash{
-					"detail": "Missing parameters",
-				},
-			},
+	if !wantsCSV(c) {
+		return utils.JSONList(c, http.StatusOK, list, q, total)
+	}
+
+	records := [][]string{{
+		"id", "user-id", "user-mail", "user-firstname", "user-lastname",
+		"machine-id", "connection-id", "app-alias", "start-date", "end-date",
+		"duration",
+	}}
+
+	now := time.Now()
+	for _, h := range list {
+		end := now
+		endDate := ""
+		if h.EndDate != nil {
+			end = *h.EndDate
+			endDate = formatDate(end, loc)
+		}
+
+		records = append(records, []string{
+			h.Id, h.UserId, h.UserMail, h.UserFirstname, h.UserLastname,
+			h.MachineId, h.ConnectionId, h.AppAlias, formatDate(h.StartDate, loc), endDate,
+			strconv.FormatInt(int64(end.Sub(h.StartDate)/time.Second), 10),
 		})
 	}
+	return writeCSV(c, "histories.csv", records)
+}
 
-	err = utils.ParseJSONBody(c, &history)
-	newHistory, err := histories.CreateHistory(
-		history.UserId,
-		history.UserMail,
-		history.UserFirstname,
-		history.UserLastname,
-		history.ConnectionId,
-		history.StartDate,
-		history.EndDate,
-	)
+// Usage returns the connection time (in seconds) and number of sessions
+// between `from` and `to` (the current month by default) of the histories
+// matching the `filter` parameters. `group-by` is a comma separated list of
+// `user`, `app`, `machine` and `day`.
+func Usage(c *echo.Context) error {
+	filter, loc, err := reportFilter(c)
 	if err != nil {
 		return err
 	}
 
-	return utils.JSON(c, http.StatusCreated, newHistory)
+	groups := make([]string, 0)
+	if c.Query("group-by") != "" {
+		groups = strings.Split(c.Query("group-by"), ",")
+	}
+
+	err = histories.ValidateGroups(groups)
+	if err != nil {
+		return apiErrors.InvalidRequest.Detail("group-by must only contain user, app, machine and day")
+	}
+
+	list, _, _, err := findHistories(c, filter, false)
+	if err != nil {
+		return err
+	}
+
+	usages := histories.Aggregate(list, groups, filter.From, filter.To, loc)
+	if !wantsCSV(c) {
+		return utils.JSON(c, http.StatusOK, usages)
+	}
+
+	header := make([]string, 0)
+	for _, group := range groups {
+		switch group {
+		case histories.ByUser:
+			header = append(header, "user-id", "user-mail")
+		case histories.ByApp:
+			header = append(header, "app-alias")
+		case histories.ByMachine:
+			header = append(header, "machine-id")
+		case histories.ByDay:
+			header = append(header, "day")
+		}
+	}
+	header = append(header, "sessions", "duration")
+
+	records := [][]string{header}
+	for _, u := range usages {
+		record := make([]string, 0, len(header))
+		for _, group := range groups {
+			switch group {
+			case histories.ByUser:
+				record = append(record, u.UserId, u.UserMail)
+			case histories.ByApp:
+				record = append(record, u.AppAlias)
+			case histories.ByMachine:
+				record = append(record, u.MachineId)
+			case histories.ByDay:
+				record = append(record, u.Day)
+			}
+		}
+		record = append(record, strconv.Itoa(u.Sessions), strconv.FormatInt(u.Duration, 10))
+		records = append(records, record)
+	}
+	return writeCSV(c, "usage.csv", records)
+}
+
+// Concurrency returns, for each day between `from` and `to` (the current
+// month by default), the largest number of sessions matching the `filter`
+// parameters running at the same time.
+func Concurrency(c *echo.Context) error {
+	filter, loc, err := reportFilter(c)
+	if err != nil {
+		return err
+	}
+
+	list, _, _, err := findHistories(c, filter, false)
+	if err != nil {
+		return err
+	}
+
+	peaks := histories.Peaks(list, filter.From, filter.To, loc)
+	if !wantsCSV(c) {
+		return utils.JSON(c, http.StatusOK, peaks)
+	}
+
+	records := [][]string{{"day", "sessions", "at"}}
+	for _, p := range peaks {
+		records = append(records, []string{p.Day, strconv.Itoa(p.Sessions), formatDate(p.At, loc)})
+	}
+	return writeCSV(c, "concurrency.csv", records)
 }
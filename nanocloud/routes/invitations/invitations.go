@@ -0,0 +1,115 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package invitations
+
+import (
+	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/mail"
+	"github.com/Nanocloud/community/nanocloud/models/signup"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+func List(c *echo.Context) error {
+	q, err := utils.ParseQuery(c, signup.Columns.Fields())
+	if err != nil {
+		return err
+	}
+
+	invitations, total, err := signup.FindInvitations(q)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the invitations")
+	}
+
+	return utils.JSONList(c, http.StatusOK, invitations, q, total)
+}
+
+// Create invites the email to sign up. The invitation token is only sent by
+// email.
+func Create(c *echo.Context) error {
+	if !mail.Configured() {
+		return apiErrors.MailNotConfigured
+	}
+
+	var attributes signup.Invitation
+
+	err := utils.ParseJSONBody(c, &attributes)
+	if err != nil {
+		return err
+	}
+
+	user := c.Get("user").(*users.User)
+
+	invitation, token, err := signup.Invite(attributes.Email, user.GetID())
+	switch err {
+	case nil:
+	case signup.InvalidEmail:
+		return apiErrors.InvalidRequest.Detail(err.Error())
+	case users.UserDuplicated:
+		return apiErrors.UserExists
+	default:
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to create the invitation")
+	}
+
+	err = mail.Send(signup.InvitationMessage(invitation.Email, user, token))
+	if err != nil {
+		log.Errorf("Unable to send the invitation to %s: %s", invitation.Email, err.Error())
+		invitation.Revoke()
+		return apiErrors.InternalError.Detail("Unable to send the email")
+	}
+
+	return utils.JSON(c, http.StatusCreated, invitation)
+}
+
+// Delete revokes a pending invitation.
+func Delete(c *echo.Context) error {
+	invitation, err := signup.GetInvitation(c.Param("id"))
+	if err == signup.InvitationNotFound {
+		return apiErrors.InvitationNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the invitation")
+	}
+
+	err = invitation.Revoke()
+	if err == signup.InvalidInvitation {
+		return apiErrors.InvalidInvitation.Detail("The invitation was already accepted")
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to revoke the invitation")
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
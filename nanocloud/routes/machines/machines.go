@@ -24,6 +24,7 @@ package machines
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/vms"
 	"github.com/Nanocloud/community/nanocloud/errors"
@@ -56,6 +57,26 @@ func (m *machine) SetID(id string) error {
 	return nil
 }
 
+// Fields the machines can be filtered and sorted by. The machines are
+// listed by the driver so the query is applied in memory.
+var machineFields = []string{"name", "ip", "status", "platform", "progress"}
+
+func (m *machine) field(name string) string {
+	switch name {
+	case "name":
+		return m.Name
+	case "ip":
+		return m.Ip
+	case "status":
+		return m.Status
+	case "platform":
+		return m.Platform
+	case "progress":
+		return strconv.Itoa(m.Progress)
+	}
+	return ""
+}
+
 func (m *machine) SetToOneReferenceID(name, ID string) error {
 	if name == "type" {
 		m.Type = ID
@@ -97,7 +118,7 @@ func PatchMachine(c *echo.Context) error {
 		}
 
 	case "down":
-		if status != vms.StatusUp {
+		if status != vms.StatusUp && status != vms.StatusDegraded {
 			return errors.UnableToUpdateMachineStatus
 		}
 		err = m.Stop()
@@ -170,6 +191,11 @@ func GetMachine(c *echo.Context) error {
 }
 
 func Machines(c *echo.Context) error {
+	q, err := utils.ParseQuery(c, machineFields)
+	if err != nil {
+		return err
+	}
+
 	machines, err := vms.Machines()
 	if err != nil {
 		log.Error(err)
@@ -212,7 +238,16 @@ func Machines(c *echo.Context) error {
 		res[i] = &m
 	}
 
-	return utils.JSON(c, http.StatusOK, res)
+	indexes, total := q.Apply(len(res), func(i int, field string) string {
+		return res[i].field(field)
+	})
+
+	page := make([]*machine, len(indexes))
+	for i, index := range indexes {
+		page[i] = res[index]
+	}
+
+	return utils.JSONList(c, http.StatusOK, page, q, total)
 }
 
 func CreateMachine(c *echo.Context) error {
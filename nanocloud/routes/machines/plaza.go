@@ -0,0 +1,77 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package machines
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/vms"
+	"github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/plaza"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// UpdatePlaza pushes the plaza release to the machines running an other
+// version and returns the version of each machine up.
+func UpdatePlaza(c *echo.Context) error {
+	release, err := plaza.LoadRelease()
+	if err != nil {
+		log.Error(err)
+		return errors.PlazaReleaseNotFound
+	}
+
+	port, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
+	if err != nil {
+		return err
+	}
+
+	machines, err := vms.Machines()
+	if err != nil {
+		log.Error(err)
+		return errors.UnableToRetrieveMachineList
+	}
+
+	results := plaza.UpdateMachines(machines, port, release)
+
+	res := make([]hash, len(results))
+	for i, result := range results {
+		res[i] = hash{
+			"machine": result.MachineId,
+			"version": result.Version,
+			"updated": result.Err == nil,
+		}
+		if result.Err != nil {
+			res[i]["error"] = result.Err.Error()
+		}
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"data": res,
+		"meta": hash{
+			"version": release.Version,
+		},
+	})
+}
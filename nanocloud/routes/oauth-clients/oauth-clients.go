@@ -0,0 +1,148 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package oauthclients
+
+import (
+	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+// getClient returns the client specified in the route.
+func getClient(c *echo.Context) (*oauth.Client, error) {
+	client, err := oauth.GetClient(c.Param("id"))
+	if err == oauth.ClientNotFound {
+		return nil, apiErrors.OAuthClientNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return nil, apiErrors.InternalError.Detail("Unable to retrieve the OAuth client")
+	}
+	return client, nil
+}
+
+func clientError(err error) error {
+	switch err {
+	case oauth.InvalidName, oauth.InvalidRedirectURIs, oauth.InvalidScopes, oauth.ClientProtected:
+		return apiErrors.InvalidRequest.Detail(err.Error())
+	case oauth.ClientDuplicated:
+		return apiErrors.OAuthClientDuplicated
+	case oauth.ClientNotFound:
+		return apiErrors.OAuthClientNotFound
+	}
+
+	log.Error(err)
+	return apiErrors.InternalError
+}
+
+func List(c *echo.Context) error {
+	q, err := utils.ParseQuery(c, oauth.Columns.Fields())
+	if err != nil {
+		return err
+	}
+
+	clients, total, err := oauth.FindClients(q)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the OAuth clients")
+	}
+
+	return utils.JSONList(c, http.StatusOK, clients, q, total)
+}
+
+func Get(c *echo.Context) error {
+	client, err := getClient(c)
+	if err != nil {
+		return err
+	}
+
+	return utils.JSON(c, http.StatusOK, client)
+}
+
+// Create registers a client. The secret of confidential clients is only
+// returned in this response.
+func Create(c *echo.Context) error {
+	var attributes oauth.Client
+
+	err := utils.ParseJSONBody(c, &attributes)
+	if err != nil {
+		return err
+	}
+
+	client, err := oauth.CreateClient(
+		attributes.Name,
+		attributes.RedirectURIs,
+		attributes.Scopes,
+		attributes.Confidential,
+	)
+	if err != nil {
+		return clientError(err)
+	}
+
+	return utils.JSON(c, http.StatusCreated, client)
+}
+
+// Update renames the client and replaces its redirect URIs and scopes.
+func Update(c *echo.Context) error {
+	client, err := getClient(c)
+	if err != nil {
+		return err
+	}
+
+	var attributes oauth.Client
+
+	err = utils.ParseJSONBody(c, &attributes)
+	if err != nil {
+		return err
+	}
+
+	err = client.Update(attributes.Name, attributes.RedirectURIs, attributes.Scopes)
+	if err != nil {
+		return clientError(err)
+	}
+
+	return utils.JSON(c, http.StatusOK, client)
+}
+
+// Delete the client. The tokens issued to it are revoked.
+func Delete(c *echo.Context) error {
+	client, err := getClient(c)
+	if err != nil {
+		return err
+	}
+
+	err = client.Delete()
+	if err != nil {
+		return clientError(err)
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
@@ -0,0 +1,235 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package passwordresets
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/mail"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+	"github.com/Nanocloud/community/nanocloud/models/throttle"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+type resetAttributes struct {
+	Data struct {
+		Attributes struct {
+			Email           string `json:"email"`
+			Token           string `json:"token"`
+			CurrentPassword string `json:"current-password"`
+			Password        string `json:"password"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+func parseBody(c *echo.Context) (*resetAttributes, error) {
+	var body resetAttributes
+
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &body)
+	if err != nil {
+		return nil, apiErrors.InvalidRequest
+	}
+	return &body, nil
+}
+
+func resetMessage(user *users.User, token string) *mail.Message {
+	link := mail.Link("reset-password", url.Values{"token": {token}})
+
+	return &mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Nanocloud password",
+		Body: "Hello " + user.FirstName + ",\n\n" +
+			"Someone asked to reset the password of your Nanocloud account. " +
+			"Follow this link within an hour to choose a new one:\n\n" +
+			link + "\n\n" +
+			"If you did not ask for it, you can ignore this email.\n",
+	}
+}
+
+// Create emails a reset link to the user. The reply is the same whether
+// the email is known or not.
+func Create(c *echo.Context) error {
+	if !mail.Configured() {
+		return apiErrors.MailNotConfigured
+	}
+
+	body, err := parseBody(c)
+	if err != nil {
+		return err
+	}
+
+	email := body.Data.Attributes.Email
+	if email == "" {
+		return apiErrors.InvalidRequest.Detail("email is missing")
+	}
+
+	user, err := users.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	// Users of a directory or of the single sign-on reset their password
+	// with their identity provider.
+	if user != nil && user.Activated && user.AuthProvider == users.LocalProvider {
+		token, err := passwords.CreateResetToken(user.GetID())
+		if err == passwords.ResetTooSoon {
+			log.Warnf("Password reset requested again too soon for %s", user.Email)
+		} else if err != nil {
+			return err
+		} else {
+			err = mail.Send(resetMessage(user, token))
+			if err != nil {
+				log.Errorf("Unable to send the password reset email to %s: %s", user.Email, err.Error())
+				return apiErrors.InternalError.Detail("Unable to send the email")
+			}
+		}
+	}
+
+	return c.JSON(http.StatusAccepted, hash{
+		"data": hash{
+			"success": true,
+		},
+	})
+}
+
+// Reset sets the new password of the user of the token. The lockout after
+// failed login attempts is lifted too. Without a token, the email and the
+// current password let users change their password once it has expired, so
+// that they are not locked out when emails cannot be sent.
+func Reset(c *echo.Context) error {
+	body, err := parseBody(c)
+	if err != nil {
+		return err
+	}
+
+	attributes := body.Data.Attributes
+	if attributes.Password == "" {
+		return apiErrors.InvalidRequest.Detail("password is missing")
+	}
+
+	var userId string
+	if attributes.Token == "" && attributes.CurrentPassword != "" {
+		userId, err = changeExpired(c, attributes.Email, attributes.CurrentPassword, attributes.Password)
+	} else if attributes.Token != "" {
+		userId, err = passwords.Reset(attributes.Token, attributes.Password)
+		if err == passwords.InvalidResetToken || err == users.UserNotFound {
+			return apiErrors.InvalidResetToken
+		}
+	} else {
+		return apiErrors.InvalidRequest.Detail("token is missing")
+	}
+	if v, ok := err.(*passwords.Violation); ok {
+		return apiErrors.WeakPassword.Detail(v.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	err = throttle.Unlock(userId)
+	if err != nil {
+		log.Errorf("Unable to unlock %s after a password reset: %s", userId, err.Error())
+	}
+
+	// Whoever knew the previous password is logged out
+	err = oauth.RevokeSessions(userId, "")
+	if err == nil {
+		err = oauth.RevokeAPIKeys(userId)
+	}
+	if err != nil {
+		log.Errorf("Unable to revoke the tokens of %s after a password reset: %s", userId, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"data": hash{
+			"success": true,
+		},
+	})
+}
+
+// changeExpired sets the new password of a user whose password has expired,
+// after checking the current one. The attempts are throttled like the
+// logins.
+func changeExpired(c *echo.Context, email, current, password string) (string, error) {
+	if email == "" {
+		return "", apiErrors.InvalidRequest.Detail("email is missing")
+	}
+
+	req := c.Request()
+	ip := utils.ClientIP(req)
+
+	wait, err := throttle.Check(email, ip)
+	if err != nil {
+		return "", err
+	}
+	if wait > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		return "", apiErrors.TooManyAttempts
+	}
+
+	user, err := users.GetUserFromEmailPassword(email, current)
+	if err == users.InvalidCredentials || err == users.UserNotFound {
+		err = throttle.Record(email, ip, req.UserAgent(), false)
+		if err != nil {
+			log.Error(err)
+		}
+		return "", apiErrors.InvalidCredentials
+	}
+	if err == users.UserDisabled {
+		return "", apiErrors.Unauthorized.Detail("The account is disabled or not activated yet")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = throttle.Record(email, ip, req.UserAgent(), true)
+	if err != nil {
+		log.Error(err)
+	}
+
+	expired, err := passwords.Expired(user.GetID())
+	if err != nil {
+		return "", err
+	}
+	if !expired {
+		return "", apiErrors.InvalidRequest.Detail("The password has not expired")
+	}
+
+	return user.GetID(), passwords.Change(user.GetID(), password)
+}
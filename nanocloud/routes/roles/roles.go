@@ -0,0 +1,217 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package roles
+
+import (
+	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/groups"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+// getRole returns the role specified in the route.
+func getRole(c *echo.Context) (*roles.Role, error) {
+	role, err := roles.GetRole(c.Param("id"))
+	if err == roles.RoleNotFound {
+		return nil, apiErrors.RoleNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return nil, apiErrors.InternalError.Detail("Unable to retrieve the role")
+	}
+	return role, nil
+}
+
+func roleError(err error) error {
+	if _, ok := err.(roles.InvalidPermission); ok {
+		return apiErrors.InvalidRequest.Detail(err.Error())
+	}
+
+	switch err {
+	case roles.InvalidName:
+		return apiErrors.InvalidRequest.Detail("name is missing")
+	case roles.InvalidAssignment:
+		return apiErrors.InvalidRequest.Detail(err.Error())
+	case roles.RoleDuplicated:
+		return apiErrors.RoleDuplicated
+	case roles.RoleNotFound:
+		return apiErrors.RoleNotFound
+	case roles.AssignmentDuplicated:
+		return apiErrors.RoleAssignmentDuplicated
+	case roles.AssignmentNotFound:
+		return apiErrors.AssignmentNotFound
+	case users.UserNotFound:
+		return apiErrors.UserNotFound
+	case groups.GroupNotFound:
+		return apiErrors.GroupNotFound
+	}
+
+	log.Error(err)
+	return apiErrors.InternalError
+}
+
+// Permissions returns every permission a role can grant.
+func Permissions(c *echo.Context) error {
+	return c.JSON(http.StatusOK, hash{
+		"data": roles.All,
+	})
+}
+
+func List(c *echo.Context) error {
+	q, err := utils.ParseQuery(c, roles.Columns.Fields())
+	if err != nil {
+		return err
+	}
+
+	list, total, err := roles.FindRoles(q)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the roles")
+	}
+
+	return utils.JSONList(c, http.StatusOK, list, q, total)
+}
+
+func Get(c *echo.Context) error {
+	role, err := getRole(c)
+	if err != nil {
+		return err
+	}
+
+	return utils.JSON(c, http.StatusOK, role)
+}
+
+func Create(c *echo.Context) error {
+	var attributes roles.Role
+
+	err := utils.ParseJSONBody(c, &attributes)
+	if err != nil {
+		return err
+	}
+
+	role, err := roles.CreateRole(attributes.Name, attributes.Permissions)
+	if err != nil {
+		return roleError(err)
+	}
+
+	return utils.JSON(c, http.StatusCreated, role)
+}
+
+// Update renames the role and replaces its permissions.
+func Update(c *echo.Context) error {
+	role, err := getRole(c)
+	if err != nil {
+		return err
+	}
+
+	var attributes roles.Role
+
+	err = utils.ParseJSONBody(c, &attributes)
+	if err != nil {
+		return err
+	}
+
+	err = role.Update(attributes.Name, attributes.Permissions)
+	if err != nil {
+		return roleError(err)
+	}
+
+	return utils.JSON(c, http.StatusOK, role)
+}
+
+func Delete(c *echo.Context) error {
+	role, err := getRole(c)
+	if err != nil {
+		return err
+	}
+
+	err = role.Delete()
+	if err != nil {
+		return roleError(err)
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
+
+// ListAssignments returns the users and groups the role is granted to.
+func ListAssignments(c *echo.Context) error {
+	role, err := getRole(c)
+	if err != nil {
+		return err
+	}
+
+	assignments, err := role.Assignments()
+	if err != nil {
+		return roleError(err)
+	}
+
+	return utils.JSON(c, http.StatusOK, assignments)
+}
+
+// CreateAssignment grants the role to the user (`user-id`) or the group
+// (`group-id`).
+func CreateAssignment(c *echo.Context) error {
+	role, err := getRole(c)
+	if err != nil {
+		return err
+	}
+
+	var attributes roles.Assignment
+
+	err = utils.ParseJSONBody(c, &attributes)
+	if err != nil {
+		return err
+	}
+
+	assignment, err := role.Assign(attributes.UserId, attributes.GroupId)
+	if err != nil {
+		return roleError(err)
+	}
+
+	return utils.JSON(c, http.StatusCreated, assignment)
+}
+
+func DeleteAssignment(c *echo.Context) error {
+	role, err := getRole(c)
+	if err != nil {
+		return err
+	}
+
+	err = role.Unassign(c.Param("assignment_id"))
+	if err != nil {
+		return roleError(err)
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"meta": hash{},
+	})
+}
@@ -0,0 +1,151 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sessions
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/vms"
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/sessions"
+	"github.com/Nanocloud/community/nanocloud/plaza"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+func plazaPort() (int, error) {
+	return strconv.Atoi(kPort)
+}
+
+// ListAll returns the sessions opened on every machine. They can be filtered
+// with the `user` (user id), `machine` (machine id) and `state` (`active`,
+// `disconnected`...) query parameters.
+func ListAll(c *echo.Context) error {
+	port, err := plazaPort()
+	if err != nil {
+		return err
+	}
+
+	machines, err := vms.Machines()
+	if err != nil {
+		log.Error(err)
+		return apiErrors.UnableToRetrieveMachineList
+	}
+
+	list, err := sessions.ListMachineSessions(machines, port, sessions.Filter{
+		UserId:    c.Query("user"),
+		MachineId: c.Query("machine"),
+		State:     c.Query("state"),
+	})
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the sessions")
+	}
+
+	return utils.JSON(c, http.StatusOK, list)
+}
+
+// sessionLocation returns the address of the machine running the session
+// specified in the route and the Windows session id.
+func sessionLocation(c *echo.Context) (string, int, string, error) {
+	machineId, sessionId, err := sessions.ParseMachineSessionId(c.Param("id"))
+	if err != nil {
+		return "", 0, "", apiErrors.SessionNotFound
+	}
+
+	port, err := plazaPort()
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	machine, err := vms.Machine(machineId)
+	if err != nil {
+		return "", 0, "", apiErrors.SessionNotFound
+	}
+
+	ip, err := machine.IP()
+	if err != nil || ip == nil {
+		return "", 0, "", apiErrors.SessionNotFound
+	}
+	return ip.String(), port, sessionId, nil
+}
+
+func sessionActionDone(c *echo.Context, err error) error {
+	if err != nil {
+		log.Error(err)
+		return apiErrors.WindowsNotOnline.Detail(err.Error())
+	}
+	return c.JSON(http.StatusOK, hash{"meta": hash{}})
+}
+
+// Disconnect disconnects a session; its applications keep running until the
+// user reconnects.
+func Disconnect(c *echo.Context) error {
+	address, port, id, err := sessionLocation(c)
+	if err != nil {
+		return err
+	}
+	return sessionActionDone(c, plaza.DisconnectSession(address, port, id))
+}
+
+// LogoffSession closes any session.
+func LogoffSession(c *echo.Context) error {
+	address, port, id, err := sessionLocation(c)
+	if err != nil {
+		return err
+	}
+	return sessionActionDone(c, plaza.LogoffSession(address, port, id))
+}
+
+// SendMessage displays the `message` attribute of the request body in a
+// session.
+func SendMessage(c *echo.Context) error {
+	var body struct {
+		Data struct {
+			Attributes struct {
+				Message string `json:"message"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(b, &body)
+	message := body.Data.Attributes.Message
+	if err != nil || message == "" {
+		return apiErrors.InvalidRequest.Detail("message is missing")
+	}
+
+	address, port, id, err := sessionLocation(c)
+	if err != nil {
+		return err
+	}
+	return sessionActionDone(c, plaza.SendSessionMessage(address, port, id, message))
+}
@@ -0,0 +1,278 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package signup exposes the routes used without an access token to join
+// Nanocloud: the public signup, the activation and the invitations.
+package signup
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/mail"
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+	signupModel "github.com/Nanocloud/community/nanocloud/models/signup"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+type signupAttributes struct {
+	Data struct {
+		Attributes struct {
+			Email     string `json:"email"`
+			FirstName string `json:"first-name"`
+			LastName  string `json:"last-name"`
+			Password  string `json:"password"`
+			Token     string `json:"token"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+func parseBody(c *echo.Context) (*signupAttributes, error) {
+	var body signupAttributes
+
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &body)
+	if err != nil {
+		return nil, apiErrors.InvalidRequest
+	}
+	return &body, nil
+}
+
+// checkUser validates the attributes of the new user.
+func checkUser(body *signupAttributes) error {
+	attributes := body.Data.Attributes
+	if attributes.FirstName == "" || attributes.LastName == "" {
+		return apiErrors.InvalidRequest.Detail("first name and last name are required")
+	}
+
+	if attributes.Password == "" {
+		return apiErrors.InvalidRequest.Detail("password is missing")
+	}
+
+	err := passwords.LoadPolicy().Check(attributes.Password)
+	if err != nil {
+		return apiErrors.WeakPassword.Detail(err.Error())
+	}
+	return nil
+}
+
+var accountFailed = errors.New("Windows account not created")
+
+// createAccount creates the Windows account of the user who just confirmed
+// the email. The account may already exist when a previous activation
+// failed after creating it.
+func createAccount(userId string) error {
+	_, err := ldap.CreateAccount(userId)
+	if err != nil && err != ldap.AlreadyExists {
+		log.Errorf("Unable to create the Windows account of %s: %s", userId, err.Error())
+		return accountFailed
+	}
+	return nil
+}
+
+func activationMessage(user *users.User, token string) *mail.Message {
+	link := mail.Link("activate", url.Values{"token": {token}})
+
+	return &mail.Message{
+		To:      user.Email,
+		Subject: "Activate your Nanocloud account",
+		Body: "Hello " + user.FirstName + ",\n\n" +
+			"Follow this link within two days to activate your Nanocloud account:\n\n" +
+			link + "\n\n" +
+			"If you did not sign up, you can ignore this email.\n",
+	}
+}
+
+// Settings tells whether anyone can sign up and with which email domains.
+func Settings(c *echo.Context) error {
+	settings := signupModel.LoadSettings()
+	if !mail.Configured() {
+		settings.Enabled = false
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"data": settings,
+	})
+}
+
+// Create signs up a user and emails the activation link. The reply is the
+// same whether the email is already used or not.
+func Create(c *echo.Context) error {
+	if !mail.Configured() {
+		return apiErrors.SignupDisabled.Detail("Emails cannot be sent")
+	}
+
+	body, err := parseBody(c)
+	if err != nil {
+		return err
+	}
+
+	err = checkUser(body)
+	if err != nil {
+		return err
+	}
+
+	attributes := body.Data.Attributes
+	user, token, err := signupModel.SignUp(
+		attributes.Email,
+		attributes.FirstName,
+		attributes.LastName,
+		attributes.Password,
+	)
+	switch err {
+	case nil:
+		err = mail.Send(activationMessage(user, token))
+		if err != nil {
+			log.Errorf("Unable to send the activation email to %s: %s", user.Email, err.Error())
+			users.DeleteUser(user.Id)
+			return apiErrors.InternalError.Detail("Unable to send the email")
+		}
+	case users.UserDuplicated:
+		log.Warnf("Signup with the email of an existing user: %s", attributes.Email)
+	case signupModel.SignupDisabled:
+		return apiErrors.SignupDisabled
+	case signupModel.DomainNotAllowed:
+		return apiErrors.EmailDomainNotAllowed
+	case signupModel.InvalidEmail:
+		return apiErrors.InvalidRequest.Detail(err.Error())
+	default:
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to sign up")
+	}
+
+	return c.JSON(http.StatusAccepted, hash{
+		"data": hash{
+			"success": true,
+		},
+	})
+}
+
+// Activate activates the user of the token sent by email and creates the
+// Windows account.
+func Activate(c *echo.Context) error {
+	body, err := parseBody(c)
+	if err != nil {
+		return err
+	}
+
+	token := body.Data.Attributes.Token
+	if token == "" {
+		return apiErrors.InvalidRequest.Detail("token is missing")
+	}
+
+	// The token is only consumed once the Windows account exists, so that
+	// the activation can be retried
+	_, err = signupModel.Activate(token, createAccount)
+	if err == signupModel.InvalidActivationToken {
+		return apiErrors.InvalidActivationToken
+	}
+	if err == accountFailed {
+		return apiErrors.InternalError.Detail("Unable to create the Windows account")
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to activate the account")
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"data": hash{
+			"success": true,
+		},
+	})
+}
+
+// GetInvitation returns the email of a pending invitation.
+func GetInvitation(c *echo.Context) error {
+	token := c.Query("token")
+	if token == "" {
+		return apiErrors.InvalidRequest.Detail("token is missing")
+	}
+
+	invitation, err := signupModel.FindInvitation(token)
+	if err == signupModel.InvalidInvitation {
+		return apiErrors.InvalidInvitation
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to retrieve the invitation")
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"data": hash{
+			"email":      invitation.Email,
+			"expires-at": invitation.ExpiresAt,
+		},
+	})
+}
+
+// AcceptInvitation creates the user invited. The email is already
+// confirmed by the invitation, so the user is activated right away.
+func AcceptInvitation(c *echo.Context) error {
+	body, err := parseBody(c)
+	if err != nil {
+		return err
+	}
+
+	token := body.Data.Attributes.Token
+	if token == "" {
+		return apiErrors.InvalidRequest.Detail("token is missing")
+	}
+
+	err = checkUser(body)
+	if err != nil {
+		return err
+	}
+
+	attributes := body.Data.Attributes
+	_, err = signupModel.Accept(token, attributes.FirstName, attributes.LastName, attributes.Password, createAccount)
+	switch err {
+	case nil:
+	case signupModel.InvalidInvitation:
+		return apiErrors.InvalidInvitation
+	case users.UserDuplicated:
+		return apiErrors.UserExists
+	case accountFailed:
+		return apiErrors.InternalError.Detail("Unable to create the Windows account")
+	default:
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to accept the invitation")
+	}
+
+	return c.JSON(http.StatusCreated, hash{
+		"data": hash{
+			"success": true,
+		},
+	})
+}
@@ -0,0 +1,183 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sso
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/auth"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
+	"github.com/Nanocloud/community/nanocloud/sso"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+// Cookie binding the OIDC callback and the SAML response to the browser
+// which started the login. It holds the OIDC state or the SAML request id.
+const stateCookie = "nanocloud_sso_state"
+
+// Providers lists the identity providers shown on the login page.
+func Providers(c *echo.Context) error {
+	return c.JSON(http.StatusOK, hash{
+		"data": sso.Providers(),
+	})
+}
+
+// Login sends the user to the identity provider.
+func Login(c *echo.Context) error {
+	provider := c.Param("provider")
+
+	u, key, err := sso.StartLogin(provider)
+	if err == sso.ProviderNotFound {
+		return apiErrors.SSOProviderNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to reach the identity provider")
+	}
+
+	cookie := &http.Cookie{
+		Name:     stateCookie,
+		Value:    key,
+		Path:     "/sso/",
+		MaxAge:   600,
+		Secure:   sso.IsSecure(),
+		HttpOnly: true,
+	}
+	v := cookie.String()
+	if cookie.Secure {
+		// The SAML response is posted by the identity provider's page: the
+		// browsers only send the cookie with such requests when it is
+		// SameSite=None.
+		v += "; SameSite=None"
+	}
+	c.Response().Header().Add("Set-Cookie", v)
+	return c.Redirect(http.StatusFound, u)
+}
+
+// OIDCCallback receives the authorization code of the OpenID Connect
+// provider.
+func OIDCCallback(c *echo.Context) error {
+	if e := c.Query("error"); e != "" {
+		log.Errorf("OpenID Connect login failed: %s %s", e, c.Query("error_description"))
+		return failure(c)
+	}
+
+	state := c.Query("state")
+	cookie, err := c.Request().Cookie(stateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		log.Error("OpenID Connect callback without a matching state")
+		return failure(c)
+	}
+
+	identity, err := sso.FinishOIDCLogin(state, c.Query("code"))
+	return login(c, sso.OIDCProvider, identity, err)
+}
+
+// SAMLACS is the assertion consumer service receiving the responses of
+// the SAML identity provider. The response must answer the request sent
+// from the same browser.
+func SAMLACS(c *echo.Context) error {
+	cookie, err := c.Request().Cookie(stateCookie)
+	if err != nil || cookie.Value == "" {
+		log.Error("SAML response without a matching request")
+		return failure(c)
+	}
+
+	identity, err := sso.FinishSAMLLogin(c.Form("SAMLResponse"), cookie.Value)
+	return login(c, sso.SAMLProvider, identity, err)
+}
+
+// SAMLMetadata returns the metadata to register Nanocloud at the identity
+// provider.
+func SAMLMetadata(c *echo.Context) error {
+	metadata, err := sso.Metadata()
+	if err == sso.ProviderNotFound {
+		return apiErrors.SSOProviderNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	r := c.Response()
+	r.Header().Set("Content-Type", "application/samlmetadata+xml")
+	r.WriteHeader(http.StatusOK)
+	r.Write(metadata)
+	return nil
+}
+
+// login provisions the user and sends them back to the webapp with a
+// Nanocloud access token. The tokens are put in the fragment of the URL so
+// that they are not sent to any server.
+func login(c *echo.Context, provider string, identity *auth.Identity, err error) error {
+	if err != nil {
+		log.Errorf("SSO login with %s failed: %s", provider, err)
+		return failure(c)
+	}
+
+	user, err := auth.Provision(provider, identity)
+	if err != nil {
+		log.Errorf("Unable to provision %s from %s: %s", identity.Email, provider, err)
+		return failure(c)
+	}
+
+	// The identity provider replaces the password, not the second factor:
+	// the login page asks for the code like after a password.
+	mfaToken, enroll, err := oauth.WebappMFAChallenge(user)
+	if err != nil {
+		log.Error(err)
+		return failure(c)
+	}
+
+	if mfaToken != "" {
+		v := url.Values{"mfa_token": {mfaToken}}
+		if enroll {
+			v.Set("mfa_enroll", "true")
+		}
+		return c.Redirect(http.StatusFound, "/#/login?"+v.Encode())
+	}
+
+	token, err := oauth.IssueWebappTokens(user, c.Request())
+	if err != nil {
+		log.Error(err)
+		return failure(c)
+	}
+
+	v := url.Values{
+		"access_token":  {token.Token},
+		"token_type":    {token.Type},
+		"refresh_token": {token.RefreshToken},
+		"expires_in":    {strconv.Itoa(int(token.ExpiresIn))},
+	}
+	return c.Redirect(http.StatusFound, "/#/sso?"+v.Encode())
+}
+
+func failure(c *echo.Context) error {
+	return c.Redirect(http.StatusFound, "/#/login?sso_error=true")
+}
@@ -23,22 +23,125 @@
 package tokens
 
 import (
-	"fmt"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/oauth"
 	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/Nanocloud/community/nanocloud/oauth2"
+	"github.com/Nanocloud/community/nanocloud/query"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 )
 
 type hash map[string]interface{}
 
-func Get(c *echo.Context) error {
-	user := c.Get("user").(*users.User)
+// Type of the personal API keys, the other tokens are "oauth".
+const apiKeyType = "api-key"
+
+// token is an access token issued by the OAuth endpoints or a personal API
+// key. Times are in milliseconds. The client, the user agent and the
+// address are those of the login for the access tokens.
+type token struct {
+	Id         string   `json:"-"`
+	Type       string   `json:"type"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Client     string   `json:"client"`
+	UserAgent  string   `json:"user-agent"`
+	IP         string   `json:"ip"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created-at"`
+	ExpiresAt  int64    `json:"expires-at"`
+	LastUsedAt int64    `json:"last-used-at"`
+
+	// Whether the token authenticates the request listing it
+	Current bool `json:"current"`
+
+	// The API key, only returned when it is created
+	Key string `json:"key,omitempty"`
+}
+
+func (t *token) GetID() string {
+	return t.Id
+}
+
+func (t *token) SetID(id string) error {
+	t.Id = id
+	return nil
+}
+
+var columns = query.Columns{
+	"created-at":   "created_at",
+	"last-used-at": "last_used_at",
+	"type":         "type",
+	"name":         "name",
+	"client":       "client",
+}
+
+// tokensTable lists both kinds of tokens.
+const tokensTable = ` FROM (
+	SELECT oauth_access_tokens.id, user_id, 'oauth'::varchar AS type,
+		''::varchar AS name, ''::varchar AS prefix,
+		COALESCE(oauth_clients.name, '') AS client,
+		COALESCE(user_agent, '') AS user_agent, COALESCE(ip, '') AS ip, scope,
+		created_at::timestamp with time zone AS created_at,
+		expires_at::timestamp with time zone AS expires_at,
+		last_used_at
+	FROM oauth_access_tokens
+	LEFT JOIN oauth_clients ON oauth_clients.id = oauth_access_tokens.oauth_client_id
+	UNION ALL
+	SELECT id, user_id, 'api-key', name, prefix, '', '', '', scope,
+		created_at, expires_at, last_used_at
+	FROM api_keys
+) AS tokens`
+
+func milliseconds(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// currentTokenId returns the id of the token authenticating the request.
+func currentTokenId(c *echo.Context) (string, error) {
+	accessToken, oauthErr := oauth2.GetAccessToken(c.Request())
+	if oauthErr != nil {
+		return "", nil
+	}
+	return oauth.TokenId(accessToken)
+}
+
+// findTokens replies with the tokens of the user.
+func findTokens(c *echo.Context, userId string) error {
+	q, err := utils.ParseQuery(c, columns.Fields())
+	if err != nil {
+		return err
+	}
+
+	currentId, err := currentTokenId(c)
+	if err != nil {
+		return err
+	}
+
+	where, args := q.Where(columns, []string{"user_id = $1::varchar"}, []interface{}{userId})
+
+	total, err := db.Count("SELECT count(*)"+tokensTable+where, args...)
+	if err != nil {
+		return err
+	}
 
 	res, err := db.Query(
-		"SELECT id, created_at FROM oauth_access_tokens WHERE user_id = $1::varchar",
-		user.Id,
+		`SELECT id, type, name, prefix, client, user_agent, ip, scope,
+		created_at, expires_at, last_used_at`+
+			tokensTable+where+q.OrderBy(columns, "created_at DESC")+q.Limit(),
+		args...,
 	)
 
 	if err != nil {
@@ -47,60 +150,203 @@ func Get(c *echo.Context) error {
 
 	defer res.Close()
 
-	r := make([]hash, 0)
+	r := make([]*token, 0)
 	for res.Next() {
-		var id, createdAt string
-		err := res.Scan(&id, &createdAt)
+		var t token
+		var scope string
+		var createdAt, expiresAt, lastUsedAt *time.Time
+
+		err := res.Scan(
+			&t.Id, &t.Type, &t.Name, &t.Prefix,
+			&t.Client, &t.UserAgent, &t.IP, &scope,
+			&createdAt, &expiresAt, &lastUsedAt,
+		)
 		if err != nil {
+			log.Error(err)
 			continue
 		}
 
-		r = append(r, hash{
-			"id":   id,
-			"type": "token",
-			"attributes": hash{
-				"created-at": createdAt,
-			},
-		})
+		t.Scopes = strings.Fields(scope)
+		t.CreatedAt = milliseconds(createdAt)
+		t.ExpiresAt = milliseconds(expiresAt)
+		t.LastUsedAt = milliseconds(lastUsedAt)
+		t.Current = t.Id == currentId
+		r = append(r, &t)
 	}
 
-	return c.JSON(http.StatusOK, r)
+	return utils.JSONList(c, http.StatusOK, r, q, total)
 }
 
-func Delete(c *echo.Context) error {
-	tokenId := c.Param("id")
+// Get returns the access tokens and the API keys of the current user.
+func Get(c *echo.Context) error {
+	user := c.Get("user").(*users.User)
+	return findTokens(c, user.Id)
+}
+
+// userId returns the id of the user specified in the admin routes.
+func userId(c *echo.Context) (string, error) {
+	exists, err := users.UserExists(c.Param("id"))
+	if err != nil {
+		log.Error(err)
+		return "", apiErrors.InternalError.Detail("Unable to retrieve the user")
+	}
+	if !exists {
+		return "", apiErrors.UserNotFound
+	}
+	return c.Param("id"), nil
+}
+
+// GetUserTokens returns the tokens of the user specified in the route.
+func GetUserTokens(c *echo.Context) error {
+	id, err := userId(c)
+	if err != nil {
+		return err
+	}
+	return findTokens(c, id)
+}
+
+type apiKeyAttributes struct {
+	Data struct {
+		Attributes struct {
+			Name      string   `json:"name"`
+			Scopes    []string `json:"scopes"`
+			ExpiresAt int64    `json:"expires-at"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// Create makes a personal API key. The key is only returned in this
+// response.
+func Create(c *echo.Context) error {
+	user := c.Get("user").(*users.User)
+
+	var body apiKeyAttributes
 
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(b, &body)
+	if err != nil {
+		return apiErrors.InvalidRequest
+	}
+
+	attributes := body.Data.Attributes
+
+	var expiresAt *time.Time
+	if attributes.ExpiresAt != 0 {
+		t := time.Unix(0, attributes.ExpiresAt*int64(time.Millisecond))
+		expiresAt = &t
+	}
+
+	// The key cannot be granted more than the token creating it
+	accessToken, _ := oauth2.GetAccessToken(c.Request())
+	granted, err := oauth.TokenScopes(accessToken)
+	if err != nil {
+		return err
+	}
+
+	err = oauth.ValidateScopes(attributes.Scopes, granted)
+	if err != nil {
+		return apiErrors.InvalidRequest.Detail(err.Error())
+	}
+
+	key, err := oauth.CreateAPIKey(user.Id, attributes.Name, attributes.Scopes, expiresAt)
+	switch err {
+	case nil:
+	case oauth.InvalidKeyName, oauth.InvalidKeyExpiry:
+		return apiErrors.InvalidRequest.Detail(err.Error())
+	default:
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to create the API key")
+	}
+
+	return utils.JSON(c, http.StatusCreated, &token{
+		Id:        key.Id,
+		Type:      apiKeyType,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scopes:    key.Scopes,
+		CreatedAt: milliseconds(&key.CreatedAt),
+		ExpiresAt: milliseconds(key.ExpiresAt),
+		Key:       key.Key,
+	})
+}
+
+// revoke revokes a token or an API key of the user.
+func revoke(c *echo.Context, userId, tokenId string) error {
 	if len(tokenId) == 4 {
 		return c.JSON(http.StatusBadRequest, hash{
 			"error": "Invalid token id",
 		})
 	}
 
+	// The refresh tokens issued with the access token are revoked too
+	err := oauth.RevokeToken(userId, tokenId)
+	if err == oauth.TokenNotFound {
+		err = oauth.RevokeAPIKey(userId, tokenId)
+	}
+	if err == oauth.APIKeyNotFound {
+		return c.JSON(http.StatusNotFound, hash{
+			"error": "No such token",
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, hash{})
+}
+
+func Delete(c *echo.Context) error {
 	user := c.Get("user").(*users.User)
+	return revoke(c, user.Id, c.Param("id"))
+}
 
-	fmt.Println(user.Id)
-	fmt.Println(tokenId)
-	res, err := db.Exec(
-		`DELETE FROM oauth_access_tokens
-		WHERE user_id = $1::varchar
-		AND id = $2::varchar`,
-		user.Id,
-		tokenId,
-	)
+// DeleteOthers revokes the sessions of the current user but the one of the
+// request. The API keys are kept.
+func DeleteOthers(c *echo.Context) error {
+	user := c.Get("user").(*users.User)
+
+	currentId, err := currentTokenId(c)
+	if err != nil {
+		return err
+	}
+
+	err = oauth.RevokeSessions(user.Id, currentId)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to revoke the sessions")
+	}
+
+	return c.JSON(http.StatusOK, hash{})
+}
 
+// DeleteUserToken revokes a token of the user specified in the route.
+func DeleteUserToken(c *echo.Context) error {
+	id, err := userId(c)
 	if err != nil {
 		return err
 	}
+	return revoke(c, id, c.Param("token_id"))
+}
 
-	affected, err := res.RowsAffected()
+// DeleteUserTokens revokes all the sessions and the API keys of the user
+// specified in the route.
+func DeleteUserTokens(c *echo.Context) error {
+	id, err := userId(c)
 	if err != nil {
 		return err
 	}
 
-	if affected < 1 {
-		return c.JSON(http.StatusNotFound, hash{
-			"error": "No such token",
-		})
+	err = oauth.RevokeSessions(id, "")
+	if err == nil {
+		err = oauth.RevokeAPIKeys(id)
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to revoke the tokens")
 	}
 
 	return c.JSON(http.StatusOK, hash{})
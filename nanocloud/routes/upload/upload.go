@@ -25,15 +25,37 @@ package upload
 import (
 	"net/http"
 	"net/url"
+	"strconv"
 
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/models/storage"
+	"github.com/Nanocloud/community/nanocloud/models/uploads"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/oauth2"
+	"github.com/Nanocloud/community/nanocloud/plaza"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
 )
 
+const tusVersion = "1.0.0"
+
+func plazaLocation() (string, int, error) {
+	port, err := strconv.Atoi(utils.Env("PLAZA_PORT", "9090"))
+	if err != nil {
+		return "", 0, err
+	}
+	return utils.Env("PLAZA_ADDRESS", "iaas-module"), port, nil
+}
+
+// Post uploads a file in a single request.
+// Large files should rather be sent with the resumable upload routes.
 func Post(w http.ResponseWriter, r *http.Request) {
-	filename := r.URL.Query()["filename"][0]
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		http.Error(w, "filename is missing", http.StatusBadRequest)
+		return
+	}
 
 	rawuser, oauthErr := oauth2.GetUser(w, r)
 	if rawuser == nil || oauthErr != nil {
@@ -49,25 +71,265 @@ func Post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The size of a chunked request is unknown: such uploads are only bounded
+	// by the quota checked when resumable uploads are created.
+	if r.ContentLength > 0 {
+		err = storage.CheckQuota(user, r.ContentLength)
+		if err == storage.QuotaExceeded {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			log.Error("Unable to check the storage quota: ", err)
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
 	sam := winUser.Sam
 	winServer := utils.Env("PLAZA_ADDRESS", "iaas-module")
 
 	request, err := http.NewRequest(
 		"POST",
-		"http://"+winServer+":"+utils.Env("PLAZA_PORT", "9090")+"/upload?sam="+url.QueryEscape(sam)+"&userId="+url.QueryEscape(user.Id)+"&filename="+url.QueryEscape(filename),
+		"http://"+winServer+":"+utils.Env("PLAZA_PORT", "9090")+"/upload?username="+url.QueryEscape(sam)+"&userId="+url.QueryEscape(user.Id)+"&filename="+url.QueryEscape(filename),
 		r.Body,
 	)
 	if err != nil {
-		log.Println("Unable de create request ", err)
+		log.Error("Unable to create request: ", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
 	}
 	request.Header = r.Header
 	client := &http.Client{}
 	resp, err := client.Do(request)
 	if err != nil {
-		log.Println("Unable to send request ", err)
+		log.Error("Unable to send request: ", err)
+		http.Error(w, "", http.StatusServiceUnavailable)
+		return
 	}
+	defer resp.Body.Close()
 
-	log.Error(resp)
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("Plaza replied %s to the upload of %s", resp.Status, filename)
+	}
 	http.Error(w, "", resp.StatusCode)
-	return
+}
+
+/*
+ * Resumable uploads follow the tus protocol (http://tus.io/protocols/resumable-upload.html).
+ * An upload is first created with its total length, then its content is sent in
+ * one or several PATCH requests. If a request is interrupted, the client gets
+ * the number of bytes received with a HEAD request and resumes from there.
+ * Chunks are streamed to plaza which stores them in the user's directory.
+ */
+
+func setTusHeaders(c *echo.Context) {
+	h := c.Response().Header()
+	h.Set("Tus-Resumable", tusVersion)
+	h.Set("Cache-Control", "no-store")
+}
+
+// CreateUpload creates a resumable upload.
+// The file name is passed in the query string and its size in bytes in the
+// Upload-Length header.
+func CreateUpload(c *echo.Context) error {
+	setTusHeaders(c)
+	user := c.Get("user").(*users.User)
+
+	filename := c.Query("filename")
+	if filename == "" {
+		return apiErrors.InvalidRequest.Detail("filename is missing")
+	}
+
+	length, err := strconv.ParseInt(c.Request().Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return apiErrors.InvalidRequest.Detail("Invalid Upload-Length header")
+	}
+
+	err = uploads.CheckSize(length)
+	if err != nil {
+		return apiErrors.UploadTooLarge
+	}
+
+	winUser, err := user.WindowsCredentials()
+	if err != nil {
+		log.Error(err)
+		return apiErrors.NeedFirstConnection
+	}
+
+	err = storage.CheckQuota(user, length)
+	if err == storage.QuotaExceeded {
+		return apiErrors.QuotaExceeded
+	}
+	if err != nil {
+		log.Error(err)
+		return apiErrors.WindowsNotOnline.Detail("Unable to check the storage quota")
+	}
+
+	address, port, err := plazaLocation()
+	if err != nil {
+		return err
+	}
+
+	upload, err := uploads.CreateUpload(user.Id, filename, length)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to create the upload")
+	}
+
+	err = plaza.CreateUpload(address, port, upload.Id, winUser.Sam, filename, length)
+	if err != nil {
+		log.Error(err)
+		uploads.DeleteUpload(upload.Id)
+		return apiErrors.WindowsNotOnline.Detail(err.Error())
+	}
+
+	c.Response().Header().Set("Location", "/api/uploads/"+upload.Id)
+	return utils.JSON(c, http.StatusCreated, upload)
+}
+
+// GetUploadOffset returns the number of bytes received for an upload in the
+// Upload-Offset header.
+func GetUploadOffset(c *echo.Context) error {
+	setTusHeaders(c)
+	user := c.Get("user").(*users.User)
+
+	upload, err := uploads.GetUpload(user.Id, c.Param("id"))
+	if err == uploads.UploadNotFound {
+		return apiErrors.UploadNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	offset := upload.Length
+	if !upload.Completed {
+		address, port, err := plazaLocation()
+		if err != nil {
+			return err
+		}
+
+		offset, err = plaza.UploadOffset(address, port, upload.Id)
+		if err == plaza.UploadNotFound {
+			return apiErrors.UploadNotFound
+		}
+		if err != nil {
+			log.Error(err)
+			return apiErrors.WindowsNotOnline.Detail(err.Error())
+		}
+	}
+
+	h := c.Response().Header()
+	h.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Response().WriteHeader(http.StatusOK)
+	return nil
+}
+
+// PatchUpload streams a chunk of an upload to plaza.
+// The Upload-Offset header must match the number of bytes already received. An
+// optional Upload-Checksum header ("sha1 {base64 digest}") is verified by plaza
+// before the chunk is accepted.
+func PatchUpload(c *echo.Context) error {
+	setTusHeaders(c)
+	user := c.Get("user").(*users.User)
+	r := c.Request()
+
+	upload, err := uploads.GetUpload(user.Id, c.Param("id"))
+	if err == uploads.UploadNotFound {
+		return apiErrors.UploadNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if upload.Completed {
+		return apiErrors.InvalidUploadOffset.Detail("The upload is already completed")
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return apiErrors.InvalidRequest.Detail("Invalid Upload-Offset header")
+	}
+
+	if r.ContentLength > upload.Length-offset {
+		return apiErrors.UploadTooLarge.Detail("The chunk exceeds the upload length")
+	}
+
+	address, port, err := plazaLocation()
+	if err != nil {
+		return err
+	}
+
+	offset, err = plaza.PatchUpload(
+		address, port,
+		upload.Id, offset,
+		r.Header.Get("Upload-Checksum"),
+		r.Body,
+	)
+	switch err {
+	case nil:
+	case plaza.UploadNotFound:
+		return apiErrors.UploadNotFound
+	case plaza.UploadOffsetMismatch:
+		return apiErrors.InvalidUploadOffset
+	case plaza.UploadChecksumMismatch:
+		return apiErrors.UploadChecksumMismatch
+	case plaza.UploadChunkTooLarge:
+		return apiErrors.UploadTooLarge.Detail("The chunk exceeds the upload length")
+	default:
+		log.Error(err)
+		return apiErrors.WindowsNotOnline.Detail(err.Error())
+	}
+
+	if offset == upload.Length {
+		err = uploads.SetCompleted(upload.Id)
+		if err != nil {
+			log.Error(err)
+			return apiErrors.InternalError
+		}
+	}
+
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Response().WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// DeleteUpload cancels an upload in progress and discards the bytes already
+// received.
+func DeleteUpload(c *echo.Context) error {
+	setTusHeaders(c)
+	user := c.Get("user").(*users.User)
+
+	upload, err := uploads.GetUpload(user.Id, c.Param("id"))
+	if err == uploads.UploadNotFound {
+		return apiErrors.UploadNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if upload.Completed {
+		return apiErrors.InvalidRequest.Detail("A completed upload cannot be cancelled")
+	}
+
+	address, port, err := plazaLocation()
+	if err != nil {
+		return err
+	}
+
+	err = plaza.DeleteUpload(address, port, upload.Id)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.WindowsNotOnline.Detail(err.Error())
+	}
+
+	err = uploads.DeleteUpload(upload.Id)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	c.Response().WriteHeader(http.StatusNoContent)
+	return nil
 }
@@ -0,0 +1,143 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package users
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/mail"
+	"github.com/Nanocloud/community/nanocloud/models/bulk"
+	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/signup"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// wantsCSV tells whether the users are exchanged as CSV rather than JSON.
+func wantsCSV(c *echo.Context) bool {
+	return c.Query("format") == "csv" ||
+		strings.HasPrefix(c.Request().Header.Get("Content-Type"), "text/csv")
+}
+
+// Import creates the users of a CSV or a JSON body. With `dry-run=true`,
+// the import is only validated.
+func Import(c *echo.Context) error {
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return apiErrors.InvalidRequest
+	}
+
+	var rows []*bulk.Row
+	if wantsCSV(c) {
+		rows, err = bulk.ParseCSV(bytes.NewReader(b))
+	} else {
+		rows, err = bulk.ParseJSON(b)
+	}
+	if err != nil {
+		return apiErrors.InvalidRequest.Detail(err.Error())
+	}
+
+	current := c.Get("user").(*users.User)
+	for _, row := range rows {
+		if row.IsAdmin && !current.IsAdmin {
+			return apiErrors.AdminLevelRequired
+		}
+		if row.Invite && !mail.Configured() {
+			return apiErrors.MailNotConfigured.Detail("Invitations cannot be sent")
+		}
+	}
+
+	// A user without a Windows account cannot launch the applications: the
+	// accounts are created before the import is committed
+	report, err := bulk.Import(rows, current.GetID(), c.Query("dry-run") == "true", createAccount, ldap.DeleteAccount)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to import the users")
+	}
+
+	if !report.Valid() {
+		return c.JSON(http.StatusBadRequest, hash{
+			"data": report,
+		})
+	}
+
+	if report.DryRun {
+		return c.JSON(http.StatusOK, hash{
+			"data": report,
+		})
+	}
+
+	// The users are committed: a failure below does not undo the import
+	for _, invitation := range report.Invitations {
+		err = mail.Send(signup.InvitationMessage(invitation.Email, current, invitation.Token))
+		if err != nil {
+			log.Errorf("Unable to send the invitation to %s: %s", invitation.Email, err.Error())
+		}
+	}
+
+	return c.JSON(http.StatusCreated, hash{
+		"data": report,
+	})
+}
+
+// createAccount creates the Windows account of an imported user.
+func createAccount(userId string) error {
+	_, err := ldap.CreateAccount(userId)
+	if err != nil {
+		log.Errorf("Unable to create the Windows account of %s: %s", userId, err.Error())
+	}
+	return err
+}
+
+// Export returns all the users in the format of the imports, without
+// passwords.
+func Export(c *echo.Context) error {
+	rows, err := bulk.Export()
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError.Detail("Unable to export the users")
+	}
+
+	if !wantsCSV(c) {
+		return c.JSON(http.StatusOK, hash{
+			"data": rows,
+		})
+	}
+
+	w := c.Response()
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"users.csv\"")
+	w.WriteHeader(http.StatusOK)
+
+	err = csv.NewWriter(w).WriteAll(bulk.Records(rows))
+	if err != nil {
+		log.Errorf("Unable to write users.csv: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package users
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	m "github.com/Nanocloud/community/nanocloud/middlewares"
+	"github.com/Nanocloud/community/nanocloud/models/mfa"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
+	"github.com/Nanocloud/community/nanocloud/models/users"
+	"github.com/labstack/echo"
+)
+
+type mfaAttributes struct {
+	Data struct {
+		Attributes struct {
+			Code     string `json:"code"`
+			Required *bool  `json:"required"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+func parseMFABody(c *echo.Context) (*mfaAttributes, error) {
+	var body mfaAttributes
+
+	b, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &body)
+	if err != nil {
+		return nil, apiErrors.InvalidRequest
+	}
+	return &body, nil
+}
+
+// mfaError converts the errors of the mfa package.
+func mfaError(err error) error {
+	switch err {
+	case mfa.NotEnrolled:
+		return apiErrors.MFANotEnrolled
+	case mfa.AlreadyEnabled:
+		return apiErrors.MFAAlreadyEnabled
+	case mfa.InvalidCode:
+		return apiErrors.InvalidMFACode
+	}
+	return err
+}
+
+// isSelf tells whether the route targets the user of the access token.
+func isSelf(c *echo.Context) bool {
+	current := c.Get("user").(*users.User)
+	return c.Param("id") == current.GetID()
+}
+
+func mfaUser(c *echo.Context) (*users.User, error) {
+	user, err := users.GetUser(c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return nil, apiErrors.UserNotFound
+	}
+	return user, nil
+}
+
+// checkCredentialsOwner keeps users from removing or un-requiring the second
+// factor of a user holding more permissions than them.
+func checkCredentialsOwner(c *echo.Context, target *users.User) error {
+	actor := c.Get("user").(*users.User)
+	if !roles.CanChangeCredentials(actor, target) {
+		return apiErrors.Unauthorized.Detail("You cannot change the credentials of a user holding permissions you do not have")
+	}
+	return nil
+}
+
+func mfaStatusReply(c *echo.Context, userId string) error {
+	status, err := mfa.GetStatus(userId)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, hash{
+		"data": status,
+	})
+}
+
+// GetMFA returns whether the second factor of the user is enabled and
+// required.
+func GetMFA(c *echo.Context) error {
+	if !isSelf(c) && !m.Can(c, roles.UsersRead) {
+		return apiErrors.AdminLevelRequired
+	}
+
+	user, err := mfaUser(c)
+	if err != nil {
+		return err
+	}
+	return mfaStatusReply(c, user.GetID())
+}
+
+// UpdateMFA lets an administrator require a second factor for the user.
+func UpdateMFA(c *echo.Context) error {
+	body, err := parseMFABody(c)
+	if err != nil {
+		return err
+	}
+
+	if body.Data.Attributes.Required == nil {
+		return apiErrors.InvalidRequest.Detail("required is missing")
+	}
+
+	user, err := mfaUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err = checkCredentialsOwner(c, user); err != nil {
+		return err
+	}
+
+	err = mfa.SetRequired(user.GetID(), *body.Data.Attributes.Required)
+	if err != nil {
+		return err
+	}
+	return mfaStatusReply(c, user.GetID())
+}
+
+// EnrollMFA starts the enrollment of the current user. The secret and the
+// recovery codes are only returned here.
+func EnrollMFA(c *echo.Context) error {
+	if !isSelf(c) {
+		return apiErrors.Unauthorized
+	}
+
+	enrollment, err := mfa.Enroll(c.Get("user").(*users.User))
+	if err != nil {
+		return mfaError(err)
+	}
+
+	return c.JSON(http.StatusCreated, hash{
+		"data": enrollment,
+	})
+}
+
+// ConfirmMFA enables the second factor of the current user with a code of
+// the authenticator app.
+func ConfirmMFA(c *echo.Context) error {
+	if !isSelf(c) {
+		return apiErrors.Unauthorized
+	}
+
+	body, err := parseMFABody(c)
+	if err != nil {
+		return err
+	}
+
+	if body.Data.Attributes.Code == "" {
+		return apiErrors.InvalidRequest.Detail("code is missing")
+	}
+
+	userId := c.Param("id")
+	err = mfa.Confirm(userId, body.Data.Attributes.Code)
+	if err != nil {
+		return mfaError(err)
+	}
+	return mfaStatusReply(c, userId)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the current user.
+// A code is needed so that a stolen access token is not enough.
+func RegenerateRecoveryCodes(c *echo.Context) error {
+	if !isSelf(c) {
+		return apiErrors.Unauthorized
+	}
+
+	body, err := parseMFABody(c)
+	if err != nil {
+		return err
+	}
+
+	if body.Data.Attributes.Code == "" {
+		return apiErrors.InvalidRequest.Detail("code is missing")
+	}
+
+	userId := c.Param("id")
+	err = mfa.Verify(userId, body.Data.Attributes.Code)
+	if err != nil {
+		return mfaError(err)
+	}
+
+	codes, err := mfa.RegenerateRecoveryCodes(userId)
+	if err != nil {
+		return mfaError(err)
+	}
+
+	return c.JSON(http.StatusCreated, hash{
+		"data": hash{
+			"recovery-codes": codes,
+		},
+	})
+}
+
+// ResetMFA removes the second factor of a user. Administrators can reset
+// any user, for instance one who lost the device and the recovery codes;
+// the holders of users:manage can reset the users whose permissions they
+// all hold.
+// Users can remove their own second factor with a code, unless it is
+// required.
+func ResetMFA(c *echo.Context) error {
+	user, err := mfaUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err = checkCredentialsOwner(c, user); err != nil {
+		return err
+	}
+
+	if !m.Can(c, roles.UsersManage) {
+		if !isSelf(c) {
+			return apiErrors.AdminLevelRequired
+		}
+
+		body, err := parseMFABody(c)
+		if err != nil {
+			return err
+		}
+
+		status, err := mfa.GetStatus(user.GetID())
+		if err != nil {
+			return err
+		}
+
+		if status.Required {
+			return apiErrors.Unauthorized.Detail("Two-factor authentication is required for this user")
+		}
+
+		if body.Data.Attributes.Code == "" {
+			return apiErrors.InvalidRequest.Detail("code is missing")
+		}
+
+		err = mfa.Verify(user.GetID(), body.Data.Attributes.Code)
+		if err != nil {
+			return mfaError(err)
+		}
+	}
+
+	err = mfa.Reset(user.GetID())
+	if err != nil {
+		return err
+	}
+	return mfaStatusReply(c, user.GetID())
+}
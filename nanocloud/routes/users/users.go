@@ -27,7 +27,12 @@ import (
 	"net/http"
 
 	apiErrors "github.com/Nanocloud/community/nanocloud/errors"
+	m "github.com/Nanocloud/community/nanocloud/middlewares"
 	"github.com/Nanocloud/community/nanocloud/models/ldap"
+	"github.com/Nanocloud/community/nanocloud/models/passwords"
+	"github.com/Nanocloud/community/nanocloud/models/roles"
+	"github.com/Nanocloud/community/nanocloud/models/storage"
+	"github.com/Nanocloud/community/nanocloud/models/throttle"
 	"github.com/Nanocloud/community/nanocloud/models/users"
 	"github.com/Nanocloud/community/nanocloud/utils"
 	log "github.com/Sirupsen/logrus"
@@ -116,15 +121,42 @@ func Update(c *echo.Context) error {
 	}
 
 	currentUser, err := users.GetUser(updatedUser.GetID())
-	if err != nil {
+	if err != nil || currentUser == nil {
 		return apiErrors.UserNotFound
 	}
 
-	if !user.IsAdmin && (updatedUser.GetID() != user.GetID()) {
+	currentQuota, err := storage.UserQuota(currentUser.GetID())
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	permissions, err := m.Permissions(c)
+	if err != nil {
+		log.Error(err)
+		return apiErrors.InternalError
+	}
+
+	// Helpdesk staff may reset the password of other users without being
+	// able to change anything else.
+	self := updatedUser.GetID() == user.GetID()
+	passwordOnly := updatedUser.IsAdmin == currentUser.IsAdmin &&
+		(updatedUser.StorageQuota == nil || *updatedUser.StorageQuota == currentQuota) &&
+		updatedUser.Password != ""
+	if !self && !permissions.Has(roles.UsersManage) &&
+		!(passwordOnly && permissions.Has(roles.UsersPassword)) {
 		return apiErrors.Unauthorized.Detail("You can only update your account")
 	}
 
+	credentials := updatedUser.Password != "" || updatedUser.Email != currentUser.Email
+	if credentials && !roles.CanChangeCredentials(user, currentUser) {
+		return apiErrors.Unauthorized.Detail("You cannot change the credentials of a user holding permissions you do not have")
+	}
+
 	if updatedUser.IsAdmin != currentUser.IsAdmin {
+		if !user.IsAdmin {
+			return apiErrors.AdminLevelRequired
+		}
 		if currentUser.Id == user.GetID() {
 			return apiErrors.Unauthorized.Detail("You cannot grant administration rights")
 		}
@@ -133,8 +165,24 @@ func Update(c *echo.Context) error {
 			log.Error(err)
 			return apiErrors.InternalError.Detail("Unable to update the rank")
 		}
+	} else if updatedUser.StorageQuota != nil && *updatedUser.StorageQuota != currentQuota {
+		if !permissions.Has(roles.UsersManage) {
+			return apiErrors.AdminLevelRequired
+		}
+		// -1 removes the override of the user so the default quota applies
+		if *updatedUser.StorageQuota < -1 {
+			return apiErrors.InvalidRequest.Detail("storage-quota cannot be negative, except -1 to use the default quota")
+		}
+		err = storage.SetUserQuota(updatedUser.GetID(), *updatedUser.StorageQuota)
+		if err != nil {
+			log.Error(err)
+			return apiErrors.InternalError.Detail("Unable to update the storage quota")
+		}
 	} else if updatedUser.Password != "" {
-		err = users.UpdateUserPassword(updatedUser.GetID(), updatedUser.Password)
+		err = passwords.Change(updatedUser.GetID(), updatedUser.Password)
+		if v, ok := err.(*passwords.Violation); ok {
+			return apiErrors.WeakPassword.Detail(v.Error())
+		}
 		if err != nil {
 			log.Error(err)
 			return apiErrors.InternalError.Detail("Unable to update the password")
@@ -157,6 +205,15 @@ func Update(c *echo.Context) error {
 			log.Error(err)
 			return apiErrors.InternalError.Detail("Unable to update the last name")
 		}
+	} else if currentUser.Locked && !updatedUser.Locked {
+		if !permissions.Has(roles.UsersManage) {
+			return apiErrors.AdminLevelRequired
+		}
+		err = throttle.Unlock(updatedUser.GetID())
+		if err != nil {
+			log.Error(err)
+			return apiErrors.InternalError.Detail("Unable to unlock the user")
+		}
 	} else {
 		return apiErrors.InvalidRequest.Detail("No field sent")
 	}
@@ -170,17 +227,22 @@ func Get(c *echo.Context) error {
 		return utils.JSON(c, http.StatusOK, user)
 	}
 
-	if !user.IsAdmin {
+	if !m.Can(c, roles.UsersRead) {
 		return apiErrors.AdminLevelRequired
 	}
 
-	users, err := users.FindUsers()
+	q, err := utils.ParseQuery(c, users.Columns.Fields())
+	if err != nil {
+		return err
+	}
+
+	users, total, err := users.FindUsers(q)
 	if err != nil {
 		log.Error(err)
 		return apiErrors.InternalError.Detail("Unable to retreive the user list")
 	}
 
-	return utils.JSON(c, http.StatusOK, users)
+	return utils.JSONList(c, http.StatusOK, users, q, total)
 }
 
 func Post(c *echo.Context) error {
@@ -231,6 +293,11 @@ func Post(c *echo.Context) error {
 		})
 	}
 
+	err = passwords.LoadPolicy().Check(u.Password)
+	if err != nil {
+		return apiErrors.WeakPassword.Detail(err.Error())
+	}
+
 	newUser, err := users.CreateUser(
 		true,
 		u.Email,
@@ -252,8 +319,7 @@ func Post(c *echo.Context) error {
 		return err
 	}
 
-	winpass := utils.RandomString(8) + "s4D+"
-	_, err = ldap.AddUser(newUser.Id, winpass)
+	_, err = ldap.CreateAccount(newUser.Id)
 	if err != nil {
 		return err
 	}
@@ -284,13 +350,13 @@ func UpdatePassword(c *echo.Context) error {
 		return nil
 	}
 
-	exists, err := users.UserExists(userId)
+	target, err := users.GetUser(userId)
 	if err != nil {
 		log.Errorf("Unable to check user existance: %s", err.Error())
 		return err
 	}
 
-	if !exists {
+	if target == nil {
 		return c.JSON(http.StatusNotFound, hash{
 			"error": [1]hash{
 				hash{
@@ -300,7 +366,15 @@ func UpdatePassword(c *echo.Context) error {
 		})
 	}
 
-	err = users.UpdateUserPassword(userId, user.Data.Password)
+	actor := c.Get("user").(*users.User)
+	if !roles.CanChangeCredentials(actor, target) {
+		return apiErrors.Unauthorized.Detail("You cannot change the credentials of a user holding permissions you do not have")
+	}
+
+	err = passwords.Change(userId, user.Data.Password)
+	if v, ok := err.(*passwords.Violation); ok {
+		return apiErrors.WeakPassword.Detail(v.Error())
+	}
 	if err != nil {
 		log.Errorf("Unable to update user password: %s", err.Error())
 		return err
@@ -314,6 +388,8 @@ func UpdatePassword(c *echo.Context) error {
 }
 
 func GetUser(c *echo.Context) error {
+	current := c.Get("user").(*users.User)
+
 	userId := c.Param("id")
 	if userId == "" {
 		return c.JSON(http.StatusBadRequest, hash{
@@ -325,6 +401,10 @@ func GetUser(c *echo.Context) error {
 		})
 	}
 
+	if userId != current.GetID() && !m.Can(c, roles.UsersRead) {
+		return apiErrors.AdminLevelRequired
+	}
+
 	user, err := users.GetUser(userId)
 	if err != nil {
 		return err
@@ -340,5 +420,23 @@ func GetUser(c *echo.Context) error {
 		})
 	}
 
+	usage, err := storage.GetUsage(user)
+	if err != nil {
+		// The execution server may be offline or the user may have never
+		// logged in: the account is still returned, without its usage.
+		log.Warnf("Unable to get the storage usage of %s: %s", user.GetID(), err.Error())
+		quota, err := storage.UserQuota(user.GetID())
+		if err != nil {
+			return err
+		}
+		user.StorageQuota = &quota
+	} else {
+		user.StorageQuota = &usage.Quota
+		user.StorageUsed = &usage.Used
+		if usage.Quota > 0 {
+			user.StorageAvailable = &usage.Available
+		}
+	}
+
 	return utils.JSON(c, http.StatusOK, user)
 }
@@ -0,0 +1,195 @@
+package sso
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type testProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+	form   url.Values
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testProvider{key: key}
+	mux := http.NewServeMux()
+	p.server = httptest.NewServer(mux)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.form = r.PostForm
+
+		id, secret, _ := r.BasicAuth()
+		if id != "nanocloud" || secret != "secret" || r.PostForm.Get("code") != "code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "token",
+			"id_token":     p.idToken(t, "key1", p.claims),
+		})
+	})
+	return p
+}
+
+func (p *testProvider) idToken(t *testing.T, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(input))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (p *testProvider) validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":         p.server.URL,
+		"aud":         "nanocloud",
+		"sub":         "1234",
+		"exp":         testNow.Unix() + 60,
+		"nonce":       "nonce",
+		"email":       "jane@example.com",
+		"given_name":  "Jane",
+		"family_name": "Doe",
+	}
+}
+
+func (p *testProvider) client() *OIDC {
+	return NewOIDC(OIDCConfig{
+		Issuer:       p.server.URL,
+		ClientID:     "nanocloud",
+		ClientSecret: "secret",
+		RedirectURI:  "https://nanocloud.example.com/sso/oidc/callback",
+		Scopes:       []string{"openid", "email"},
+	})
+}
+
+func TestOIDCAuthURL(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+
+	u, err := p.client().AuthURL("state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := parsed.Query()
+	if !strings.HasPrefix(u, p.server.URL+"/authorize?") || q.Get("state") != "state" ||
+		q.Get("nonce") != "nonce" || q.Get("code_challenge") != CodeChallenge("verifier") ||
+		q.Get("scope") != "openid email" {
+		t.Errorf("unexpected URL %s", u)
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+	p.claims = p.validClaims()
+
+	claims, err := p.client().Exchange("code", "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.String("email") != "jane@example.com" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+	if p.form.Get("code_verifier") != "verifier" || p.form.Get("grant_type") != "authorization_code" {
+		t.Errorf("unexpected token request %v", p.form)
+	}
+
+	_, err = p.client().Exchange("bad code", "verifier", "nonce")
+	if err == nil {
+		t.Error("token errors must be reported")
+	}
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.server.Close()
+	o := p.client()
+
+	_, err := o.VerifyIDToken(p.idToken(t, "key1", p.validClaims()), "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := map[string]func(map[string]interface{}){
+		"issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"audience": func(c map[string]interface{}) { c["aud"] = "other" },
+		"azp":      func(c map[string]interface{}) { c["aud"] = []string{"nanocloud", "other"} },
+		"expired":  func(c map[string]interface{}) { c["exp"] = testNow.Unix() - 600 },
+		"nonce":    func(c map[string]interface{}) { c["nonce"] = "other" },
+	}
+	for name, change := range invalid {
+		claims := p.validClaims()
+		change(claims)
+
+		_, err = o.VerifyIDToken(p.idToken(t, "key1", claims), "nonce")
+		if err == nil {
+			t.Errorf("ID token with an invalid %s accepted", name)
+		}
+	}
+
+	token := p.idToken(t, "key1", p.validClaims())
+	parts := strings.Split(token, ".")
+	payload, _ := json.Marshal(map[string]interface{}{"iss": p.server.URL, "aud": "nanocloud", "exp": testNow.Unix() + 60, "nonce": "nonce", "email": "admin@example.com"})
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+	if _, err = o.VerifyIDToken(forged, "nonce"); err == nil {
+		t.Error("ID token with an invalid signature accepted")
+	}
+
+	none, _ := json.Marshal(map[string]string{"alg": "none", "kid": "key1"})
+	unsigned := base64.RawURLEncoding.EncodeToString(none) + "." + parts[1] + "."
+	if _, err = o.VerifyIDToken(unsigned, "nonce"); err == nil {
+		t.Error("unsigned ID token accepted")
+	}
+}
@@ -0,0 +1,393 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sso
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	samlNamespace         = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlpNamespace        = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlSuccess           = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBearer            = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlPOSTBinding       = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlRedirectBinding   = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	samlEmailNameIDFormat = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+
+	// Tolerated difference between the clocks of the IdP and ours.
+	clockSkew = 3 * time.Minute
+)
+
+var InvalidResponse = errors.New("invalid SAML response")
+
+// now is replaced by the tests.
+var now = time.Now
+
+// SAMLConfig describes the service provider (Nanocloud) and the identity
+// provider.
+type SAMLConfig struct {
+	EntityID string
+	ACSURL   string
+
+	// IdPURL is the single sign-on endpoint of the IdP (HTTP-Redirect
+	// binding) and IdPCertificate the certificate signing its responses.
+	IdPURL         string
+	IdPEntityID    string
+	IdPCertificate *x509.Certificate
+}
+
+type SAML struct {
+	config SAMLConfig
+}
+
+func NewSAML(config SAMLConfig) *SAML {
+	return &SAML{config: config}
+}
+
+// ParseCertificate reads a PEM or a base64 DER certificate, as found in the
+// metadata of the identity providers. Only RSA keys of 2048 bits or more are
+// accepted.
+func ParseCertificate(data []byte) (*x509.Certificate, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		der = block.Bytes
+	} else {
+		var err error
+		der, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return cert, checkKey(cert)
+}
+
+var authnRequestTemplate = template.Must(template.New("AuthnRequest").Parse(
+	`<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion"` +
+		` ID="{{.Id}}" Version="2.0" IssueInstant="{{.IssueInstant}}" Destination="{{.Destination}}"` +
+		` AssertionConsumerServiceURL="{{.ACSURL}}" ProtocolBinding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST">` +
+		`<saml:Issuer>{{.Issuer}}</saml:Issuer>` +
+		`<samlp:NameIDPolicy Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress" AllowCreate="true"/>` +
+		`</samlp:AuthnRequest>`,
+))
+
+// AuthnRequestURL returns the id of a new authentication request and the
+// URL sending it to the identity provider.
+func (s *SAML) AuthnRequestURL(relayState string) (string, string, error) {
+	id := "_" + RandomID()
+
+	var doc bytes.Buffer
+	err := authnRequestTemplate.Execute(&doc, map[string]string{
+		"Id":           id,
+		"IssueInstant": now().UTC().Format(time.RFC3339),
+		"Destination":  s.config.IdPURL,
+		"ACSURL":       s.config.ACSURL,
+		"Issuer":       s.config.EntityID,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	// HTTP-Redirect binding: deflated, base64 encoded, in the query
+	var deflated bytes.Buffer
+	w, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", "", err
+	}
+	w.Write(doc.Bytes())
+	w.Close()
+
+	u, err := url.Parse(s.config.IdPURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if relayState != "" {
+		q.Set("RelayState", relayState)
+	}
+	u.RawQuery = q.Encode()
+	return id, u.String(), nil
+}
+
+var metadataTemplate = template.Must(template.New("metadata").Parse(
+	`<?xml version="1.0" encoding="UTF-8"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="{{.EntityID}}">
+  <md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:NameIDFormat>urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress</md:NameIDFormat>
+    <md:AssertionConsumerService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="{{.ACSURL}}" index="0" isDefault="true"/>
+  </md:SPSSODescriptor>
+</md:EntityDescriptor>
+`))
+
+// Metadata returns the metadata describing Nanocloud to the identity
+// provider.
+func (s *SAML) Metadata() ([]byte, error) {
+	var buf bytes.Buffer
+	err := metadataTemplate.Execute(&buf, s.config)
+	return buf.Bytes(), err
+}
+
+// Assertion is the identity asserted by the IdP.
+type Assertion struct {
+	NameID       string
+	InResponseTo string
+	Attributes   map[string][]string
+}
+
+// Attribute returns the first value of the attribute.
+func (a *Assertion) Attribute(name string) string {
+	if values := a.Attributes[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func parseTime(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// checkValidity checks the NotBefore and NotOnOrAfter attributes of an
+// element, if present.
+func checkValidity(n *xmlNode) error {
+	t := now()
+
+	if v := n.attr("NotBefore"); v != "" {
+		notBefore, err := parseTime(v)
+		if err != nil || t.Add(clockSkew).Before(notBefore) {
+			return fmt.Errorf("%s not valid yet", n.local)
+		}
+	}
+
+	if v := n.attr("NotOnOrAfter"); v != "" {
+		notOnOrAfter, err := parseTime(v)
+		if err != nil || !t.Add(-clockSkew).Before(notOnOrAfter) {
+			return fmt.Errorf("%s expired", n.local)
+		}
+	}
+	return nil
+}
+
+// ParseResponse verifies the base64 encoded response posted by the IdP and
+// returns its assertion. Either the response or the assertion must be
+// signed; the signatures are checked by goxmldsig and the values are only
+// read from the elements it returns, so that signature wrapping attacks have
+// no effect. Encrypted assertions are not supported.
+func (s *SAML) ParseResponse(encoded string) (*Assertion, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return nil, InvalidResponse
+	}
+
+	// parseXML refuses the DTDs before the document is read by etree
+	_, err = parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := etree.NewDocument()
+	err = doc.ReadFromBytes(data)
+	if err != nil || doc.Root() == nil {
+		return nil, InvalidResponse
+	}
+	response := doc.Root()
+
+	responseSigned, err := hasSignature(response)
+	if err != nil {
+		return nil, InvalidResponse
+	}
+	if responseSigned {
+		response, err = s.verify(response)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := toNode(response)
+	if err != nil {
+		return nil, err
+	}
+
+	if !res.is(samlpNamespace, "Response") || res.attr("Version") != "2.0" {
+		return nil, InvalidResponse
+	}
+
+	if dest := res.attr("Destination"); dest != "" && dest != s.config.ACSURL {
+		return nil, fmt.Errorf("response sent to %s", dest)
+	}
+
+	if err = s.checkIssuer(res); err != nil {
+		return nil, err
+	}
+
+	status := res.child(samlpNamespace, "Status")
+	if status == nil {
+		return nil, InvalidResponse
+	}
+	code := status.child(samlpNamespace, "StatusCode")
+	if code == nil || code.attr("Value") != samlSuccess {
+		return nil, errors.New("authentication failed at the identity provider")
+	}
+
+	if len(res.childrenNamed(samlNamespace, "Assertion")) != 1 || res.child(samlNamespace, "EncryptedAssertion") != nil {
+		return nil, errors.New("the response must hold exactly one unencrypted assertion")
+	}
+
+	assertionEl, err := detachAssertion(response)
+	if err != nil {
+		return nil, InvalidResponse
+	}
+
+	assertionSigned, err := hasSignature(assertionEl)
+	if err != nil {
+		return nil, InvalidResponse
+	}
+	if assertionSigned || !responseSigned {
+		assertionEl, err = s.verify(assertionEl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	assertion, err := toNode(assertionEl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.checkIssuer(assertion); err != nil {
+		return nil, err
+	}
+
+	result := &Assertion{Attributes: make(map[string][]string)}
+
+	// InResponseTo is only trusted from signed elements
+	if responseSigned {
+		result.InResponseTo = res.attr("InResponseTo")
+	}
+
+	conditions := assertion.child(samlNamespace, "Conditions")
+	if conditions == nil {
+		return nil, errors.New("the assertion has no conditions")
+	}
+	if err = checkValidity(conditions); err != nil {
+		return nil, err
+	}
+
+	audienceOK := false
+	for _, restriction := range conditions.childrenNamed(samlNamespace, "AudienceRestriction") {
+		audienceOK = false
+		for _, audience := range restriction.childrenNamed(samlNamespace, "Audience") {
+			if strings.TrimSpace(audience.textContent()) == s.config.EntityID {
+				audienceOK = true
+			}
+		}
+		if !audienceOK {
+			break
+		}
+	}
+	if !audienceOK {
+		return nil, errors.New("the assertion is not intended for Nanocloud")
+	}
+
+	subject := assertion.child(samlNamespace, "Subject")
+	if subject == nil {
+		return nil, InvalidResponse
+	}
+	nameID := subject.child(samlNamespace, "NameID")
+	if nameID == nil {
+		return nil, InvalidResponse
+	}
+	result.NameID = strings.TrimSpace(nameID.textContent())
+
+	confirmed := false
+	for _, confirmation := range subject.childrenNamed(samlNamespace, "SubjectConfirmation") {
+		data := confirmation.child(samlNamespace, "SubjectConfirmationData")
+		if confirmation.attr("Method") != samlBearer || data == nil {
+			continue
+		}
+		if data.attr("Recipient") != s.config.ACSURL || data.attr("NotOnOrAfter") == "" || checkValidity(data) != nil {
+			continue
+		}
+		if inResponseTo := data.attr("InResponseTo"); inResponseTo != "" {
+			if result.InResponseTo != "" && inResponseTo != result.InResponseTo {
+				continue
+			}
+			result.InResponseTo = inResponseTo
+		}
+		confirmed = true
+		break
+	}
+	if !confirmed {
+		return nil, errors.New("the subject of the assertion is not confirmed")
+	}
+
+	if statement := assertion.child(samlNamespace, "AttributeStatement"); statement != nil {
+		for _, attr := range statement.childrenNamed(samlNamespace, "Attribute") {
+			name := attr.attr("Name")
+			for _, value := range attr.childrenNamed(samlNamespace, "AttributeValue") {
+				result.Attributes[name] = append(result.Attributes[name], strings.TrimSpace(value.textContent()))
+			}
+		}
+	}
+	return result, nil
+}
+
+// checkIssuer checks the Issuer of a response or an assertion when the
+// entity id of the IdP is configured.
+func (s *SAML) checkIssuer(n *xmlNode) error {
+	if s.config.IdPEntityID == "" {
+		return nil
+	}
+
+	issuer := n.child(samlNamespace, "Issuer")
+	if issuer == nil {
+		// The issuer of a response is optional
+		if n.is(samlpNamespace, "Response") {
+			return nil
+		}
+		return InvalidResponse
+	}
+
+	if strings.TrimSpace(issuer.textContent()) != s.config.IdPEntityID {
+		return fmt.Errorf("unexpected issuer %q", issuer.textContent())
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package sso
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+var testNow = time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func init() {
+	now = func() time.Time {
+		return testNow
+	}
+}
+
+func testCertificate(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	key, der := testKey(t, 2048)
+
+	cert, err := ParseCertificate([]byte(base64.StdEncoding.EncodeToString(der)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+func testKey(t *testing.T, bits int) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    testNow.Add(-time.Hour),
+		NotAfter:     testNow.Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, der
+}
+
+type testKeyStore struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func (ks testKeyStore) GetKeyPair() (*rsa.PrivateKey, []byte, error) {
+	return ks.key, ks.cert.Raw, nil
+}
+
+// sign returns the element with its enveloped signature, the way the
+// identity providers sign the responses and the assertions.
+func sign(t *testing.T, key *rsa.PrivateKey, cert *x509.Certificate, el string) string {
+	doc := etree.NewDocument()
+	err := doc.ReadFromString(el)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := dsig.NewDefaultSigningContext(testKeyStore{key, cert})
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.SetRoot(signed)
+
+	out, err := doc.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+const testACS = "https://nanocloud.example.com/sso/saml/acs"
+
+func testAssertion(audience, recipient, notOnOrAfter string) string {
+	return `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_assertion" Version="2.0" IssueInstant="2016-06-01T11:59:00Z">
+    <saml:Issuer>https://idp.example.com</saml:Issuer>
+    <saml:Subject>
+      <saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">jane@example.com</saml:NameID>
+      <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml:SubjectConfirmationData InResponseTo="_request" NotOnOrAfter="` + notOnOrAfter + `" Recipient="` + recipient + `"/>
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotBefore="2016-06-01T11:59:00Z" NotOnOrAfter="` + notOnOrAfter + `">
+      <saml:AudienceRestriction><saml:Audience>` + audience + `</saml:Audience></saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="firstName"><saml:AttributeValue>Jane</saml:AttributeValue></saml:Attribute>
+      <saml:Attribute Name="groups"><saml:AttributeValue>staff</saml:AttributeValue><saml:AttributeValue>admins</saml:AttributeValue></saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>`
+}
+
+func testResponse(assertions ...string) string {
+	return `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="_response" Version="2.0" IssueInstant="2016-06-01T11:59:00Z" Destination="` + testACS + `" InResponseTo="_request">
+  <saml:Issuer xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">https://idp.example.com</saml:Issuer>
+  <samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+  ` + strings.Join(assertions, "\n") + `
+</samlp:Response>`
+}
+
+func testSAML(cert *x509.Certificate) *SAML {
+	return NewSAML(SAMLConfig{
+		EntityID:       "https://nanocloud.example.com/sso/saml/metadata",
+		ACSURL:         testACS,
+		IdPURL:         "https://idp.example.com/sso",
+		IdPEntityID:    "https://idp.example.com",
+		IdPCertificate: cert,
+	})
+}
+
+func encode(doc string) string {
+	return base64.StdEncoding.EncodeToString([]byte(doc))
+}
+
+const (
+	validAudience = "https://nanocloud.example.com/sso/saml/metadata"
+	validUntil    = "2016-06-01T12:05:00Z"
+)
+
+func TestParseSignedAssertion(t *testing.T) {
+	key, cert := testCertificate(t)
+	s := testSAML(cert)
+
+	doc := testResponse(sign(t, key, cert, testAssertion(validAudience, testACS, validUntil)))
+
+	a, err := s.ParseResponse(encode(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.NameID != "jane@example.com" || a.InResponseTo != "_request" {
+		t.Errorf("unexpected assertion %+v", a)
+	}
+	if a.Attribute("firstName") != "Jane" || len(a.Attributes["groups"]) != 2 {
+		t.Errorf("unexpected attributes %v", a.Attributes)
+	}
+}
+
+func TestParseSignedResponse(t *testing.T) {
+	key, cert := testCertificate(t)
+	s := testSAML(cert)
+
+	doc := sign(t, key, cert, testResponse(testAssertion(validAudience, testACS, validUntil)))
+
+	a, err := s.ParseResponse(encode(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.NameID != "jane@example.com" {
+		t.Errorf("unexpected NameID %q", a.NameID)
+	}
+}
+
+func TestParseInvalidResponses(t *testing.T) {
+	key, cert := testCertificate(t)
+	s := testSAML(cert)
+
+	signed := func(audience, recipient, notOnOrAfter string) string {
+		return testResponse(sign(t, key, cert, testAssertion(audience, recipient, notOnOrAfter)))
+	}
+	valid := signed(validAudience, testACS, validUntil)
+	otherKey, otherCert := testCertificate(t)
+
+	tests := map[string]string{
+		"unsigned":  testResponse(testAssertion(validAudience, testACS, validUntil)),
+		"tampered":  strings.Replace(valid, "jane@example.com", "admin@example.com", 1),
+		"other key": testResponse(sign(t, otherKey, otherCert, testAssertion(validAudience, testACS, validUntil))),
+		"audience":  signed("https://other.example.com", testACS, validUntil),
+		"recipient": signed(validAudience, "https://other.example.com/acs", validUntil),
+		"expired":   signed(validAudience, testACS, "2016-06-01T11:50:00Z"),
+		"wrapped": strings.Replace(valid, "<samlp:Status>",
+			strings.Replace(testAssertion(validAudience, testACS, validUntil), "jane@", "evil@", 1)+"<samlp:Status>", 1),
+		"sha1": testResponse(strings.Replace(sign(t, key, cert, testAssertion(validAudience, testACS, validUntil)),
+			dsig.RSASHA256SignatureMethod, dsig.RSASHA1SignatureMethod, 1)),
+	}
+
+	for name, doc := range tests {
+		_, err := s.ParseResponse(encode(doc))
+		if err == nil {
+			t.Errorf("%s response accepted", name)
+		}
+	}
+}
+
+func TestAuthnRequestURL(t *testing.T) {
+	_, cert := testCertificate(t)
+	s := testSAML(cert)
+
+	id, u, err := s.AuthnRequestURL("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(id, "_") || !strings.HasPrefix(u, "https://idp.example.com/sso?SAMLRequest=") {
+		t.Errorf("unexpected request %s %s", id, u)
+	}
+}
+
+func TestParseIdPResponses(t *testing.T) {
+	defer func(n func() time.Time) { now = n }(now)
+
+	tests := []struct {
+		response, certificate string
+		config                SAMLConfig
+		at                    time.Time
+		nameID, inResponseTo  string
+	}{
+		{
+			// both the response and the assertion are signed
+			response:    "testdata/okta-response.xml",
+			certificate: "testdata/okta.pem",
+			config: SAMLConfig{
+				EntityID:    "123",
+				ACSURL:      "http://localhost:8080/v1/_saml_callback",
+				IdPEntityID: "http://www.okta.com/exk5zt0r12Edi4rD20h7",
+			},
+			at:           time.Date(2016, 3, 22, 19, 23, 0, 0, time.UTC),
+			nameID:       "phoebe.simon@scaleft.com",
+			inResponseTo: "_213843b4-0693-47b8-b2f6-c41e316015cc",
+		},
+		{
+			// only the response is signed
+			response:    "testdata/okta-unsolicited-response.xml",
+			certificate: "testdata/okta-unsolicited.pem",
+			config: SAMLConfig{
+				EntityID:    "b",
+				ACSURL:      "https://f1f51ddc.ngrok.io/api/sso/saml2/acs/58cafd0573d4f375b8e70e8e",
+				IdPEntityID: "a",
+			},
+			at:     time.Date(2017, 3, 18, 2, 30, 0, 0, time.UTC),
+			nameID: "arun+okta@launchdarkly.com",
+		},
+	}
+
+	for _, test := range tests {
+		data, err := ioutil.ReadFile(test.response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pem, err := ioutil.ReadFile(test.certificate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.config.IdPCertificate, err = ParseCertificate(pem)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		at := test.at
+		now = func() time.Time {
+			return at
+		}
+		s := NewSAML(test.config)
+
+		a, err := s.ParseResponse(encode(string(data)))
+		if err != nil {
+			t.Errorf("%s: %s", test.response, err)
+			continue
+		}
+		if a.NameID != test.nameID || a.InResponseTo != test.inResponseTo {
+			t.Errorf("%s: unexpected assertion %+v", test.response, a)
+		}
+
+		tampered := strings.Replace(string(data), test.nameID, "admin@example.com", 1)
+		_, err = s.ParseResponse(encode(tampered))
+		if err == nil {
+			t.Errorf("%s: tampered response accepted", test.response)
+		}
+	}
+}
+
+func TestWeakCertificate(t *testing.T) {
+	_, der := testKey(t, 1024)
+
+	_, err := ParseCertificate([]byte(base64.StdEncoding.EncodeToString(der)))
+	if err != WeakKey {
+		t.Errorf("1024 bits keys must be refused, have %v", err)
+	}
+}
+
+func TestPendingLogins(t *testing.T) {
+	err := savePending("key", pendingLogin{nonce: "nonce"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := takePending("key")
+	if err != nil || p.nonce != "nonce" {
+		t.Fatalf("unexpected pending login %+v %v", p, err)
+	}
+
+	_, err = takePending("key")
+	if err != LoginExpired {
+		t.Error("pending logins must be used only once")
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sso
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+)
+
+const dsigNamespace = "http://www.w3.org/2000/09/xmldsig#"
+
+// Smallest RSA key accepted from the identity provider.
+const minKeySize = 2048
+
+// Algorithms accepted in the signatures, SHA-1 being refused.
+var signatureAlgorithms = map[string]bool{
+	dsig.RSASHA256SignatureMethod:             true,
+	dsig.RSASHA512SignatureMethod:             true,
+	"http://www.w3.org/2001/04/xmlenc#sha256": true,
+	"http://www.w3.org/2001/04/xmlenc#sha512": true,
+}
+
+var (
+	WeakKey       = errors.New("the certificate must hold an RSA key of at least 2048 bits")
+	WeakAlgorithm = errors.New("the XML signature must use SHA-256 or SHA-512")
+)
+
+// checkKey refuses the certificates whose key is too weak to trust the
+// signatures made with it.
+func checkKey(cert *x509.Certificate) error {
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok || key.N.BitLen() < minKeySize {
+		return WeakKey
+	}
+	return nil
+}
+
+// hasSignature reports whether the element holds an enveloped signature.
+func hasSignature(el *etree.Element) (bool, error) {
+	sig, err := etreeutils.NSFindOneChild(el, dsigNamespace, "Signature")
+	return sig != nil, err
+}
+
+// checkAlgorithms refuses the signatures using an algorithm too weak to be
+// trusted.
+func checkAlgorithms(el *etree.Element) error {
+	sig, err := etreeutils.NSFindOneChild(el, dsigNamespace, "Signature")
+	if err != nil || sig == nil {
+		return InvalidResponse
+	}
+
+	var walk func(e *etree.Element) error
+	walk = func(e *etree.Element) error {
+		if e.Tag == "SignatureMethod" || e.Tag == "DigestMethod" {
+			if !signatureAlgorithms[e.SelectAttrValue("Algorithm", "")] {
+				return WeakAlgorithm
+			}
+		}
+		for _, c := range e.ChildElements() {
+			if err := walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(sig)
+}
+
+// verify checks the enveloped signature of the element with the certificate
+// of the identity provider and returns the signed element, without its
+// signature. The element must declare the namespaces it uses.
+func (s *SAML) verify(el *etree.Element) (*etree.Element, error) {
+	if err := checkAlgorithms(el); err != nil {
+		return nil, err
+	}
+
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{s.config.IdPCertificate},
+	})
+	ctx.Clock = dsig.NewFakeClockAt(now())
+
+	signed, err := ctx.Validate(el)
+	if err != nil {
+		return nil, fmt.Errorf("invalid XML signature: %s", err)
+	}
+	return signed, nil
+}
+
+// detachAssertion returns a copy of the assertion of the response declaring
+// the namespaces it inherits, so that its signature can be checked alone.
+func detachAssertion(response *etree.Element) (*etree.Element, error) {
+	var assertion *etree.Element
+
+	err := etreeutils.NSFindChildrenIterateCtx(
+		etreeutils.DefaultNSContext, response, samlNamespace, "Assertion",
+		func(ctx etreeutils.NSContext, el *etree.Element) error {
+			var err error
+			assertion, err = etreeutils.NSDetatch(ctx, el)
+			if err != nil {
+				return err
+			}
+			return etreeutils.ErrTraversalHalted
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if assertion == nil {
+		return nil, InvalidResponse
+	}
+	return assertion, nil
+}
+
+// toNode converts an element to the tree the values are read from.
+func toNode(el *etree.Element) (*xmlNode, error) {
+	doc := etree.NewDocument()
+	doc.SetRoot(el.Copy())
+
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		return nil, err
+	}
+	return parseXML(data)
+}
@@ -0,0 +1,334 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package sso implements the single sign-on with OpenID Connect and SAML
+// 2.0 identity providers, configured with the SSO_* variables.
+package sso
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/connectors/db"
+	"github.com/Nanocloud/community/nanocloud/models/auth"
+	"github.com/Nanocloud/community/nanocloud/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Names of the providers, stored as the auth_provider of the users.
+const (
+	OIDCProvider = "oidc"
+	SAMLProvider = "saml"
+)
+
+// Time given to the user to log in at the identity provider.
+const loginTimeout = 10 * time.Minute
+
+var (
+	ProviderNotFound = errors.New("SSO provider not found")
+	LoginExpired     = errors.New("SSO login expired or unknown")
+	MissingEmail     = errors.New("the identity provider returned no email")
+)
+
+// RandomID returns a random string usable in URLs.
+func RandomID() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Provider is an identity provider users can log in with.
+type Provider struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+var (
+	configOnce sync.Once
+	baseURL    string
+	providers  []Provider
+
+	oidc        *OIDC
+	oidcMapping mapping
+	saml        *SAML
+	samlMapping mapping
+)
+
+// mapping tells which claims or attributes describe the user. Email is
+// only used by SAML, the NameID being the email when it is empty.
+type mapping struct {
+	Email      string
+	FirstName  string
+	LastName   string
+	Groups     string
+	AdminGroup string
+}
+
+func loadConfig() {
+	baseURL = strings.TrimSuffix(utils.Env("SSO_URL", "http://localhost"), "/")
+
+	issuer := utils.Env("SSO_OIDC_ISSUER", "")
+	if issuer != "" {
+		oidc = NewOIDC(OIDCConfig{
+			Issuer:       issuer,
+			ClientID:     utils.Env("SSO_OIDC_CLIENT_ID", ""),
+			ClientSecret: utils.Env("SSO_OIDC_CLIENT_SECRET", ""),
+			RedirectURI:  baseURL + "/sso/oidc/callback",
+			Scopes:       strings.Fields(utils.Env("SSO_OIDC_SCOPES", "openid email profile")),
+		})
+		oidcMapping = mapping{
+			FirstName:  "given_name",
+			LastName:   "family_name",
+			Groups:     utils.Env("SSO_OIDC_GROUPS_CLAIM", "groups"),
+			AdminGroup: utils.Env("SSO_OIDC_ADMIN_GROUP", ""),
+		}
+		providers = append(providers, Provider{OIDCProvider, utils.Env("SSO_OIDC_NAME", "OpenID Connect")})
+	}
+
+	idpURL := utils.Env("SSO_SAML_IDP_URL", "")
+	if idpURL != "" {
+		data, err := ioutil.ReadFile(utils.Env("SSO_SAML_IDP_CERTIFICATE", ""))
+		if err != nil {
+			log.Error("Unable to read the certificate of the SAML identity provider: ", err)
+			return
+		}
+
+		cert, err := ParseCertificate(data)
+		if err != nil {
+			log.Error("Invalid certificate of the SAML identity provider: ", err)
+			return
+		}
+
+		saml = NewSAML(SAMLConfig{
+			EntityID:       utils.Env("SSO_SAML_ENTITY_ID", baseURL+"/sso/saml/metadata"),
+			ACSURL:         baseURL + "/sso/saml/acs",
+			IdPURL:         idpURL,
+			IdPEntityID:    utils.Env("SSO_SAML_IDP_ENTITY_ID", ""),
+			IdPCertificate: cert,
+		})
+		samlMapping = mapping{
+			Email:      utils.Env("SSO_SAML_ATTR_EMAIL", ""),
+			FirstName:  utils.Env("SSO_SAML_ATTR_FIRSTNAME", "firstName"),
+			LastName:   utils.Env("SSO_SAML_ATTR_LASTNAME", "lastName"),
+			Groups:     utils.Env("SSO_SAML_ATTR_GROUPS", "groups"),
+			AdminGroup: utils.Env("SSO_SAML_ADMIN_GROUP", ""),
+		}
+		providers = append(providers, Provider{SAMLProvider, utils.Env("SSO_SAML_NAME", "SAML")})
+	}
+}
+
+// Providers returns the configured identity providers.
+func Providers() []Provider {
+	configOnce.Do(loadConfig)
+	return providers
+}
+
+// IsSecure tells whether Nanocloud is served over HTTPS.
+func IsSecure() bool {
+	configOnce.Do(loadConfig)
+	return strings.HasPrefix(baseURL, "https://")
+}
+
+// A login started and not finished yet.
+type pendingLogin struct {
+	nonce        string
+	codeVerifier string
+}
+
+// savePending stores the login in the database, so that it can be finished
+// on any instance and survives a restart.
+func savePending(key string, p pendingLogin) error {
+	t := now()
+
+	_, err := db.Exec(`DELETE FROM sso_logins WHERE expires_at < $1::timestamptz`, t)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO sso_logins (key, nonce, code_verifier, expires_at)
+		VALUES ($1::varchar, $2::varchar, $3::varchar, $4::timestamptz)`,
+		key, p.nonce, p.codeVerifier, t.Add(loginTimeout),
+	)
+	return err
+}
+
+// takePending returns the pending login. It can be used only once.
+func takePending(key string) (pendingLogin, error) {
+	var p pendingLogin
+	var expiresAt time.Time
+
+	rows, err := db.Query(
+		`DELETE FROM sso_logins WHERE key = $1::varchar
+		RETURNING nonce, code_verifier, expires_at`,
+		key,
+	)
+	if err != nil {
+		return p, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return p, LoginExpired
+	}
+	err = rows.Scan(&p.nonce, &p.codeVerifier, &expiresAt)
+	if err != nil {
+		return p, err
+	}
+	if now().After(expiresAt) {
+		return p, LoginExpired
+	}
+	return p, nil
+}
+
+// StartLogin returns the URL of the identity provider the user is sent to
+// and the key identifying the login: the OIDC state or the SAML request
+// id.
+func StartLogin(provider string) (string, string, error) {
+	configOnce.Do(loadConfig)
+
+	switch {
+	case provider == OIDCProvider && oidc != nil:
+		state := RandomID()
+		p := pendingLogin{nonce: RandomID(), codeVerifier: RandomID()}
+
+		u, err := oidc.AuthURL(state, p.nonce, p.codeVerifier)
+		if err != nil {
+			return "", "", err
+		}
+		err = savePending(state, p)
+		if err != nil {
+			return "", "", err
+		}
+		return u, state, nil
+
+	case provider == SAMLProvider && saml != nil:
+		id, u, err := saml.AuthnRequestURL("")
+		if err != nil {
+			return "", "", err
+		}
+		err = savePending(id, pendingLogin{})
+		if err != nil {
+			return "", "", err
+		}
+		return u, id, nil
+	}
+	return "", "", ProviderNotFound
+}
+
+// FinishOIDCLogin exchanges the code received by the callback and returns
+// the identity of the user.
+func FinishOIDCLogin(state, code string) (*auth.Identity, error) {
+	configOnce.Do(loadConfig)
+	if oidc == nil {
+		return nil, ProviderNotFound
+	}
+
+	p, err := takePending(state)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := oidc.Exchange(code, p.codeVerifier, p.nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return nil, errors.New("the email of the user is not verified")
+	}
+
+	return oidcMapping.identity(claims.String("email"), claims.String, claims.Strings)
+}
+
+// FinishSAMLLogin verifies the response posted to the assertion consumer
+// service and returns the identity of the user. Only the response to the
+// request sent from the same browser, whose id is given, is accepted.
+func FinishSAMLLogin(response, requestId string) (*auth.Identity, error) {
+	configOnce.Do(loadConfig)
+	if saml == nil {
+		return nil, ProviderNotFound
+	}
+
+	assertion, err := saml.ParseResponse(response)
+	if err != nil {
+		return nil, err
+	}
+
+	if assertion.InResponseTo == "" {
+		return nil, errors.New("unsolicited SAML responses are not accepted")
+	}
+	if assertion.InResponseTo != requestId {
+		return nil, errors.New("the SAML response was not requested by this browser")
+	}
+	_, err = takePending(assertion.InResponseTo)
+	if err != nil {
+		return nil, err
+	}
+
+	email := assertion.NameID
+	if samlMapping.Email != "" {
+		email = assertion.Attribute(samlMapping.Email)
+	}
+
+	return samlMapping.identity(email, assertion.Attribute, func(name string) []string {
+		return assertion.Attributes[name]
+	})
+}
+
+func (m mapping) identity(email string, get func(string) string, getAll func(string) []string) (*auth.Identity, error) {
+	identity := &auth.Identity{
+		Email:     strings.TrimSpace(email),
+		FirstName: get(m.FirstName),
+		LastName:  get(m.LastName),
+	}
+	if identity.Email == "" {
+		return nil, MissingEmail
+	}
+
+	if m.AdminGroup != "" {
+		identity.AdminSynced = true
+		for _, group := range getAll(m.Groups) {
+			if group == m.AdminGroup {
+				identity.IsAdmin = true
+			}
+		}
+	}
+	return identity, nil
+}
+
+// Metadata returns the metadata of the SAML service provider.
+func Metadata() ([]byte, error) {
+	configOnce.Do(loadConfig)
+	if saml == nil {
+		return nil, ProviderNotFound
+	}
+	return saml.Metadata()
+}
@@ -0,0 +1,180 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package sso
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+)
+
+const (
+	xmlNamespace   = "http://www.w3.org/XML/1998/namespace"
+	maxDocumentLen = 1 << 20
+)
+
+type xmlAttr struct {
+	prefix string
+	local  string
+	value  string
+}
+
+// xmlNode is an element or a text of a parsed document. Unlike encoding/xml, it resolves the namespaces of the elements
+// from the prefixes declared in the document.
+type xmlNode struct {
+	prefix   string
+	local    string
+	attrs    []xmlAttr
+	children []*xmlNode
+	parent   *xmlNode
+
+	text   string
+	isText bool
+}
+
+// parseXML parses a document. DTDs are refused: they are not used by SAML
+// and only open the door to entity expansion attacks.
+func parseXML(data []byte) (*xmlNode, error) {
+	if len(data) > maxDocumentLen {
+		return nil, errors.New("document too large")
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = true
+
+	var root, current *xmlNode
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{prefix: t.Name.Space, local: t.Name.Local, parent: current}
+			for _, a := range t.Attr {
+				n.attrs = append(n.attrs, xmlAttr{a.Name.Space, a.Name.Local, a.Value})
+			}
+			if current == nil {
+				if root != nil {
+					return nil, errors.New("several root elements")
+				}
+				root = n
+			} else {
+				current.children = append(current.children, n)
+			}
+			current = n
+		case xml.EndElement:
+			if current == nil || current.prefix != t.Name.Space || current.local != t.Name.Local {
+				return nil, errors.New("unexpected end element")
+			}
+			current = current.parent
+		case xml.CharData:
+			if current != nil {
+				current.children = append(current.children, &xmlNode{text: string(t), isText: true, parent: current})
+			}
+		case xml.Directive:
+			return nil, errors.New("DTDs are not supported")
+		}
+	}
+
+	if root == nil || current != nil {
+		return nil, errors.New("incomplete document")
+	}
+	return root, nil
+}
+
+func (n *xmlNode) isElement() bool {
+	return n.local != ""
+}
+
+// lookupNamespace returns the namespace bound to the prefix in the scope of
+// the node, "" for the default namespace.
+func (n *xmlNode) lookupNamespace(prefix string) string {
+	if prefix == "xml" {
+		return xmlNamespace
+	}
+
+	for e := n; e != nil; e = e.parent {
+		for _, a := range e.attrs {
+			if (prefix == "" && a.prefix == "" && a.local == "xmlns") ||
+				(prefix != "" && a.prefix == "xmlns" && a.local == prefix) {
+				return a.value
+			}
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) namespace() string {
+	return n.lookupNamespace(n.prefix)
+}
+
+func (n *xmlNode) is(namespace, local string) bool {
+	return n.isElement() && n.local == local && n.namespace() == namespace
+}
+
+// attr returns the value of an attribute without prefix.
+func (n *xmlNode) attr(local string) string {
+	for _, a := range n.attrs {
+		if a.prefix == "" && a.local == local {
+			return a.value
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) childrenNamed(namespace, local string) []*xmlNode {
+	var nodes []*xmlNode
+	for _, c := range n.children {
+		if c.is(namespace, local) {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+func (n *xmlNode) child(namespace, local string) *xmlNode {
+	nodes := n.childrenNamed(namespace, local)
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[0]
+}
+
+// textContent concatenates the texts of the node and of its descendants.
+func (n *xmlNode) textContent() string {
+	if n.isText {
+		return n.text
+	}
+
+	var s string
+	for _, c := range n.children {
+		s += c.textContent()
+	}
+	return s
+}
@@ -0,0 +1,12 @@
+package sso
+
+import (
+	"testing"
+)
+
+func TestParseXMLRefusesDTD(t *testing.T) {
+	_, err := parseXML([]byte(`<!DOCTYPE root [<!ENTITY e "x">]><root>&e;</root>`))
+	if err == nil {
+		t.Error("DTDs must be refused")
+	}
+}
@@ -23,9 +23,11 @@
 package utils
 
 import (
+	"encoding/json"
 	"io/ioutil"
 
 	"github.com/Nanocloud/community/nanocloud/errors"
+	"github.com/Nanocloud/community/nanocloud/query"
 	"github.com/labstack/echo"
 	"github.com/manyminds/api2go/jsonapi"
 )
@@ -45,6 +47,48 @@ func JSON(c *echo.Context, code int, i interface{}) error {
 	return nil
 }
 
+// JSONList sends a page of a collection with the links to the other pages
+// and the number of items matching the query in `meta.total`.
+func JSONList(c *echo.Context, code int, i interface{}, q *query.Query, total int) error {
+	b, err := jsonapi.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	var document map[string]interface{}
+	err = json.Unmarshal(b, &document)
+	if err != nil {
+		return err
+	}
+
+	document["links"] = q.Links(c.Request().URL, total)
+	document["meta"] = map[string]interface{}{
+		"total": total,
+	}
+
+	b, err = json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	r := c.Response()
+
+	r.Header().Set("Content-Type", "application/vnd.api+json")
+	r.WriteHeader(code)
+	r.Write(b)
+	return nil
+}
+
+// ParseQuery reads the pagination, filtering and sorting parameters of the
+// request. See query.Parse.
+func ParseQuery(c *echo.Context, fields []string) (*query.Query, error) {
+	q, err := query.Parse(c.Request().URL.Query(), fields)
+	if err != nil {
+		return nil, errors.InvalidRequest.Detail(err.Error())
+	}
+	return q, nil
+}
+
 func ParseJSONBody(c *echo.Context, dest interface{}) error {
 	body, err := ioutil.ReadAll(c.Request().Body)
 	if err != nil {
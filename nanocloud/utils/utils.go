@@ -25,7 +25,9 @@ package utils
 import (
 	"io"
 	"math/rand"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -39,6 +41,28 @@ func Env(key, def string) string {
 	return v
 }
 
+// ClientIP returns the address of the client that sent the request. When
+// TRUST_PROXY is "true", the X-Forwarded-For header set by the proxy is used.
+func ClientIP(req *http.Request) string {
+	var ip string
+	if os.Getenv("TRUST_PROXY") == "true" {
+		xForwardedFor := req.Header["X-Forwarded-For"]
+		if len(xForwardedFor) > 0 {
+			ip = xForwardedFor[0]
+		}
+	}
+
+	if len(ip) == 0 {
+		addr := req.RemoteAddr
+		i := strings.LastIndex(addr, ":")
+		if i < 0 {
+			return addr
+		}
+		ip = addr[0:i]
+	}
+	return ip
+}
+
 // randomString
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 const (
@@ -1,6 +1,10 @@
 package utils
 
-import "testing"
+import (
+	"net/http"
+	"os"
+	"testing"
+)
 
 func TestRandomness(t *testing.T) {
 	a := RandomString(10)
@@ -19,3 +23,21 @@ func TestLength(t *testing.T) {
 		}
 	}
 }
+
+func TestClientIP(t *testing.T) {
+	req := &http.Request{
+		RemoteAddr: "10.0.0.1:51234",
+		Header:     http.Header{"X-Forwarded-For": []string{"192.168.1.10"}},
+	}
+
+	os.Setenv("TRUST_PROXY", "")
+	if ip := ClientIP(req); ip != "10.0.0.1" {
+		t.Errorf("Expected the remote address, got %s", ip)
+	}
+
+	os.Setenv("TRUST_PROXY", "true")
+	defer os.Setenv("TRUST_PROXY", "")
+	if ip := ClientIP(req); ip != "192.168.1.10" {
+		t.Errorf("Expected the forwarded address, got %s", ip)
+	}
+}
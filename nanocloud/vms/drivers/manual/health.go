@@ -0,0 +1,128 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package manual
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Nanocloud/community/nanocloud/vms"
+	"github.com/labstack/gommon/log"
+)
+
+// healthTTL is how long the health of a machine is reused before plaza is
+// queried again. Plaza runs PowerShell checks to answer, which takes a while.
+const healthTTL = 30 * time.Second
+
+type health struct {
+	status     vms.MachineStatus
+	checkedAt  time.Time
+	refreshing bool
+}
+
+var (
+	healthLock sync.Mutex
+	healths    = make(map[string]*health)
+)
+
+// cachedStatus returns the last known status of the machine. A status older
+// than healthTTL is still returned while a new one is queried in the
+// background; only the first call for a machine waits for plaza.
+func cachedStatus(m *machine) vms.MachineStatus {
+	healthLock.Lock()
+	h, ok := healths[m.id]
+	if !ok {
+		healthLock.Unlock()
+
+		status := queryHealth(m)
+
+		healthLock.Lock()
+		healths[m.id] = &health{status: status, checkedAt: time.Now()}
+		healthLock.Unlock()
+		return status
+	}
+
+	status := h.status
+	if !h.refreshing && time.Since(h.checkedAt) > healthTTL {
+		h.refreshing = true
+		go func() {
+			status := queryHealth(m)
+
+			healthLock.Lock()
+			h.status = status
+			h.checkedAt = time.Now()
+			h.refreshing = false
+			healthLock.Unlock()
+		}()
+	}
+	healthLock.Unlock()
+	return status
+}
+
+// forgetStatus drops the cached status of a machine.
+func forgetStatus(id string) {
+	healthLock.Lock()
+	delete(healths, id)
+	healthLock.Unlock()
+}
+
+// queryHealth queries the health of plaza. The machine is degraded if plaza
+// is reachable but some of its checks fail.
+func queryHealth(m *machine) vms.MachineStatus {
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get("http://" + m.server + ":" + m.plazaport + "/health")
+	if err != nil {
+		log.Error(err)
+		return vms.StatusDown
+	}
+	defer resp.Body.Close()
+
+	// Versions of plaza without health checks are only checked for
+	// reachability.
+	if resp.StatusCode == http.StatusNotFound {
+		return vms.StatusUp
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return vms.StatusUnknown
+	}
+
+	var health struct {
+		Data struct {
+			Status string `json:"status"`
+		} `json:"data"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	if err != nil {
+		log.Error(err)
+		return vms.StatusUnknown
+	}
+
+	if health.Data.Status != "ok" {
+		return vms.StatusDegraded
+	}
+	return vms.StatusUp
+}
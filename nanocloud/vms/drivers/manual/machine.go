@@ -25,11 +25,9 @@ package manual
 import (
 	"errors"
 	"net"
-	"net/http"
 
 	"github.com/Nanocloud/community/nanocloud/connectors/db"
 	"github.com/Nanocloud/community/nanocloud/vms"
-	"github.com/labstack/gommon/log"
 )
 
 type machine struct {
@@ -41,18 +39,9 @@ type machine struct {
 	password  string
 }
 
+// Status returns the health of plaza, as last queried. See cachedStatus.
 func (m *machine) Status() (vms.MachineStatus, error) {
-	resp, err := http.Get("http://" + m.server + ":" + m.plazaport + "/")
-	if err != nil {
-		log.Error(err)
-		return vms.StatusDown, nil
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return vms.StatusUnknown, nil
-	}
-
-	return vms.StatusUp, nil
+	return cachedStatus(m), nil
 }
 
 func (m *machine) IP() (net.IP, error) {
@@ -91,6 +80,7 @@ func (m *machine) Terminate() error {
 	if deleted == 0 {
 		return errors.New("machine entry not found")
 	}
+	forgetStatus(m.id)
 	return nil
 }
 
@@ -34,6 +34,7 @@ const (
 	StatusBooting    MachineStatus = 4
 	StatusCreating   MachineStatus = 5
 	StatusStopping   MachineStatus = 6
+	StatusDegraded   MachineStatus = 7 // reachable but some components fail
 )
 
 type Machine interface {
@@ -65,6 +66,8 @@ func StatusToString(status MachineStatus) string {
 		return "creating"
 	case StatusStopping:
 		return "stopping"
+	case StatusDegraded:
+		return "degraded"
 	}
 	return "unknown"
 }
@@ -23,12 +23,14 @@
 COMMAND=${1}
 GOOS=${GOOS:-windows}
 GOARCH=${GOARCH:-amd64}
+VERSION=${VERSION:-$(git describe --tags --always 2>/dev/null || echo dev)}
 
 if [ "${COMMAND}" = "docker" ]; then
     docker build -t nanocloud/plaza .
     docker run \
         -e "GOOS=${GOOS}" \
         -e "GOARCH=${GOARCH}" \
+        -e "VERSION=${VERSION}" \
         -i --name plaza nanocloud/plaza ./build.sh
     if [ "${GOOS}" = "windows" ]; then
       docker cp plaza:/go/src/github.com/Nanocloud/community/plaza/plaza.exe .
@@ -42,5 +44,5 @@ else
     export GOOS
     export GOARCH
 
-    go build
+    go build -ldflags "-X github.com/Nanocloud/community/plaza/updater.Version=${VERSION}"
 fi
@@ -24,8 +24,78 @@
 
 package main
 
-import "github.com/Nanocloud/community/plaza/router"
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/Nanocloud/community/plaza/router"
+	"github.com/Nanocloud/community/plaza/updater"
+	log "github.com/Sirupsen/logrus"
+)
+
+// Time given to a new version of plaza to answer once restarted.
+const upgradeTimeout = 60 * time.Second
+
+// stopProcess terminates the process and waits for it to exit.
+func stopProcess(pid int) {
+	err := syscall.Kill(pid, syscall.SIGTERM)
+	if err != nil {
+		return
+	}
+
+	for i := 0; i < 30; i++ {
+		// Signal 0 only checks that the process still exists.
+		if syscall.Kill(pid, 0) != nil {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+	syscall.Kill(pid, syscall.SIGKILL)
+}
+
+// upgrade replaces the plaza process identified by pid by the new version of
+// the binary at exe. If the new version doesn't answer, the previous binary
+// is restored and started again.
+func upgrade(version string, pid int, exe string) error {
+	// Let plaza answer the update request before stopping it.
+	time.Sleep(time.Second)
+	stopProcess(pid)
+
+	p, err := updater.Start(exe)
+	if err == nil {
+		if updater.WaitHealthy(version, upgradeTimeout) {
+			log.Infof("plaza updated to %s", version)
+			return nil
+		}
+		stopProcess(p.Pid)
+	}
+
+	log.Errorf("plaza %s failed to start, rolling back", version)
+	err = updater.Rollback(exe)
+	if err != nil {
+		return err
+	}
+
+	_, err = updater.Start(exe)
+	return err
+}
+
+// main starts the plaza server. When launched with
+// `upgrade {version} {pid} {path}`, plaza restarts the server after an update
+// instead.
 func main() {
+	if len(os.Args) == 5 && os.Args[1] == "upgrade" {
+		pid, err := strconv.Atoi(os.Args[3])
+		if err == nil {
+			err = upgrade(os.Args[2], pid, os.Args[4])
+		}
+		if err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
 	router.Start()
 }
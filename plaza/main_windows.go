@@ -117,6 +117,10 @@ func sendShellInfo() {
 //            This option exists mainly for test purposes.
 //  - shell: This will launch plaza in shell mode. It should only be called by
 //           plaza itself.
+//  - upgrade: This will restart the service after an update and roll it back
+//             if the new version doesn't start. It should only be called by
+//             plaza itself, with the new version, the pid and the path of
+//             plaza as arguments.
 // If launched without argument, we asume Windows launched plaza as the shell
 // application of the session. The shell application of a sessions, is the
 // application launched when the user logon to his Windows session. Usually it's
@@ -144,6 +148,15 @@ func main() {
 			initPlatform()
 			router.Start()
 
+		case "upgrade":
+			// upgrade {version} {pid} {path}: the service is restarted, the
+			// pid is not needed.
+			if len(os.Args) < 5 {
+				err = fmt.Errorf("upgrade requires the new version, the pid and the path of plaza")
+				break
+			}
+			err = service.Upgrade(os.Args[2], os.Args[4])
+
 		case "shell":
 			sendShellInfo()
 
@@ -29,9 +29,11 @@ import (
 	"github.com/Nanocloud/community/plaza/routes/apps"
 	"github.com/Nanocloud/community/plaza/routes/exec"
 	"github.com/Nanocloud/community/plaza/routes/files"
+	"github.com/Nanocloud/community/plaza/routes/health"
 	"github.com/Nanocloud/community/plaza/routes/power"
 	"github.com/Nanocloud/community/plaza/routes/sessions"
 	"github.com/Nanocloud/community/plaza/routes/shells"
+	"github.com/Nanocloud/community/plaza/routes/update"
 	log "github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 	mw "github.com/labstack/echo/middleware"
@@ -47,13 +49,34 @@ func Start() {
 	e.Post("/exec", exec.Route)
 	e.Get("/", about.Get)
 
+	/***
+	HEALTH
+	***/
+
+	e.Get("/health", health.Get)
+	e.Get("/metrics", health.GetMetrics)
+
+	/***
+	UPDATE
+	***/
+
+	e.Get("/version", update.GetVersion)
+	e.Post("/update", update.Post)
+
 	/***
 	FILES
 	***/
 
 	e.Get("/files", files.Get)
+	e.Get("/files/archive", files.GetArchive)
+	e.Get("/files/usage", files.GetUsage)
 	e.Post("/upload", files.Post)
 
+	e.Post("/uploads", files.PostUpload)
+	e.Head("/uploads/:id", files.HeadUpload)
+	e.Patch("/uploads/:id", files.PatchUpload)
+	e.Delete("/uploads/:id", files.DeleteUpload)
+
 	/***
 	POWER
 	***/
@@ -66,8 +89,12 @@ func Start() {
 	SESSIONS
 	***/
 
+	e.Get("/sessions", sessions.List)
 	e.Get("/sessions/:id", sessions.Get)
 	e.Delete("/sessions/:id", sessions.Logoff)
+	e.Post("/sessions/:id/disconnect", sessions.Disconnect)
+	e.Post("/sessions/:id/logoff", sessions.LogoffSession)
+	e.Post("/sessions/:id/message", sessions.SendMessage)
 
 	/***
 	SHELLS
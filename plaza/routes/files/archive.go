@@ -0,0 +1,221 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+func isSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// walkDir calls fn for every regular file and directory under root with its
+// path relative to root, using forward slashes. Symbolic links are ignored.
+func walkDir(root string, fn func(path, name string, fi os.FileInfo) error) error {
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == root || fi.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return fn(path, filepath.ToSlash(name), fi)
+	})
+}
+
+func copyFileTo(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func writeZip(w io.Writer, root string) error {
+	zw := zip.NewWriter(w)
+
+	err := walkDir(root, func(path, name string, fi os.FileInfo) error {
+		header, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+
+		if fi.IsDir() {
+			header.Name += "/"
+			_, err = zw.CreateHeader(header)
+			return err
+		}
+
+		header.Method = zip.Deflate
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		return copyFileTo(fw, path)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, root string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	err := walkDir(root, func(path, name string, fi os.FileInfo) error {
+		header, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+
+		if fi.IsDir() {
+			header.Name += "/"
+			return tw.WriteHeader(header)
+		}
+
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+		return copyFileTo(tw, path)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// GetArchive streams an archive of the directory specified by the `path`
+// query parameter. The `format` parameter can be `zip` (default) or `tar.gz`.
+// The archive is built on the fly so its size is unknown and no
+// Content-Length is sent.
+func GetArchive(c *echo.Context) error {
+	dir := c.Query("path")
+	format := c.Query("format")
+	if format == "" {
+		format = "zip"
+	}
+
+	if len(dir) < 1 {
+		return c.JSON(
+			http.StatusBadRequest,
+			hash{
+				"error": "Path not specified",
+			},
+		)
+	}
+
+	// The backend confines the path to the directory of the user; parent
+	// references are refused in case it is called otherwise.
+	for _, part := range strings.FieldsFunc(dir, isSeparator) {
+		if part == ".." {
+			return c.JSON(
+				http.StatusBadRequest,
+				hash{
+					"error": "Path cannot contain parent references",
+				},
+			)
+		}
+	}
+
+	var contentType string
+	var write func(io.Writer, string) error
+
+	switch format {
+	case "zip":
+		contentType = "application/zip"
+		write = writeZip
+	case "tar.gz":
+		contentType = "application/gzip"
+		write = writeTarGz
+	default:
+		return c.JSON(
+			http.StatusBadRequest,
+			hash{
+				"error": "Unsupported archive format",
+			},
+		)
+	}
+
+	s, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c.JSON(
+				http.StatusNotFound,
+				hash{
+					"error": "no such file or directory",
+				},
+			)
+		}
+		return err
+	}
+
+	if !s.IsDir() {
+		return c.JSON(
+			http.StatusBadRequest,
+			hash{
+				"error": "Path is not a directory",
+			},
+		)
+	}
+
+	w := c.Response()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+s.Name()+"."+format+"\"")
+	w.WriteHeader(http.StatusOK)
+
+	// Headers are already sent: an error can only interrupt the stream.
+	err = write(w, dir)
+	if err != nil {
+		log.Errorf("Unable to archive %s: %s", dir, err.Error())
+	}
+	return nil
+}
@@ -51,46 +51,65 @@ func (f *file) GetID() string {
 	return f.Id
 }
 
-func Post(w http.ResponseWriter, r *http.Request) {
-	var dst *os.File
-	username := r.URL.Query()["username"][0]
-	filename := r.URL.Query()["filename"][0]
-	var path string
+// userDir returns the directory where the files uploaded by username are
+// stored.
+func userDir(username string) string {
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf(`C:\Users\%s\Desktop\Nanocloud`, username)
+	}
+	return fmt.Sprintf("/home/%s", username)
+}
 
+// destinationPath returns the path where a file named filename uploaded by
+// username should be written.
+func destinationPath(username, filename string) (string, error) {
+	dstDir := userDir(username)
 	if runtime.GOOS == "windows" {
-		dstDir := fmt.Sprintf(`C:\Users\%s\Desktop\Nanocloud`, username)
 		err := os.MkdirAll(dstDir, 0777)
-
 		if err != nil {
-			log.Error(err)
-			http.Error(w, "Unable to create destination directory", http.StatusInternalServerError)
-			return
+			return "", err
 		}
 
-		path = fmt.Sprintf(`%s\%s`, dstDir, filename)
-	} else {
-		path = fmt.Sprintf("/home/%s/%s", username, filename)
+		return fmt.Sprintf(`%s\%s`, dstDir, filename), nil
 	}
+	return fmt.Sprintf("%s/%s", dstDir, filename), nil
+}
 
+// uniquePath returns path if no file exists at this location. Otherwise the
+// file is renamed like 'file (2).txt' until a free name is found.
+func uniquePath(path string) string {
 	_, err := os.Stat(path)
-	// if a file with exactly the same name already exists
-	if err == nil {
-		// we rename it like 'file (2).txt'
-		for i := 1; i > 0; i++ {
-			extension := filepath.Ext(path)
-			new_file := path[0:len(path)-len(extension)] + " (" + strconv.Itoa(i) + ")" + extension
-			_, err = os.Stat(new_file)
-			if err != nil {
-				dst, err = os.Create(new_file)
-				if err != nil {
-					log.Error(err)
-				}
-				break
-			}
+	if err != nil {
+		return path
+	}
+
+	extension := filepath.Ext(path)
+	for i := 1; i > 0; i++ {
+		newFile := path[0:len(path)-len(extension)] + " (" + strconv.Itoa(i) + ")" + extension
+		_, err = os.Stat(newFile)
+		if err != nil {
+			return newFile
 		}
-	} else {
-		dst, err = os.Create(path)
 	}
+	return path
+}
+
+func Post(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	filename := r.URL.Query().Get("filename")
+	if username == "" || filename == "" {
+		http.Error(w, "username and filename are required", http.StatusBadRequest)
+		return
+	}
+
+	path, err := destinationPath(username, filename)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "Unable to create destination directory", http.StatusInternalServerError)
+		return
+	}
+
+	dst, err := os.Create(uniquePath(path))
 	if err != nil {
 		log.Error(err)
 		http.Error(w, "Unable to create destination file", http.StatusInternalServerError)
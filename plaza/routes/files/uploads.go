@@ -0,0 +1,465 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package files
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	stdhash "hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+/*
+ * Resumable uploads follow the tus protocol (http://tus.io/protocols/resumable-upload.html):
+ *  - POST /uploads creates an upload of a known length
+ *  - HEAD /uploads/:id returns the number of bytes already received
+ *  - PATCH /uploads/:id appends a chunk at the given offset
+ *  - DELETE /uploads/:id discards the upload
+ *
+ * Chunks are appended to a part file stored in the uploads directory. The
+ * offset of an upload is the size of its part file so an upload can be resumed
+ * even if plaza has been restarted. Once the last byte is received, the part
+ * file is moved to the user's directory.
+ */
+
+const (
+	tusVersion = "1.0.0"
+
+	// statusChecksumMismatch is the status defined by the tus checksum
+	// extension when a chunk doesn't match its Upload-Checksum header.
+	statusChecksumMismatch = 460
+)
+
+var (
+	uploadNotFound   = errors.New("upload not found")
+	invalidChecksum  = errors.New("invalid Upload-Checksum header")
+	checksumMismatch = errors.New("checksum mismatch")
+	chunkTooLarge    = errors.New("chunk exceeds the upload length")
+)
+
+type upload struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+	Filename string `json:"filename"`
+	Length   int64  `json:"length"`
+}
+
+var (
+	uploadsDir = filepath.Join(os.TempDir(), "plaza-uploads")
+
+	// busyUploads prevents two chunks of the same upload from being written
+	// concurrently.
+	busyUploads     = make(map[string]bool)
+	busyUploadsLock sync.Mutex
+)
+
+func isValidUploadId(id string) bool {
+	if len(id) == 0 || len(id) > 64 {
+		return false
+	}
+
+	for _, c := range id {
+		if c != '-' && (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *upload) metaPath() string {
+	return filepath.Join(uploadsDir, u.Id+".json")
+}
+
+func (u *upload) partPath() string {
+	return filepath.Join(uploadsDir, u.Id+".part")
+}
+
+func (u *upload) offset() (int64, error) {
+	s, err := os.Stat(u.partPath())
+	if err != nil {
+		return 0, err
+	}
+	return s.Size(), nil
+}
+
+func (u *upload) remove() {
+	os.Remove(u.partPath())
+	os.Remove(u.metaPath())
+}
+
+func loadUpload(id string) (*upload, error) {
+	if !isValidUploadId(id) {
+		return nil, uploadNotFound
+	}
+
+	u := upload{Id: id}
+	b, err := ioutil.ReadFile(u.metaPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, uploadNotFound
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &u)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func lockUpload(id string) bool {
+	busyUploadsLock.Lock()
+	defer busyUploadsLock.Unlock()
+
+	if busyUploads[id] {
+		return false
+	}
+	busyUploads[id] = true
+	return true
+}
+
+func unlockUpload(id string) {
+	busyUploadsLock.Lock()
+	delete(busyUploads, id)
+	busyUploadsLock.Unlock()
+}
+
+// newChecksum parses an Upload-Checksum header ("{algorithm} {base64 digest}")
+// and returns the hash to compute along with the expected digest.
+func newChecksum(header string) (stdhash.Hash, []byte, error) {
+	splt := strings.SplitN(header, " ", 2)
+	if len(splt) != 2 {
+		return nil, nil, invalidChecksum
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(splt[1])
+	if err != nil {
+		return nil, nil, invalidChecksum
+	}
+
+	switch splt[0] {
+	case "md5":
+		return md5.New(), expected, nil
+	case "sha1":
+		return sha1.New(), expected, nil
+	case "sha256":
+		return sha256.New(), expected, nil
+	}
+	return nil, nil, invalidChecksum
+}
+
+// moveFile renames src to dst. If both paths are not on the same volume, the
+// file is copied then removed.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	cerr := out.Close()
+	if err != nil {
+		return err
+	}
+	if cerr != nil {
+		return cerr
+	}
+	return os.Remove(src)
+}
+
+func uploadError(c *echo.Context, status int, message string) error {
+	return c.JSON(
+		status,
+		hash{
+			"error": message,
+		},
+	)
+}
+
+func setTusHeaders(c *echo.Context) {
+	h := c.Response().Header()
+	h.Set("Tus-Resumable", tusVersion)
+	h.Set("Cache-Control", "no-store")
+}
+
+// PostUpload creates a new upload.
+// The upload id, the owner's username and the file name are passed in the
+// query string and the total size of the file in the Upload-Length header.
+func PostUpload(c *echo.Context) error {
+	setTusHeaders(c)
+
+	u := upload{
+		Id:       c.Query("id"),
+		Username: c.Query("username"),
+		Filename: filepath.Base(c.Query("filename")),
+	}
+
+	if !isValidUploadId(u.Id) {
+		return uploadError(c, http.StatusBadRequest, "Invalid upload id")
+	}
+
+	if u.Username == "" || u.Filename == "" || u.Filename == "." {
+		return uploadError(c, http.StatusBadRequest, "username and filename are required")
+	}
+
+	length, err := strconv.ParseInt(c.Request().Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return uploadError(c, http.StatusBadRequest, "Invalid Upload-Length header")
+	}
+	u.Length = length
+
+	err = os.MkdirAll(uploadsDir, 0700)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	_, err = os.Stat(u.metaPath())
+	if err == nil {
+		return uploadError(c, http.StatusConflict, "Upload exists already")
+	}
+
+	part, err := os.Create(u.partPath())
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	part.Close()
+
+	b, err := json.Marshal(u)
+	if err != nil {
+		u.remove()
+		return err
+	}
+
+	err = ioutil.WriteFile(u.metaPath(), b, 0600)
+	if err != nil {
+		log.Error(err)
+		u.remove()
+		return err
+	}
+
+	c.Response().Header().Set("Location", "/uploads/"+u.Id)
+	c.Response().WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// HeadUpload returns the offset and the length of an upload.
+func HeadUpload(c *echo.Context) error {
+	setTusHeaders(c)
+
+	u, err := loadUpload(c.Param("id"))
+	if err == uploadNotFound {
+		c.Response().WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	offset, err := u.offset()
+	if err != nil {
+		return err
+	}
+
+	h := c.Response().Header()
+	h.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	c.Response().WriteHeader(http.StatusOK)
+	return nil
+}
+
+// writeChunk appends the content of r to the upload at the specified offset.
+// If checksumHeader is not empty, the chunk is discarded if it doesn't match
+// the checksum. Returns the new offset of the upload.
+func writeChunk(u *upload, offset int64, r io.Reader, checksumHeader string) (int64, error) {
+	var h stdhash.Hash
+	var expected []byte
+	var err error
+
+	if checksumHeader != "" {
+		h, expected, err = newChecksum(checksumHeader)
+		if err != nil {
+			return offset, err
+		}
+	}
+
+	part, err := os.OpenFile(u.partPath(), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return offset, err
+	}
+	defer part.Close()
+
+	var w io.Writer = part
+	if h != nil {
+		w = io.MultiWriter(part, h)
+	}
+
+	remaining := u.Length - offset
+	written, err := io.Copy(w, io.LimitReader(r, remaining))
+
+	// An interrupted chunk is kept so the client can resume from where it
+	// stopped, unless it has to be verified.
+	if err != nil {
+		if h != nil {
+			part.Truncate(offset)
+			return offset, err
+		}
+		return offset + written, err
+	}
+
+	if written == remaining {
+		extra, _ := r.Read(make([]byte, 1))
+		if extra > 0 {
+			part.Truncate(offset)
+			return offset, chunkTooLarge
+		}
+	}
+
+	if h != nil && !bytes.Equal(h.Sum(nil), expected) {
+		part.Truncate(offset)
+		return offset, checksumMismatch
+	}
+
+	return offset + written, nil
+}
+
+// PatchUpload appends a chunk to an upload. The Upload-Offset header must
+// match the current offset of the upload.
+func PatchUpload(c *echo.Context) error {
+	setTusHeaders(c)
+	r := c.Request()
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		return uploadError(c, http.StatusUnsupportedMediaType, "Invalid Content-Type")
+	}
+
+	id := c.Param("id")
+	if !lockUpload(id) {
+		return uploadError(c, http.StatusConflict, "A chunk is already being written for this upload")
+	}
+	defer unlockUpload(id)
+
+	u, err := loadUpload(id)
+	if err == uploadNotFound {
+		return uploadError(c, http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	offset, err := u.offset()
+	if err != nil {
+		return err
+	}
+
+	requestOffset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || requestOffset != offset {
+		c.Response().Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		return uploadError(c, http.StatusConflict, "Upload-Offset doesn't match the current offset")
+	}
+
+	offset, err = writeChunk(u, offset, r.Body, r.Header.Get("Upload-Checksum"))
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+
+	switch err {
+	case nil:
+	case invalidChecksum:
+		return uploadError(c, http.StatusBadRequest, err.Error())
+	case checksumMismatch:
+		return uploadError(c, statusChecksumMismatch, err.Error())
+	case chunkTooLarge:
+		return uploadError(c, http.StatusRequestEntityTooLarge, err.Error())
+	default:
+		log.Error(err)
+		return uploadError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if offset == u.Length {
+		dst, err := destinationPath(u.Username, u.Filename)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+
+		err = moveFile(u.partPath(), uniquePath(dst))
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		os.Remove(u.metaPath())
+	}
+
+	c.Response().WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// DeleteUpload discards an upload and the data already received.
+func DeleteUpload(c *echo.Context) error {
+	setTusHeaders(c)
+
+	id := c.Param("id")
+	if !lockUpload(id) {
+		return uploadError(c, http.StatusConflict, "A chunk is being written for this upload")
+	}
+	defer unlockUpload(id)
+
+	u, err := loadUpload(id)
+	if err == uploadNotFound {
+		return uploadError(c, http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	u.remove()
+	c.Response().WriteHeader(http.StatusNoContent)
+	return nil
+}
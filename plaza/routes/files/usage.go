@@ -0,0 +1,91 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package files
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+// dirSize returns the total size in bytes of the regular files under root.
+func dirSize(root string) (int64, error) {
+	var size int64
+
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// Files can be removed while walking the directory.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// GetUsage returns the number of bytes used by the files of the user specified
+// by the `username` query parameter.
+func GetUsage(c *echo.Context) error {
+	username := c.Query("username")
+	if username == "" {
+		return c.JSON(
+			http.StatusBadRequest,
+			hash{
+				"error": "username not specified",
+			},
+		)
+	}
+
+	dir := userDir(username)
+
+	var used int64
+	_, err := os.Stat(dir)
+	if err == nil {
+		used, err = dirSize(dir)
+		if err != nil {
+			log.Errorf("Unable to compute the size of %s: %s", dir, err.Error())
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return c.JSON(
+		http.StatusOK,
+		hash{
+			"data": hash{
+				"path": dir,
+				"used": used,
+			},
+		},
+	)
+}
@@ -0,0 +1,212 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package health
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+const (
+	statusOk       = "ok"
+	statusDegraded = "degraded"
+	statusFailing  = "failing"
+
+	// Below this amount of free space, the disk check fails.
+	minFreeDisk = 1 << 30
+)
+
+type check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Resources used by the processes of a Windows session.
+type sessionStats struct {
+	Id         string
+	Username   string
+	Processes  int
+	CPUSeconds float64
+	Memory     uint64
+}
+
+type systemStats struct {
+	// CPU load between 0 and 1. Negative if unknown.
+	CPULoad         float64
+	MemoryTotal     uint64
+	MemoryAvailable uint64
+	DiskTotal       uint64
+	DiskFree        uint64
+	Sessions        []sessionStats
+}
+
+// userSessions returns the number of sessions opened by users.
+func (s *systemStats) userSessions() int {
+	n := 0
+	for _, session := range s.Sessions {
+		if session.Username != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func diskCheck(s *systemStats) check {
+	c := check{
+		Name:   "disk",
+		Status: statusOk,
+		Detail: fmt.Sprintf("%d bytes free of %d", s.DiskFree, s.DiskTotal),
+	}
+	if s.DiskFree < minFreeDisk {
+		c.Status = statusFailing
+	}
+	return c
+}
+
+func sessionsCheck(s *systemStats) check {
+	return check{
+		Name:   "sessions",
+		Status: statusOk,
+		Detail: strconv.Itoa(s.userSessions()),
+	}
+}
+
+// Get returns the status of the components required to run applications.
+// The status is `degraded` if any check fails: plaza is reachable but users
+// may not be able to use the machine.
+func Get(c *echo.Context) error {
+	checks := serviceChecks()
+
+	stats, err := getSystemStats()
+	if err != nil {
+		log.Error(err)
+		checks = append(checks, check{
+			Name:   "system",
+			Status: statusFailing,
+			Detail: err.Error(),
+		})
+	} else {
+		checks = append(checks, diskCheck(stats), sessionsCheck(stats))
+	}
+
+	status := statusOk
+	for _, c := range checks {
+		if c.Status != statusOk {
+			status = statusDegraded
+		}
+	}
+
+	return c.JSON(
+		http.StatusOK,
+		hash{
+			"data": hash{
+				"status": status,
+				"checks": checks,
+			},
+		},
+	)
+}
+
+func writeMetric(b *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Quote a label value as specified by the Prometheus text format.
+func labelValue(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+// GetMetrics returns the resources used by the machine and by each session in
+// the Prometheus text format.
+func GetMetrics(c *echo.Context) error {
+	stats, err := getSystemStats()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	b := new(bytes.Buffer)
+
+	if stats.CPULoad >= 0 {
+		writeMetric(b, "plaza_cpu_load", "gauge", "CPU load between 0 and 1.")
+		fmt.Fprintf(b, "plaza_cpu_load %g\n", stats.CPULoad)
+	}
+
+	writeMetric(b, "plaza_memory_total_bytes", "gauge", "Total physical memory.")
+	fmt.Fprintf(b, "plaza_memory_total_bytes %d\n", stats.MemoryTotal)
+	writeMetric(b, "plaza_memory_available_bytes", "gauge", "Available physical memory.")
+	fmt.Fprintf(b, "plaza_memory_available_bytes %d\n", stats.MemoryAvailable)
+
+	writeMetric(b, "plaza_disk_total_bytes", "gauge", "Size of the system disk.")
+	fmt.Fprintf(b, "plaza_disk_total_bytes %d\n", stats.DiskTotal)
+	writeMetric(b, "plaza_disk_free_bytes", "gauge", "Free space on the system disk.")
+	fmt.Fprintf(b, "plaza_disk_free_bytes %d\n", stats.DiskFree)
+
+	writeMetric(b, "plaza_sessions", "gauge", "Number of sessions opened by users.")
+	fmt.Fprintf(b, "plaza_sessions %d\n", stats.userSessions())
+
+	sort.Sort(bySessionId(stats.Sessions))
+
+	writeMetric(b, "plaza_session_processes", "gauge", "Number of processes running in the session.")
+	for _, s := range stats.Sessions {
+		fmt.Fprintf(b, "plaza_session_processes{session=%s,username=%s} %d\n",
+			labelValue(s.Id), labelValue(s.Username), s.Processes)
+	}
+
+	writeMetric(b, "plaza_session_cpu_seconds_total", "counter", "CPU time used by the processes of the session.")
+	for _, s := range stats.Sessions {
+		fmt.Fprintf(b, "plaza_session_cpu_seconds_total{session=%s,username=%s} %g\n",
+			labelValue(s.Id), labelValue(s.Username), s.CPUSeconds)
+	}
+
+	writeMetric(b, "plaza_session_memory_bytes", "gauge", "Memory used by the processes of the session.")
+	for _, s := range stats.Sessions {
+		fmt.Fprintf(b, "plaza_session_memory_bytes{session=%s,username=%s} %d\n",
+			labelValue(s.Id), labelValue(s.Username), s.Memory)
+	}
+
+	r := c.Response()
+	r.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteHeader(http.StatusOK)
+	_, err = r.Write(b.Bytes())
+	return err
+}
+
+type bySessionId []sessionStats
+
+func (s bySessionId) Len() int           { return len(s) }
+func (s bySessionId) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySessionId) Less(i, j int) bool { return s[i].Id < s[j].Id }
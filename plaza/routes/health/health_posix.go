@@ -0,0 +1,90 @@
+// +build !windows
+
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package health
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// RDS and Active Directory only exist on Windows.
+func serviceChecks() []check {
+	return []check{}
+}
+
+// Read the memory sizes from /proc/meminfo, in bytes.
+func memoryStats(s *systemStats) error {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "MemTotal:":
+			s.MemoryTotal = value * 1024
+		case "MemAvailable:":
+			s.MemoryAvailable = value * 1024
+		}
+	}
+	return scanner.Err()
+}
+
+func getSystemStats() (*systemStats, error) {
+	s := systemStats{
+		CPULoad:  -1,
+		Sessions: []sessionStats{},
+	}
+
+	err := memoryStats(&s)
+	if err != nil {
+		return nil, err
+	}
+
+	var fs syscall.Statfs_t
+	err = syscall.Statfs("/", &fs)
+	if err != nil {
+		return nil, err
+	}
+
+	s.DiskTotal = fs.Blocks * uint64(fs.Bsize)
+	s.DiskFree = fs.Bavail * uint64(fs.Bsize)
+	return &s, nil
+}
@@ -0,0 +1,228 @@
+// +build windows
+
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package health
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                 = syscall.NewLazyDLL("kernel32.dll")
+	procGlobalMemoryStatusEx = kernel32.NewProc("GlobalMemoryStatusEx")
+	procGetDiskFreeSpaceExW  = kernel32.NewProc("GetDiskFreeSpaceExW")
+)
+
+type memoryStatusEx struct {
+	dwLength                uint32
+	dwMemoryLoad            uint32
+	ullTotalPhys            uint64
+	ullAvailPhys            uint64
+	ullTotalPageFile        uint64
+	ullAvailPageFile        uint64
+	ullTotalVirtual         uint64
+	ullAvailVirtual         uint64
+	ullAvailExtendedVirtual uint64
+}
+
+// The CPU load and the resources used by each session are retrieved in a
+// single powershell call as it takes a while to start.
+const statsScript = `
+$cpu = (Get-WmiObject Win32_Processor | Measure-Object -Property LoadPercentage -Average).Average
+$sessions = Get-Process -IncludeUserName | Group-Object SessionId | ForEach-Object {
+	@{
+		id = $_.Name;
+		username = ($_.Group | Where-Object { $_.UserName } | Select-Object -First 1).UserName;
+		processes = $_.Count;
+		cpu = ($_.Group | Measure-Object -Property CPU -Sum).Sum;
+		memory = ($_.Group | Measure-Object -Property WorkingSet64 -Sum).Sum
+	}
+}
+@{ cpu = $cpu; sessions = @($sessions) } | ConvertTo-Json -Compress -Depth 3
+`
+
+func powershell(command string) (string, error) {
+	cmd := exec.Command("powershell.exe", command)
+	resp, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(resp)), nil
+}
+
+// serviceCheck checks that the Windows service is running.
+func serviceCheck(name, service string) check {
+	c := check{
+		Name:   name,
+		Status: statusFailing,
+	}
+
+	state, err := powershell("Write-Host (Get-Service -Name " + service + ").Status")
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+
+	c.Detail = state
+	if state == "Running" {
+		c.Status = statusOk
+	}
+	return c
+}
+
+func adCheck() check {
+	c := check{
+		Name:   "ad",
+		Status: statusFailing,
+	}
+
+	// Checks that the machine can reach a domain controller of its domain.
+	secure, err := powershell("Write-Host (Test-ComputerSecureChannel)")
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+
+	if secure == "True" {
+		c.Status = statusOk
+	} else {
+		c.Detail = "The secure channel with the domain is broken"
+	}
+	return c
+}
+
+func serviceChecks() []check {
+	return []check{
+		serviceCheck("rds", "TermService"),
+		adCheck(),
+	}
+}
+
+func memoryStats(s *systemStats) error {
+	var m memoryStatusEx
+	m.dwLength = uint32(unsafe.Sizeof(m))
+
+	r, _, err := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&m)))
+	if r == 0 {
+		return err
+	}
+
+	s.MemoryTotal = m.ullTotalPhys
+	s.MemoryAvailable = m.ullAvailPhys
+	return nil
+}
+
+func diskStats(s *systemStats) error {
+	root, err := syscall.UTF16PtrFromString(`C:\`)
+	if err != nil {
+		return err
+	}
+
+	var freeToCaller, total, free uint64
+	r, _, err := procGetDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(root)),
+		uintptr(unsafe.Pointer(&freeToCaller)),
+		uintptr(unsafe.Pointer(&total)),
+		uintptr(unsafe.Pointer(&free)),
+	)
+	if r == 0 {
+		return err
+	}
+
+	s.DiskTotal = total
+	s.DiskFree = free
+	return nil
+}
+
+func processStats(s *systemStats) error {
+	out, err := powershell(statsScript)
+	if err != nil {
+		return err
+	}
+
+	var res struct {
+		CPU      *float64 `json:"cpu"`
+		Sessions []struct {
+			Id        string   `json:"id"`
+			Username  *string  `json:"username"`
+			Processes int      `json:"processes"`
+			CPU       *float64 `json:"cpu"`
+			Memory    uint64   `json:"memory"`
+		} `json:"sessions"`
+	}
+
+	err = json.Unmarshal([]byte(out), &res)
+	if err != nil {
+		return err
+	}
+
+	s.CPULoad = -1
+	if res.CPU != nil {
+		s.CPULoad = *res.CPU / 100
+	}
+
+	for _, session := range res.Sessions {
+		stats := sessionStats{
+			Id:        session.Id,
+			Processes: session.Processes,
+			Memory:    session.Memory,
+		}
+
+		// Session 0 runs the services, not a user.
+		if session.Username != nil && session.Id != "0" {
+			splt := strings.Split(*session.Username, `\`)
+			stats.Username = splt[len(splt)-1]
+		}
+
+		if session.CPU != nil {
+			stats.CPUSeconds = *session.CPU
+		}
+		s.Sessions = append(s.Sessions, stats)
+	}
+	return nil
+}
+
+func getSystemStats() (*systemStats, error) {
+	s := systemStats{}
+
+	err := memoryStats(&s)
+	if err != nil {
+		return nil, err
+	}
+
+	err = diskStats(&s)
+	if err != nil {
+		return nil, err
+	}
+
+	err = processStats(&s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
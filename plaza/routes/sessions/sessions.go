@@ -26,6 +26,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
@@ -47,6 +48,182 @@ func formatResponse(tab []string, id string) [][]string {
 	return format
 }
 
+// A session as listed by `query session`.
+type session struct {
+	Id       string   `json:"id"`
+	Name     string   `json:"name"`
+	Username string   `json:"username"`
+	State    string   `json:"state"`
+	Programs []string `json:"programs"`
+}
+
+// parseSessions parses the output of `query session`. Disconnected sessions
+// have no session name. Sessions without user (services, console, listeners)
+// are ignored.
+func parseSessions(lines []string) []session {
+	sessions := make([]session, 0)
+	for _, line := range lines {
+		fields := strings.Fields(strings.TrimLeft(line, ">"))
+
+		var s session
+		switch {
+		case len(fields) >= 4 && isSessionId(fields[2]):
+			s = session{Name: fields[0], Username: fields[1], Id: fields[2], State: fields[3]}
+		case len(fields) == 3 && isSessionId(fields[1]) && fields[2] == "Disc":
+			s = session{Username: fields[0], Id: fields[1], State: fields[2]}
+		default:
+			continue
+		}
+
+		if s.Id == "0" {
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func isSessionId(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+func querySessions() ([]session, error) {
+	cmd := exec.Command("powershell.exe", "query session | ConvertTo-Json -Compress")
+	resp, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	err = json.Unmarshal(resp, &lines)
+	if err != nil {
+		return nil, err
+	}
+	return parseSessions(lines), nil
+}
+
+// sessionPrograms returns the paths of the executables running in each
+// session, indexed by session id.
+func sessionPrograms() (map[string][]string, error) {
+	cmd := exec.Command(
+		"powershell.exe",
+		"@(Get-Process | Where-Object { $_.SessionId -ne 0 -and $_.Path } | Select-Object SessionId, Path) | ConvertTo-Json -Compress",
+	)
+	resp, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, err
+	}
+
+	var processes []struct {
+		SessionId int
+		Path      string
+	}
+	err = json.Unmarshal(resp, &processes)
+	if err != nil {
+		return nil, err
+	}
+
+	programs := make(map[string][]string)
+	for _, p := range processes {
+		id := strconv.Itoa(p.SessionId)
+		programs[id] = append(programs[id], p.Path)
+	}
+	return programs, nil
+}
+
+// List returns the sessions of all the users.
+func List(c *echo.Context) error {
+	sessions, err := querySessions()
+	if err != nil {
+		log.Error("Unable to query sessions: ", err)
+		return err
+	}
+
+	programs, err := sessionPrograms()
+	if err != nil {
+		log.Error("Unable to list the programs of the sessions: ", err)
+	}
+	for i := range sessions {
+		sessions[i].Programs = programs[sessions[i].Id]
+		if sessions[i].Programs == nil {
+			sessions[i].Programs = make([]string, 0)
+		}
+	}
+
+	return c.JSON(
+		http.StatusOK,
+		hash{
+			"data": sessions,
+		},
+	)
+}
+
+// runSessionCommand runs a command taking the session id as first argument.
+func runSessionCommand(c *echo.Context, name string, args ...string) error {
+	id := c.Param("id")
+	if !isSessionId(id) {
+		return c.JSON(
+			http.StatusBadRequest,
+			hash{
+				"error": "Invalid session id",
+			},
+		)
+	}
+
+	cmd := exec.Command(name, append([]string{id}, args...)...)
+	resp, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Errorf("%s %s failed: %s: %s", name, id, err, string(resp))
+		return c.JSON(
+			http.StatusInternalServerError,
+			hash{
+				"error":    err.Error(),
+				"response": string(resp),
+			},
+		)
+	}
+
+	return c.JSON(
+		http.StatusOK,
+		hash{
+			"data": hash{
+				"success": true,
+			},
+		},
+	)
+}
+
+// Disconnect disconnects a session. Its applications keep running.
+func Disconnect(c *echo.Context) error {
+	return runSessionCommand(c, "tsdiscon")
+}
+
+// LogoffSession closes a session by its id.
+func LogoffSession(c *echo.Context) error {
+	return runSessionCommand(c, "logoff")
+}
+
+// SendMessage displays the message sent in the request body
+// (`{"message": "..."}`) in a session.
+func SendMessage(c *echo.Context) error {
+	var body struct {
+		Message string `json:"message"`
+	}
+
+	err := json.NewDecoder(c.Request().Body).Decode(&body)
+	if err != nil || strings.TrimSpace(body.Message) == "" {
+		return c.JSON(
+			http.StatusBadRequest,
+			hash{
+				"error": "message is missing",
+			},
+		)
+	}
+
+	return runSessionCommand(c, "msg", body.Message)
+}
+
 func Get(c *echo.Context) error {
 	cmd := exec.Command("powershell.exe", "query session | ConvertTo-Json -Compress")
 	resp, err := cmd.CombinedOutput()
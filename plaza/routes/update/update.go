@@ -0,0 +1,185 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package update
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"sync"
+
+	"github.com/Nanocloud/community/plaza/updater"
+	log "github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+type hash map[string]interface{}
+
+// maxBinarySize is the largest plaza binary accepted by Post.
+const maxBinarySize = 128 << 20
+
+var (
+	updating     bool
+	updatingLock sync.Mutex
+)
+
+// GetVersion returns the version of plaza. It is also used as a health check
+// once plaza has been updated.
+func GetVersion(c *echo.Context) error {
+	return c.JSON(
+		http.StatusOK,
+		hash{
+			"data": hash{
+				"version":      updater.Version,
+				"system":       runtime.GOOS,
+				"architecture": runtime.GOARCH,
+			},
+		},
+	)
+}
+
+// Post replaces plaza by the binary sent in the request body.
+// The X-Plaza-Version header is the version of the new binary and
+// X-Plaza-Signature the base64 encoded signature of its manifest, which binds
+// the version and the platform. Older versions are refused. Plaza is restarted once the
+// response is sent and rolled back if the new version doesn't start.
+func Post(c *echo.Context) error {
+	r := c.Request()
+	version := r.Header.Get("X-Plaza-Version")
+	signature := r.Header.Get("X-Plaza-Signature")
+
+	if version == "" || signature == "" {
+		return c.JSON(
+			http.StatusBadRequest,
+			hash{
+				"error": "X-Plaza-Version and X-Plaza-Signature headers are required",
+			},
+		)
+	}
+
+	if version == updater.Version {
+		return c.JSON(
+			http.StatusOK,
+			hash{
+				"data": hash{
+					"version": updater.Version,
+				},
+			},
+		)
+	}
+
+	// An older release, even signed, must not replace a newer plaza
+	if updater.CompareVersions(version, updater.Version) < 0 {
+		return c.JSON(
+			http.StatusConflict,
+			hash{
+				"error": "plaza already runs a newer version",
+			},
+		)
+	}
+
+	updatingLock.Lock()
+	defer updatingLock.Unlock()
+
+	if updating {
+		return c.JSON(
+			http.StatusConflict,
+			hash{
+				"error": "An update is already in progress",
+			},
+		)
+	}
+
+	binary, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBinarySize+1))
+	if err != nil {
+		return err
+	}
+
+	if len(binary) > maxBinarySize {
+		return c.JSON(
+			http.StatusRequestEntityTooLarge,
+			hash{
+				"error": "The binary is too large",
+			},
+		)
+	}
+
+	err = updater.Verify(binary, version, signature)
+	switch err {
+	case nil:
+	case updater.InvalidSignature, updater.PublicKeyNotFound, updater.InvalidPublicKey:
+		log.Error(err)
+		return c.JSON(
+			http.StatusForbidden,
+			hash{
+				"error": err.Error(),
+			},
+		)
+	default:
+		log.Error(err)
+		return err
+	}
+
+	exe, err := updater.Executable()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	// No other update can be started from here: the binary is replaced and
+	// plaza will be restarted. The flag is cleared if that doesn't happen.
+	updating = true
+
+	err = updater.Stage(binary)
+	if err != nil {
+		log.Error(err)
+		updating = false
+		return err
+	}
+
+	err = updater.StartUpgrade(version)
+	if err != nil {
+		log.Error(err)
+		rollbackErr := updater.Rollback(exe)
+		if rollbackErr != nil {
+			// The new binary stays in place and will be run on the next
+			// restart: don't accept another update until then.
+			log.Error(rollbackErr)
+		} else {
+			updating = false
+		}
+		return err
+	}
+
+	log.Infof("Updating plaza from %s to %s", updater.Version, version)
+
+	return c.JSON(
+		http.StatusAccepted,
+		hash{
+			"data": hash{
+				"version": version,
+			},
+		},
+	)
+}
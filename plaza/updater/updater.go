@@ -0,0 +1,322 @@
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package updater replaces the plaza binary by a new version signed by
+// Nanocloud.
+//
+// An update is applied in the following steps:
+//   - the new binary is verified with the public key installed next to plaza
+//   - the running binary is renamed with the `.old` suffix and the new one
+//     takes its place
+//   - the previous binary is launched in `upgrade` mode with the path of
+//     plaza; it restarts plaza and waits for the new version to answer on
+//     /version. If it doesn't, the previous binary is restored and restarted.
+package updater
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Version is the version of the running plaza. It is set at build time:
+//
+//	go build -ldflags "-X github.com/Nanocloud/community/plaza/updater.Version=1.0.0"
+var Version = "dev"
+
+const (
+	keyFile = "plaza-update.pem"
+
+	healthURL = "http://127.0.0.1:9090/version"
+)
+
+var (
+	PublicKeyNotFound = errors.New("no public key installed to verify updates")
+	InvalidPublicKey  = errors.New("invalid public key")
+	InvalidSignature  = errors.New("invalid signature")
+	NoPreviousVersion = errors.New("no previous version to roll back to")
+)
+
+// Executable returns the absolute path of the running binary.
+func Executable() (string, error) {
+	return filepath.Abs(os.Args[0])
+}
+
+// Return the path of the binary kept to roll back an update.
+func previousPath(exe string) string {
+	return exe + ".old"
+}
+
+// Return the public key used to verify the updates. It is a PEM encoded RSA
+// key installed in the same directory as plaza.
+func publicKey(exe string) (*rsa.PublicKey, error) {
+	b, err := ioutil.ReadFile(filepath.Join(filepath.Dir(exe), keyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, PublicKeyNotFound
+		}
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, InvalidPublicKey
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, InvalidPublicKey
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, InvalidPublicKey
+	}
+	return rsaKey, nil
+}
+
+// Platform returns the system and the architecture plaza is built for, as
+// signed in the updates.
+func Platform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// Manifest returns the text signed for an update: the version, the platform
+// and the hex encoded SHA-256 digest of the binary, one per line. Binding
+// the version and the platform keeps an older or a foreign release from being
+// replayed.
+func Manifest(version, platform string, binary []byte) []byte {
+	digest := sha256.Sum256(binary)
+	return []byte(version + "\n" + platform + "\n" + hex.EncodeToString(digest[:]) + "\n")
+}
+
+// Verify checks that signature is the base64 encoded RSA PKCS #1 v1.5
+// SHA-256 signature of the manifest of binary, for version and the platform
+// of the running plaza.
+func Verify(binary []byte, version, signature string) error {
+	exe, err := Executable()
+	if err != nil {
+		return err
+	}
+
+	key, err := publicKey(exe)
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return InvalidSignature
+	}
+
+	digest := sha256.Sum256(Manifest(version, Platform(), binary))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+	if err != nil {
+		return InvalidSignature
+	}
+	return nil
+}
+
+// CompareVersions compares two versions given by `git describe`, like 1.2.0
+// or 1.2.0-4-gd2a1c3e, and returns -1, 0 or 1. The versions that cannot be
+// parsed, like dev, come before the others.
+func CompareVersions(a, b string) int {
+	va, vb := parseVersion(a), parseVersion(b)
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseVersion returns the numbers of the version followed by the number of
+// commits since its tag, nil if it is not a version.
+func parseVersion(version string) []int {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), "-")
+
+	numbers := make([]int, 0)
+	for _, field := range strings.Split(parts[0], ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil
+		}
+		numbers = append(numbers, n)
+	}
+
+	// The commits since the tag are compared after the major, minor and
+	// patch numbers
+	for len(numbers) < 3 {
+		numbers = append(numbers, 0)
+	}
+	commits := 0
+	if len(parts) > 1 {
+		commits, _ = strconv.Atoi(parts[1])
+	}
+	return append(numbers, commits)
+}
+
+// Stage replaces the running binary by binary. The running binary is kept to
+// roll back the update. The new version is only used once plaza is restarted.
+func Stage(binary []byte) error {
+	exe, err := Executable()
+	if err != nil {
+		return err
+	}
+
+	next := exe + ".new"
+	err = ioutil.WriteFile(next, binary, 0755)
+	if err != nil {
+		return err
+	}
+
+	previous := previousPath(exe)
+	os.Remove(previous)
+
+	// A running binary cannot be overwritten on Windows but it can be renamed.
+	err = os.Rename(exe, previous)
+	if err != nil {
+		os.Remove(next)
+		return err
+	}
+
+	err = os.Rename(next, exe)
+	if err != nil {
+		os.Rename(previous, exe)
+		os.Remove(next)
+		return err
+	}
+	return nil
+}
+
+// Rollback restores the binary at exe replaced by the last update. It is
+// called by the upgrade process, which runs the previous binary: exe is the
+// path of plaza, not the path of the running binary.
+func Rollback(exe string) error {
+	previous := previousPath(exe)
+	_, err := os.Stat(previous)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return NoPreviousVersion
+		}
+		return err
+	}
+
+	err = os.Remove(exe)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(previous, exe)
+}
+
+// StartUpgrade launches the previous binary in `upgrade` mode so it can
+// restart plaza with the new version and roll back if the new version is not
+// healthy. The upgrade process gets the version, the pid of the running
+// plaza, to stop it when plaza doesn't run as a service, and the path of
+// plaza, as its own path is the one of the previous binary.
+func StartUpgrade(version string) error {
+	exe, err := Executable()
+	if err != nil {
+		return err
+	}
+
+	_, err = startDetached(
+		previousPath(exe),
+		"upgrade", version, strconv.Itoa(os.Getpid()), exe,
+	)
+	return err
+}
+
+// Start launches the plaza binary at exe in the background with args.
+func Start(exe string, args ...string) (*os.Process, error) {
+	return startDetached(exe, args...)
+}
+
+// Return the version reported by the plaza listening locally.
+func runningVersion() (string, error) {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(healthURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("plaza replied " + resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Version string `json:"version"`
+		} `json:"data"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+	return body.Data.Version, nil
+}
+
+// WaitHealthy waits until the local plaza reports version. Returns false if
+// it doesn't within timeout.
+func WaitHealthy(version string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		current, err := runningVersion()
+		if err == nil && current == version {
+			return true
+		}
+		if err == nil {
+			log.Infof("plaza reports version %s, waiting for %s", current, version)
+		}
+		time.Sleep(time.Second)
+	}
+	return false
+}
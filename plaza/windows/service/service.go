@@ -34,6 +34,7 @@ import (
 	"time"
 
 	"github.com/Nanocloud/community/plaza/router"
+	"github.com/Nanocloud/community/plaza/updater"
 	log "github.com/Sirupsen/logrus"
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/eventlog"
@@ -42,6 +43,9 @@ import (
 
 const (
 	serviceName = "plaza"
+
+	// Time given to a new version of plaza to answer once restarted.
+	upgradeTimeout = 60 * time.Second
 )
 
 var (
@@ -95,6 +99,37 @@ func startService(name string) error {
 	return nil
 }
 
+func stopService(name string) error {
+	log.Info("Stopping Service")
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("could not access service: %v", err)
+	}
+	defer s.Close()
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return fmt.Errorf("could not stop service: %v", err)
+	}
+	timeout := time.Now().Add(30 * time.Second)
+	for status.State != svc.Stopped {
+		if time.Now().After(timeout) {
+			return fmt.Errorf("timeout waiting for service to stop")
+		}
+		time.Sleep(300 * time.Millisecond)
+		status, err = s.Query()
+		if err != nil {
+			return fmt.Errorf("could not retrieve service status: %v", err)
+		}
+	}
+	log.Info("Service stopped")
+	return nil
+}
+
 func removeService(name string) error {
 	log.Info("Removing service")
 
@@ -212,6 +247,33 @@ func InstallItSelf() error {
 	return startService(serviceName)
 }
 
+// Upgrade restarts the service after its binary, at exe, has been replaced
+// and waits for the new version to be up. If it isn't, the previous binary is
+// restored. It is run by the previous binary, launched by the updater.
+func Upgrade(version, exe string) error {
+	// Let the service answer the update request before stopping it.
+	time.Sleep(time.Second)
+
+	err := stopService(serviceName)
+	if err == nil {
+		err = startService(serviceName)
+	}
+
+	if err == nil && updater.WaitHealthy(version, upgradeTimeout) {
+		log.Infof("plaza updated to %s", version)
+		return nil
+	}
+
+	log.Errorf("plaza %s failed to start, rolling back", version)
+	stopService(serviceName)
+
+	err = updater.Rollback(exe)
+	if err != nil {
+		return err
+	}
+	return startService(serviceName)
+}
+
 type myservice struct{}
 
 func (m *myservice) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
@@ -53,3 +53,4 @@ require('./test-download')(admin);
 require('./test-files')(admin);
 require('./test-histories')(admin);
 require('./test-machines')(admin);
+require('./test-oauth-clients')(admin);
@@ -95,6 +95,16 @@ var nano = {
 
         return this;
       },
+      patch: function(url, params, options) {
+        this.response = makeRequest('PATCH', url, params, options);
+
+        return this;
+      },
+      put: function(url, params, options) {
+        this.response = makeRequest('PUT', url, params, options);
+
+        return this;
+      },
       get: function(url, data) {
         this.response = makeRequest('GET', url, data || null);
 
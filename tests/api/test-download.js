@@ -27,7 +27,14 @@ var expect = nano.expect;
 module.exports = function(admin) {
 
   downloadToken = null;
-  pathToBeTested = "C:\\";
+
+  // The filenames are relative to the directory of the user
+  fileToBeTested = "nanotest.txt";
+
+  describe("Upload file", function() {
+    nano.as(admin).post("upload?filename=" + fileToBeTested, "drivers")
+      .shouldReturn(200);
+  });
 
   describe("Get download token", function() {
 
@@ -40,7 +47,7 @@ module.exports = function(admin) {
       additionalProperties: false
     };
 
-    var requestToken = nano.as(admin).get("api/files/token", { filename : pathToBeTested + "\\Windows\\system.ini" })
+    var requestToken = nano.as(admin).get("api/files/token", { filename : fileToBeTested })
       .shouldReturn(200)
       .shouldComplyToNotJsonAPI(expectedSchema);
 
@@ -49,21 +56,29 @@ module.exports = function(admin) {
     });
 
     if (requestToken.response.data.token) {
-      downloadToken = requestToken.response.token;
+      downloadToken = requestToken.response.data.token;
     }
   });
 
   describe("Download file", function() {
 
-    var downloadFile = nano.as(admin).get("api/files", { filename : pathToBeTested + "\\Windows\\system.ini" , token : downloadToken  })
+    var downloadFile = nano.as(admin).get("api/files", { filename : fileToBeTested , token : downloadToken  })
       .shouldReturn(200)
 
     it("should download the file",  function() {
       return expect(downloadFile.response.data).not.to.be.empty; 
     });
 
-    it("should match system.ini content",  function() {
+    it("should match the uploaded content",  function() {
       return expect(downloadFile.response.data.toString()).to.have.string('drivers');
     });
   });
+
+  describe("Get download token outside of the user's directory", function() {
+    nano.as(admin).get("api/files/token", { filename : "C:\\Windows\\system.ini" })
+      .shouldReturn(400);
+
+    nano.as(admin).get("api/files/token", { filename : "..\\..\\..\\Windows\\system.ini" })
+      .shouldReturn(400);
+  });
 }
@@ -28,7 +28,8 @@ module.exports = function(admin) {
 
   describe("List files", function() {
 
-    var pathToBeTested = "C:\\";
+    // The filenames are relative to the directory of the user
+    var pathToBeTested = ".";
     var expectedSchema = {
       type: 'object',
       properties: {
@@ -44,12 +45,19 @@ module.exports = function(admin) {
         .shouldBeJSONAPI()
         .shouldComplyTo(expectedSchema);
 
-    it("should contains Windows directory",  function() {
+    it("should contain the uploaded file",  function() {
       return expect(request).to.comprise.of.json({
-        name: "Windows",
-        size: 0,
-        type: "directory",
+        name: "nanotest.txt",
+        type: "regular file",
       });
     });
   });
+
+  describe("List files outside of the user's directory", function() {
+    nano.as(admin).get("api/files", { filename : "C:\\" })
+      .shouldReturn(400);
+
+    nano.as(admin).get("api/files", { filename : ".." })
+      .shouldReturn(400);
+  });
 }
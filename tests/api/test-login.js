@@ -28,18 +28,31 @@ var expect = nano.expect;
 module.exports = function() {
 
   var access_token = null;
-  describe('Login as an admin', function() {
+  var refresh_token = null;
 
-    var expectedSchema = {
-      type: 'object',
-      properties: {
-        access_token: {type: 'string'},
-        token_type: {'type': 'string'},
-        expires_in: {type: 'integer'}
-      },
-      required: ['access_token', 'token_type', 'expires_in'],
-      additionalProperties: false
-    };
+  var expectedSchema = {
+    type: 'object',
+    properties: {
+      access_token: {type: 'string'},
+      token_type: {'type': 'string'},
+      expires_in: {type: 'integer'},
+      refresh_token: {type: 'string'}
+    },
+    required: ['access_token', 'token_type', 'expires_in', 'refresh_token'],
+    additionalProperties: false
+  };
+
+  var expectedErrorSchema = {
+    type: 'object',
+    properties: {
+      error: {type: 'string'},
+      error_description: {'type': 'string'}
+    },
+    required: ['error', 'error_description'],
+    additionalProperties: false
+  };
+
+  describe('Login as an admin', function() {
 
     var request = nano.post('oauth/token', {
       username: nano.ADMIN_USERNAME,
@@ -63,8 +76,78 @@ module.exports = function() {
 
     if (request.response.data.access_token) {
       access_token = request.response.data.access_token;
+      refresh_token = request.response.data.refresh_token;
+    }
+
+  });
+
+  describe('Refresh the access token', function() {
+    var used_refresh_token = refresh_token;
+
+    var request = nano.post('oauth/token', {
+      refresh_token: refresh_token,
+      grant_type: 'refresh_token'
+    }, {
+      headers: {
+        Authorization: 'Basic ' + new Buffer(nano.CLIENTID).toString('base64')
+      }
+    }).shouldReturn(200)
+        .shouldBeJSON()
+        .shouldComplyToNotJsonAPI(expectedSchema);
+
+    it('should rotate the refresh token', function() {
+      expect(request.response.data.refresh_token).to.not.equal(used_refresh_token);
+    });
+
+    if (request.response.data.access_token) {
+      access_token = request.response.data.access_token;
+      refresh_token = request.response.data.refresh_token;
     }
+  });
+
+  describe('Refresh with a used refresh token', function() {
+    var used_refresh_token = refresh_token;
+
+    // Use the current refresh token, then present it again
+    nano.post('oauth/token', {
+      refresh_token: used_refresh_token,
+      grant_type: 'refresh_token'
+    }, {
+      headers: {
+        Authorization: 'Basic ' + new Buffer(nano.CLIENTID).toString('base64')
+      }
+    }).shouldReturn(200);
+
+    var request = nano.post('oauth/token', {
+      refresh_token: used_refresh_token,
+      grant_type: 'refresh_token'
+    }, {
+      headers: {
+        Authorization: 'Basic ' + new Buffer(nano.CLIENTID).toString('base64')
+      }
+    }).shouldReturn(400)
+        .shouldBeJSON()
+        .shouldComplyToNotJsonAPI(expectedErrorSchema);
+
+    it('should return invalid_grant', function() {
+      expect(request.response.data.error).to.equal('invalid_grant');
+    });
+  });
+
+  describe('Login again after the tokens were revoked', function() {
+    var request = nano.post('oauth/token', {
+      username: nano.ADMIN_USERNAME,
+      password: nano.ADMIN_PASSWORD,
+      grant_type: 'password'
+    }, {
+      headers: {
+        Authorization: 'Basic ' + new Buffer(nano.CLIENTID).toString('base64')
+      }
+    }).shouldReturn(200);
 
+    if (request.response.data.access_token) {
+      access_token = request.response.data.access_token;
+    }
   });
 
   describe("Logout", function() {
@@ -82,16 +165,6 @@ module.exports = function() {
     });
   });
 
-  var expectedErrorSchema = {
-    type: 'object',
-    properties: {
-      error: {type: 'string'},
-      error_description: {'type': 'string'}
-    },
-    required: ['error', 'error_description'],
-    additionalProperties: false
-  };
-
   describe('Login with an invalid user', function() {
 
     var request = nano.post('oauth/token', {
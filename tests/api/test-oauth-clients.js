@@ -0,0 +1,124 @@
+#!/usr/bin/nodejs
+/*
+ * Nanocloud Community, a comprehensive platform to turn any application
+ * into a cloud solution.
+ *
+ * Copyright (C) 2016 Nanocloud Software
+ *
+ * This file is part of Nanocloud community.
+ *
+ * Nanocloud community is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * Nanocloud community is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// jshint mocha:true
+
+var nano = require('./nanotest');
+var expect = nano.expect;
+
+module.exports = function(admin) {
+
+  var expectedSchema = {
+    type: 'object',
+    properties: {
+      name: {type: 'string'},
+      key: {type: 'string'},
+      secret: {type: 'string'},
+      'redirect-uris': {type: 'array', items: {type: 'string'}},
+      scopes: {type: 'array', items: {type: 'string'}},
+      confidential: {type: 'boolean'}
+    },
+    required: ['name', 'key', 'redirect-uris', 'scopes', 'confidential'],
+    additionalProperties: false
+  };
+
+  var client = null;
+  describe('Create an OAuth client', function() {
+
+    var request = nano.as(admin).post('api/oauth-clients', {
+      data: {
+        type: 'clients',
+        attributes: {
+          name: 'Portal',
+          'redirect-uris': ['https://portal.example.com/callback'],
+          scopes: ['profile', 'apps'],
+          confidential: true
+        }
+      }
+    }).shouldReturn(201)
+        .shouldBeJSONAPI()
+        .shouldComplyTo(expectedSchema);
+
+    it('should return the secret', function() {
+      expect(request.response.data.data.attributes.secret).to.exist;
+    });
+
+    if (request.response.data.data) {
+      client = request.response.data.data;
+    }
+  });
+
+  describe('List OAuth clients', function() {
+
+    var request = nano.as(admin).get('api/oauth-clients')
+        .shouldReturn(200)
+        .shouldBeJSONAPI()
+        .shouldComplyTo(expectedSchema);
+
+    it('should not return the secrets', function() {
+      request.response.data.data.forEach(function(c) {
+        expect(c.attributes.secret).to.not.exist;
+      });
+    });
+  });
+
+  describe('Show the consent page', function() {
+
+    nano.get('oauth/authorize?response_type=code&client_id=' + client.attributes.key +
+      '&redirect_uri=' + encodeURIComponent('https://portal.example.com/callback') +
+      '&scope=profile&state=xyz&code_challenge=E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM' +
+      '&code_challenge_method=S256')
+        .shouldReturn(200);
+  });
+
+  describe('Refuse an unregistered redirect URI', function() {
+
+    nano.get('oauth/authorize?response_type=code&client_id=' + client.attributes.key +
+      '&redirect_uri=' + encodeURIComponent('https://attacker.example.com/') +
+      '&scope=profile&code_challenge=E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM' +
+      '&code_challenge_method=S256')
+        .shouldReturn(400);
+  });
+
+  describe('Update an OAuth client', function() {
+
+    nano.as(admin).patch('api/oauth-clients/' + client.id, {
+      data: {
+        type: 'clients',
+        id: client.id,
+        attributes: {
+          name: 'Intranet portal',
+          'redirect-uris': ['https://portal.example.com/callback'],
+          scopes: ['profile']
+        }
+      }
+    }).shouldReturn(200)
+        .shouldBeJSONAPI()
+        .shouldComplyTo(expectedSchema);
+  });
+
+  describe('Delete an OAuth client', function() {
+
+    nano.as(admin).delete('api/oauth-clients/' + client.id)
+        .shouldReturn(200);
+  });
+};
@@ -41,6 +41,7 @@ module.exports = function(admin) {
     additionalProperties: false
   };
 
+  var admin_id = null;
   describe('List users', function() {
 
     var request = nano.as(admin).get('api/users')
@@ -57,6 +58,12 @@ module.exports = function(admin) {
         'last-name': 'Nanocloud'
       });
     });
+
+    (request.response.data.data || []).forEach(function(user) {
+      if (user.attributes.email === nano.ADMIN_USERNAME) {
+        admin_id = user.id;
+      }
+    });
   });
 
   var user_id = null;
@@ -88,4 +95,129 @@ module.exports = function(admin) {
     .shouldBeJSONAPI();
 
   });
+
+  describe('Helpdesk against an administrator', function() {
+    var helpdesk_email = 'helpdesk@nanocloud.com';
+    var helpdesk_id = null;
+
+    var roles = nano.as(admin).get('api/roles');
+    var role_id = null;
+    (roles.response.data.data || []).forEach(function(role) {
+      if (role.attributes.name === 'helpdesk') {
+        role_id = role.id;
+      }
+    });
+
+    var created = nano.as(admin).post('api/users', {
+      'data' : {
+        'type': 'users',
+        'attributes': {
+          'first-name': 'Help',
+          'last-name': 'Desk',
+          'email': helpdesk_email,
+          'password': nano.USER_PASSWORD
+        }
+      }
+    }).shouldReturn(201);
+
+    if (created.response.data.data) {
+      helpdesk_id = created.response.data.data.id;
+    }
+
+    nano.as(admin).post('api/roles/' + role_id + '/assignments', {
+      'data': {
+        'type': 'assignments',
+        'attributes': {
+          'user-id': helpdesk_id
+        }
+      }
+    }).shouldReturn(201);
+
+    var helpdesk = nano.login({
+      username: helpdesk_email,
+      password: nano.USER_PASSWORD
+    });
+
+    describe('Change the password of the administrator', function() {
+      nano.as(helpdesk).put('api/users/' + admin_id, {
+        data: {
+          password: 'Helpdesk@123'
+        }
+      }).shouldReturn(401);
+    });
+
+    // users:manage lets the email of other users be changed, which must not
+    // allow taking over an administrator through a password reset.
+    var managers = nano.as(admin).post('api/roles', {
+      'data': {
+        'type': 'roles',
+        'attributes': {
+          'name': 'user-managers',
+          'permissions': ['users:manage']
+        }
+      }
+    }).shouldReturn(201);
+
+    var managers_id = null;
+    if (managers.response.data.data) {
+      managers_id = managers.response.data.data.id;
+    }
+
+    nano.as(admin).post('api/roles/' + managers_id + '/assignments', {
+      'data': {
+        'type': 'assignments',
+        'attributes': {
+          'user-id': helpdesk_id
+        }
+      }
+    }).shouldReturn(201);
+
+    describe('Change the email of the administrator', function() {
+      nano.as(helpdesk).patch('api/users/' + admin_id, {
+        'data': {
+          'id': admin_id,
+          'type': 'users',
+          'attributes': {
+            'email': helpdesk_email,
+            'first-name': 'Admin',
+            'last-name': 'Nanocloud',
+            'is-admin': true
+          }
+        }
+      }).shouldReturn(401);
+    });
+
+    describe('Change the second factor of the administrator', function() {
+      nano.as(helpdesk).patch('api/users/' + admin_id + '/mfa', {
+        'data': {
+          'attributes': {
+            'required': false
+          }
+        }
+      }).shouldReturn(401);
+
+      nano.as(helpdesk).delete('api/users/' + admin_id + '/mfa')
+      .shouldReturn(401);
+    });
+
+    describe('The administrator can still log in', function() {
+      nano.post('oauth/token', {
+        username: nano.ADMIN_USERNAME,
+        password: nano.ADMIN_PASSWORD,
+        grant_type: 'password'
+      }, {
+        headers: {
+          Authorization: 'Basic ' + new Buffer(nano.CLIENTID).toString('base64')
+        }
+      }).shouldReturn(200);
+    });
+
+    describe('Remove the helpdesk user', function() {
+      nano.as(admin).delete('api/roles/' + managers_id)
+      .shouldReturn(200);
+
+      nano.as(admin).delete('api/users/' + helpdesk_id)
+      .shouldReturn(200);
+    });
+  });
 };
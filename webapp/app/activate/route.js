@@ -0,0 +1,34 @@
+import Ember from 'ember';
+
+export default Ember.Route.extend({
+  queryParams: {
+    token: {
+      refreshModel: true
+    }
+  },
+
+  beforeModel(transition) {
+    let token = transition.queryParams.token;
+
+    return Ember.$.ajax({
+      type: 'POST',
+      url: 'api/signup/activate',
+      contentType: 'application/json',
+      data: JSON.stringify({
+        data: {
+          attributes: {
+            token: token
+          }
+        }
+      })
+    }).then(() => {
+      this.toast.success("Your account is activated, you can now log in");
+      this.transitionTo('login');
+    }, (xhr) => {
+      let errors = xhr.responseJSON && xhr.responseJSON.errors;
+      let detail = errors && errors[0] && (errors[0].detail || errors[0].title);
+      this.toast.error(detail || "Your account has not been activated");
+      this.transitionTo('login');
+    });
+  }
+});
@@ -0,0 +1,25 @@
+import Ember from 'ember';
+import OAuth2 from './oauth2';
+
+// Completes a password login with the code of the authenticator app, using
+// the token of the mfa_required error.
+export default OAuth2.extend({
+  authenticate(mfaToken, otp) {
+    return new Ember.RSVP.Promise((resolve, reject) => {
+      const data = { 'grant_type': 'mfa_otp', 'mfa_token': mfaToken, otp };
+
+      this.makeRequest(this.get('serverTokenEndpoint'), data).then((response) => {
+        Ember.run(() => {
+          const expiresAt = this._absolutizeExpirationTime(response['expires_in']);
+          this._scheduleAccessTokenRefresh(response['expires_in'], expiresAt, response['refresh_token']);
+          if (!Ember.isEmpty(expiresAt)) {
+            response = Ember.merge(response, { 'expires_at': expiresAt });
+          }
+          resolve(response);
+        });
+      }, (xhr) => {
+        Ember.run(null, reject, xhr.responseJSON || xhr.responseText);
+      });
+    });
+  }
+});
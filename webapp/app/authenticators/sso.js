@@ -0,0 +1,17 @@
+import Ember from 'ember';
+import OAuth2 from './oauth2';
+
+// Authenticates with the tokens issued by the single sign-on. They are
+// refreshed and revoked like the ones of the password grant.
+export default OAuth2.extend({
+  authenticate(data) {
+    return new Ember.RSVP.Promise((resolve) => {
+      const expiresAt = this._absolutizeExpirationTime(data.expires_in);
+      this._scheduleAccessTokenRefresh(data.expires_in, expiresAt, data.refresh_token);
+      if (!Ember.isEmpty(expiresAt)) {
+        data = Ember.merge(data, { 'expires_at': expiresAt });
+      }
+      resolve(data);
+    });
+  }
+});
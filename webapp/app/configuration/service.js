@@ -1,7 +1,15 @@
 import Ember from 'ember';
 
 export default Ember.Service.extend({
-  loadData() {},
+  loadData() {
+    return Ember.$.getJSON('api/signup').then((response) => {
+      this.setProperties({
+        autoRegisterChecked: response.data.enabled,
+        signupDomains: response.data.domains
+      });
+    });
+  },
   autoRegisterChecked: false,
   autoLogoffChecked: false,
+  signupDomains: [],
 });
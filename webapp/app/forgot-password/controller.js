@@ -0,0 +1,34 @@
+import Ember from 'ember';
+
+export default Ember.Controller.extend({
+  email: '',
+  sent: false,
+
+  reset() {
+    this.setProperties({
+      email: '',
+      sent: false
+    });
+  },
+
+  actions: {
+    send() {
+      Ember.$.ajax({
+        type: 'POST',
+        url: 'api/password-resets',
+        contentType: 'application/json',
+        data: JSON.stringify({
+          data: {
+            attributes: {
+              email: this.get('email')
+            }
+          }
+        })
+      }).then(() => {
+        this.set('sent', true);
+      }, () => {
+        this.toast.error("Unable to send the reset link");
+      });
+    }
+  }
+});
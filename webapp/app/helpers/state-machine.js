@@ -9,6 +9,7 @@ export function stateMachine([val]/*, hash*/) {
     terminated: "Terminated",
     booting: "Boot in progress",
     downloading: "Downloading",
+    degraded: "Degraded",
   };
 
   return states[val] ? states[val] : val;
@@ -8,11 +8,14 @@ export default DS.Model.extend({
     userFirstname: DS.attr('string'),
     userLastname: DS.attr('string'),
     connectionId: DS.attr('string'),
+    machineId: DS.attr('string'),
+    appAlias: DS.attr('string'),
     startDate: DS.attr('date'),
     endDate: DS.attr('date'),
+    isRunning: Ember.computed.empty('endDate'),
     duration: Ember.computed('startDate', 'endDate', function() {
       var start = window.moment(this.get('startDate'));
-      var end = window.moment(this.get('endDate'));
+      var end = window.moment(this.get('endDate') || undefined);
       return end.diff(start);
     }),
     userFullName: Ember.computed('userFirstname', 'userLastname', function() {
@@ -0,0 +1,9 @@
+import DS from 'ember-data';
+
+export default DS.Model.extend({
+  email: DS.attr('string'),
+  invitedBy: DS.attr('string'),
+  createdAt: DS.attr('number'),
+  expiresAt: DS.attr('number'),
+  accepted: DS.attr('boolean')
+});
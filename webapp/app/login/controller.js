@@ -3,12 +3,36 @@ import Ember from 'ember';
 export default Ember.Controller.extend({
   identification: '',
   password: '',
+  otp: '',
+  mfaToken: null,
+  mfaEnrollment: null,
+  ssoProviders: [],
   configuration: Ember.inject.service('configuration'),
 
   reset() {
     this.setProperties({
       identification: '',
-      password: ''
+      password: '',
+      otp: '',
+      mfaToken: null,
+      mfaEnrollment: null
+    });
+  },
+
+  // The user must set up the authenticator app before the first login.
+  enrollMFA(mfaToken) {
+    let clientId = Ember.getOwner(this).lookup('authenticator:oauth2').get('clientId');
+
+    Ember.$.post('oauth/mfa/enroll', {
+      'client_id': clientId,
+      'mfa_token': mfaToken
+    }).then((enrollment) => {
+      this.setProperties({
+        mfaToken: mfaToken,
+        mfaEnrollment: enrollment
+      });
+    }, () => {
+      this.toast.error("Unable to set up two-factor authentication");
     });
   },
 
@@ -21,9 +45,40 @@ export default Ember.Controller.extend({
         'authenticator:oauth2',
         identification,
         password
-      ).catch(() => {
-        this.toast.error("Invalid credentials");
+      ).catch((err) => {
+        if (err && err.error === 'mfa_required') {
+          this.set('mfaToken', err.mfa_token);
+        } else if (err && err.error === 'mfa_enrollment_required') {
+          this.enrollMFA(err.mfa_token);
+        } else if (err && err.error === 'password_expired') {
+          this.toast.error("Your password has expired, please choose a new one");
+          this.transitionToRoute('reset-password', { queryParams: { email: identification } });
+        } else if (err && err.error === 'temporarily_unavailable') {
+          this.toast.error(err.error_description);
+        } else {
+          this.toast.error("Invalid credentials");
+        }
       });
+    },
+
+    verify() {
+      let { mfaToken, otp } = this.getProperties('mfaToken', 'otp');
+
+      this.get('session')
+      .authenticate('authenticator:mfa', mfaToken, otp)
+      .catch((err) => {
+        this.set('otp', '');
+        if (err && err.error === 'invalid_grant') {
+          this.reset();
+          this.toast.error("The login has expired, please try again");
+        } else {
+          this.toast.error("Invalid authentication code");
+        }
+      });
+    },
+
+    cancel() {
+      this.reset();
     }
   }
 });
@@ -8,10 +8,28 @@ export default Ember.Route.extend({
     }
   },
 
-  setupController(controller) {
+  setupController(controller, model, transition) {
     controller.reset();
     this.get('configuration').loadData();
     this._super(...arguments);
+
+    Ember.$.getJSON('sso/providers').then((response) => {
+      controller.set('ssoProviders', response.data);
+    });
+
+    if (transition.queryParams.sso_error) {
+      this.toast.error("Single sign-on failed");
+    }
+
+    // The single sign-on asks for the second factor like the password.
+    let mfaToken = transition.queryParams.mfa_token;
+    if (mfaToken) {
+      if (transition.queryParams.mfa_enroll) {
+        controller.enrollMFA(mfaToken);
+      } else {
+        controller.set('mfaToken', mfaToken);
+      }
+    }
   },
   configuration: Ember.inject.service('configuration')
 });
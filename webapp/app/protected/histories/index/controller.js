@@ -33,9 +33,9 @@ export default Ember.Controller.extend({
     this.get('items').forEach(function(item) {
       ret.push(Ember.Object.create({
         user: item.get('userFullName'),
-        application: item.get('app.displayName'),
+        application: item.get('appAlias'),
         start: window.moment(item.get('startDate')).format('MMMM Do YYYY, h:mm:ss A'),
-        end: window.moment(item.get('endDate')).format('MMMM Do YYYY, h:mm:ss A'),
+        end: item.get('isRunning') ? 'Running' : window.moment(item.get('endDate')).format('MMMM Do YYYY, h:mm:ss A'),
       }));
     });
     this.set('data', ret);
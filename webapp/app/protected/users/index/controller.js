@@ -0,0 +1,22 @@
+import Ember from 'ember';
+
+export default Ember.Controller.extend({
+  inviteEmail: '',
+
+  actions: {
+    invite() {
+      let invitation = this.store.createRecord('invitation', {
+        email: this.get('inviteEmail')
+      });
+
+      invitation.save()
+        .then(() => {
+          this.set('inviteEmail', '');
+          this.toast.success('Invitation sent to ' + invitation.get('email'));
+        }, (errorMessage) => {
+          invitation.deleteRecord();
+          this.toast.error('Cannot send the invitation : ' + errorMessage);
+        });
+    }
+  }
+});
@@ -19,6 +19,18 @@ export default Ember.Controller.extend({
         });
     },
 
+    unlock: function() {
+      this.set('model.locked', false);
+      this.get('model').save()
+        .then(() => {
+          this.send('refreshModel');
+          this.toast.success("User has been unlocked");
+        }, () => {
+          this.get('model').rollbackAttributes();
+          this.toast.error("User has not been unlocked");
+        });
+    },
+
     toggleEditPassword: function() {
       this.set('model.password', "");
       this.set('passwordConfirmation', "");
@@ -0,0 +1,59 @@
+import Ember from 'ember';
+
+export default Ember.Controller.extend({
+  queryParams: ['token', 'email'],
+  token: null,
+  email: null,
+  currentPassword: '',
+  password: '',
+  passwordConfirmation: '',
+
+  // Without a reset link, the expired password is changed with the
+  // current one.
+  expired: Ember.computed.not('token'),
+  hasToken: Ember.computed.bool('token'),
+
+  reset() {
+    this.setProperties({
+      currentPassword: '',
+      password: '',
+      passwordConfirmation: ''
+    });
+  },
+
+  actions: {
+    resetPassword() {
+      let { token, email, currentPassword, password, passwordConfirmation } = this.getProperties('token', 'email', 'currentPassword', 'password', 'passwordConfirmation');
+
+      if (password !== passwordConfirmation) {
+        this.toast.error("The passwords do not match");
+        return;
+      }
+
+      Ember.$.ajax({
+        type: 'PUT',
+        url: 'api/password-resets',
+        contentType: 'application/json',
+        data: JSON.stringify({
+          data: {
+            attributes: this.get('expired') ? {
+              email: email,
+              'current-password': currentPassword,
+              password: password
+            } : {
+              token: token,
+              password: password
+            }
+          }
+        })
+      }).then(() => {
+        this.toast.success("Your password has been changed");
+        this.transitionToRoute('login');
+      }, (xhr) => {
+        let errors = xhr.responseJSON && xhr.responseJSON.errors;
+        let detail = errors && errors[0] && (errors[0].detail || errors[0].title);
+        this.toast.error(detail || "Your password has not been changed");
+      });
+    }
+  }
+});
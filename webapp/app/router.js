@@ -27,6 +27,11 @@ Router.map(function() {
   });
 
   this.route('login');
+  this.route('forgot-password');
+  this.route('reset-password');
+  this.route('sign-up');
+  this.route('activate');
+  this.route('sso');
   this.route('direct-link');
 });
 
@@ -0,0 +1,71 @@
+import Ember from 'ember';
+
+export default Ember.Controller.extend({
+  queryParams: ['invitation'],
+  invitation: null,
+  email: '',
+  firstName: '',
+  lastName: '',
+  password: '',
+  passwordConfirmation: '',
+  configuration: Ember.inject.service('configuration'),
+
+  canSignUp: Ember.computed('invitation', 'configuration.autoRegisterChecked', function() {
+    return !!this.get('invitation') || this.get('configuration.autoRegisterChecked');
+  }),
+
+  allowedDomains: Ember.computed('configuration.signupDomains.[]', function() {
+    return (this.get('configuration.signupDomains') || []).join(', ');
+  }),
+
+  reset() {
+    this.setProperties({
+      email: '',
+      firstName: '',
+      lastName: '',
+      password: '',
+      passwordConfirmation: ''
+    });
+  },
+
+  actions: {
+    signUp() {
+      let { invitation, email, firstName, lastName, password, passwordConfirmation } = this.getProperties(
+        'invitation', 'email', 'firstName', 'lastName', 'password', 'passwordConfirmation'
+      );
+
+      if (password !== passwordConfirmation) {
+        this.toast.error("The passwords do not match");
+        return;
+      }
+
+      Ember.$.ajax({
+        type: 'POST',
+        url: invitation ? 'api/signup/invitation' : 'api/signup',
+        contentType: 'application/json',
+        data: JSON.stringify({
+          data: {
+            attributes: {
+              token: invitation,
+              email: email,
+              'first-name': firstName,
+              'last-name': lastName,
+              password: password
+            }
+          }
+        })
+      }).then(() => {
+        if (invitation) {
+          this.toast.success("Your account has been created, you can now log in");
+        } else {
+          this.toast.success("Check your emails to activate your account");
+        }
+        this.transitionToRoute('login');
+      }, (xhr) => {
+        let errors = xhr.responseJSON && xhr.responseJSON.errors;
+        let detail = errors && errors[0] && (errors[0].detail || errors[0].title);
+        this.toast.error(detail || "Your account has not been created");
+      });
+    }
+  }
+});
@@ -0,0 +1,20 @@
+import Ember from 'ember';
+
+export default Ember.Route.extend({
+  setupController(controller) {
+    this._super(...arguments);
+    controller.reset();
+    this.get('configuration').loadData();
+
+    let invitation = controller.get('invitation');
+    if (invitation) {
+      Ember.$.getJSON('api/signup/invitation', { token: invitation }).then((response) => {
+        controller.set('email', response.data.email);
+      }, () => {
+        this.toast.error("The invitation is invalid or has expired");
+        controller.set('invitation', null);
+      });
+    }
+  },
+  configuration: Ember.inject.service('configuration')
+});
@@ -0,0 +1,18 @@
+import Ember from 'ember';
+
+export default Ember.Route.extend({
+
+  beforeModel(transition) {
+    let params = transition.queryParams;
+
+    return this.get('session')
+    .authenticate('authenticator:sso', {
+      'access_token': params.access_token,
+      'token_type': params.token_type,
+      'refresh_token': params.refresh_token,
+      'expires_in': parseInt(params.expires_in, 10)
+    }).catch(() => {
+      this.transitionTo('login');
+    });
+  }
+});
@@ -51,6 +51,7 @@ export default DS.Model.extend(Validations, {
   lastName: DS.attr('string'),
   password: DS.attr('string'),
   signupDate: DS.attr('number'),
+  locked: DS.attr('boolean'),
 
   fullName: function() {
     if (this.get('firstName') && this.get('lastName')) {